@@ -0,0 +1,90 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Usage
+// The arguments of the migrate subcommand of the service binaries.
+const Usage = "migrate up|down|status|to <version>"
+
+// Run
+// Runs the migrate subcommand described by args (without "migrate"), writing its report to out:
+//   - up: applies every pending migration.
+//   - down: reverts the last applied migration.
+//   - status: lists the migrations, and when they were applied.
+//   - to <version>: applies or reverts the migrations until version is the last one applied, 0 reverts them all.
+//
+// Errors:
+//   - The arguments do not match Usage.
+//   - The error of the migrator.
+func Run(ctx context.Context, m *Migrator, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command, usage: %s", Usage)
+	}
+
+	switch command := args[0]; {
+	case command == "up" && len(args) == 1:
+		applied, err := m.Up(ctx)
+		for _, migration := range applied {
+			fmt.Fprintf(out, "applied %s\n", migration)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "no pending migration")
+		}
+		return err
+
+	case command == "down" && len(args) == 1:
+		reverted, err := m.Down(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "reverted %s\n", reverted)
+		return nil
+
+	case command == "status" && len(args) == 1:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			if status.Unknown {
+				appliedAt += " (unknown to this binary)"
+			}
+			fmt.Fprintf(writer, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return writer.Flush()
+
+	case command == "to" && len(args) == 2:
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil || version < 0 {
+			return fmt.Errorf("invalid version %q, usage: %s", args[1], Usage)
+		}
+		run, err := m.To(ctx, version)
+		for _, migration := range run {
+			if migration.Version > version {
+				fmt.Fprintf(out, "reverted %s\n", migration)
+			} else {
+				fmt.Fprintf(out, "applied %s\n", migration)
+			}
+		}
+		if err == nil && len(run) == 0 {
+			fmt.Fprintf(out, "already at version %d\n", version)
+		}
+		return err
+
+	default:
+		return fmt.Errorf("invalid command %q, usage: %s", command, Usage)
+	}
+}
@@ -0,0 +1,501 @@
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknownVersion
+// Returned when a version has no migration file.
+var ErrUnknownVersion = errors.New("unknown migration version")
+
+// ErrNothingToRevert
+// Returned by Down when no migration is applied.
+var ErrNothingToRevert = errors.New("no migration to revert")
+
+// fileName
+// The name of a migration file: "<version>_<name>.up.sql" or "<version>_<name>.down.sql".
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration
+// A numbered schema change, with the SQL applying it and the SQL reverting it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// String
+// Returns the file name of the migration, without its direction.
+func (m *Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Status
+// A migration, and when it was applied, nil while it is pending.
+// Unknown is set for the applied versions without a migration file, written by a newer binary.
+type Status struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+	Unknown   bool
+}
+
+// ErrUnknownSchema
+// Returned when the database has tables of a Baseline but no applied migration, and matches none of the Baselines.
+var ErrUnknownSchema = errors.New("unknown schema")
+
+// Baseline
+// A schema created before the migrations, adopted at Version: the versions up to Version are recorded as applied,
+// and the later ones are applied to it. A zero Version records none, the first migrations upgrade the schema in place.
+// Columns are the "<table>.<column>" of the schema, with their information_schema data_type.
+// The schema matches when it has every one of them, and no other table of the Baselines.
+type Baseline struct {
+	Version int64
+	Columns map[string]string
+}
+
+// Migrator
+// Applies and reverts the migrations of a service.
+// Every command holds an advisory lock named LockName, so replicas starting together migrate one at a time.
+// Databases created before the migrations are adopted by the Baseline they match, see adopt.
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []*Migration
+	LockName   string
+	Baselines  []*Baseline
+}
+
+// Load
+// Reads the migration files at the root of fsys, other files are ignored.
+//
+// Returns:
+//   - The migrations, by version.
+//
+// Errors:
+//   - A file could not be read.
+//   - Two migrations have the same version, or one is missing its up or down file.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %v", match[1], err)
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		} else if migration.Name != match[2] {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migration, entry.Name())
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %s needs both an up and a down file", migration)
+		}
+		migrations = append(migrations, migration)
+	}
+	slices.SortFunc(migrations, func(a, b *Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// Up
+// Applies every pending migration, by version.
+// The applied versions unknown to this binary are left alone, so an older replica still starts during a rollout.
+//
+// Returns:
+//   - The applied migrations.
+//
+// Errors:
+//   - The first migration failing, which is rolled back, the ones before it stay applied.
+func (m *Migrator) Up(ctx context.Context) ([]*Migration, error) {
+	return m.migrate(ctx, m.latest(), false)
+}
+
+// Down
+// Reverts the last applied migration.
+//
+// Returns:
+//   - The reverted migration.
+//
+// Errors:
+//   - ErrNothingToRevert: When no migration is applied.
+//   - ErrUnknownVersion: When the last applied version has no migration file.
+func (m *Migrator) Down(ctx context.Context) (*Migration, error) {
+	var reverted *Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			return ErrNothingToRevert
+		}
+		last := slices.Max(slices.Collect(maps.Keys(applied)))
+		reverted = m.find(last)
+		if reverted == nil {
+			return fmt.Errorf("%w: %d", ErrUnknownVersion, last)
+		}
+		return revert(ctx, conn, reverted)
+	})
+	return reverted, err
+}
+
+// To
+// Applies the pending migrations up to version, and reverts the applied ones after it, the last one first.
+// A zero version reverts every migration.
+//
+// Returns:
+//   - The applied or reverted migrations, in the order they were run.
+//
+// Errors:
+//   - ErrUnknownVersion: When version, or an applied version to revert, has no migration file.
+//   - The first migration failing, which is rolled back, the ones before it stay applied.
+func (m *Migrator) To(ctx context.Context, version int64) ([]*Migration, error) {
+	if version != 0 && m.find(version) == nil {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, version)
+	}
+	return m.migrate(ctx, version, true)
+}
+
+// migrate
+// Applies the pending migrations up to version, and with revertAfter, reverts the applied ones after it, see To.
+func (m *Migrator) migrate(ctx context.Context, version int64, revertAfter bool) ([]*Migration, error) {
+	var run []*Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// Reverted first, so the schema does not mix versions
+		reverting := slices.Collect(maps.Keys(applied))
+		slices.Sort(reverting)
+		slices.Reverse(reverting)
+		for _, appliedVersion := range reverting {
+			if !revertAfter || appliedVersion <= version {
+				break
+			}
+			migration := m.find(appliedVersion)
+			if migration == nil {
+				return fmt.Errorf("%w: %d", ErrUnknownVersion, appliedVersion)
+			}
+			if err := revert(ctx, conn, migration); err != nil {
+				return err
+			}
+			run = append(run, migration)
+		}
+
+		for _, migration := range m.Migrations {
+			if migration.Version > version {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, migration); err != nil {
+				return err
+			}
+			run = append(run, migration)
+		}
+		return nil
+	})
+	return run, err
+}
+
+// Status
+// Returns every migration, with the applied versions unknown to this binary, by version.
+func (m *Migrator) Status(ctx context.Context) ([]*Status, error) {
+	var statuses []*Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.Migrations {
+			status := &Status{Version: migration.Version, Name: migration.Name}
+			if row, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &row.appliedAt
+				delete(applied, migration.Version)
+			}
+			statuses = append(statuses, status)
+		}
+		for version, row := range applied {
+			statuses = append(statuses, &Status{Version: version, Name: row.name, AppliedAt: &row.appliedAt, Unknown: true})
+		}
+		return nil
+	})
+	slices.SortFunc(statuses, func(a, b *Status) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return statuses, err
+}
+
+// latest
+// Returns the version of the last migration, 0 when there is none.
+func (m *Migrator) latest() int64 {
+	if len(m.Migrations) == 0 {
+		return 0
+	}
+	return m.Migrations[len(m.Migrations)-1].Version
+}
+
+// find
+// Returns the migration with version, nil when there is none.
+func (m *Migrator) find(version int64) *Migration {
+	for _, migration := range m.Migrations {
+		if migration.Version == version {
+			return migration
+		}
+	}
+	return nil
+}
+
+// withLock
+// Runs fn on a dedicated connection, holding the advisory lock of the migrator,
+// once schema_migrations exists, and the database was adopted by its Baseline.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// A session lock, held across the transactions of the migrations
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock(hashtext($1))`, m.LockName); err != nil {
+		return fmt.Errorf("failed to lock migrations: %v", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock(hashtext($1))`, m.LockName)
+
+	query :=
+		`CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	if err := m.adopt(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// adopt
+// Adopts a database created before the migrations, when schema_migrations is empty
+// and the tables of the Baselines exist: the versions of the Baseline the schema matches are recorded as applied.
+// A database without any of the tables is new, the migrations create them.
+//
+// Errors:
+//   - ErrUnknownSchema: When the schema matches none of the Baselines, with what each of them is missing.
+func (m *Migrator) adopt(ctx context.Context, conn *sql.Conn) error {
+	if len(m.Baselines) == 0 {
+		return nil
+	}
+	var migrated bool
+	if err := conn.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations)`).Scan(&migrated); err != nil {
+		return err
+	}
+	if migrated {
+		return nil
+	}
+
+	columns, err := schemaColumns(ctx, conn)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for column := range columns {
+		table, _, _ := strings.Cut(column, ".")
+		existing[table] = true
+	}
+	tables := make(map[string]bool)
+	for _, baseline := range m.Baselines {
+		for table := range baseline.tables() {
+			if existing[table] {
+				tables[table] = true
+			}
+		}
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	baselines := slices.Clone(m.Baselines)
+	slices.SortFunc(baselines, func(a, b *Baseline) int {
+		return cmp.Compare(b.Version, a.Version)
+	})
+	var mismatches []string
+	for _, baseline := range baselines {
+		mismatch := baseline.mismatch(columns, tables)
+		if len(mismatch) > 0 {
+			mismatches = append(mismatches, fmt.Sprintf("version %d: %s", baseline.Version, strings.Join(mismatch, ", ")))
+			continue
+		}
+		for _, migration := range m.Migrations {
+			if migration.Version > baseline.Version {
+				break
+			}
+			query := `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+			if _, err := conn.ExecContext(ctx, query, migration.Version, migration.Name); err != nil {
+				return fmt.Errorf("failed to adopt migration %s: %v", migration, err)
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: the database has no applied migration, and its tables match no baseline (%s)",
+		ErrUnknownSchema, strings.Join(mismatches, "; "))
+}
+
+// tables
+// Returns the tables of the baseline.
+func (b *Baseline) tables() map[string]bool {
+	tables := make(map[string]bool)
+	for column := range b.Columns {
+		table, _, _ := strings.Cut(column, ".")
+		tables[table] = true
+	}
+	return tables
+}
+
+// mismatch
+// Returns how the schema differs from the baseline, nil when it matches,
+// from its columns and the tables of the Baselines it has.
+func (b *Baseline) mismatch(columns map[string]string, existing map[string]bool) []string {
+	var mismatch []string
+	tables := b.tables()
+	for _, table := range slices.Sorted(maps.Keys(existing)) {
+		if !tables[table] {
+			mismatch = append(mismatch, fmt.Sprintf("has table %s", table))
+		}
+	}
+	for _, column := range slices.Sorted(maps.Keys(b.Columns)) {
+		dataType, ok := columns[column]
+		if !ok {
+			mismatch = append(mismatch, fmt.Sprintf("%s is missing", column))
+		} else if dataType != b.Columns[column] {
+			mismatch = append(mismatch, fmt.Sprintf("%s is %s, not %s", column, dataType, b.Columns[column]))
+		}
+	}
+	return mismatch
+}
+
+// schemaColumns
+// Returns the data_type of the columns of the current schema, by "<table>.<column>".
+func schemaColumns(ctx context.Context, conn *sql.Conn) (map[string]string, error) {
+	query :=
+		`SELECT table_name || '.' || column_name, data_type 
+		FROM information_schema.columns 
+		WHERE table_schema = current_schema()`
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]string)
+	for rows.Next() {
+		var column, dataType string
+		if err := rows.Scan(&column, &dataType); err != nil {
+			return nil, err
+		}
+		columns[column] = dataType
+	}
+	return columns, rows.Err()
+}
+
+// appliedRow
+// A row of schema_migrations.
+type appliedRow struct {
+	name      string
+	appliedAt time.Time
+}
+
+// appliedVersions
+// Returns the rows of schema_migrations, by version.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]appliedRow, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]appliedRow)
+	for rows.Next() {
+		var version int64
+		var row appliedRow
+		if err := rows.Scan(&version, &row.name, &row.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = row
+	}
+	return applied, rows.Err()
+}
+
+// apply
+// Runs the up SQL of a migration and records it, in a single transaction.
+func apply(ctx context.Context, conn *sql.Conn, migration *Migration) error {
+	return inTx(ctx, conn, migration, migration.Up,
+		`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+}
+
+// revert
+// Runs the down SQL of a migration and forgets it, in a single transaction.
+func revert(ctx context.Context, conn *sql.Conn, migration *Migration) error {
+	return inTx(ctx, conn, migration, migration.Down,
+		`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+}
+
+// inTx
+// Runs script, then the query recording it, in a transaction of conn.
+func inTx(ctx context.Context, conn *sql.Conn, migration *Migration, script string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %s failed: %v", migration, err)
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to record migration %s: %v", migration, err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,87 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"grpc-services/migrate"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name         string
+		givenFiles   fstest.MapFS
+		wantVersions []int64
+		wantErrorMsg string
+	}{
+		{
+			name: "sorted by version",
+			givenFiles: fstest.MapFS{
+				"0010_add_index.up.sql":      {Data: []byte("CREATE INDEX")},
+				"0010_add_index.down.sql":    {Data: []byte("DROP INDEX")},
+				"0002_create_users.up.sql":   {Data: []byte("CREATE TABLE")},
+				"0002_create_users.down.sql": {Data: []byte("DROP TABLE")},
+				"README.md":                  {Data: []byte("not a migration")},
+			},
+			wantVersions: []int64{2, 10},
+		},
+		{
+			name: "missing down file",
+			givenFiles: fstest.MapFS{
+				"0001_create_users.up.sql": {Data: []byte("CREATE TABLE")},
+			},
+			wantErrorMsg: "migration 0001_create_users needs both an up and a down file",
+		},
+		{
+			name: "duplicate version",
+			givenFiles: fstest.MapFS{
+				"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE")},
+				"0001_create_users.down.sql": {Data: []byte("DROP TABLE")},
+				"0001_create_events.up.sql":  {Data: []byte("CREATE TABLE")},
+			},
+			wantErrorMsg: "have the same version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := migrate.Load(tt.givenFiles)
+			if tt.wantErrorMsg != "" {
+				assert.ErrorContains(t, err, tt.wantErrorMsg)
+				return
+			}
+			assert.NoError(t, err)
+			var versions []int64
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, tt.wantVersions, versions)
+		})
+	}
+}
+
+func TestRunMigrateCommand_InvalidArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		givenArgs    []string
+		wantErrorMsg string
+	}{
+		{name: "no command", givenArgs: nil, wantErrorMsg: "missing command"},
+		{name: "unknown command", givenArgs: []string{"sideways"}, wantErrorMsg: `invalid command "sideways"`},
+		{name: "missing version", givenArgs: []string{"to"}, wantErrorMsg: `invalid command "to"`},
+		{name: "invalid version", givenArgs: []string{"to", "latest"}, wantErrorMsg: `invalid version "latest"`},
+		{name: "negative version", givenArgs: []string{"to", "-1"}, wantErrorMsg: `invalid version "-1"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Invalid arguments are rejected before the database is used
+			err := migrate.Run(context.Background(), &migrate.Migrator{}, tt.givenArgs, &bytes.Buffer{})
+			assert.ErrorContains(t, err, tt.wantErrorMsg)
+		})
+	}
+}
@@ -173,42 +173,6 @@ func (c *SQLClient) GetLatestOperation(ctx context.Context) (*Operation, error)
 	return &op, nil
 }
 
-// CreateTables creates the necessary tables for operations
-func (c *SQLClient) CreateTables() error {
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS operations (
-		id VARCHAR(36) PRIMARY KEY,
-		marshalled_request JSONB NOT NULL,
-		step_id INTEGER NOT NULL DEFAULT 0,
-		state VARCHAR(20) NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE OR REPLACE FUNCTION update_updated_at_column()
-	RETURNS TRIGGER AS $$
-	BEGIN
-		NEW.updated_at = CURRENT_TIMESTAMP;
-		RETURN NEW;
-	END;
-	$$ language 'plpgsql';
-
-	DROP TRIGGER IF EXISTS update_operations_updated_at ON operations;
-	CREATE TRIGGER update_operations_updated_at
-		BEFORE UPDATE ON operations
-		FOR EACH ROW
-		EXECUTE FUNCTION update_updated_at_column();
-	`
-
-	_, err := c.DB.Exec(createTableSQL)
-	if err != nil {
-		return fmt.Errorf("failed to create tables: %v", err)
-	}
-
-	log.Println("Database tables created/verified successfully")
-	return nil
-}
-
 // Close closes the database connection
 func (c *SQLClient) Close() error {
 	if c.DB != nil {
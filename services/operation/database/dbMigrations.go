@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"grpc-services/migrate"
+)
+
+// migrationFiles holds the numbered up and down SQL files of the operations schema
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// baseline is the schema created by CreateTables before the migrations, adopted at version 1
+var baseline = &migrate.Baseline{Version: 1, Columns: map[string]string{
+	"operations.id":                 "character varying",
+	"operations.marshalled_request": "jsonb",
+	"operations.step_id":            "integer",
+	"operations.state":              "character varying",
+	"operations.created_at":         "timestamp without time zone",
+	"operations.updated_at":         "timestamp without time zone",
+}}
+
+// Migrator returns the migrator of the operations schema.
+// Databases created by CreateTables before the migrations are adopted at version 1, when they match its baseline.
+func (c *SQLClient) Migrator() (*migrate.Migrator, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := migrate.Load(files)
+	if err != nil {
+		return nil, err
+	}
+	return &migrate.Migrator{
+		DB:         c.DB,
+		Migrations: migrations,
+		LockName:   "operation schema",
+		Baselines:  []*migrate.Baseline{baseline},
+	}, nil
+}
+
+// CreateTables applies the pending migrations
+func (c *SQLClient) CreateTables() error {
+	migrator, err := c.Migrator()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+	applied, err := migrator.Up(context.Background())
+	for _, migration := range applied {
+		log.Printf("Applied migration %s", migration)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate: %v", err)
+	}
+
+	log.Println("Database schema is up to date")
+	return nil
+}
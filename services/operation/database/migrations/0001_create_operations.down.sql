@@ -0,0 +1,2 @@
+DROP TABLE operations;
+DROP FUNCTION update_updated_at_column();
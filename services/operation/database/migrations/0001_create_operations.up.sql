@@ -0,0 +1,21 @@
+CREATE TABLE operations (
+    id VARCHAR(36) PRIMARY KEY,
+    marshalled_request JSONB NOT NULL,
+    step_id INTEGER NOT NULL DEFAULT 0,
+    state VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE FUNCTION update_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER update_operations_updated_at
+    BEFORE UPDATE ON operations
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
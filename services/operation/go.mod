@@ -6,6 +6,7 @@ require (
 	github.com/lib/pq v1.10.9
 	github.com/stretchr/testify v1.11.1
 	google.golang.org/grpc v1.76.0
+	grpc-services/migrate v0.0.0
 	grpc-services/user v0.0.0
 )
 
@@ -20,4 +21,6 @@ require (
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
+replace grpc-services/migrate => ../migrate
+
 replace grpc-services/user => ../user
@@ -4,7 +4,9 @@ import (
 	"context"
 	"log"
 	"net"
+	"os"
 
+	"grpc-services/migrate"
 	"grpc-services/operation/config"
 	"grpc-services/operation/database"
 	"grpc-services/operation/server"
@@ -29,13 +31,6 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
-	userClient, err := userCl.NewGRPCClient(ctx, userServiceAddr)
-	if err != nil {
-		log.Fatalf("Failed to connect to user service: %v", err)
-		panic(err)
-	}
-	defer userClient.Close()
-
 	// Initialize database
 	db, err := database.NewPostgresClient(cfg)
 	if err != nil {
@@ -44,6 +39,25 @@ func main() {
 	}
 	defer db.Close()
 
+	// The migrate subcommand manages the schema, then exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrator, err := db.Migrator()
+		if err != nil {
+			log.Fatalf("Failed to load migrations: %v", err)
+		}
+		if err := migrate.Run(ctx, migrator, os.Args[2:], os.Stdout); err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		return
+	}
+
+	userClient, err := userCl.NewGRPCClient(ctx, userServiceAddr)
+	if err != nil {
+		log.Fatalf("Failed to connect to user service: %v", err)
+		panic(err)
+	}
+	defer userClient.Close()
+
 	// Create server instance with user client
 	operationServer := server.NewServer(cfg, db, userClient)
 
@@ -51,7 +65,7 @@ func main() {
 	grpcServer := grpc.NewServer()
 	pb.RegisterOperationServiceServer(grpcServer, operationServer)
 
-	// Create tables if they don't exist
+	// Apply the pending migrations
 	if err := db.CreateTables(); err != nil {
 		log.Fatalf("Failed to create tables: %v", err)
 	}
@@ -11,7 +11,6 @@ import (
 	userpb "grpc-services/user/proto"
 
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
 // OperationStep represents the steps in the operation process
@@ -35,6 +34,9 @@ func (s OperationStep) String() string {
 	return [...]string{"INITIAL", "LIST_USERS", "DELETE_USERS", "CREATE_USERS", "COMPLETED"}[s]
 }
 
+// deleteBatchSize is the number of users deleted per BatchDeleteUsers call
+const deleteBatchSize = 100
+
 // TotalSteps returns the total number of processing steps (excluding initial and completed)
 const TotalSteps = StepCompleted
 
@@ -111,18 +113,15 @@ func (p *OperationProcessor) processStepInitial(ctx context.Context, operation *
 func (p *OperationProcessor) processStepListUsers(ctx context.Context, operation *database.Operation) error {
 	log.Printf("Operation %s: Listing existing users", operation.ID)
 
-	// List all users (assuming reasonable number, pagination would be needed for large datasets)
-	resp, err := p.userClient.ListUsers(ctx, &userpb.ListUsersRequest{
-		Page:  1,
-		Limit: 100, // High limit to get all users
-	})
+	// List all users, page by page
+	users, err := p.listAllUsers(ctx)
 	if err != nil {
 		// Update operation state to failed
 		p.dbClient.UpdateOperationState(ctx, operation.ID, database.StateFailed)
 		return fmt.Errorf("failed to list users: %v", err)
 	}
 
-	log.Printf("Found %d existing users", len(resp.Users))
+	log.Printf("Found %d existing users", len(users))
 
 	// Store user IDs for next step (in a real implementation, you might store this in operation data)
 	// For now, we'll just proceed to deletion
@@ -141,30 +140,37 @@ func (p *OperationProcessor) processStepDeleteUsers(ctx context.Context, operati
 	log.Printf("Operation %s: Deleting existing users", operation.ID)
 
 	// List users to get IDs for deletion
-	resp, err := p.userClient.ListUsers(ctx, &userpb.ListUsersRequest{
-		Page:  1,
-		Limit: 100,
-	})
+	users, err := p.listAllUsers(ctx)
 	if err != nil {
 		p.dbClient.UpdateOperationState(ctx, operation.ID, database.StateFailed)
 		return fmt.Errorf("failed to list users for deletion: %v", err)
 	}
 
-	// Delete each user
-	for _, user := range resp.Users {
-		_, err := p.userClient.DeleteUser(ctx, &userpb.DeleteUserRequest{
-			Id: user.Id,
+	// Delete the users in batches, a user deleted meanwhile doesn't fail the step
+	for start := 0; start < len(users); start += deleteBatchSize {
+		end := min(start+deleteBatchSize, len(users))
+		requests := make([]*userpb.DeleteUserRequest, 0, end-start)
+		for _, user := range users[start:end] {
+			requests = append(requests, &userpb.DeleteUserRequest{Id: user.Id})
+		}
+
+		resp, err := p.userClient.BatchDeleteUsers(ctx, &userpb.BatchDeleteUsersRequest{
+			Requests: requests,
+			Mode:     userpb.BatchMode_BATCH_MODE_BEST_EFFORT,
 		})
 		if err != nil {
-			// Check if it's a not found error (user might have been deleted already)
-			if status.Code(err) != codes.NotFound {
+			p.dbClient.UpdateOperationState(ctx, operation.ID, database.StateFailed)
+			return fmt.Errorf("failed to delete users: %v", err)
+		}
+		for _, result := range resp.GetResults() {
+			if result.GetError() != nil && codes.Code(result.GetError().GetCode()) != codes.NotFound {
 				p.dbClient.UpdateOperationState(ctx, operation.ID, database.StateFailed)
-				return fmt.Errorf("failed to delete user %s: %v", user.Id, err)
+				return fmt.Errorf("failed to delete user %s: %s", result.GetId(), result.GetError().GetMessage())
 			}
 		}
 	}
 
-	log.Printf("Deleted %d users", len(resp.Users))
+	log.Printf("Deleted %d users", len(users))
 
 	// Update to next step
 	if err := p.dbClient.UpdateOperationStep(ctx, operation.ID, int(StepCreateUsers), database.StateRunning); err != nil {
@@ -192,16 +198,24 @@ func (p *OperationProcessor) processStepCreateUsers(ctx context.Context, operati
 		{"User Five", "user5@example.com", 32},
 	}
 
+	// Create them all or none, with request ids derived from the operation,
+	// so re-running the step after a crash returns the users it already created
+	requests := make([]*userpb.CreateUserRequest, 0, len(users))
 	for i, userData := range users {
-		_, err := p.userClient.CreateUser(ctx, &userpb.CreateUserRequest{
-			Name:  userData.name,
-			Email: userData.email,
-			Age:   userData.age,
+		requests = append(requests, &userpb.CreateUserRequest{
+			Name:      userData.name,
+			Email:     userData.email,
+			Age:       userData.age,
+			RequestId: fmt.Sprintf("%s/create-users/%d", operation.ID, i),
 		})
-		if err != nil {
-			p.dbClient.UpdateOperationState(ctx, operation.ID, database.StateFailed)
-			return fmt.Errorf("failed to create user %d: %v", i+1, err)
-		}
+	}
+	_, err := p.userClient.BatchCreateUsers(ctx, &userpb.BatchCreateUsersRequest{
+		Requests: requests,
+		Mode:     userpb.BatchMode_BATCH_MODE_ALL_OR_NOTHING,
+	})
+	if err != nil {
+		p.dbClient.UpdateOperationState(ctx, operation.ID, database.StateFailed)
+		return fmt.Errorf("failed to create users: %v", err)
 	}
 
 	log.Printf("Created %d new users", len(users))
@@ -215,6 +229,27 @@ func (p *OperationProcessor) processStepCreateUsers(ctx context.Context, operati
 	return nil
 }
 
+// listAllUsers lists every user, following the page tokens until the last page
+func (p *OperationProcessor) listAllUsers(ctx context.Context) ([]*userpb.User, error) {
+	var users []*userpb.User
+	pageToken := ""
+	for {
+		resp, err := p.userClient.ListUsers(ctx, &userpb.ListUsersRequest{
+			Limit:     100,
+			PageToken: pageToken,
+		})
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, resp.GetUsers()...)
+
+		pageToken = resp.GetNextPageToken()
+		if pageToken == "" {
+			return users, nil
+		}
+	}
+}
+
 // StartBackgroundProcessor starts the background worker that processes operations
 func (p *OperationProcessor) StartBackgroundProcessor(ctx context.Context) {
 	go func() {
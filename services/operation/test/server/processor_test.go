@@ -11,6 +11,8 @@ import (
 	userMock "grpc-services/user/test/client"
 
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestOperationProcessor_ProcessOperation(t *testing.T) {
@@ -31,6 +33,38 @@ func TestOperationProcessor_ProcessOperation(t *testing.T) {
 			},
 			wantStepChange: true,
 		},
+		{
+			name:        "error - batch delete item fails",
+			operationID: "op-3",
+			setupMock: func(ctx context.Context, m *dbMock.MockClient, u *userMock.MockGRPCClient) {
+				m.CreateOperation(ctx, &database.Operation{
+					ID:     "op-3",
+					StepID: int(server.StepDeleteUsers),
+					State:  database.StateRunning,
+				})
+				u.SetListUsersResponse([]*userPb.User{{Id: "1"}, {Id: "2"}}, 2)
+				u.SetBatchDeleteUsersResponse([]*userPb.BatchUserResult{
+					{Id: "1", Error: &userPb.BatchError{Code: int32(codes.NotFound), Message: "user not found"}},
+					{Id: "2", Error: &userPb.BatchError{Code: int32(codes.Internal), Message: "boom", Index: 1}},
+				})
+			},
+			wantError:    true,
+			wantErrorMsg: "failed to delete user 2: boom",
+		},
+		{
+			name:        "error - batch create fails",
+			operationID: "op-4",
+			setupMock: func(ctx context.Context, m *dbMock.MockClient, u *userMock.MockGRPCClient) {
+				m.CreateOperation(ctx, &database.Operation{
+					ID:     "op-4",
+					StepID: int(server.StepCreateUsers),
+					State:  database.StateRunning,
+				})
+				u.BatchCreateUsersError = status.Error(codes.Internal, "boom")
+			},
+			wantError:    true,
+			wantErrorMsg: "failed to create users",
+		},
 		{
 			name:        "error - unknown step",
 			operationID: "op-5",
@@ -85,3 +119,23 @@ func TestOperationProcessor_ProcessOperation(t *testing.T) {
 		})
 	}
 }
+
+func TestOperationProcessor_CreateUsers_RequestIDs(t *testing.T) {
+	ctx := context.Background()
+	dbClient := dbMock.NewMockClient(nil, nil, nil)
+	userClient := &userMock.MockGRPCClient{}
+	dbClient.CreateOperation(ctx, &database.Operation{
+		ID:     "op-6",
+		StepID: int(server.StepCreateUsers),
+		State:  database.StateRunning,
+	})
+	processor := server.NewTestOperationProcessor(dbClient, userClient)
+
+	assert.NoError(t, processor.ProcessOperation(ctx, "op-6"))
+
+	// Re-running the step sends the same request ids, so no user is created twice
+	requests := userClient.LastBatchCreateUsersRequest.GetRequests()
+	assert.Len(t, requests, 5)
+	assert.Equal(t, "op-6/create-users/0", requests[0].GetRequestId())
+	assert.Equal(t, "op-6/create-users/4", requests[4].GetRequestId())
+}
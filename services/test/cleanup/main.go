@@ -37,22 +37,32 @@ func cleanUserDatabase(ctx context.Context) {
 	}
 	defer userClient.Close()
 
-	// List all users
-	resp, err := userClient.ListUsers(ctx, &userPb.ListUsersRequest{Limit: 1000})
-	if err != nil {
-		log.Printf("Warning: Failed to list users: %v", err)
-		return
+	// List all users, page by page
+	var users []*userPb.User
+	pageToken := ""
+	for {
+		resp, err := userClient.ListUsers(ctx, &userPb.ListUsersRequest{Limit: 100, PageToken: pageToken})
+		if err != nil {
+			log.Printf("Warning: Failed to list users: %v", err)
+			return
+		}
+		users = append(users, resp.GetUsers()...)
+
+		pageToken = resp.GetNextPageToken()
+		if pageToken == "" {
+			break
+		}
 	}
 
 	// Delete all users
-	for _, user := range resp.GetUsers() {
+	for _, user := range users {
 		_, err := userClient.DeleteUser(ctx, &userPb.DeleteUserRequest{Id: user.GetId()})
 		if err != nil {
 			log.Printf("Warning: Failed to delete user %s: %v", user.GetId(), err)
 		}
 	}
 
-	log.Printf("Cleaned %d users from database", len(resp.GetUsers()))
+	log.Printf("Cleaned %d users from database", len(users))
 }
 
 func cleanOperationDatabase(ctx context.Context) {
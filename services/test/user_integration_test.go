@@ -107,7 +107,7 @@ func TestUser_Integration(t *testing.T) {
 	}
 	if diff := cmp.Diff(respGetUser.GetUser(), testUser1,
 		protocmp.Transform(),
-		protocmp.IgnoreFields(&pb.User{}, "id", "created_at", "updated_at")); diff != "" {
+		protocmp.IgnoreFields(&pb.User{}, "id", "created_at", "updated_at", "etag")); diff != "" {
 		t.Fatalf("ListUsers response: %s", diff)
 	}
 
@@ -118,7 +118,7 @@ func TestUser_Integration(t *testing.T) {
 	}
 	if diff := cmp.Diff(respListUsers, expectedListUsersResponse2,
 		protocmp.Transform(),
-		protocmp.IgnoreFields(&pb.User{}, "id", "created_at", "updated_at")); diff != "" {
+		protocmp.IgnoreFields(&pb.User{}, "id", "created_at", "updated_at", "etag")); diff != "" {
 		t.Fatalf("ListUsers response: %s", diff)
 	}
 	// 5. Delete Users.
@@ -57,6 +57,14 @@ func (c *GRPCClient) GetUser(ctx context.Context, in *pb.GetUserRequest, opts ..
 	return c.Client.GetUser(ctx, in, opts...)
 }
 
+func (c *GRPCClient) GetUserByEmail(ctx context.Context, in *pb.GetUserByEmailRequest, opts ...grpc.CallOption) (*pb.UserResponse, error) {
+	return c.Client.GetUserByEmail(ctx, in, opts...)
+}
+
+func (c *GRPCClient) ListUserHistory(ctx context.Context, in *pb.ListUserHistoryRequest, opts ...grpc.CallOption) (*pb.ListUserHistoryResponse, error) {
+	return c.Client.ListUserHistory(ctx, in, opts...)
+}
+
 func (c *GRPCClient) UpdateUser(ctx context.Context, in *pb.UpdateUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error) {
 	return c.Client.UpdateUser(ctx, in, opts...)
 }
@@ -65,6 +73,62 @@ func (c *GRPCClient) DeleteUser(ctx context.Context, in *pb.DeleteUserRequest, o
 	return c.Client.DeleteUser(ctx, in, opts...)
 }
 
+func (c *GRPCClient) UndeleteUser(ctx context.Context, in *pb.UndeleteUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error) {
+	return c.Client.UndeleteUser(ctx, in, opts...)
+}
+
+func (c *GRPCClient) BatchCreateUsers(ctx context.Context, in *pb.BatchCreateUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error) {
+	return c.Client.BatchCreateUsers(ctx, in, opts...)
+}
+
+func (c *GRPCClient) BatchGetUsers(ctx context.Context, in *pb.BatchGetUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error) {
+	return c.Client.BatchGetUsers(ctx, in, opts...)
+}
+
+func (c *GRPCClient) BatchDeleteUsers(ctx context.Context, in *pb.BatchDeleteUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error) {
+	return c.Client.BatchDeleteUsers(ctx, in, opts...)
+}
+
 func (c *GRPCClient) ListUsers(ctx context.Context, in *pb.ListUsersRequest, opts ...grpc.CallOption) (*pb.ListUsersResponse, error) {
 	return c.Client.ListUsers(ctx, in, opts...)
 }
+
+func (c *GRPCClient) SearchUsers(ctx context.Context, in *pb.SearchUsersRequest, opts ...grpc.CallOption) (*pb.SearchUsersResponse, error) {
+	return c.Client.SearchUsers(ctx, in, opts...)
+}
+
+func (c *GRPCClient) ExportUsers(ctx context.Context, in *pb.ExportUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.User], error) {
+	return c.Client.ExportUsers(ctx, in, opts...)
+}
+
+func (c *GRPCClient) ImportUsers(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[pb.ImportUsersRequest, pb.ImportUsersResponse], error) {
+	return c.Client.ImportUsers(ctx, opts...)
+}
+
+func (c *GRPCClient) WatchUsers(ctx context.Context, in *pb.WatchUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.UserEvent], error) {
+	return c.Client.WatchUsers(ctx, in, opts...)
+}
+
+func (c *GRPCClient) CreateWebhook(ctx context.Context, in *pb.CreateWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error) {
+	return c.Client.CreateWebhook(ctx, in, opts...)
+}
+
+func (c *GRPCClient) GetWebhook(ctx context.Context, in *pb.GetWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error) {
+	return c.Client.GetWebhook(ctx, in, opts...)
+}
+
+func (c *GRPCClient) ListWebhooks(ctx context.Context, in *pb.ListWebhooksRequest, opts ...grpc.CallOption) (*pb.ListWebhooksResponse, error) {
+	return c.Client.ListWebhooks(ctx, in, opts...)
+}
+
+func (c *GRPCClient) EnableWebhook(ctx context.Context, in *pb.EnableWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error) {
+	return c.Client.EnableWebhook(ctx, in, opts...)
+}
+
+func (c *GRPCClient) DeleteWebhook(ctx context.Context, in *pb.DeleteWebhookRequest, opts ...grpc.CallOption) (*pb.DeleteWebhookResponse, error) {
+	return c.Client.DeleteWebhook(ctx, in, opts...)
+}
+
+func (c *GRPCClient) ListWebhookAttempts(ctx context.Context, in *pb.ListWebhookAttemptsRequest, opts ...grpc.CallOption) (*pb.ListWebhookAttemptsResponse, error) {
+	return c.Client.ListWebhookAttempts(ctx, in, opts...)
+}
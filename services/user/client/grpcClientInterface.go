@@ -14,7 +14,23 @@ import (
 type GRPCClientInterface interface {
 	CreateUser(ctx context.Context, in *pb.CreateUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
 	GetUser(ctx context.Context, in *pb.GetUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
+	GetUserByEmail(ctx context.Context, in *pb.GetUserByEmailRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
+	ListUserHistory(ctx context.Context, in *pb.ListUserHistoryRequest, opts ...grpc.CallOption) (*pb.ListUserHistoryResponse, error)
 	UpdateUser(ctx context.Context, in *pb.UpdateUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
 	DeleteUser(ctx context.Context, in *pb.DeleteUserRequest, opts ...grpc.CallOption) (*pb.DeleteUserResponse, error)
+	UndeleteUser(ctx context.Context, in *pb.UndeleteUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
+	BatchCreateUsers(ctx context.Context, in *pb.BatchCreateUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error)
+	BatchGetUsers(ctx context.Context, in *pb.BatchGetUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error)
+	BatchDeleteUsers(ctx context.Context, in *pb.BatchDeleteUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error)
 	ListUsers(ctx context.Context, in *pb.ListUsersRequest, opts ...grpc.CallOption) (*pb.ListUsersResponse, error)
+	SearchUsers(ctx context.Context, in *pb.SearchUsersRequest, opts ...grpc.CallOption) (*pb.SearchUsersResponse, error)
+	ExportUsers(ctx context.Context, in *pb.ExportUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.User], error)
+	ImportUsers(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[pb.ImportUsersRequest, pb.ImportUsersResponse], error)
+	WatchUsers(ctx context.Context, in *pb.WatchUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.UserEvent], error)
+	CreateWebhook(ctx context.Context, in *pb.CreateWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error)
+	GetWebhook(ctx context.Context, in *pb.GetWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error)
+	ListWebhooks(ctx context.Context, in *pb.ListWebhooksRequest, opts ...grpc.CallOption) (*pb.ListWebhooksResponse, error)
+	EnableWebhook(ctx context.Context, in *pb.EnableWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error)
+	DeleteWebhook(ctx context.Context, in *pb.DeleteWebhookRequest, opts ...grpc.CallOption) (*pb.DeleteWebhookResponse, error)
+	ListWebhookAttempts(ctx context.Context, in *pb.ListWebhookAttemptsRequest, opts ...grpc.CallOption) (*pb.ListWebhookAttemptsResponse, error)
 }
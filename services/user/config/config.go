@@ -3,35 +3,223 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // Config
 // Includes some of the server configs.
 type Config struct {
+	// StorageBackend is where the users are stored, one of the Storage* backends.
+	// The DB configs are only required by StoragePostgres, SQLitePath by StorageSQLite.
+	StorageBackend string
+
+	// SQLitePath is the file of the StorageSQLite database, created if missing.
+	SQLitePath string
+
 	DBHost     string
 	DBPort     string
 	DBUser     string
 	DBPassword string
 	DBName     string
 	GRPCPort   string
+
+	// DBReplicas are the DSNs of the read replicas of StoragePostgres, which serve GetUser, ListUsers and CountUsers.
+	DBReplicas []string
+	// DBReplicaMaxLag is the replication lag beyond which a replica is ejected.
+	DBReplicaMaxLag time.Duration
+	// ReadYourWritesWindow is how long the reads of an actor go to the primary after its writes, callers without actor have none.
+	ReadYourWritesWindow time.Duration
+
+	// PurgeRetention is how long soft deleted users are kept before being purged.
+	PurgeRetention time.Duration
+	// PurgeInterval is how often the purge runs.
+	PurgeInterval time.Duration
+	// IdempotencyWindow is how long a CreateUser request_id is remembered.
+	IdempotencyWindow time.Duration
+
+	// FoldEmailLocalPart also lowercases the part of emails before the @,
+	// the domain is always lowercased.
+	FoldEmailLocalPart bool
+
+	// RejectLegacyIDs stops accepting the numeric ids of the users created before ids were UUIDs.
+	RejectLegacyIDs bool
+
+	// OutboxPublisher is where the outbox events are published, one of the Outbox* publishers,
+	// only with StoragePostgres.
+	OutboxPublisher string
+	// OutboxPath is the file of OutboxNDJSON.
+	OutboxPath string
+	// OutboxInterval is how often the outbox is read.
+	OutboxInterval time.Duration
+	// OutboxRetention is how long the published outbox events are kept before being purged.
+	OutboxRetention time.Duration
+
+	// WebhooksEnabled serves the webhook RPCs and delivers the webhooks, only with StoragePostgres.
+	WebhooksEnabled bool
+	// WebhookInterval is how often the due webhook deliveries are read.
+	WebhookInterval time.Duration
+	// WebhookTimeout is how long a webhook endpoint has to answer a delivery.
+	WebhookTimeout time.Duration
+	// WebhookConcurrency is the number of webhooks delivered to at the same time, each in order.
+	WebhookConcurrency int
+	// WebhookMaxFailures is the number of failed attempts in a row after which a webhook is disabled, 0 never disables them.
+	WebhookMaxFailures int
+	// WebhookRetention is how long delivered deliveries, and the pending ones of disabled webhooks, are kept before being purged.
+	WebhookRetention time.Duration
+
+	// CacheSize is the maximum number of users cached by GetUser, 0 disables the cache.
+	CacheSize int
+	// CacheTTL is how long a cached user is served before being read again.
+	CacheTTL time.Duration
+	// CacheNegativeTTL is how long an unknown id is remembered as not found.
+	CacheNegativeTTL time.Duration
 }
 
+// Storage backends of Config.StorageBackend.
+const (
+	StoragePostgres = "postgres"
+	// StorageMemory keeps the users in the service, they are lost when it stops.
+	StorageMemory = "memory"
+	// StorageSQLite keeps the users in an embedded SQLite file, for single node deployments and CI.
+	StorageSQLite = "sqlite"
+)
+
+// Outbox publishers of Config.OutboxPublisher.
+const (
+	// OutboxNone leaves the events in the outbox.
+	OutboxNone = "none"
+	// OutboxNDJSON appends the events to the file at Config.OutboxPath, one JSON object per line.
+	OutboxNDJSON = "ndjson"
+)
+
+// Defaults of the optional configs.
+const (
+	defaultPurgeRetention = 30 * 24 * time.Hour
+	defaultPurgeInterval  = time.Hour
+
+	defaultIdempotencyWindow = 24 * time.Hour
+
+	defaultDBReplicaMaxLag      = 10 * time.Second
+	defaultReadYourWritesWindow = 5 * time.Second
+
+	defaultOutboxInterval  = time.Second
+	defaultOutboxRetention = 24 * time.Hour
+
+	defaultWebhookInterval    = time.Second
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookConcurrency = 10
+	defaultWebhookMaxFailures = 20
+	defaultWebhookRetention   = 7 * 24 * time.Hour
+
+	defaultCacheTTL         = time.Minute
+	defaultCacheNegativeTTL = 5 * time.Second
+)
+
 // LoadConfig
 // Gets the required configs for the service.
+// Optional configs fall back to their defaults.
 //
 // Returns:
 //   - *Config
 //
+// Errors:
+//   - If an optional value is malformed.
+//   - If OUTBOX_PUBLISHER or WEBHOOKS_ENABLED is set without StoragePostgres.
+//
 // Panic:
-//   - If any of the values are missing.
+//   - If any of the required values are missing, the DB values are only required by StoragePostgres,
+//     SQLITE_PATH by StorageSQLite, OUTBOX_PATH by OutboxNDJSON.
 func LoadConfig() (*Config, error) {
 	cfg := &Config{
-		DBHost:     getEnvRequired("DB_HOST"),
-		DBPort:     getEnvRequired("DB_PORT"),
-		DBUser:     getEnvRequired("DB_USER"),
-		DBPassword: getEnvRequired("DB_PASSWORD"),
-		DBName:     getEnvRequired("DB_NAME"),
-		GRPCPort:   getEnvRequired("GRPC_PORT"),
+		GRPCPort: getEnvRequired("GRPC_PORT"),
+	}
+
+	var err error
+	if cfg.StorageBackend, err = getEnvChoice("STORAGE_BACKEND", StoragePostgres, StorageMemory, StorageSQLite); err != nil {
+		return nil, err
+	}
+	if cfg.StorageBackend == StoragePostgres {
+		cfg.DBHost = getEnvRequired("DB_HOST")
+		cfg.DBPort = getEnvRequired("DB_PORT")
+		cfg.DBUser = getEnvRequired("DB_USER")
+		cfg.DBPassword = getEnvRequired("DB_PASSWORD")
+		cfg.DBName = getEnvRequired("DB_NAME")
+		cfg.DBReplicas = getEnvList("DB_REPLICAS")
+	}
+	if cfg.StorageBackend == StorageSQLite {
+		cfg.SQLitePath = getEnvRequired("SQLITE_PATH")
+	}
+	if cfg.PurgeRetention, err = getEnvDuration("PURGE_RETENTION", defaultPurgeRetention); err != nil {
+		return nil, err
+	}
+	if cfg.PurgeInterval, err = getEnvDuration("PURGE_INTERVAL", defaultPurgeInterval); err != nil {
+		return nil, err
+	}
+	if cfg.IdempotencyWindow, err = getEnvDuration("IDEMPOTENCY_WINDOW", defaultIdempotencyWindow); err != nil {
+		return nil, err
+	}
+	if cfg.FoldEmailLocalPart, err = getEnvBool("EMAIL_FOLD_LOCAL_PART", false); err != nil {
+		return nil, err
+	}
+	if cfg.RejectLegacyIDs, err = getEnvBool("REJECT_LEGACY_IDS", false); err != nil {
+		return nil, err
+	}
+	if cfg.DBReplicaMaxLag, err = getEnvDuration("DB_REPLICA_MAX_LAG", defaultDBReplicaMaxLag); err != nil {
+		return nil, err
+	}
+	if cfg.ReadYourWritesWindow, err = getEnvDuration("READ_YOUR_WRITES_WINDOW", defaultReadYourWritesWindow); err != nil {
+		return nil, err
+	}
+	if cfg.OutboxPublisher, err = getEnvChoice("OUTBOX_PUBLISHER", OutboxNone, OutboxNDJSON); err != nil {
+		return nil, err
+	}
+	if cfg.OutboxPublisher != OutboxNone && cfg.StorageBackend != StoragePostgres {
+		return nil, fmt.Errorf("environment variable OUTBOX_PUBLISHER needs STORAGE_BACKEND=%s", StoragePostgres)
+	}
+	if cfg.OutboxPublisher == OutboxNDJSON {
+		cfg.OutboxPath = getEnvRequired("OUTBOX_PATH")
+	}
+	if cfg.OutboxInterval, err = getEnvDuration("OUTBOX_INTERVAL", defaultOutboxInterval); err != nil {
+		return nil, err
+	}
+	if cfg.OutboxRetention, err = getEnvDuration("OUTBOX_RETENTION", defaultOutboxRetention); err != nil {
+		return nil, err
+	}
+	if cfg.WebhooksEnabled, err = getEnvBool("WEBHOOKS_ENABLED", false); err != nil {
+		return nil, err
+	}
+	if cfg.WebhooksEnabled && cfg.StorageBackend != StoragePostgres {
+		return nil, fmt.Errorf("environment variable WEBHOOKS_ENABLED needs STORAGE_BACKEND=%s", StoragePostgres)
+	}
+	if cfg.WebhookInterval, err = getEnvDuration("WEBHOOK_INTERVAL", defaultWebhookInterval); err != nil {
+		return nil, err
+	}
+	if cfg.WebhookTimeout, err = getEnvDuration("WEBHOOK_TIMEOUT", defaultWebhookTimeout); err != nil {
+		return nil, err
+	}
+	if cfg.WebhookConcurrency, err = getEnvCount("WEBHOOK_CONCURRENCY", defaultWebhookConcurrency); err != nil {
+		return nil, err
+	}
+	if cfg.WebhookConcurrency == 0 {
+		return nil, fmt.Errorf("environment variable WEBHOOK_CONCURRENCY must be positive")
+	}
+	if cfg.WebhookMaxFailures, err = getEnvCount("WEBHOOK_MAX_FAILURES", defaultWebhookMaxFailures); err != nil {
+		return nil, err
+	}
+	if cfg.WebhookRetention, err = getEnvDuration("WEBHOOK_RETENTION", defaultWebhookRetention); err != nil {
+		return nil, err
+	}
+	if cfg.CacheSize, err = getEnvCount("CACHE_SIZE", 0); err != nil {
+		return nil, err
+	}
+	if cfg.CacheTTL, err = getEnvDuration("CACHE_TTL", defaultCacheTTL); err != nil {
+		return nil, err
+	}
+	if cfg.CacheNegativeTTL, err = getEnvDuration("CACHE_NEGATIVE_TTL", defaultCacheNegativeTTL); err != nil {
+		return nil, err
 	}
 	return cfg, nil
 }
@@ -46,3 +234,70 @@ func getEnvRequired(key string) string {
 	}
 	return value
 }
+
+// getEnvList
+// Gets an optional comma separated Env Variable, without its empty items, nil if missing.
+func getEnvList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnvChoice
+// Gets an optional Env Variable that must be one of choices, or the first choice if missing.
+func getEnvChoice(key string, choices ...string) (string, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return choices[0], nil
+	}
+	if !slices.Contains(choices, value) {
+		return "", fmt.Errorf("environment variable %s must be one of %s, got %q", key, strings.Join(choices, ", "), value)
+	}
+	return value, nil
+}
+
+// getEnvDuration
+// Gets an optional duration Env Variable (e.g. "720h"), or the default value if missing.
+func getEnvDuration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("environment variable %s must be a positive duration, got %q", key, value)
+	}
+	return d, nil
+}
+
+// getEnvCount
+// Gets an optional non negative integer Env Variable, or the default value if missing.
+func getEnvCount(key string, defaultValue int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("environment variable %s must be a non negative integer, got %q", key, value)
+	}
+	return n, nil
+}
+
+// getEnvBool
+// Gets an optional boolean Env Variable (e.g. "true", "1"), or the default value if missing.
+func getEnvBool(key string, defaultValue bool) (bool, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("environment variable %s must be a boolean, got %q", key, value)
+	}
+	return b, nil
+}
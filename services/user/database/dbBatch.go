@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// withTx
+// Runs fn in a transaction, committed when fn succeeds and rolled back otherwise.
+// The writes of fn are recorded with the actor of ctx, see WithActor,
+// whose reads then go to the primary for a while, see SQLClient.reader.
+func (c *SQLClient) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	if err := setActor(ctx, tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	c.replicas.wrote(ctx)
+	return nil
+}
+
+// runBatch
+// Runs write for each of n items in a single transaction.
+// With atomic, the first failing item rolls back the whole batch,
+// and the other items get ErrBatchAborted.
+// Otherwise each item runs in its own savepoint, so failing items don't affect the others,
+// rolled back to when the item failed, and released after every item.
+// With dryRun, the transaction is always rolled back, after all the items ran.
+//
+// Returns:
+//   - The per item results, in order.
+//
+// Errors:
+//   - When the transaction itself fails (begin/commit/savepoints).
+func (c *SQLClient) runBatch(ctx context.Context, n int, atomic, dryRun bool, write func(tx *sql.Tx, i int) (*UserRow, error)) ([]*BatchResult, error) {
+	results := make([]*BatchResult, n)
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		for i := 0; i < n; i++ {
+			if !atomic {
+				if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+
+			user, err := write(tx, i)
+			results[i] = &BatchResult{User: user, Err: err}
+			if err != nil {
+				results[i].User = nil
+				if atomic {
+					return errBatchItemFailed
+				}
+				if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+			// Released after every item, so the savepoints of a large batch do not nest n levels deep
+			if !atomic {
+				if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	if errors.Is(err, errDryRun) {
+		return results, nil
+	}
+	if errors.Is(err, errBatchItemFailed) {
+		abortBatch(results)
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// errBatchItemFailed
+// Rolls back the transaction of an atomic batch, the item error is kept in its result.
+var errBatchItemFailed = errors.New("batch item failed")
+
+// errDryRun
+// Rolls back the transaction of a dry run batch.
+var errDryRun = errors.New("dry run")
+
+// abortBatch
+// Marks the items of a rolled back batch, that did not fail themselves, with ErrBatchAborted.
+func abortBatch(results []*BatchResult) {
+	for i, result := range results {
+		if result == nil {
+			results[i] = &BatchResult{Err: ErrBatchAborted}
+			continue
+		}
+		if result.Err == nil {
+			result.User = nil
+			result.Err = ErrBatchAborted
+		}
+	}
+}
+
+// BatchCreateUsers
+// Creates the users in a single transaction, see runBatch for atomic.
+// Users with a RequestID are created idempotently, see CreateUserOnce.
+func (c *SQLClient) BatchCreateUsers(ctx context.Context, users []*NewUser, atomic bool) ([]*BatchResult, error) {
+	return c.runBatch(ctx, len(users), atomic, false, func(tx *sql.Tx, i int) (*UserRow, error) {
+		if users[i].RequestID != "" {
+			return insertUserOnce(ctx, tx, users[i], c.IdempotencyWindow)
+		}
+		return insertUser(ctx, tx, users[i])
+	})
+}
+
+// BatchDeleteUsers
+// Soft deletes the users in a single transaction, see runBatch for atomic.
+// Item errors are the ones of DeleteUser.
+func (c *SQLClient) BatchDeleteUsers(ctx context.Context, deletes []*UserDelete, atomic bool) ([]*BatchResult, error) {
+	return c.runBatch(ctx, len(deletes), atomic, false, func(tx *sql.Tx, i int) (*UserRow, error) {
+		return nil, softDeleteUser(ctx, tx, deletes[i].ID, deletes[i].ExpectedVersion)
+	})
+}
+
+// ImportUsers
+// Creates the users in a single transaction, each in its own savepoint,
+// so failing users don't affect the others.
+// With upsert, a user whose email belongs to a live user updates its name, age and labels instead,
+// and its result is marked Updated.
+// With dryRun, the transaction is rolled back, the results are the ones of a real import.
+//
+// Errors (per item):
+//   - ErrDuplicateEmail: When a live user already has the email, without upsert.
+func (c *SQLClient) ImportUsers(ctx context.Context, users []*NewUser, upsert, dryRun bool) ([]*BatchResult, error) {
+	updated := make([]bool, len(users))
+	results, err := c.runBatch(ctx, len(users), false, dryRun, func(tx *sql.Tx, i int) (*UserRow, error) {
+		if !upsert {
+			user, err := insertUser(ctx, tx, users[i])
+			if isUniqueViolation(err) {
+				return nil, ErrDuplicateEmail
+			}
+			return user, err
+		}
+
+		query :=
+			`INSERT INTO users 
+			(id, name, email, age, labels) 
+			VALUES ($1, $2, $3, $4, $5) 
+			ON CONFLICT (email) WHERE deleted_at IS NULL 
+			DO UPDATE SET name = EXCLUDED.name, age = EXCLUDED.age, labels = EXCLUDED.labels 
+			RETURNING ` + userColumns
+		user, err := scanUser(tx.QueryRowContext(ctx, query, NewUserID(), users[i].Name, users[i].Email, users[i].Age, users[i].Labels))
+		if err != nil {
+			return nil, err
+		}
+		// Inserted rows start at version 1, updates increment it
+		updated[i] = user.Version > 1
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		result.Updated = updated[i] && result.Err == nil
+	}
+	return results, nil
+}
+
+// BatchGetUsers
+// Reads the users with the given ids in a single statement.
+// Missing ids are left out of the result, soft deleted users are only returned with showDeleted.
+func (c *SQLClient) BatchGetUsers(ctx context.Context, ids []string, showDeleted bool) ([]*UserRow, error) {
+	query :=
+		`SELECT ` + userColumns + ` 
+		FROM users 
+		WHERE id = ANY($1) AND ($2 OR deleted_at IS NULL)`
+	rows, err := c.DB.QueryContext(ctx, query, pq.Array(ids), showDeleted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*UserRow
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
@@ -0,0 +1,260 @@
+package database
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"grpc-services/user/config"
+)
+
+// CachedClient
+// Wraps a SQLClientInterface with a read-through LRU cache of GetUser, every other method goes to the wrapped client.
+// Users are cached for cfg.CacheTTL, unknown ids for cfg.CacheNegativeTTL,
+// and the writes of this client to existing users invalidate them right away.
+// Writes of other service instances are only seen once their users expire, or by reads bypassing the cache (WithPrimaryReads).
+type CachedClient struct {
+	SQLClientInterface
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+
+	mu sync.Mutex
+	// entries holds the *cacheEntry, most recently used first
+	entries *list.List
+	byID    map[string]*list.Element
+	// generation changes with every invalidation, so a read started before one is not cached
+	generation uint64
+	stats      CacheStats
+}
+
+// CacheStats
+// The counters of a CachedClient, since it was created.
+type CacheStats struct {
+	// Hits are the GetUser calls answered by the cache, NegativeHits the ones of them answered not found.
+	Hits         int64
+	NegativeHits int64
+	// Misses are the GetUser calls that read the wrapped client, and cached what they read.
+	Misses int64
+	// Bypasses are the GetUser calls that read the primary through the cache, see WithPrimaryReads.
+	Bypasses int64
+	// Evictions are the entries dropped to stay within the size limit.
+	Evictions int64
+}
+
+// cacheEntry
+// A cached user, nil when the id was not found.
+type cacheEntry struct {
+	id      string
+	user    *UserRow
+	expires time.Time
+}
+
+// NewCachedClient
+// Wraps db with a cache of at most cfg.CacheSize users, which must be positive.
+//
+// Returns:
+//   - *CachedClient
+func NewCachedClient(db SQLClientInterface, cfg *config.Config) *CachedClient {
+	return &CachedClient{
+		SQLClientInterface: db,
+		size:               cfg.CacheSize,
+		ttl:                cfg.CacheTTL,
+		negativeTTL:        cfg.CacheNegativeTTL,
+		entries:            list.New(),
+		byID:               make(map[string]*list.Element),
+	}
+}
+
+// Stats
+// Returns the current counters.
+func (c *CachedClient) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// GetUser
+// Soft deleted users are only returned with showDeleted.
+// Users are cached whether deleted or not, so both reads share their entry.
+// Reads that must go to the primary (WithPrimaryReads) bypass the cache: they read through, and are not stored.
+// Misses are read from the primary too, so the cache only holds primary reads, made after the writes it invalidated:
+// a caller reading after its write through this client sees it, whatever the replication lag.
+// The returned user is a copy, callers may change it.
+func (c *CachedClient) GetUser(ctx context.Context, id string, showDeleted bool) (*UserRow, error) {
+	if PrimaryReads(ctx) {
+		c.mu.Lock()
+		c.stats.Bypasses++
+		c.mu.Unlock()
+		return c.SQLClientInterface.GetUser(ctx, id, showDeleted)
+	}
+
+	entry, generation, ok := c.lookup(id)
+	if ok {
+		return visibleUser(entry.user, showDeleted)
+	}
+
+	user, err := c.SQLClientInterface.GetUser(WithPrimaryReads(ctx), id, true)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Cached as not found
+		user = nil
+	} else if err != nil {
+		return nil, err
+	}
+	c.store(id, user, generation)
+	return visibleUser(user, showDeleted)
+}
+
+// visibleUser
+// Returns a copy of user, unless it is missing, or soft deleted without showDeleted.
+func visibleUser(user *UserRow, showDeleted bool) (*UserRow, error) {
+	if user == nil || (user.DeletedAt != nil && !showDeleted) {
+		return nil, sql.ErrNoRows
+	}
+	return cloneUser(user), nil
+}
+
+// lookup
+// Returns the live entry of id, counting the hit or the miss.
+//
+// Returns:
+//   - The entry, and true, on a hit.
+//   - The current generation, to store the read of a miss with.
+func (c *CachedClient) lookup(id string) (*cacheEntry, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.byID[id]; ok {
+		entry := element.Value.(*cacheEntry)
+		if time.Now().Before(entry.expires) {
+			c.entries.MoveToFront(element)
+			c.stats.Hits++
+			if entry.user == nil {
+				c.stats.NegativeHits++
+			}
+			return entry, c.generation, true
+		}
+		c.remove(element)
+	}
+	c.stats.Misses++
+	return nil, c.generation, false
+}
+
+// store
+// Caches the user read for id, nil when it was not found,
+// unless an invalidation happened since generation, as the read may predate the write.
+// Evicts the least recently used entries beyond the size limit.
+func (c *CachedClient) store(id string, user *UserRow, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation != c.generation {
+		return
+	}
+
+	ttl := c.ttl
+	if user == nil {
+		ttl = c.negativeTTL
+	}
+	if element, ok := c.byID[id]; ok {
+		c.remove(element)
+	}
+	c.byID[id] = c.entries.PushFront(&cacheEntry{id: id, user: user, expires: time.Now().Add(ttl)})
+
+	for c.entries.Len() > c.size {
+		c.remove(c.entries.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove
+// Drops an entry, with c.mu held.
+func (c *CachedClient) remove(element *list.Element) {
+	c.entries.Remove(element)
+	delete(c.byID, element.Value.(*cacheEntry).id)
+}
+
+// invalidate
+// Drops the entries of ids, and makes the reads in flight skip the cache.
+func (c *CachedClient) invalidate(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for _, id := range ids {
+		if element, ok := c.byID[id]; ok {
+			c.remove(element)
+		}
+	}
+}
+
+// invalidateAll
+// Drops every entry, and makes the reads in flight skip the cache.
+func (c *CachedClient) invalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.entries.Init()
+	clear(c.byID)
+}
+
+// UpdateUser
+// Invalidates the user, even when the update fails, as a failure may come from a stale read.
+func (c *CachedClient) UpdateUser(ctx context.Context, id string, update *UserUpdate) (*UserRow, error) {
+	defer c.invalidate(id)
+	return c.SQLClientInterface.UpdateUser(ctx, id, update)
+}
+
+// DeleteUser
+// Invalidates the user, see UpdateUser.
+func (c *CachedClient) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	defer c.invalidate(id)
+	return c.SQLClientInterface.DeleteUser(ctx, id, expectedVersion)
+}
+
+// UndeleteUser
+// Invalidates the user, see UpdateUser.
+func (c *CachedClient) UndeleteUser(ctx context.Context, id string) (*UserRow, error) {
+	defer c.invalidate(id)
+	return c.SQLClientInterface.UndeleteUser(ctx, id)
+}
+
+// BatchDeleteUsers
+// Invalidates every user of the batch, see UpdateUser.
+func (c *CachedClient) BatchDeleteUsers(ctx context.Context, deletes []*UserDelete, atomic bool) ([]*BatchResult, error) {
+	ids := make([]string, len(deletes))
+	for i, d := range deletes {
+		ids[i] = d.ID
+	}
+	defer c.invalidate(ids...)
+	return c.SQLClientInterface.BatchDeleteUsers(ctx, deletes, atomic)
+}
+
+// ImportUsers
+// Invalidates the users updated by an upsert.
+// Created users have new ids, which can't be cached yet.
+func (c *CachedClient) ImportUsers(ctx context.Context, users []*NewUser, upsert, dryRun bool) ([]*BatchResult, error) {
+	results, err := c.SQLClientInterface.ImportUsers(ctx, users, upsert, dryRun)
+	if upsert && !dryRun {
+		var ids []string
+		for _, result := range results {
+			if result.Updated {
+				ids = append(ids, result.User.ID)
+			}
+		}
+		c.invalidate(ids...)
+	}
+	return results, err
+}
+
+// PurgeDeletedUsers
+// Drops every entry once users were purged, as the purged ids are not returned.
+func (c *CachedClient) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error) {
+	purged, err := c.SQLClientInterface.PurgeDeletedUsers(ctx, retention)
+	if purged > 0 {
+		c.invalidateAll()
+	}
+	return purged, err
+}
@@ -3,8 +3,12 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"grpc-services/user/config"
 
@@ -15,10 +19,19 @@ import (
 // Implements SQLClientInterface
 type SQLClient struct {
 	DB *sql.DB
+
+	// IdempotencyWindow is how long a request id is remembered, see CreateUserOnce.
+	IdempotencyWindow time.Duration
+
+	// connStr opens the dedicated connections of WatchUsers listeners
+	connStr string
+
+	// replicas serve GetUser, ListUsers, CountUsers and ListUsersPage, nil without replicas
+	replicas *replicaSet
 }
 
 // NewPostgresClient
-// Creates the connection to a postgress DB.
+// Creates the connection to a postgress DB, and to its read replicas when cfg.DBReplicas are set.
 // Expects config values to be already checked to not be empty.
 //
 // Returns:
@@ -27,6 +40,7 @@ type SQLClient struct {
 // Error:
 //   - Failed to open sql connection.
 //   - Failed to ping database
+//   - Failed to open a replica, replicas that are down are only ejected, see replicaSet.
 func NewPostgresClient(cfg *config.Config) (*SQLClient, error) {
 	// Create connection string
 	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
@@ -44,67 +58,179 @@ func NewPostgresClient(cfg *config.Config) (*SQLClient, error) {
 	}
 
 	log.Println("Successfully connected to PostgreSQL database")
-	return &SQLClient{DB: db}, nil
+	client := &SQLClient{DB: db, IdempotencyWindow: cfg.IdempotencyWindow, connStr: connStr}
+	if len(cfg.DBReplicas) > 0 {
+		if client.replicas, err = openReplicas(cfg.DBReplicas, cfg.DBReplicaMaxLag, cfg.ReadYourWritesWindow); err != nil {
+			db.Close()
+			return nil, err
+		}
+		log.Printf("Reading from %d replicas", len(cfg.DBReplicas))
+	}
+	return client, nil
+}
+
+// userColumns
+// The users columns, in the order read by scanUser.
+const userColumns = "id, name, email, age, created_at, updated_at, deleted_at, version, labels"
+
+// rowScanner
+// Implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (c *SQLClient) CreateUser(ctx context.Context, name, email string, age int32) (*UserRow, error) {
+// scanUser
+// Reads a row selected with userColumns.
+func scanUser(row rowScanner) (*UserRow, error) {
 	var user UserRow
-	query :=
-		`INSERT INTO users 
-		(name, email, age) 
-		VALUES ($1, $2, $3) 
-	    RETURNING id, name, email, age, created_at, updated_at`
-	err := c.DB.QueryRowContext(ctx, query, name, email, age).Scan(
+	err := row.Scan(
 		&user.ID,
 		&user.Name,
 		&user.Email,
 		&user.Age,
 		&user.CreatedAt,
 		&user.UpdatedAt,
+		&user.DeletedAt,
+		&user.Version,
+		&user.Labels,
 	)
 	return &user, err
 }
 
-func (c *SQLClient) GetUser(ctx context.Context, id int) (*UserRow, error) {
-	var user UserRow
+// querier
+// Implemented by *sql.DB and *sql.Tx,
+// so statements can run on their own or as part of a transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (c *SQLClient) CreateUser(ctx context.Context, user *NewUser) (*UserRow, error) {
+	var created *UserRow
+	err := c.withActor(ctx, func(q querier) error {
+		var err error
+		created, err = insertUser(ctx, q, user)
+		return err
+	})
+	return created, err
+}
+
+// insertUser
+// Inserts a new user using q, with a new id.
+func insertUser(ctx context.Context, q querier, user *NewUser) (*UserRow, error) {
 	query :=
-		`SELECT id, name, email, age, created_at, updated_at 
-		FROM users 
-		WHERE id = $1`
-	err := c.DB.QueryRowContext(ctx, query, id).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Email,
-		&user.Age,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-	return &user, err
+		`INSERT INTO users 
+		(id, name, email, age, labels) 
+		VALUES ($1, $2, $3, $4, $5) 
+	    RETURNING ` + userColumns
+	return scanUser(q.QueryRowContext(ctx, query, NewUserID(), user.Name, user.Email, user.Age, user.Labels))
 }
 
-func (c *SQLClient) UpdateUser(ctx context.Context, id int, name, email string, age int32) (*UserRow, error) {
-	var user UserRow
+// GetUser
+// Soft deleted users are only returned with showDeleted.
+// Reads from a replica, see read.
+func (c *SQLClient) GetUser(ctx context.Context, id string, showDeleted bool) (*UserRow, error) {
+	var user *UserRow
+	err := c.read(ctx, func(q querier) error {
+		var err error
+		user, err = getUser(ctx, q, id, showDeleted)
+		return err
+	})
+	return user, err
+}
+
+// getUser
+// Reads a user using q.
+func getUser(ctx context.Context, q querier, id string, showDeleted bool) (*UserRow, error) {
 	query :=
+		`SELECT ` + userColumns + ` 
+		FROM users 
+		WHERE id = $1 AND ($2 OR deleted_at IS NULL)`
+	return scanUser(q.QueryRowContext(ctx, query, id, showDeleted))
+}
+
+// UpdateUser
+// Updates only the columns set in update, soft deleted users can't be updated.
+// An empty update returns the current row.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist.
+//   - ErrEtagMismatch: When update.ExpectedVersion is set and the row is at another version.
+func (c *SQLClient) UpdateUser(ctx context.Context, id string, update *UserUpdate) (*UserRow, error) {
+	var sets []string
+	var args queryArgs
+	if update.Name != nil {
+		sets = append(sets, "name = "+args.add(*update.Name))
+	}
+	if update.Email != nil {
+		sets = append(sets, "email = "+args.add(*update.Email))
+	}
+	if update.Age != nil {
+		sets = append(sets, "age = "+args.add(*update.Age))
+	}
+	if update.Labels != nil {
+		sets = append(sets, "labels = "+args.add(update.Labels))
+	}
+	if len(sets) == 0 {
+		user, err := c.GetUser(ctx, id, false)
+		if err == nil && update.ExpectedVersion != 0 && user.Version != update.ExpectedVersion {
+			return nil, ErrEtagMismatch
+		}
+		return user, err
+	}
+
+	conds := []string{"id = " + args.add(id), "deleted_at IS NULL"}
+	if update.ExpectedVersion != 0 {
+		conds = append(conds, "version = "+args.add(update.ExpectedVersion))
+	}
+	query := fmt.Sprintf(
 		`UPDATE users 
-		SET name = $1, email = $2, age = $3 WHERE id = $4 
-		RETURNING id, name, email, age, created_at, updated_at`
-	err := c.DB.QueryRowContext(ctx, query, name, email, age, id).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Email,
-		&user.Age,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
-	return &user, err
+		SET %s %s 
+		RETURNING `+userColumns,
+		strings.Join(sets, ", "), whereClause(conds))
+	var user *UserRow
+	err := c.withActor(ctx, func(q querier) error {
+		var err error
+		user, err = scanUser(q.QueryRowContext(ctx, query, args...))
+		if errors.Is(err, sql.ErrNoRows) && update.ExpectedVersion != 0 {
+			return conditionalWriteError(ctx, q, id)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
-func (c *SQLClient) DeleteUser(ctx context.Context, id int) error {
+// DeleteUser
+// Soft deletes a user, by setting its deleted_at.
+// The row is kept until purged by PurgeDeletedUsers.
+// A non zero expectedVersion only deletes the user if it is still at that version.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist.
+//   - ErrEtagMismatch: When expectedVersion is set and the row is at another version.
+func (c *SQLClient) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	return c.withActor(ctx, func(q querier) error {
+		return softDeleteUser(ctx, q, id, expectedVersion)
+	})
+}
+
+// softDeleteUser
+// Soft deletes a user using q, see DeleteUser.
+func softDeleteUser(ctx context.Context, q querier, id string, expectedVersion int64) error {
+	var args queryArgs
+	conds := []string{"id = " + args.add(id), "deleted_at IS NULL"}
+	if expectedVersion != 0 {
+		conds = append(conds, "version = "+args.add(expectedVersion))
+	}
 	query :=
-		`DELETE 
-		FROM users 
-		WHERE id = $1`
-	result, err := c.DB.ExecContext(ctx, query, id)
+		`UPDATE users 
+		SET deleted_at = CURRENT_TIMESTAMP 
+		` + whereClause(conds)
+	result, err := q.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -115,18 +241,149 @@ func (c *SQLClient) DeleteUser(ctx context.Context, id int) error {
 	}
 
 	if rowsAffected == 0 {
+		if expectedVersion != 0 {
+			return conditionalWriteError(ctx, q, id)
+		}
 		return sql.ErrNoRows
 	}
 	return nil
 }
 
-func (c *SQLClient) ListUsers(ctx context.Context, limit, offset int) ([]*UserRow, error) {
+// conditionalWriteError
+// Explains why a conditional write on a live user matched no row.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist.
+//   - ErrEtagMismatch: When it exists, so its version did not match.
+func conditionalWriteError(ctx context.Context, q querier, id string) error {
+	if _, err := getUser(ctx, q, id, false); err != nil {
+		return err
+	}
+	return ErrEtagMismatch
+}
+
+// UndeleteUser
+// Restores a soft deleted user.
+// Fails with a unique violation when a live user took its email meanwhile.
+func (c *SQLClient) UndeleteUser(ctx context.Context, id string) (*UserRow, error) {
 	query :=
-		`SELECT id, name, email, age, created_at, updated_at 
+		`UPDATE users 
+		SET deleted_at = NULL 
+		WHERE id = $1 AND deleted_at IS NOT NULL 
+		RETURNING ` + userColumns
+	var user *UserRow
+	err := c.withActor(ctx, func(q querier) error {
+		var err error
+		user, err = scanUser(q.QueryRowContext(ctx, query, id))
+		return err
+	})
+	return user, err
+}
+
+// PurgeDeletedUsers
+// Hard deletes the users soft deleted for longer than retention.
+//
+// Returns:
+//   - The number of purged users.
+func (c *SQLClient) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error) {
+	query :=
+		`DELETE 
+		FROM users 
+		WHERE deleted_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`
+	result, err := c.DB.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ListUsers
+// Reads from a replica, see read.
+func (c *SQLClient) ListUsers(ctx context.Context, opts *ListOptions) ([]*UserRow, error) {
+	query, args := listUsersQuery(opts)
+	var users []*UserRow
+	err := c.read(ctx, func(q querier) error {
+		var err error
+		users, err = queryUsers(ctx, q, query, args...)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// CountUsers
+// Reads from a replica, see read.
+func (c *SQLClient) CountUsers(ctx context.Context, filter *UserFilter) (int, error) {
+	var count int
+	err := c.read(ctx, func(q querier) error {
+		var err error
+		count, err = countUsers(ctx, q, filter)
+		return err
+	})
+	return count, err
+}
+
+// ListUsersPage
+// Reads the page and its total from a replica, in one read only REPEATABLE READ transaction, see readTx,
+// so the total counts the users of the snapshot the page was read from.
+// TotalEstimate only estimates the total when users has at least estimateMinRows rows, see estimateUsers.
+func (c *SQLClient) ListUsersPage(ctx context.Context, opts *ListOptions) (*UserPage, error) {
+	query, args := listUsersQuery(opts)
+	var page *UserPage
+	err := c.readTx(ctx, func(tx *sql.Tx) error {
+		users, err := queryUsers(ctx, tx, query, args...)
+		if err != nil {
+			return err
+		}
+		page = &UserPage{Users: users, TotalMode: TotalNone}
+		if opts.TotalMode == TotalNone {
+			return nil
+		}
+
+		if opts.TotalMode == TotalEstimate {
+			estimate, ok, err := estimateUsers(ctx, tx, &opts.Filter)
+			if err != nil {
+				return err
+			}
+			if ok {
+				page.Total, page.TotalMode = estimate, TotalEstimate
+				return nil
+			}
+		}
+		page.Total, err = countUsers(ctx, tx, &opts.Filter)
+		page.TotalMode = TotalExact
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// listUsersQuery
+// Returns the query of the page of users selected by opts, and its arguments.
+func listUsersQuery(opts *ListOptions) (string, queryArgs) {
+	var args queryArgs
+	conds := filterConditions(&opts.Filter, &args)
+	if cond := cursorCondition(opts, &args); cond != "" {
+		conds = append(conds, cond)
+	}
+	query := fmt.Sprintf(
+		`SELECT `+userColumns+` 
 	    FROM users 
-		ORDER BY id 
-		LIMIT $1 OFFSET $2`
-	rows, err := c.DB.QueryContext(ctx, query, limit, offset)
+		%s 
+		%s 
+		LIMIT %s OFFSET %s`,
+		whereClause(conds), orderClause(opts), args.add(opts.Limit), args.add(opts.Offset))
+	return query, args
+}
+
+// queryUsers
+// Returns the users read by query, in order.
+func queryUsers(ctx context.Context, q querier, query string, args ...interface{}) ([]*UserRow, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -134,70 +391,118 @@ func (c *SQLClient) ListUsers(ctx context.Context, limit, offset int) ([]*UserRo
 
 	var users []*UserRow
 	for rows.Next() {
-		var user UserRow
-		err := rows.Scan(
-			&user.ID,
-			&user.Name,
-			&user.Email,
-			&user.Age,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
-		users = append(users, &user)
+		users = append(users, user)
 	}
-	return users, nil
+	return users, rows.Err()
 }
 
-func (c *SQLClient) CountUsers(ctx context.Context) (int, error) {
+// countUsers
+// Returns the number of users matching filter.
+func countUsers(ctx context.Context, q querier, filter *UserFilter) (int, error) {
 	var count int
-	query :=
+	var args queryArgs
+	query := fmt.Sprintf(
 		`SELECT COUNT(*) 
-		FROM users`
-	err := c.DB.QueryRowContext(ctx, query).Scan(&count)
+		FROM users 
+		%s`,
+		whereClause(filterConditions(filter, &args)))
+	err := q.QueryRowContext(ctx, query, args...).Scan(&count)
 	return count, err
 }
 
-func (c *SQLClient) CreateTables() error {
-	createTableSQL := `
-	CREATE TABLE IF NOT EXISTS users (
-		id SERIAL PRIMARY KEY,
-		name VARCHAR(100) NOT NULL,
-		email VARCHAR(100) UNIQUE NOT NULL,
-		age INTEGER NOT NULL,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE OR REPLACE FUNCTION update_updated_at_column()
-	RETURNS TRIGGER AS $$
-	BEGIN
-		NEW.updated_at = CURRENT_TIMESTAMP;
-		RETURN NEW;
-	END;
-	$$ language 'plpgsql';
-
-	DROP TRIGGER IF EXISTS update_users_updated_at ON users;
-	CREATE TRIGGER update_users_updated_at
-		BEFORE UPDATE ON users
-		FOR EACH ROW
-		EXECUTE FUNCTION update_updated_at_column();
-	`
-
-	_, err := c.DB.Exec(createTableSQL)
+// estimateMinRows
+// The fewest rows of users, according to its statistics, for TotalEstimate to estimate the total.
+// Smaller tables are cheap to count, and their statistics are less reliable.
+const estimateMinRows = 100000
+
+// estimateUsers
+// Returns the planner estimate of the number of users matching filter,
+// which is pg_class.reltuples of users scaled by the selectivity of the filter, without reading the rows.
+// ok is false when users has fewer than estimateMinRows rows, or was never analyzed, the users should be counted then.
+func estimateUsers(ctx context.Context, q querier, filter *UserFilter) (estimate int, ok bool, err error) {
+	var reltuples float64
+	err = q.QueryRowContext(ctx,
+		`SELECT reltuples 
+		FROM pg_class 
+		WHERE oid = 'users'::regclass`).Scan(&reltuples)
+	if err != nil || reltuples < estimateMinRows {
+		return 0, false, err
+	}
+
+	var args queryArgs
+	query := fmt.Sprintf(
+		`EXPLAIN (FORMAT JSON) SELECT 1 
+		FROM users 
+		%s`,
+		whereClause(filterConditions(filter, &args)))
+	var explained []byte
+	if err := q.QueryRowContext(ctx, query, args...).Scan(&explained); err != nil {
+		return 0, false, err
+	}
+	var plans []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		}
+	}
+	if err := json.Unmarshal(explained, &plans); err != nil || len(plans) == 0 {
+		return 0, false, fmt.Errorf("failed to read the plan of the users count: %v", err)
+	}
+	return int(plans[0].Plan.Rows), true, nil
+}
+
+// ExportUsers
+// Calls fn for every user matching filter, ordered by id.
+// The rows are read from a single query in a read only REPEATABLE READ transaction,
+// so the export is a consistent snapshot however long it takes.
+// Rows are fetched as fn returns, a slow fn slows down the query instead of buffering it.
+//
+// Errors:
+//   - The first error of fn, which stops the export.
+//   - ctx error, when it is done before the export ends.
+func (c *SQLClient) ExportUsers(ctx context.Context, filter *UserFilter, fn func(*UserRow) error) error {
+	tx, err := c.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
 	if err != nil {
-		return fmt.Errorf("failed to create tables: %v", err)
+		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
+	// Read only, there is nothing to commit
+	defer tx.Rollback()
 
-	log.Println("Database tables created/verified successfully")
-	return nil
+	var args queryArgs
+	query := fmt.Sprintf(
+		`SELECT `+userColumns+` 
+		FROM users 
+		%s 
+		ORDER BY id`,
+		whereClause(filterConditions(filter, &args)))
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
 func (c *SQLClient) Close() error {
+	var errs []error
+	if c.replicas != nil {
+		errs = append(errs, c.replicas.close())
+	}
 	if c.DB != nil {
-		return c.DB.Close()
+		errs = append(errs, c.DB.Close())
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -2,16 +2,52 @@ package database
 
 import (
 	"context"
+	"time"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
 // SQLClientInterface
 type SQLClientInterface interface {
-	CreateUser(ctx context.Context, name, email string, age int32) (*UserRow, error)
-	GetUser(ctx context.Context, id int) (*UserRow, error)
-	UpdateUser(ctx context.Context, id int, name, email string, age int32) (*UserRow, error)
-	DeleteUser(ctx context.Context, id int) error
-	ListUsers(ctx context.Context, limit, offset int) ([]*UserRow, error)
-	CountUsers(ctx context.Context) (int, error)
+	CreateUser(ctx context.Context, user *NewUser) (*UserRow, error)
+	CreateUserOnce(ctx context.Context, user *NewUser) (*UserRow, error)
+	GetUser(ctx context.Context, id string, showDeleted bool) (*UserRow, error)
+	LegacyUserIDs(ctx context.Context, legacyIDs []int) (map[int]string, error)
+	GetUserAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*UserRow, error)
+	ListUserHistory(ctx context.Context, opts *HistoryOptions) ([]*HistoryEntry, error)
+	GetUserByEmail(ctx context.Context, email string, showDeleted bool) (*UserRow, error)
+	UpdateUser(ctx context.Context, id string, update *UserUpdate) (*UserRow, error)
+	DeleteUser(ctx context.Context, id string, expectedVersion int64) error
+	UndeleteUser(ctx context.Context, id string) (*UserRow, error)
+	PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error)
+	ListUsers(ctx context.Context, opts *ListOptions) ([]*UserRow, error)
+	CountUsers(ctx context.Context, filter *UserFilter) (int, error)
+	ListUsersPage(ctx context.Context, opts *ListOptions) (*UserPage, error)
+	SearchUsers(ctx context.Context, opts *SearchOptions) ([]*SearchResult, error)
+	ExportUsers(ctx context.Context, filter *UserFilter, fn func(*UserRow) error) error
+	BatchCreateUsers(ctx context.Context, users []*NewUser, atomic bool) ([]*BatchResult, error)
+	BatchGetUsers(ctx context.Context, ids []string, showDeleted bool) ([]*UserRow, error)
+	BatchDeleteUsers(ctx context.Context, deletes []*UserDelete, atomic bool) ([]*BatchResult, error)
+	ImportUsers(ctx context.Context, users []*NewUser, upsert, dryRun bool) ([]*BatchResult, error)
+	WatchUsers(ctx context.Context, afterSeq int64, fn func(*UserEvent) error) error
+	PurgeUserEvents(ctx context.Context, retention time.Duration) (int64, error)
+	PurgeIdempotencyKeys(ctx context.Context, window time.Duration) (int64, error)
+}
+
+// OutboxStore
+// The transactional outbox, implemented by SQLClient only, see RelayOutbox for its relay.
+type OutboxStore interface {
+	PurgeOutbox(ctx context.Context, retention time.Duration) (int64, error)
+}
+
+// WebhookStore
+// The webhooks of the users, implemented by SQLClient only, see ClaimWebhookDeliveries for their deliveries.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, webhook *NewWebhook) (*WebhookRow, error)
+	GetWebhook(ctx context.Context, id string) (*WebhookRow, error)
+	ListWebhooks(ctx context.Context) ([]*WebhookRow, error)
+	EnableWebhook(ctx context.Context, id string) (*WebhookRow, error)
+	DeleteWebhook(ctx context.Context, id string) error
+	ListWebhookAttempts(ctx context.Context, opts *WebhookAttemptOptions) ([]*WebhookAttempt, error)
+	PurgeWebhookDeliveries(ctx context.Context, retention time.Duration) (int64, error)
 }
@@ -2,32 +2,387 @@ package database
 
 import (
 	"fmt"
+	"strconv"
 	"time"
 
 	pb "grpc-services/user/proto"
 
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
 	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
 // UserRow
 // Represent a single DB row.
+// ID is a UUIDv7, see NewUserID.
 type UserRow struct {
-	ID        int
+	ID        string
 	Name      string
 	Email     string
 	Age       int32
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	DeletedAt *time.Time
+	Version   int64
+	Labels    Labels
+}
+
+// NewUser
+// Holds the columns of a user to create.
+// A set RequestID makes the create idempotent, see insertUserOnce.
+// RequestHash fingerprints the request, so a reused RequestID can be told apart from a retry.
+type NewUser struct {
+	Name        string
+	Email       string
+	Age         int32
+	Labels      Labels
+	RequestID   string
+	RequestHash string
+}
+
+// EmailConflict
+// Live users whose emails have the same normalized form, see NormalizeEmails.
+type EmailConflict struct {
+	Email string
+	IDs   []string
+}
+
+// UserDelete
+// Identifies a user to delete.
+// A non zero ExpectedVersion only deletes the user if it is still at that version.
+type UserDelete struct {
+	ID              string
+	ExpectedVersion int64
+}
+
+// BatchResult
+// The outcome of a single item of a batch write, in request order.
+// User is nil for deletes and failed items.
+// Updated is set by imports that updated an existing user, instead of creating one.
+type BatchResult struct {
+	User    *UserRow
+	Updated bool
+	Err     error
+}
+
+// UserUpdate
+// Holds the columns to change on a user row.
+// nil fields are left untouched, a non nil Labels replaces all the labels.
+// A non zero ExpectedVersion only applies the update if the row is still at that version.
+type UserUpdate struct {
+	Name            *string
+	Email           *string
+	Age             *int32
+	Labels          Labels
+	ExpectedVersion int64
+}
+
+// Sortable columns for ListOptions.OrderBy.
+const (
+	OrderByID        = "id"
+	OrderByName      = "name"
+	OrderByEmail     = "email"
+	OrderByAge       = "age"
+	OrderByCreatedAt = "created_at"
+	OrderByUpdatedAt = "updated_at"
+)
+
+// UserFilter
+// Restricts the users returned by ListUsers and counted by CountUsers.
+// Zero values mean no restriction, except for soft deleted users which are only included with ShowDeleted.
+type UserFilter struct {
+	ShowDeleted   bool
+	NamePrefix    string
+	EmailDomain   string
+	MinAge        int32
+	MaxAge        int32
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	Labels        []*LabelRequirement
+}
+
+// ListOptions
+// Selects the page of users returned by ListUsers and ListUsersPage.
+// OrderBy is one of the OrderBy columns (defaults to id), ties are broken by id.
+// AfterID and AfterValue are the keyset cursor (id and OrderBy value of the last row of the previous page),
+// Offset is kept for page based callers.
+// TotalMode is one of the Total modes, how ListUsersPage counts the matching users (defaults to TotalExact).
+type ListOptions struct {
+	Filter     UserFilter
+	OrderBy    string
+	Desc       bool
+	Limit      int
+	Offset     int
+	AfterID    string
+	AfterValue string
+	TotalMode  string
+}
+
+// Total modes for ListOptions.TotalMode and UserPage.TotalMode.
+const (
+	// TotalExact counts the matching users.
+	TotalExact = "exact"
+	// TotalEstimate estimates the matching users from the table statistics, when the backend has them,
+	// and counts them otherwise.
+	TotalEstimate = "estimate"
+	// TotalNone skips the count.
+	TotalNone = "none"
+)
+
+// UserPage
+// A page of users, with the total of the users matching the filter, from the same snapshot.
+// TotalMode is how Total was computed, Total is 0 for TotalNone.
+type UserPage struct {
+	Users     []*UserRow
+	Total     int
+	TotalMode string
+}
+
+// HistoryEntry
+// A recorded change of a user, Type is one of the Event* types, or EventPurged.
+// Before is nil for creates, After is nil for purges, Actor is empty when the writer was unknown.
+type HistoryEntry struct {
+	ID        int64
+	UserID    string
+	Type      string
+	Before    *UserRow
+	After     *UserRow
+	Actor     string
+	ChangedAt time.Time
+}
+
+// HistoryOptions
+// The user and page of ListUserHistory.
+// A non zero BeforeID continues with the entries older than that one.
+type HistoryOptions struct {
+	UserID   string
+	Limit    int
+	BeforeID int64
+}
+
+// SearchOptions
+// The query and page of SearchUsers.
+// A non empty AfterID continues after the result with that id and AfterScore.
+type SearchOptions struct {
+	Query       string
+	ShowDeleted bool
+	Limit       int
+	AfterID     string
+	AfterScore  float64
+}
+
+// SearchResult
+// A user matching a search, Score is between 0 and 1, higher is a better match.
+type SearchResult struct {
+	User  *UserRow
+	Score float64
+}
+
+// SortValue
+// Returns the value of an OrderBy column, as used for ListOptions.AfterValue.
+func (u *UserRow) SortValue(column string) string {
+	switch column {
+	case OrderByName:
+		return u.Name
+	case OrderByEmail:
+		return u.Email
+	case OrderByAge:
+		return strconv.Itoa(int(u.Age))
+	case OrderByCreatedAt:
+		return u.CreatedAt.Format(time.RFC3339Nano)
+	case OrderByUpdatedAt:
+		return u.UpdatedAt.Format(time.RFC3339Nano)
+	default:
+		return u.ID
+	}
 }
 
 // ToProto
 func (u *UserRow) ToProto() *pb.User {
 	return &pb.User{
-		Id:        fmt.Sprintf("%d", u.ID),
+		Id:        u.ID,
 		Name:      u.Name,
 		Email:     u.Email,
 		Age:       u.Age,
 		CreatedAt: u.CreatedAt.Format(time.RFC3339),
 		UpdatedAt: u.UpdatedAt.Format(time.RFC3339),
+		DeletedAt: formatOptionalTime(u.DeletedAt),
+		Etag:      FormatEtag(u.Version),
+		Labels:    u.Labels,
+	}
+}
+
+// UserEvent
+// A row of user_events, the user as written by a single write.
+type UserEvent struct {
+	Sequence int64
+	Type     string
+	User     *UserRow
+}
+
+// Event types, as recorded by the record_user_event trigger
+const (
+	EventCreated = "CREATED"
+	EventUpdated = "UPDATED"
+	EventDeleted = "DELETED"
+)
+
+// EventPurged
+// The history type of a hard deleted user, recorded by the record_user_history trigger,
+// and the record_user_outbox one, but not in user_events.
+const EventPurged = "PURGED"
+
+// OutboxEvent
+// A row of the outbox, the user as written, or purged, by a single write, see SQLClient.RelayOutbox.
+// ID increases with every write, consumers can skip the events they already got.
+type OutboxEvent struct {
+	ID        int64
+	Type      string
+	User      *UserRow
+	CreatedAt time.Time
+	// Attempts is the number of failed attempts to publish the event
+	Attempts int
+}
+
+// NewWebhook
+// The fields of a webhook to create.
+// Empty EventTypes delivers every kind of write.
+type NewWebhook struct {
+	URL        string
+	EventTypes []string
+	Secret     string
+}
+
+// WebhookRow
+// Represent a single row of webhooks.
+// DisabledAt is set once it failed too many deliveries in a row, see SQLClient.RecordWebhookAttempt.
+type WebhookRow struct {
+	ID                  string
+	URL                 string
+	EventTypes          []string
+	Secret              string
+	ConsecutiveFailures int
+	DisabledAt          *time.Time
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+// WebhookDelivery
+// A row of webhook_deliveries, a user write to deliver to a webhook, see SQLClient.ClaimWebhookDeliveries.
+// Retries of a delivery share its ID, endpoints can skip the deliveries they already got.
+type WebhookDelivery struct {
+	ID        int64
+	Webhook   *WebhookRow
+	Type      string
+	User      *UserRow
+	CreatedAt time.Time
+	// Attempts is the number of failed attempts to deliver it
+	Attempts int
+}
+
+// WebhookAttempt
+// A row of webhook_attempts, a single attempt to deliver a WebhookDelivery.
+// StatusCode is 0 when no response was received, Error is empty when it was delivered.
+type WebhookAttempt struct {
+	ID          int64
+	DeliveryID  int64
+	WebhookID   string
+	EventType   string
+	Attempt     int
+	StatusCode  int
+	Error       string
+	Duration    time.Duration
+	AttemptedAt time.Time
+}
+
+// WebhookAttemptOptions
+// The webhook and page of ListWebhookAttempts.
+// A non zero BeforeID continues with the attempts older than that one.
+type WebhookAttemptOptions struct {
+	WebhookID string
+	Limit     int
+	BeforeID  int64
+}
+
+// ToProto
+func (e *UserEvent) ToProto() *pb.UserEvent {
+	return &pb.UserEvent{
+		Sequence: e.Sequence,
+		Type:     pb.UserEventType(pb.UserEventType_value["USER_EVENT_TYPE_"+e.Type]),
+		User:     e.User.ToProto(),
+	}
+}
+
+// ToProto
+func (e *HistoryEntry) ToProto() *pb.UserHistoryEntry {
+	entry := &pb.UserHistoryEntry{
+		Type:      pb.UserEventType(pb.UserEventType_value["USER_EVENT_TYPE_"+e.Type]),
+		Actor:     e.Actor,
+		ChangedAt: timestamppb.New(e.ChangedAt),
+	}
+	if e.Before != nil {
+		entry.Before = e.Before.ToProto()
+	}
+	if e.After != nil {
+		entry.After = e.After.ToProto()
+	}
+	return entry
+}
+
+// FormatEtag
+// Returns the etag of a row version.
+func FormatEtag(version int64) string {
+	return strconv.FormatInt(version, 10)
+}
+
+// ParseEtag
+// Returns the row version of an etag.
+func ParseEtag(etag string) (int64, error) {
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid etag: %q", etag)
+	}
+	return version, nil
+}
+
+// formatOptionalTime
+// Formats t as RFC3339, or returns an empty string when it is nil.
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+// ToProto
+// The secret is left out, it is never returned.
+func (w *WebhookRow) ToProto() *pb.Webhook {
+	webhook := &pb.Webhook{
+		Id:                  w.ID,
+		Url:                 w.URL,
+		Disabled:            w.DisabledAt != nil,
+		ConsecutiveFailures: int32(w.ConsecutiveFailures),
+		CreatedAt:           timestamppb.New(w.CreatedAt),
+		UpdatedAt:           timestamppb.New(w.UpdatedAt),
+	}
+	for _, eventType := range w.EventTypes {
+		webhook.EventTypes = append(webhook.EventTypes, pb.UserEventType(pb.UserEventType_value["USER_EVENT_TYPE_"+eventType]))
+	}
+	return webhook
+}
+
+// ToProto
+func (a *WebhookAttempt) ToProto() *pb.WebhookAttempt {
+	return &pb.WebhookAttempt{
+		DeliveryId:  a.DeliveryID,
+		EventType:   pb.UserEventType(pb.UserEventType_value["USER_EVENT_TYPE_"+a.EventType]),
+		Attempt:     int32(a.Attempt),
+		StatusCode:  int32(a.StatusCode),
+		Error:       a.Error,
+		Duration:    durationpb.New(a.Duration),
+		AttemptedAt: timestamppb.New(a.AttemptedAt),
 	}
 }
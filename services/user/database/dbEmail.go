@@ -0,0 +1,99 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// NormalizeEmail
+// Returns the stored form of an email: trimmed, with a lowercase domain,
+// and a lowercase local part with foldLocalPart.
+// Must stay in sync with normalizedEmailSQL.
+func NormalizeEmail(email string, foldLocalPart bool) string {
+	email = strings.Trim(email, emailSpaces)
+	if foldLocalPart {
+		return strings.ToLower(email)
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	return email[:at+1] + strings.ToLower(email[at+1:])
+}
+
+// emailSpaces
+// The characters trimmed from emails.
+const emailSpaces = " \t\r\n"
+
+// normalizedEmailSQL
+// NormalizeEmail of the email column, $1 is foldLocalPart.
+const normalizedEmailSQL = `CASE 
+		WHEN $1 THEN lower(btrim(email, E' \t\r\n')) 
+		WHEN position('@' IN email) = 0 THEN btrim(email, E' \t\r\n') 
+		ELSE substring(btrim(email, E' \t\r\n') FROM '^(.*)@') || '@' || lower(substring(btrim(email, E' \t\r\n') FROM '@([^@]*)$')) 
+	END`
+
+// GetUserByEmail
+// Reads the live user having email, which is expected to be normalized.
+// With showDeleted, falls back to the most recently deleted user having it.
+func (c *SQLClient) GetUserByEmail(ctx context.Context, email string, showDeleted bool) (*UserRow, error) {
+	query :=
+		`SELECT ` + userColumns + ` 
+		FROM users 
+		WHERE email = $1 AND ($2 OR deleted_at IS NULL) 
+		ORDER BY deleted_at DESC NULLS FIRST 
+		LIMIT 1`
+	return scanUser(c.DB.QueryRowContext(ctx, query, email, showDeleted))
+}
+
+// NormalizeEmails
+// Migrates the emails stored before they were normalized, in a single transaction.
+// Live users whose normalized emails collide are left unchanged,
+// so the unique index still holds, and reported for an operator to resolve.
+//
+// Returns:
+//   - The conflicts, empty when every email was normalized.
+func (c *SQLClient) NormalizeEmails(ctx context.Context, foldLocalPart bool) ([]*EmailConflict, error) {
+	var conflicts []*EmailConflict
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		query :=
+			`SELECT normalized, array_agg(id ORDER BY id) 
+			FROM (SELECT id, ` + normalizedEmailSQL + ` AS normalized FROM users WHERE deleted_at IS NULL) live 
+			GROUP BY normalized 
+			HAVING COUNT(*) > 1 
+			ORDER BY normalized`
+		rows, err := tx.QueryContext(ctx, query, foldLocalPart)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		var emails []string
+		for rows.Next() {
+			conflict := &EmailConflict{}
+			if err := rows.Scan(&conflict.Email, pq.Array(&conflict.IDs)); err != nil {
+				return err
+			}
+			conflicts = append(conflicts, conflict)
+			emails = append(emails, conflict.Email)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		query =
+			`UPDATE users 
+			SET email = ` + normalizedEmailSQL + ` 
+			WHERE email <> ` + normalizedEmailSQL + ` 
+			AND (deleted_at IS NOT NULL OR NOT ` + normalizedEmailSQL + ` = ANY($2))`
+		_, err = tx.ExecContext(ctx, query, foldLocalPart, pq.Array(emails))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ErrEtagMismatch
+// Returned by conditional writes when the row exists,
+// but its version is not the expected one.
+var ErrEtagMismatch = errors.New("etag mismatch")
+
+// ErrBatchAborted
+// Set on the items of an all-or-nothing batch that were rolled back,
+// because another item of the batch failed.
+var ErrBatchAborted = errors.New("batch aborted by another item")
+
+// ErrDuplicateEmail
+// Returned by imports, and by every write of MemoryClient and SQLiteClient, when a live user already has the email.
+var ErrDuplicateEmail = errors.New("email already in use")
+
+// ErrEventsPurged
+// Returned by WatchUsers when the event to resume after was already purged.
+var ErrEventsPurged = errors.New("events after this sequence were purged")
+
+// ErrRequestIDReused
+// Returned by idempotent creates when the request id was already used by a different request.
+var ErrRequestIDReused = errors.New("request id already used by a different request")
+
+// ErrorClass
+// The kind of a database error, so callers can react to it without knowing the driver.
+type ErrorClass int
+
+const (
+	// ClassUnknown is any error not matching another class.
+	ClassUnknown ErrorClass = iota
+	// ClassNotFound is sql.ErrNoRows.
+	ClassNotFound
+	// ClassAlreadyExists is a unique violation, or ErrDuplicateEmail.
+	ClassAlreadyExists
+	// ClassConstraint is any other integrity constraint violation.
+	ClassConstraint
+	// ClassEtagMismatch is ErrEtagMismatch.
+	ClassEtagMismatch
+	// ClassTimeout is a deadline exceeded, or a statement canceled by a timeout.
+	ClassTimeout
+	// ClassCanceled is a canceled context.
+	ClassCanceled
+	// ClassUnavailable is a connection failure, or a server refusing connections.
+	ClassUnavailable
+	// ClassRequestIDReused is ErrRequestIDReused.
+	ClassRequestIDReused
+)
+
+// PostgreSQL error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	pqUniqueViolation     = "23505"
+	pqQueryCanceled       = "57014"
+	pqClassIntegrity      = "23"
+	pqClassConnection     = "08"
+	pqClassResources      = "53"
+	pqClassOperatorAction = "57P"
+)
+
+// Classify
+// Returns the class of an error returned by SQLClient.
+func Classify(err error) ErrorClass {
+	var pqErr *pq.Error
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ClassUnknown
+	case errors.Is(err, sql.ErrNoRows):
+		return ClassNotFound
+	case errors.Is(err, ErrDuplicateEmail), isUniqueViolation(err):
+		return ClassAlreadyExists
+	case errors.Is(err, ErrEtagMismatch):
+		return ClassEtagMismatch
+	case errors.Is(err, ErrRequestIDReused):
+		return ClassRequestIDReused
+	case errors.Is(err, context.DeadlineExceeded):
+		return ClassTimeout
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.As(err, &netErr):
+		return ClassUnavailable
+	case errors.As(err, &pqErr):
+		code := string(pqErr.Code)
+		switch {
+		case code == pqQueryCanceled:
+			return ClassTimeout
+		case strings.HasPrefix(code, pqClassIntegrity):
+			return ClassConstraint
+		case strings.HasPrefix(code, pqClassConnection),
+			strings.HasPrefix(code, pqClassResources),
+			strings.HasPrefix(code, pqClassOperatorAction):
+			return ClassUnavailable
+		}
+	}
+	return ClassUnknown
+}
+
+// EmailConstraint
+// The unique index of the emails of the live users, violated by a duplicate email, see Constraint.
+const EmailConstraint = "users_email_live_idx"
+
+// Constraint
+// Returns the name of the constraint violated by err, if any, EmailConstraint for ErrDuplicateEmail.
+func Constraint(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Constraint
+	}
+	if errors.Is(err, ErrDuplicateEmail) {
+		return EmailConstraint
+	}
+	return ""
+}
+
+// ConstraintTable
+// Returns the table of the constraint violated by err, if any, "users" for ErrDuplicateEmail.
+func ConstraintTable(err error) string {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Table
+	}
+	if errors.Is(err, ErrDuplicateEmail) {
+		return "users"
+	}
+	return ""
+}
+
+// isUniqueViolation
+// Reports whether err is a PostgreSQL unique constraint violation.
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation
+}
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// sortColumns
+// Maps each sortable column to the SQL type used to cast keyset cursor values.
+var sortColumns = map[string]string{
+	OrderByID:        "uuid",
+	OrderByName:      "text",
+	OrderByEmail:     "text",
+	OrderByAge:       "integer",
+	OrderByCreatedAt: "timestamp",
+	OrderByUpdatedAt: "timestamp",
+}
+
+// IsSortColumn
+// Reports whether column can be used as ListOptions.OrderBy.
+func IsSortColumn(column string) bool {
+	_, ok := sortColumns[column]
+	return ok
+}
+
+// queryArgs
+// Collects positional query arguments, handing out their $n placeholders.
+type queryArgs []interface{}
+
+func (a *queryArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("$%d", len(*a))
+}
+
+// filterConditions
+// Returns the WHERE conditions matching the filter, with their values added to args.
+func filterConditions(f *UserFilter, args *queryArgs) []string {
+	var conds []string
+	if !f.ShowDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if f.NamePrefix != "" {
+		conds = append(conds, "name ILIKE "+args.add(escapeLike(f.NamePrefix)+"%"))
+	}
+	if f.EmailDomain != "" {
+		conds = append(conds, "lower(email) LIKE "+args.add("%@"+escapeLike(strings.ToLower(f.EmailDomain))))
+	}
+	if f.MinAge > 0 {
+		conds = append(conds, "age >= "+args.add(f.MinAge))
+	}
+	if f.MaxAge > 0 {
+		conds = append(conds, "age <= "+args.add(f.MaxAge))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, "created_at >= "+args.add(f.CreatedAfter))
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, "created_at < "+args.add(f.CreatedBefore))
+	}
+	if !f.UpdatedAfter.IsZero() {
+		conds = append(conds, "updated_at >= "+args.add(f.UpdatedAfter))
+	}
+	if !f.UpdatedBefore.IsZero() {
+		conds = append(conds, "updated_at < "+args.add(f.UpdatedBefore))
+	}
+	for _, requirement := range f.Labels {
+		conds = append(conds, labelCondition(requirement, args))
+	}
+	return conds
+}
+
+// whereClause
+// Joins conditions into a WHERE clause, or returns an empty string when there are none.
+func whereClause(conds []string) string {
+	if len(conds) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conds, " AND ")
+}
+
+// orderColumn
+// Returns the column to sort by, defaulting to id.
+func orderColumn(opts *ListOptions) string {
+	if IsSortColumn(opts.OrderBy) {
+		return opts.OrderBy
+	}
+	return OrderByID
+}
+
+// cursorCondition
+// Returns the keyset condition selecting rows after the cursor in opts,
+// or an empty string when there is no cursor.
+func cursorCondition(opts *ListOptions, args *queryArgs) string {
+	if opts.AfterID == "" {
+		return ""
+	}
+	op := ">"
+	if opts.Desc {
+		op = "<"
+	}
+	column := orderColumn(opts)
+	if column == OrderByID {
+		return fmt.Sprintf("id %s %s", op, args.add(opts.AfterID))
+	}
+	return fmt.Sprintf("(%s, id) %s (%s::%s, %s)",
+		column, op, args.add(opts.AfterValue), sortColumns[column], args.add(opts.AfterID))
+}
+
+// orderClause
+// Returns the ORDER BY clause for opts, using id as tie breaker.
+func orderClause(opts *ListOptions) string {
+	direction := "ASC"
+	if opts.Desc {
+		direction = "DESC"
+	}
+	column := orderColumn(opts)
+	if column == OrderByID {
+		return fmt.Sprintf("ORDER BY id %s", direction)
+	}
+	return fmt.Sprintf("ORDER BY %s %s, id %s", column, direction, direction)
+}
+
+// escapeLike
+// Escapes the LIKE wildcards in a value.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
@@ -0,0 +1,183 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// actorKey
+// The context key of the actor, see WithActor.
+type actorKey struct{}
+
+// WithActor
+// Returns a copy of ctx carrying actor, the identity recorded in user_history
+// for the writes made with it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor
+// Returns the actor of ctx, empty when unknown.
+func Actor(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// setActor
+// Makes the actor of ctx visible to the record_user_history trigger, until tx ends.
+func setActor(ctx context.Context, tx *sql.Tx) error {
+	actor := Actor(ctx)
+	if actor == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `SELECT set_config('app.actor', $1, true)`, actor)
+	return err
+}
+
+// withActor
+// Runs fn in a transaction when ctx has an actor, so its writes are recorded with it.
+// Otherwise fn runs directly on the database, and the writes are recorded without actor.
+func (c *SQLClient) withActor(ctx context.Context, fn func(q querier) error) error {
+	if Actor(ctx) == "" {
+		if err := fn(c.DB); err != nil {
+			return err
+		}
+		c.replicas.wrote(ctx)
+		return nil
+	}
+	return c.withTx(ctx, func(tx *sql.Tx) error {
+		return fn(tx)
+	})
+}
+
+// historyColumns
+// The user_history columns, with the users columns of its before and after rows,
+// in the order read by scanHistoryEntry.
+const historyColumns = `h.id, h.user_id, h.change_type, h.actor, h.changed_at, 
+	b.id, b.name, b.email, b.age, b.created_at, b.updated_at, b.deleted_at, b.version, b.labels, 
+	a.id, a.name, a.email, a.age, a.created_at, a.updated_at, a.deleted_at, a.version, a.labels`
+
+// historyFrom
+// Selects user_history as h, with its before and after rows as b and a.
+const historyFrom = `user_history h 
+	LEFT JOIN LATERAL jsonb_populate_record(NULL::users, h.before) b ON true 
+	LEFT JOIN LATERAL jsonb_populate_record(NULL::users, h.after) a ON true`
+
+// historyRow
+// The users columns of a before or after row, all NULL when there is no such row.
+type historyRow struct {
+	ID        sql.NullString
+	Name      sql.NullString
+	Email     sql.NullString
+	Age       sql.NullInt32
+	CreatedAt sql.NullTime
+	UpdatedAt sql.NullTime
+	DeletedAt *time.Time
+	Version   sql.NullInt64
+	Labels    Labels
+}
+
+// userRow
+// Returns the user of r, nil when there is no row.
+func (r *historyRow) userRow() *UserRow {
+	if !r.ID.Valid {
+		return nil
+	}
+	return &UserRow{
+		ID:        r.ID.String,
+		Name:      r.Name.String,
+		Email:     r.Email.String,
+		Age:       r.Age.Int32,
+		CreatedAt: r.CreatedAt.Time,
+		UpdatedAt: r.UpdatedAt.Time,
+		DeletedAt: r.DeletedAt,
+		Version:   r.Version.Int64,
+		Labels:    r.Labels,
+	}
+}
+
+// scanHistoryEntry
+// Reads a row selected with historyColumns, Before is nil for creates and After for purges.
+func scanHistoryEntry(row rowScanner) (*HistoryEntry, error) {
+	entry := &HistoryEntry{}
+	var before, after historyRow
+	err := row.Scan(
+		&entry.ID,
+		&entry.UserID,
+		&entry.Type,
+		&entry.Actor,
+		&entry.ChangedAt,
+		&before.ID,
+		&before.Name,
+		&before.Email,
+		&before.Age,
+		&before.CreatedAt,
+		&before.UpdatedAt,
+		&before.DeletedAt,
+		&before.Version,
+		&before.Labels,
+		&after.ID,
+		&after.Name,
+		&after.Email,
+		&after.Age,
+		&after.CreatedAt,
+		&after.UpdatedAt,
+		&after.DeletedAt,
+		&after.Version,
+		&after.Labels,
+	)
+	if err != nil {
+		return nil, err
+	}
+	entry.Before = before.userRow()
+	entry.After = after.userRow()
+	return entry, nil
+}
+
+// ListUserHistory
+// Returns the changes of a user, most recent first.
+// The history of a user is kept after it is purged, its last entry is then the purge.
+func (c *SQLClient) ListUserHistory(ctx context.Context, opts *HistoryOptions) ([]*HistoryEntry, error) {
+	query :=
+		`SELECT ` + historyColumns + ` 
+		FROM ` + historyFrom + ` 
+		WHERE h.user_id = $1 AND ($2 = 0 OR h.id < $2) 
+		ORDER BY h.id DESC 
+		LIMIT $3`
+	rows, err := c.DB.QueryContext(ctx, query, opts.UserID, opts.BeforeID, opts.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*HistoryEntry
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetUserAsOf
+// Reconstructs a user as it was at asOf, from its last change recorded until then.
+// A user soft deleted at asOf is only returned with showDeleted.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user did not exist at asOf, or was already purged.
+func (c *SQLClient) GetUserAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*UserRow, error) {
+	query :=
+		`SELECT ` + userColumns + ` 
+		FROM (
+			SELECT (jsonb_populate_record(NULL::users, after)).* 
+			FROM user_history 
+			WHERE user_id = $1 AND changed_at <= $2 
+			ORDER BY changed_at DESC, id DESC 
+			LIMIT 1
+		) snapshot 
+		WHERE id IS NOT NULL AND ($3 OR deleted_at IS NULL)`
+	return scanUser(c.DB.QueryRowContext(ctx, query, id, asOf, showDeleted))
+}
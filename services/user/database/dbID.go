@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// NewUserID
+// Returns a new UUIDv7 user id.
+// UUIDv7 start with their creation time, so ids sort in creation order,
+// and ids returned by a process are strictly increasing.
+func NewUserID() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// LegacyUserIDs
+// Looks up the ids of the users created with a numeric id, before ids were UUIDs.
+// Their numeric ids are kept in the legacy_id column.
+//
+// Returns:
+//   - The user id of each found legacy id, unknown legacy ids are left out.
+func (c *SQLClient) LegacyUserIDs(ctx context.Context, legacyIDs []int) (map[int]string, error) {
+	query :=
+		`SELECT legacy_id, id 
+		FROM users 
+		WHERE legacy_id = ANY($1)`
+	rows, err := c.DB.QueryContext(ctx, query, pq.Array(legacyIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[int]string, len(legacyIDs))
+	for rows.Next() {
+		var legacyID int
+		var id string
+		if err := rows.Scan(&legacyID, &id); err != nil {
+			return nil, err
+		}
+		ids[legacyID] = id
+	}
+	return ids, rows.Err()
+}
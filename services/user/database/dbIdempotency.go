@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// CreateUserOnce
+// Creates the user, unless user.RequestID was already used in the last window.
+// The request id is recorded in the same transaction as the user,
+// so a failed create can be retried with the same id.
+//
+// Returns:
+//   - The created user, or the one created by the first request with this id.
+//
+// Errors:
+//   - ErrRequestIDReused: When the id was used by a request with another RequestHash.
+//   - sql.ErrNoRows: When the user created by the first request was purged since.
+func (c *SQLClient) CreateUserOnce(ctx context.Context, user *NewUser) (*UserRow, error) {
+	var created *UserRow
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		created, err = insertUserOnce(ctx, tx, user, c.IdempotencyWindow)
+		return err
+	})
+	return created, err
+}
+
+// insertUserOnce
+// Inserts a new user using tx, see CreateUserOnce.
+// A concurrent request with the same id waits on the key row until tx ends,
+// then either replays its user, or creates it if tx was rolled back.
+func insertUserOnce(ctx context.Context, tx *sql.Tx, user *NewUser, window time.Duration) (*UserRow, error) {
+	// Expired keys are forgotten right away, not on the next purge
+	query :=
+		`DELETE 
+		FROM idempotency_keys 
+		WHERE request_id = $1 AND created_at < CURRENT_TIMESTAMP - make_interval(secs => $2)`
+	if _, err := tx.ExecContext(ctx, query, user.RequestID, window.Seconds()); err != nil {
+		return nil, err
+	}
+
+	query =
+		`INSERT INTO idempotency_keys 
+		(request_id, request_hash) 
+		VALUES ($1, $2) 
+		ON CONFLICT (request_id) DO NOTHING`
+	result, err := tx.ExecContext(ctx, query, user.RequestID, user.RequestHash)
+	if err != nil {
+		return nil, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if inserted == 0 {
+		return replayUser(ctx, tx, user)
+	}
+
+	created, err := insertUser(ctx, tx, user)
+	if err != nil {
+		return nil, err
+	}
+	query =
+		`UPDATE idempotency_keys 
+		SET user_id = $2 
+		WHERE request_id = $1`
+	if _, err := tx.ExecContext(ctx, query, user.RequestID, created.ID); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// replayUser
+// Reads the user created by the first request with user.RequestID, soft deleted or not.
+//
+// Errors:
+//   - ErrRequestIDReused: When the first request had another RequestHash.
+//   - sql.ErrNoRows: When the user was purged, which sets the user_id of its key to NULL.
+func replayUser(ctx context.Context, tx *sql.Tx, user *NewUser) (*UserRow, error) {
+	var requestHash string
+	var userID sql.NullString
+	query :=
+		`SELECT request_hash, user_id 
+		FROM idempotency_keys 
+		WHERE request_id = $1`
+	if err := tx.QueryRowContext(ctx, query, user.RequestID).Scan(&requestHash, &userID); err != nil {
+		return nil, err
+	}
+	if requestHash != user.RequestHash {
+		return nil, ErrRequestIDReused
+	}
+	if !userID.Valid {
+		return nil, sql.ErrNoRows
+	}
+	return getUser(ctx, tx, userID.String, true)
+}
+
+// PurgeIdempotencyKeys
+// Deletes the request ids recorded longer than window ago.
+//
+// Returns:
+//   - The number of purged request ids.
+func (c *SQLClient) PurgeIdempotencyKeys(ctx context.Context, window time.Duration) (int64, error) {
+	query :=
+		`DELETE 
+		FROM idempotency_keys 
+		WHERE created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`
+	result, err := c.DB.ExecContext(ctx, query, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
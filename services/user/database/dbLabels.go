@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Labels
+// The labels of a user, stored as a JSONB object.
+type Labels map[string]string
+
+// Scan
+// Implements sql.Scanner, NULL is no labels.
+func (l *Labels) Scan(src interface{}) error {
+	*l = Labels{}
+	switch data := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(data, l)
+	case string:
+		return json.Unmarshal([]byte(data), l)
+	default:
+		return fmt.Errorf("cannot scan %T into labels", src)
+	}
+}
+
+// Value
+// Implements driver.Valuer, nil is an empty object.
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(l)
+}
+
+// Label selector operators of LabelRequirement.
+const (
+	LabelEquals    = "="
+	LabelNotEquals = "!="
+	LabelIn        = "in"
+	LabelNotIn     = "notin"
+	LabelExists    = "exists"
+	LabelNotExists = "!"
+)
+
+// LabelRequirement
+// A single requirement of a label selector, e.g. "team=payments".
+// Values has a single value for LabelEquals and LabelNotEquals, and none for LabelExists and LabelNotExists.
+type LabelRequirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Matches
+// Reports whether labels meet the requirement.
+// As with Kubernetes selectors, negative requirements match users without the label.
+func (r *LabelRequirement) Matches(labels Labels) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case LabelEquals, LabelIn:
+		return ok && slices.Contains(r.Values, value)
+	case LabelNotEquals, LabelNotIn:
+		return !ok || !slices.Contains(r.Values, value)
+	case LabelExists:
+		return ok
+	case LabelNotExists:
+		return !ok
+	}
+	return false
+}
+
+// labelCondition
+// Returns the WHERE condition matching a requirement, with its values added to args.
+// Containment (@>) and key existence (?) are both served by the users_labels_idx GIN index.
+func labelCondition(r *LabelRequirement, args *queryArgs) string {
+	switch r.Operator {
+	case LabelExists:
+		return "labels ? " + args.add(r.Key)
+	case LabelNotExists:
+		return "NOT labels ? " + args.add(r.Key)
+	}
+
+	contains := make([]string, len(r.Values))
+	for i, value := range r.Values {
+		object, _ := json.Marshal(map[string]string{r.Key: value})
+		contains[i] = "labels @> " + args.add(string(object)) + "::jsonb"
+	}
+	cond := "(" + strings.Join(contains, " OR ") + ")"
+	if r.Operator == LabelNotEquals || r.Operator == LabelNotIn {
+		return "NOT " + cond
+	}
+	return cond
+}
@@ -0,0 +1,845 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-services/user/config"
+)
+
+// MemoryClient
+// Implements SQLClientInterface in memory, for demos, local development and hermetic tests.
+// Follows the semantics of SQLClient: emails are unique among live users, ids sort in creation order,
+// writes set the timestamps and version as the users triggers do, and record events and history.
+// Writes are serialized by a mutex, a batch holds it until it ends, so it is never seen half applied.
+// The users are lost when the process stops.
+type MemoryClient struct {
+	// IdempotencyWindow is how long a request id is remembered, see CreateUserOnce.
+	IdempotencyWindow time.Duration
+
+	mu    sync.RWMutex
+	users map[string]*UserRow
+	// liveEmails maps the email of every live user to its id, the users_email_live_idx of SQLClient
+	liveEmails    map[string]string
+	events        []*memoryEvent
+	lastSeq       int64
+	history       []*HistoryEntry
+	lastHistoryID int64
+	keys          map[string]*memoryKey
+	// changed is closed, then replaced, by every write recording events, waking up the watchers
+	changed chan struct{}
+}
+
+// memoryEvent
+// A recorded event, with when it was recorded for PurgeUserEvents.
+type memoryEvent struct {
+	event      *UserEvent
+	recordedAt time.Time
+}
+
+// memoryKey
+// An idempotency key, userID is empty once its user was purged.
+type memoryKey struct {
+	requestHash string
+	userID      string
+	createdAt   time.Time
+}
+
+// NewMemoryClient
+// Creates an empty in-memory store.
+func NewMemoryClient(cfg *config.Config) *MemoryClient {
+	return &MemoryClient{
+		IdempotencyWindow: cfg.IdempotencyWindow,
+		users:             make(map[string]*UserRow),
+		liveEmails:        make(map[string]string),
+		keys:              make(map[string]*memoryKey),
+		changed:           make(chan struct{}),
+	}
+}
+
+// memoryNow
+// Returns the current time, at the microsecond precision of PostgreSQL timestamps.
+func memoryNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// memoryTx
+// The writes of a single operation on a MemoryClient, made while holding its lock.
+// Every write records how to undo it, so the operation can be rolled back, or a batch item rolled back to a savepoint.
+// now is the time of every write, as CURRENT_TIMESTAMP is the start of the transaction.
+type memoryTx struct {
+	c       *MemoryClient
+	now     time.Time
+	actor   string
+	undo    []func()
+	changed bool
+}
+
+// write
+// Runs fn holding the write lock, its writes are kept when fn succeeds and undone otherwise.
+// The writes of fn are recorded with the actor of ctx, see WithActor.
+func (c *MemoryClient) write(ctx context.Context, fn func(tx *memoryTx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	tx := &memoryTx{c: c, now: memoryNow(), actor: Actor(ctx)}
+	if err := fn(tx); err != nil {
+		tx.rollbackTo(0)
+		return err
+	}
+	if tx.changed {
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}
+	return nil
+}
+
+// rollbackTo
+// Undoes the writes made after savepoint, a length of the undo log, the last one first.
+func (tx *memoryTx) rollbackTo(savepoint int) {
+	for i := len(tx.undo) - 1; i >= savepoint; i-- {
+		tx.undo[i]()
+	}
+	tx.undo = tx.undo[:savepoint]
+}
+
+// put
+// Stores user under id, nil removes it, keeping liveEmails in sync.
+func (tx *memoryTx) put(id string, user *UserRow) {
+	c := tx.c
+	previous := c.users[id]
+	c.setUser(id, user)
+	tx.undo = append(tx.undo, func() { c.setUser(id, previous) })
+}
+
+// setUser
+// Stores user under id, nil removes it, keeping liveEmails in sync.
+func (c *MemoryClient) setUser(id string, user *UserRow) {
+	if previous := c.users[id]; previous != nil && previous.DeletedAt == nil {
+		delete(c.liveEmails, previous.Email)
+	}
+	if user == nil {
+		delete(c.users, id)
+		return
+	}
+	c.users[id] = user
+	if user.DeletedAt == nil {
+		c.liveEmails[user.Email] = id
+	}
+}
+
+// setKey
+// Stores the idempotency key of requestID, nil removes it.
+func (tx *memoryTx) setKey(requestID string, key *memoryKey) {
+	c := tx.c
+	previous, existed := c.keys[requestID]
+	if key == nil {
+		delete(c.keys, requestID)
+	} else {
+		c.keys[requestID] = key
+	}
+	tx.undo = append(tx.undo, func() {
+		if existed {
+			c.keys[requestID] = previous
+		} else {
+			delete(c.keys, requestID)
+		}
+	})
+}
+
+// record
+// Records a write in the events and the history, as the record_user_event and record_user_history triggers do.
+// Purges only have a before row, and are not events.
+// Like sequences, the event sequences and history ids of rolled back writes are not reused.
+func (tx *memoryTx) record(kind string, before, after *UserRow) {
+	c := tx.c
+	events, history := len(c.events), len(c.history)
+	userID := ""
+	if after != nil {
+		userID = after.ID
+		c.lastSeq++
+		c.events = append(c.events, &memoryEvent{
+			event:      &UserEvent{Sequence: c.lastSeq, Type: kind, User: after},
+			recordedAt: tx.now,
+		})
+		tx.changed = true
+	} else {
+		userID = before.ID
+	}
+	c.lastHistoryID++
+	c.history = append(c.history, &HistoryEntry{
+		ID:        c.lastHistoryID,
+		UserID:    userID,
+		Type:      kind,
+		Before:    before,
+		After:     after,
+		Actor:     tx.actor,
+		ChangedAt: tx.now,
+	})
+	tx.undo = append(tx.undo, func() {
+		c.events = c.events[:events]
+		c.history = c.history[:history]
+	})
+}
+
+// insert
+// Creates a new user, with a new id.
+//
+// Errors:
+//   - ErrDuplicateEmail: When a live user already has the email.
+func (tx *memoryTx) insert(user *NewUser) (*UserRow, error) {
+	row := &UserRow{
+		ID:        NewUserID(),
+		Name:      user.Name,
+		Email:     user.Email,
+		Age:       user.Age,
+		CreatedAt: tx.now,
+		UpdatedAt: tx.now,
+		Version:   1,
+		Labels:    copyLabels(user.Labels),
+	}
+	if err := tx.checkEmail(row); err != nil {
+		return nil, err
+	}
+	tx.put(row.ID, row)
+	tx.record(EventCreated, nil, row)
+	return cloneUser(row), nil
+}
+
+// change
+// Writes a copy of current changed by apply, with the next version and updated_at,
+// as the users triggers do.
+//
+// Errors:
+//   - ErrDuplicateEmail: When the changed user is live, and another live user has its email.
+func (tx *memoryTx) change(current *UserRow, apply func(user *UserRow)) (*UserRow, error) {
+	row := cloneUser(current)
+	apply(row)
+	row.Version = current.Version + 1
+	row.UpdatedAt = tx.now
+	if err := tx.checkEmail(row); err != nil {
+		return nil, err
+	}
+
+	kind := EventUpdated
+	if current.DeletedAt == nil && row.DeletedAt != nil {
+		kind = EventDeleted
+	}
+	tx.put(row.ID, row)
+	tx.record(kind, current, row)
+	return cloneUser(row), nil
+}
+
+// checkEmail
+// Enforces the uniqueness of emails among live users.
+//
+// Errors:
+//   - ErrDuplicateEmail: When user is live, and another live user has its email.
+func (tx *memoryTx) checkEmail(user *UserRow) error {
+	if user.DeletedAt != nil {
+		return nil
+	}
+	if id, taken := tx.c.liveEmails[user.Email]; taken && id != user.ID {
+		return ErrDuplicateEmail
+	}
+	return nil
+}
+
+// live
+// Returns the live user with id, in a conditional write at expectedVersion when it is not zero.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist, or is soft deleted.
+//   - ErrEtagMismatch: When expectedVersion is set and the user is at another version.
+func (tx *memoryTx) live(id string, expectedVersion int64) (*UserRow, error) {
+	user := tx.c.users[id]
+	if user == nil || user.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	if expectedVersion != 0 && user.Version != expectedVersion {
+		return nil, ErrEtagMismatch
+	}
+	return user, nil
+}
+
+// softDelete
+// Soft deletes a user, see DeleteUser.
+func (tx *memoryTx) softDelete(id string, expectedVersion int64) error {
+	current, err := tx.live(id, expectedVersion)
+	if err != nil {
+		return err
+	}
+	_, err = tx.change(current, func(user *UserRow) {
+		deletedAt := tx.now
+		user.DeletedAt = &deletedAt
+	})
+	return err
+}
+
+// insertOnce
+// Creates a new user, unless user.RequestID was already used in the last window, see CreateUserOnce.
+func (tx *memoryTx) insertOnce(user *NewUser, window time.Duration) (*UserRow, error) {
+	key := tx.c.keys[user.RequestID]
+	// Expired keys are forgotten right away, not on the next purge
+	if key != nil && key.createdAt.Before(tx.now.Add(-window)) {
+		tx.setKey(user.RequestID, nil)
+		key = nil
+	}
+	if key != nil {
+		if key.requestHash != user.RequestHash {
+			return nil, ErrRequestIDReused
+		}
+		created := tx.c.users[key.userID]
+		if created == nil {
+			return nil, sql.ErrNoRows
+		}
+		return cloneUser(created), nil
+	}
+
+	created, err := tx.insert(user)
+	if err != nil {
+		return nil, err
+	}
+	tx.setKey(user.RequestID, &memoryKey{requestHash: user.RequestHash, userID: created.ID, createdAt: tx.now})
+	return created, nil
+}
+
+// cloneUser
+// Returns a deep copy of user, so callers never share the stored rows.
+func cloneUser(user *UserRow) *UserRow {
+	clone := *user
+	if user.DeletedAt != nil {
+		deletedAt := *user.DeletedAt
+		clone.DeletedAt = &deletedAt
+	}
+	clone.Labels = copyLabels(user.Labels)
+	return &clone
+}
+
+// copyLabels
+// Returns a copy of labels, never nil, as labels read from the database are.
+func copyLabels(labels Labels) Labels {
+	clone := make(Labels, len(labels))
+	maps.Copy(clone, labels)
+	return clone
+}
+
+func (c *MemoryClient) CreateUser(ctx context.Context, user *NewUser) (*UserRow, error) {
+	var created *UserRow
+	err := c.write(ctx, func(tx *memoryTx) error {
+		var err error
+		created, err = tx.insert(user)
+		return err
+	})
+	return created, err
+}
+
+// CreateUserOnce
+// Creates the user, unless user.RequestID was already used in the last window, see SQLClient.CreateUserOnce.
+func (c *MemoryClient) CreateUserOnce(ctx context.Context, user *NewUser) (*UserRow, error) {
+	var created *UserRow
+	err := c.write(ctx, func(tx *memoryTx) error {
+		var err error
+		created, err = tx.insertOnce(user, c.IdempotencyWindow)
+		return err
+	})
+	return created, err
+}
+
+// GetUser
+// Soft deleted users are only returned with showDeleted.
+func (c *MemoryClient) GetUser(ctx context.Context, id string, showDeleted bool) (*UserRow, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	user := c.users[id]
+	if user == nil || (user.DeletedAt != nil && !showDeleted) {
+		return nil, sql.ErrNoRows
+	}
+	return cloneUser(user), nil
+}
+
+// LegacyUserIDs
+// Users created in memory never had a numeric id, every legacy id is unknown.
+func (c *MemoryClient) LegacyUserIDs(ctx context.Context, legacyIDs []int) (map[int]string, error) {
+	return map[int]string{}, nil
+}
+
+// GetUserByEmail
+// Reads the live user having email, which is expected to be normalized.
+// With showDeleted, falls back to the most recently deleted user having it.
+func (c *MemoryClient) GetUserByEmail(ctx context.Context, email string, showDeleted bool) (*UserRow, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if id, ok := c.liveEmails[email]; ok {
+		return cloneUser(c.users[id]), nil
+	}
+	if !showDeleted {
+		return nil, sql.ErrNoRows
+	}
+
+	var found *UserRow
+	for _, user := range c.users {
+		if user.Email == email && (found == nil || user.DeletedAt.After(*found.DeletedAt)) {
+			found = user
+		}
+	}
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+	return cloneUser(found), nil
+}
+
+// UpdateUser
+// Updates only the fields set in update, soft deleted users can't be updated.
+// An empty update returns the current user.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist.
+//   - ErrEtagMismatch: When update.ExpectedVersion is set and the user is at another version.
+//   - ErrDuplicateEmail: When another live user has the new email.
+func (c *MemoryClient) UpdateUser(ctx context.Context, id string, update *UserUpdate) (*UserRow, error) {
+	if update.Name == nil && update.Email == nil && update.Age == nil && update.Labels == nil {
+		user, err := c.GetUser(ctx, id, false)
+		if err == nil && update.ExpectedVersion != 0 && user.Version != update.ExpectedVersion {
+			return nil, ErrEtagMismatch
+		}
+		return user, err
+	}
+
+	var updated *UserRow
+	err := c.write(ctx, func(tx *memoryTx) error {
+		current, err := tx.live(id, update.ExpectedVersion)
+		if err != nil {
+			return err
+		}
+		updated, err = tx.change(current, func(user *UserRow) {
+			if update.Name != nil {
+				user.Name = *update.Name
+			}
+			if update.Email != nil {
+				user.Email = *update.Email
+			}
+			if update.Age != nil {
+				user.Age = *update.Age
+			}
+			if update.Labels != nil {
+				user.Labels = copyLabels(update.Labels)
+			}
+		})
+		return err
+	})
+	return updated, err
+}
+
+// DeleteUser
+// Soft deletes a user, by setting its DeletedAt.
+// A non zero expectedVersion only deletes the user if it is still at that version.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist.
+//   - ErrEtagMismatch: When expectedVersion is set and the user is at another version.
+func (c *MemoryClient) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	return c.write(ctx, func(tx *memoryTx) error {
+		return tx.softDelete(id, expectedVersion)
+	})
+}
+
+// UndeleteUser
+// Restores a soft deleted user.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist, or is not deleted.
+//   - ErrDuplicateEmail: When a live user took its email meanwhile.
+func (c *MemoryClient) UndeleteUser(ctx context.Context, id string) (*UserRow, error) {
+	var restored *UserRow
+	err := c.write(ctx, func(tx *memoryTx) error {
+		current := c.users[id]
+		if current == nil || current.DeletedAt == nil {
+			return sql.ErrNoRows
+		}
+		var err error
+		restored, err = tx.change(current, func(user *UserRow) {
+			user.DeletedAt = nil
+		})
+		return err
+	})
+	return restored, err
+}
+
+// PurgeDeletedUsers
+// Hard deletes the users soft deleted for longer than retention.
+// Their history is kept, ending with the purge, and their idempotency keys no longer replay them.
+//
+// Returns:
+//   - The number of purged users.
+func (c *MemoryClient) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error) {
+	var purged int64
+	err := c.write(ctx, func(tx *memoryTx) error {
+		cutoff := tx.now.Add(-retention)
+		for _, id := range slices.Sorted(maps.Keys(c.users)) {
+			user := c.users[id]
+			if user.DeletedAt == nil || !user.DeletedAt.Before(cutoff) {
+				continue
+			}
+			tx.put(id, nil)
+			tx.record(EventPurged, user, nil)
+			purged++
+		}
+		for requestID, key := range c.keys {
+			if key.userID != "" && c.users[key.userID] == nil {
+				tx.setKey(requestID, &memoryKey{requestHash: key.requestHash, createdAt: key.createdAt})
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// ListUsers
+// Returns the page of users selected by opts, see ListOptions.
+// Text columns are sorted by bytes, while PostgreSQL sorts them by the collation of the database.
+//
+// Errors:
+//   - When opts.AfterValue is not a value of the OrderBy column.
+func (c *MemoryClient) ListUsers(ctx context.Context, opts *ListOptions) ([]*UserRow, error) {
+	page, err := c.ListUsersPage(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return page.Users, nil
+}
+
+// ListUsersPage
+// Returns the page of users selected by opts and their total, read under the same lock, see ListUsers.
+// The total is counted while the page is selected, there are no statistics to estimate it from.
+func (c *MemoryClient) ListUsersPage(ctx context.Context, opts *ListOptions) (*UserPage, error) {
+	column := orderColumn(opts)
+	var cursor *UserRow
+	if opts.AfterID != "" {
+		var err error
+		if cursor, err = cursorUser(column, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var users []*UserRow
+	total := 0
+	for _, user := range c.users {
+		if !matchesFilter(user, &opts.Filter) {
+			continue
+		}
+		total++
+		if cursor != nil {
+			order := compareUsers(column, user, cursor)
+			if (!opts.Desc && order <= 0) || (opts.Desc && order >= 0) {
+				continue
+			}
+		}
+		users = append(users, user)
+	}
+	slices.SortFunc(users, func(a, b *UserRow) int {
+		if opts.Desc {
+			return compareUsers(column, b, a)
+		}
+		return compareUsers(column, a, b)
+	})
+
+	start := min(max(opts.Offset, 0), len(users))
+	end := min(start+max(opts.Limit, 0), len(users))
+	page := &UserPage{Users: make([]*UserRow, 0, end-start), Total: total, TotalMode: TotalExact}
+	for _, user := range users[start:end] {
+		page.Users = append(page.Users, cloneUser(user))
+	}
+	if opts.TotalMode == TotalNone {
+		page.Total, page.TotalMode = 0, TotalNone
+	}
+	return page, nil
+}
+
+// cursorUser
+// Returns a user holding the keyset cursor of opts, in its id and column.
+func cursorUser(column string, opts *ListOptions) (*UserRow, error) {
+	cursor := &UserRow{ID: opts.AfterID}
+	var err error
+	switch column {
+	case OrderByName:
+		cursor.Name = opts.AfterValue
+	case OrderByEmail:
+		cursor.Email = opts.AfterValue
+	case OrderByAge:
+		var age int64
+		age, err = strconv.ParseInt(opts.AfterValue, 10, 32)
+		cursor.Age = int32(age)
+	case OrderByCreatedAt:
+		cursor.CreatedAt, err = time.Parse(time.RFC3339Nano, opts.AfterValue)
+	case OrderByUpdatedAt:
+		cursor.UpdatedAt, err = time.Parse(time.RFC3339Nano, opts.AfterValue)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor value %q for %s: %v", opts.AfterValue, column, err)
+	}
+	return cursor, nil
+}
+
+// compareUsers
+// Compares two users by column, then by id.
+// Ids are lowercase UUID strings, so they compare as the uuid column does.
+func compareUsers(column string, a, b *UserRow) int {
+	var order int
+	switch column {
+	case OrderByName:
+		order = strings.Compare(a.Name, b.Name)
+	case OrderByEmail:
+		order = strings.Compare(a.Email, b.Email)
+	case OrderByAge:
+		order = int(a.Age) - int(b.Age)
+	case OrderByCreatedAt:
+		order = a.CreatedAt.Compare(b.CreatedAt)
+	case OrderByUpdatedAt:
+		order = a.UpdatedAt.Compare(b.UpdatedAt)
+	}
+	if order != 0 {
+		return order
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// matchesFilter
+// Reports whether user is selected by f, as filterConditions does.
+func matchesFilter(user *UserRow, f *UserFilter) bool {
+	switch {
+	case !f.ShowDeleted && user.DeletedAt != nil,
+		f.NamePrefix != "" && !strings.HasPrefix(strings.ToLower(user.Name), strings.ToLower(f.NamePrefix)),
+		f.EmailDomain != "" && !strings.HasSuffix(strings.ToLower(user.Email), "@"+strings.ToLower(f.EmailDomain)),
+		f.MinAge > 0 && user.Age < f.MinAge,
+		f.MaxAge > 0 && user.Age > f.MaxAge,
+		!f.CreatedAfter.IsZero() && user.CreatedAt.Before(f.CreatedAfter),
+		!f.CreatedBefore.IsZero() && !user.CreatedAt.Before(f.CreatedBefore),
+		!f.UpdatedAfter.IsZero() && user.UpdatedAt.Before(f.UpdatedAfter),
+		!f.UpdatedBefore.IsZero() && !user.UpdatedAt.Before(f.UpdatedBefore):
+		return false
+	}
+	for _, requirement := range f.Labels {
+		if !requirement.Matches(user.Labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *MemoryClient) CountUsers(ctx context.Context, filter *UserFilter) (int, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var count int
+	for _, user := range c.users {
+		if matchesFilter(user, filter) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ExportUsers
+// Calls fn for every user matching filter, ordered by id.
+// The users are copied when the export starts, so it is a consistent snapshot however long it takes,
+// and fn runs without holding the lock.
+//
+// Errors:
+//   - The first error of fn, which stops the export.
+//   - ctx error, when it is done before the export ends.
+func (c *MemoryClient) ExportUsers(ctx context.Context, filter *UserFilter, fn func(*UserRow) error) error {
+	c.mu.RLock()
+	var users []*UserRow
+	for _, user := range c.users {
+		if matchesFilter(user, filter) {
+			users = append(users, user)
+		}
+	}
+	c.mu.RUnlock()
+	slices.SortFunc(users, func(a, b *UserRow) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	for _, user := range users {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(cloneUser(user)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBatch
+// Runs write for each of n items, holding the write lock for the whole batch.
+// With atomic, the first failing item rolls back the whole batch,
+// and the other items get ErrBatchAborted.
+// Otherwise a failing item only rolls back its own writes.
+// With dryRun, the batch is always rolled back, after all the items ran.
+//
+// Returns:
+//   - The per item results, in order.
+func (c *MemoryClient) runBatch(ctx context.Context, n int, atomic, dryRun bool, write func(tx *memoryTx, i int) (*UserRow, error)) ([]*BatchResult, error) {
+	results := make([]*BatchResult, n)
+	err := c.write(ctx, func(tx *memoryTx) error {
+		for i := 0; i < n; i++ {
+			savepoint := len(tx.undo)
+			user, err := write(tx, i)
+			results[i] = &BatchResult{User: user, Err: err}
+			if err != nil {
+				results[i].User = nil
+				if atomic {
+					return errBatchItemFailed
+				}
+				tx.rollbackTo(savepoint)
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	if errors.Is(err, errDryRun) {
+		return results, nil
+	}
+	if errors.Is(err, errBatchItemFailed) {
+		abortBatch(results)
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// BatchCreateUsers
+// Creates the users at once, see runBatch for atomic.
+// Users with a RequestID are created idempotently, see CreateUserOnce.
+func (c *MemoryClient) BatchCreateUsers(ctx context.Context, users []*NewUser, atomic bool) ([]*BatchResult, error) {
+	return c.runBatch(ctx, len(users), atomic, false, func(tx *memoryTx, i int) (*UserRow, error) {
+		if users[i].RequestID != "" {
+			return tx.insertOnce(users[i], c.IdempotencyWindow)
+		}
+		return tx.insert(users[i])
+	})
+}
+
+// BatchGetUsers
+// Reads the users with the given ids.
+// Missing ids are left out of the result, soft deleted users are only returned with showDeleted.
+func (c *MemoryClient) BatchGetUsers(ctx context.Context, ids []string, showDeleted bool) ([]*UserRow, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var users []*UserRow
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		user := c.users[id]
+		if seen[id] || user == nil || (user.DeletedAt != nil && !showDeleted) {
+			continue
+		}
+		seen[id] = true
+		users = append(users, cloneUser(user))
+	}
+	return users, nil
+}
+
+// BatchDeleteUsers
+// Soft deletes the users at once, see runBatch for atomic.
+// Item errors are the ones of DeleteUser.
+func (c *MemoryClient) BatchDeleteUsers(ctx context.Context, deletes []*UserDelete, atomic bool) ([]*BatchResult, error) {
+	return c.runBatch(ctx, len(deletes), atomic, false, func(tx *memoryTx, i int) (*UserRow, error) {
+		return nil, tx.softDelete(deletes[i].ID, deletes[i].ExpectedVersion)
+	})
+}
+
+// ImportUsers
+// Creates the users at once, failing users don't affect the others.
+// With upsert, a user whose email belongs to a live user updates its name, age and labels instead,
+// and its result is marked Updated.
+// With dryRun, the import is rolled back, the results are the ones of a real import.
+//
+// Errors (per item):
+//   - ErrDuplicateEmail: When a live user already has the email, without upsert.
+func (c *MemoryClient) ImportUsers(ctx context.Context, users []*NewUser, upsert, dryRun bool) ([]*BatchResult, error) {
+	updated := make([]bool, len(users))
+	results, err := c.runBatch(ctx, len(users), false, dryRun, func(tx *memoryTx, i int) (*UserRow, error) {
+		id, taken := c.liveEmails[users[i].Email]
+		if !upsert || !taken {
+			return tx.insert(users[i])
+		}
+		updated[i] = true
+		return tx.change(c.users[id], func(user *UserRow) {
+			user.Name = users[i].Name
+			user.Age = users[i].Age
+			user.Labels = copyLabels(users[i].Labels)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		result.Updated = updated[i] && result.Err == nil
+	}
+	return results, nil
+}
+
+// PurgeIdempotencyKeys
+// Deletes the request ids recorded longer than window ago.
+//
+// Returns:
+//   - The number of purged request ids.
+func (c *MemoryClient) PurgeIdempotencyKeys(ctx context.Context, window time.Duration) (int64, error) {
+	var purged int64
+	err := c.write(ctx, func(tx *memoryTx) error {
+		cutoff := tx.now.Add(-window)
+		for requestID, key := range c.keys {
+			if key.createdAt.Before(cutoff) {
+				tx.setKey(requestID, nil)
+				purged++
+			}
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// SearchUsers
+// Returns the users whose name or email match opts.Query, best matches first (ties by id),
+// scored in Go, see searchScore.
+func (c *MemoryClient) SearchUsers(ctx context.Context, opts *SearchOptions) ([]*SearchResult, error) {
+	queryWords := searchWords(opts.Query)
+
+	c.mu.RLock()
+	var results []*SearchResult
+	for _, user := range c.users {
+		if user.DeletedAt != nil && !opts.ShowDeleted {
+			continue
+		}
+		score, ok := searchScore(opts.Query, queryWords, user)
+		if ok && afterSearchCursor(opts, score, user.ID) {
+			results = append(results, &SearchResult{User: user, Score: score})
+		}
+	}
+	c.mu.RUnlock()
+
+	results = pageSearchResults(results, opts)
+	for _, result := range results {
+		result.User = cloneUser(result.User)
+	}
+	return results, nil
+}
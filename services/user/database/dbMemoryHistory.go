@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// cloneHistoryEntry
+// Returns a deep copy of entry, so callers never share the stored rows.
+func cloneHistoryEntry(entry *HistoryEntry) *HistoryEntry {
+	clone := *entry
+	if entry.Before != nil {
+		clone.Before = cloneUser(entry.Before)
+	}
+	if entry.After != nil {
+		clone.After = cloneUser(entry.After)
+	}
+	return &clone
+}
+
+// ListUserHistory
+// Returns the changes of a user, most recent first.
+// The history of a user is kept after it is purged, its last entry is then the purge.
+func (c *MemoryClient) ListUserHistory(ctx context.Context, opts *HistoryOptions) ([]*HistoryEntry, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var entries []*HistoryEntry
+	for i := len(c.history) - 1; i >= 0 && len(entries) < opts.Limit; i-- {
+		entry := c.history[i]
+		if entry.UserID == opts.UserID && (opts.BeforeID == 0 || entry.ID < opts.BeforeID) {
+			entries = append(entries, cloneHistoryEntry(entry))
+		}
+	}
+	return entries, nil
+}
+
+// GetUserAsOf
+// Reconstructs a user as it was at asOf, from its last change recorded until then.
+// A user soft deleted at asOf is only returned with showDeleted.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user did not exist at asOf, or was already purged.
+func (c *MemoryClient) GetUserAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*UserRow, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var last *HistoryEntry
+	for _, entry := range c.history {
+		if entry.UserID != id || entry.ChangedAt.After(asOf) {
+			continue
+		}
+		if last == nil || !entry.ChangedAt.Before(last.ChangedAt) {
+			last = entry
+		}
+	}
+	if last == nil || last.After == nil || (last.After.DeletedAt != nil && !showDeleted) {
+		return nil, sql.ErrNoRows
+	}
+	return cloneUser(last.After), nil
+}
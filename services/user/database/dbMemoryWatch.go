@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+// WatchUsers
+// Calls fn for every user event after afterSeq, until ctx is done.
+// A zero afterSeq starts with the events of the writes made after the call.
+// Writes are serialized, so events are recorded in sequence order, and a watcher waits for the next write
+// instead of polling.
+//
+// Errors:
+//   - ErrEventsPurged: When the event afterSeq was already purged.
+//   - The first error of fn, which stops the watch.
+//   - ctx error, when it is done.
+func (c *MemoryClient) WatchUsers(ctx context.Context, afterSeq int64, fn func(*UserEvent) error) error {
+	c.mu.RLock()
+	cursor := afterSeq
+	if afterSeq == 0 {
+		cursor = c.lastSeq
+	} else if _, found := c.findEvent(afterSeq); !found {
+		c.mu.RUnlock()
+		return ErrEventsPurged
+	}
+	c.mu.RUnlock()
+
+	for {
+		c.mu.RLock()
+		i, _ := c.findEvent(cursor + 1)
+		pending := slices.Clone(c.events[i:])
+		changed := c.changed
+		c.mu.RUnlock()
+
+		for _, recorded := range pending {
+			event := *recorded.event
+			event.User = cloneUser(event.User)
+			if err := fn(&event); err != nil {
+				return err
+			}
+			cursor = event.Sequence
+		}
+
+		// Closed already when events were recorded since they were read
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// findEvent
+// Returns the index of the first event at seq or after it, and whether it is at seq.
+// Expects the lock to be held.
+func (c *MemoryClient) findEvent(seq int64) (int, bool) {
+	i := sort.Search(len(c.events), func(i int) bool {
+		return c.events[i].event.Sequence >= seq
+	})
+	return i, i < len(c.events) && c.events[i].event.Sequence == seq
+}
+
+// PurgeUserEvents
+// Deletes the events recorded longer than retention ago.
+//
+// Returns:
+//   - The number of purged events.
+func (c *MemoryClient) PurgeUserEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	var purged int64
+	err := c.write(ctx, func(tx *memoryTx) error {
+		cutoff := tx.now.Add(-retention)
+		kept := c.events[:0:0]
+		for _, recorded := range c.events {
+			if recorded.recordedAt.Before(cutoff) {
+				purged++
+				continue
+			}
+			kept = append(kept, recorded)
+		}
+		c.events = kept
+		return nil
+	})
+	return purged, err
+}
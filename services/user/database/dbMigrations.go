@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+
+	"grpc-services/migrate"
+)
+
+// migrationFiles
+// The numbered up and down SQL files of the schema, see migrate.Load.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Data types of the baseline columns, as named by information_schema.
+const (
+	typeBigint    = "bigint"
+	typeInteger   = "integer"
+	typeJSONB     = "jsonb"
+	typeText      = "text"
+	typeTimestamp = "timestamp without time zone"
+	typeTSVector  = "tsvector"
+	typeUUID      = "uuid"
+	typeVarchar   = "character varying"
+	typeXID8      = "xid8"
+)
+
+// baselines
+// The schemas created by CreateTables before the migrations:
+//   - Version 0, the first schema, with SERIAL ids and only the users table. 0001 upgrades it in place.
+//   - Version 4, the last one, with every table of 0001 to 0004.
+//
+// Other schemas fail to migrate, see migrate.ErrUnknownSchema.
+var baselines = []*migrate.Baseline{
+	{Version: 0, Columns: map[string]string{
+		"users.id":         typeInteger,
+		"users.name":       typeVarchar,
+		"users.email":      typeVarchar,
+		"users.age":        typeInteger,
+		"users.created_at": typeTimestamp,
+		"users.updated_at": typeTimestamp,
+	}},
+	{Version: 4, Columns: map[string]string{
+		"users.id":                      typeUUID,
+		"users.legacy_id":               typeInteger,
+		"users.name":                    typeVarchar,
+		"users.email":                   typeVarchar,
+		"users.age":                     typeInteger,
+		"users.created_at":              typeTimestamp,
+		"users.updated_at":              typeTimestamp,
+		"users.deleted_at":              typeTimestamp,
+		"users.version":                 typeBigint,
+		"users.labels":                  typeJSONB,
+		"users.search_vector":           typeTSVector,
+		"user_events.seq":               typeBigint,
+		"user_events.event_type":        typeVarchar,
+		"user_events.id":                typeUUID,
+		"user_events.name":              typeVarchar,
+		"user_events.email":             typeVarchar,
+		"user_events.age":               typeInteger,
+		"user_events.created_at":        typeTimestamp,
+		"user_events.updated_at":        typeTimestamp,
+		"user_events.deleted_at":        typeTimestamp,
+		"user_events.version":           typeBigint,
+		"user_events.labels":            typeJSONB,
+		"user_events.recorded_at":       typeTimestamp,
+		"user_events.txid":              typeXID8,
+		"user_history.id":               typeBigint,
+		"user_history.user_id":          typeUUID,
+		"user_history.change_type":      typeVarchar,
+		"user_history.before":           typeJSONB,
+		"user_history.after":            typeJSONB,
+		"user_history.actor":            typeText,
+		"user_history.changed_at":       typeTimestamp,
+		"idempotency_keys.request_id":   typeVarchar,
+		"idempotency_keys.request_hash": typeVarchar,
+		"idempotency_keys.user_id":      typeUUID,
+		"idempotency_keys.created_at":   typeTimestamp,
+	}},
+}
+
+// Migrations
+// Returns the migrations of the user schema, by version.
+func Migrations() ([]*migrate.Migration, error) {
+	files, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return migrate.Load(files)
+}
+
+// Migrator
+// Returns the migrator of the user schema, locked with the "user schema" advisory lock.
+func (c *SQLClient) Migrator() (*migrate.Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return &migrate.Migrator{
+		DB:         c.DB,
+		Migrations: migrations,
+		LockName:   "user schema",
+		Baselines:  baselines,
+	}, nil
+}
+
+// CreateTables
+// Applies the pending migrations.
+func (c *SQLClient) CreateTables() error {
+	migrator, err := c.Migrator()
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %v", err)
+	}
+	applied, err := migrator.Up(context.Background())
+	for _, migration := range applied {
+		log.Printf("Applied migration %s", migration)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to migrate: %v", err)
+	}
+
+	log.Println("Database schema is up to date")
+	return nil
+}
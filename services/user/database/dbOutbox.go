@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// outboxLockName
+// The advisory lock held by a relay pass, so a single service instance relays at a time, in order.
+const outboxLockName = "user outbox"
+
+// outboxMaxBackoff
+// The longest wait before publishing a failed event again.
+const outboxMaxBackoff = 5 * time.Minute
+
+// outboxColumns
+// The outbox columns, with the users columns of its payload, in the order read by readOutboxEvents.
+const outboxColumns = `o.id, o.event_type, o.created_at, o.attempts, 
+	u.id, u.name, u.email, u.age, u.created_at, u.updated_at, u.deleted_at, u.version, u.labels`
+
+// RelayOutbox
+// Publishes the due outbox events with publish, oldest first, up to limit, and marks the published ones, see PurgeOutbox.
+// An event that fails is retried after a backoff, the later events of its user wait for it,
+// so the events of a user are published in order, while the other users go on.
+// Events are marked in the transaction that read them, after being published:
+// when it fails to commit, they are published again (at least once).
+// A pass runs under an advisory lock, it does nothing while another one runs.
+//
+// Returns:
+//   - The number of published events.
+//
+// Errors:
+//   - When the outbox can't be read or updated, publish errors are recorded on their events.
+func (c *SQLClient) RelayOutbox(ctx context.Context, limit int, publish func(*OutboxEvent) error) (int, error) {
+	tx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	// Rolled back unless committed
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, outboxLockName).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		return 0, nil
+	}
+
+	query :=
+		`SELECT ` + outboxColumns + ` 
+		FROM outbox o 
+		JOIN LATERAL jsonb_populate_record(NULL::users, o.payload) u ON true 
+		WHERE o.published_at IS NULL AND o.next_attempt_at <= CURRENT_TIMESTAMP 
+		AND NOT EXISTS (
+			SELECT 1 FROM outbox earlier 
+			WHERE earlier.user_id = o.user_id AND earlier.id < o.id 
+			AND earlier.published_at IS NULL AND earlier.next_attempt_at > CURRENT_TIMESTAMP 
+		) 
+		ORDER BY o.id 
+		LIMIT $1`
+	events, err := readOutboxEvents(ctx, tx, query, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	// The users with a failed event, whose later events wait for it
+	failed := make(map[string]bool)
+	var published []int64
+	for _, event := range events {
+		if failed[event.User.ID] {
+			continue
+		}
+		if err := publish(event); err != nil {
+			failed[event.User.ID] = true
+			query :=
+				`UPDATE outbox 
+				SET attempts = attempts + 1, last_error = $2, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3) 
+				WHERE id = $1`
+			if _, err := tx.ExecContext(ctx, query, event.ID, err.Error(), retryBackoff(event.Attempts, outboxMaxBackoff).Seconds()); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		published = append(published, event.ID)
+	}
+
+	query =
+		`UPDATE outbox 
+		SET published_at = CURRENT_TIMESTAMP 
+		WHERE id = ANY($1)`
+	if _, err := tx.ExecContext(ctx, query, pq.Array(published)); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(published), nil
+}
+
+// PurgeOutbox
+// Deletes the outbox events published longer than retention ago.
+// The unpublished events are kept until published, however old.
+//
+// Returns:
+//   - The number of purged events.
+func (c *SQLClient) PurgeOutbox(ctx context.Context, retention time.Duration) (int64, error) {
+	query :=
+		`DELETE 
+		FROM outbox 
+		WHERE published_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`
+	result, err := c.DB.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// retryBackoff
+// Returns the wait before retrying an outbox event or webhook delivery that failed after attempts previous failures:
+// a second, doubled on every failure, up to maxBackoff.
+func retryBackoff(attempts int, maxBackoff time.Duration) time.Duration {
+	backoff := time.Second
+	for i := 0; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
+
+// readOutboxEvents
+// Runs a query selecting outboxColumns.
+func readOutboxEvents(ctx context.Context, q querier, query string, args ...interface{}) ([]*OutboxEvent, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*OutboxEvent
+	for rows.Next() {
+		event := &OutboxEvent{User: &UserRow{}}
+		err := rows.Scan(
+			&event.ID,
+			&event.Type,
+			&event.CreatedAt,
+			&event.Attempts,
+			&event.User.ID,
+			&event.User.Name,
+			&event.User.Email,
+			&event.User.Age,
+			&event.User.CreatedAt,
+			&event.User.UpdatedAt,
+			&event.User.DeletedAt,
+			&event.User.Version,
+			&event.User.Labels,
+		)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,288 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaCheckInterval
+// How often the health of the replicas is checked.
+const replicaCheckInterval = 5 * time.Second
+
+// replicaLagQuery
+// Returns the replication lag of a replica in seconds, 0 when it replayed everything it received, or is not a replica.
+// The time since the last replayed transaction alone would grow while the primary is idle.
+const replicaLagQuery = `SELECT COALESCE(CASE 
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 
+	ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) 
+	END, 0)::float8`
+
+// primaryReadsKey
+// The context key of WithPrimaryReads.
+type primaryReadsKey struct{}
+
+// WithPrimaryReads
+// Returns ctx whose reads go to the primary, so they see every committed write, whatever the replication lag.
+func WithPrimaryReads(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryReadsKey{}, true)
+}
+
+// PrimaryReads
+// Reports whether the reads of ctx must go to the primary, see WithPrimaryReads.
+func PrimaryReads(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryReadsKey{}).(bool)
+	return primary
+}
+
+// replica
+// A read replica, serving reads while healthy.
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+	// index identifies the replica in the logs, without the credentials of its DSN
+	index int
+}
+
+// replicaSet
+// The read replicas of a SQLClient, checked every replicaCheckInterval.
+// A replica is ejected when it fails a check, lags more than maxLag, or a read on it fails to reach it,
+// and readmitted once it passes a check again.
+// Callers with an actor read from the primary for stickiness after their writes, see SQLClient.reader.
+type replicaSet struct {
+	replicas   []*replica
+	next       atomic.Uint64
+	maxLag     time.Duration
+	stickiness time.Duration
+
+	mu sync.Mutex
+	// lastWrites holds the time of the last write of every actor, within stickiness
+	lastWrites map[string]time.Time
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// openReplicas
+// Opens the replicas at dsns, all healthy until their first check, and starts checking them.
+//
+// Errors:
+//   - Failed to open a replica, a replica that is down is only ejected.
+func openReplicas(dsns []string, maxLag, stickiness time.Duration) (*replicaSet, error) {
+	set := &replicaSet{
+		maxLag:     maxLag,
+		stickiness: stickiness,
+		lastWrites: make(map[string]time.Time),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	for i, dsn := range dsns {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			set.closeReplicas()
+			return nil, fmt.Errorf("failed to open replica %d: %v", i, err)
+		}
+		r := &replica{db: db, index: i}
+		r.healthy.Store(true)
+		set.replicas = append(set.replicas, r)
+	}
+
+	set.checkAll()
+	go set.run()
+	return set, nil
+}
+
+// run
+// Checks the replicas every replicaCheckInterval, until close.
+func (s *replicaSet) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.checkAll()
+			s.forgetWrites()
+		}
+	}
+}
+
+// checkAll
+// Checks every replica, ejecting or readmitting it.
+func (s *replicaSet) checkAll() {
+	for _, r := range s.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+		err := s.check(ctx, r)
+		cancel()
+		if err != nil {
+			s.eject(r, err)
+		} else if !r.healthy.Swap(true) {
+			log.Printf("Replica %d is healthy again, readmitted", r.index)
+		}
+	}
+}
+
+// check
+// Reads the replication lag of r.
+//
+// Errors:
+//   - When r can't be reached, or lags more than maxLag.
+func (s *replicaSet) check(ctx context.Context, r *replica) error {
+	var lag float64
+	if err := r.db.QueryRowContext(ctx, replicaLagQuery).Scan(&lag); err != nil {
+		return err
+	}
+	if d := time.Duration(lag * float64(time.Second)); d > s.maxLag {
+		return fmt.Errorf("replication lag of %v exceeds %v", d.Round(time.Millisecond), s.maxLag)
+	}
+	return nil
+}
+
+// eject
+// Stops reading from r until it passes a check again.
+func (s *replicaSet) eject(r *replica, reason error) {
+	if r.healthy.Swap(false) {
+		log.Printf("Replica %d ejected: %v", r.index, reason)
+	}
+}
+
+// pick
+// Returns the next healthy replica, round robin, nil when there is none.
+func (s *replicaSet) pick() *replica {
+	n := uint64(len(s.replicas))
+	start := s.next.Add(1)
+	for i := uint64(0); i < n; i++ {
+		if r := s.replicas[(start+i)%n]; r.healthy.Load() {
+			return r
+		}
+	}
+	return nil
+}
+
+// wrote
+// Records a write of the actor of ctx, whose reads then go to the primary for stickiness.
+// Writes without actor are not recorded: anonymous callers can't be told apart,
+// they need WithPrimaryReads to read their writes.
+func (s *replicaSet) wrote(ctx context.Context) {
+	actor := Actor(ctx)
+	if s == nil || actor == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastWrites[actor] = time.Now()
+}
+
+// sticky
+// Reports whether the actor of ctx wrote within stickiness, never for callers without actor.
+func (s *replicaSet) sticky(ctx context.Context) bool {
+	actor := Actor(ctx)
+	if actor == "" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, ok := s.lastWrites[actor]
+	return ok && time.Since(last) < s.stickiness
+}
+
+// forgetWrites
+// Drops the writes older than stickiness.
+func (s *replicaSet) forgetWrites() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for actor, last := range s.lastWrites {
+		if time.Since(last) >= s.stickiness {
+			delete(s.lastWrites, actor)
+		}
+	}
+}
+
+// close
+// Stops the checks and closes the replicas.
+func (s *replicaSet) close() error {
+	close(s.stop)
+	<-s.done
+	return s.closeReplicas()
+}
+
+// closeReplicas
+// Closes the connections of every replica.
+func (s *replicaSet) closeReplicas() error {
+	var errs []error
+	for _, r := range s.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// read
+// Runs fn on a healthy replica, or on the primary when there is none, see reader.
+// A replica that can't be reached is ejected, and fn runs again on the primary.
+func (c *SQLClient) read(ctx context.Context, fn func(q querier) error) error {
+	return c.readOn(ctx, func(db *sql.DB) error {
+		return fn(db)
+	})
+}
+
+// readTx
+// Runs fn in a read only REPEATABLE READ transaction, on a replica or on the primary, see read.
+// Every statement of fn sees the same snapshot, hot standbys allow these transactions too.
+func (c *SQLClient) readTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return c.readOn(ctx, func(db *sql.DB) error {
+		tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			return err
+		}
+		// Read only, there is nothing to commit
+		defer tx.Rollback()
+		return fn(tx)
+	})
+}
+
+// readOn
+// Runs fn on the database read and readTx run on.
+func (c *SQLClient) readOn(ctx context.Context, fn func(db *sql.DB) error) error {
+	r := c.reader(ctx)
+	if r == nil {
+		return fn(c.DB)
+	}
+
+	err := fn(r.db)
+	if !isConnectionError(ctx, err) {
+		return err
+	}
+	c.replicas.eject(r, err)
+	return fn(c.DB)
+}
+
+// reader
+// Returns the replica to read from, nil to read from the primary:
+// when there are no healthy replicas, ctx asks for it (WithPrimaryReads),
+// or the actor of ctx wrote within the stickiness window, callers without actor have none.
+func (c *SQLClient) reader(ctx context.Context) *replica {
+	if c.replicas == nil || PrimaryReads(ctx) || c.replicas.sticky(ctx) {
+		return nil
+	}
+	return c.replicas.pick()
+}
+
+// isConnectionError
+// Reports whether err failed to reach the database, rather than being an error of the query or ctx.
+func isConnectionError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.As(err, &netErr)
+}
@@ -0,0 +1,789 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"grpc-services/user/config"
+
+	"github.com/mattn/go-sqlite3" // SQLite driver, requires cgo
+)
+
+// sqliteSchema
+// The users schema in the SQLite dialect, created if missing by CreateTables.
+//
+//go:embed sqlite/schema.sql
+var sqliteSchema string
+
+// sqliteTimeFormat
+// The format of the timestamps stored by SQLiteClient: UTC, with a fixed number of digits, so they sort as text.
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// SQLiteClient
+// Implements SQLClientInterface on an embedded SQLite file, for single node deployments and CI.
+// Follows the semantics of SQLClient, but SQLite triggers can't change the written row,
+// so updated_at, version, the events and the history are written in the transaction of each write.
+// SQLite has a single writer: writes are serialized on the single connection of DB,
+// reads run concurrently on other connections, from WAL snapshots.
+type SQLiteClient struct {
+	DB *sql.DB
+
+	// IdempotencyWindow is how long a request id is remembered, see CreateUserOnce.
+	IdempotencyWindow time.Duration
+
+	// readDB runs the reads, without waiting for the writes
+	readDB *sql.DB
+
+	mu sync.Mutex
+	// changed is closed, then replaced, by every committed write recording events, waking up the watchers
+	changed chan struct{}
+}
+
+// NewSQLiteClient
+// Opens the SQLite file at cfg.SQLitePath, creating it if missing, in WAL mode.
+//
+// Returns:
+//   - *SQLiteClient
+//
+// Error:
+//   - Failed to open the file.
+func NewSQLiteClient(cfg *config.Config) (*SQLiteClient, error) {
+	// Waits for the locks of other processes, and enforces the idempotency_keys foreign key
+	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on", cfg.SQLitePath)
+
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+	// Creates the file and switches it to WAL before the readers open it
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	readDB, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+
+	log.Printf("Successfully opened SQLite database %s", cfg.SQLitePath)
+	return &SQLiteClient{
+		DB:                db,
+		IdempotencyWindow: cfg.IdempotencyWindow,
+		readDB:            readDB,
+		changed:           make(chan struct{}),
+	}, nil
+}
+
+// CreateTables
+// Creates the missing tables and indexes of the SQLite schema.
+func (c *SQLiteClient) CreateTables() error {
+	if _, err := c.DB.Exec(sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create tables: %v", err)
+	}
+	log.Println("Database schema is up to date")
+	return nil
+}
+
+func (c *SQLiteClient) Close() error {
+	return errors.Join(c.readDB.Close(), c.DB.Close())
+}
+
+// sqliteArgs
+// Collects positional query arguments, handing out their ?n placeholders.
+type sqliteArgs []interface{}
+
+func (a *sqliteArgs) add(value interface{}) string {
+	*a = append(*a, value)
+	return fmt.Sprintf("?%d", len(*a))
+}
+
+// formatSQLiteTime
+// Returns t as stored by SQLiteClient, see sqliteTimeFormat.
+func formatSQLiteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// parseSQLiteTime
+// Returns a time stored by SQLiteClient.
+func parseSQLiteTime(value string) (time.Time, error) {
+	return time.Parse(sqliteTimeFormat, value)
+}
+
+// sqliteNow
+// Returns the current time, at the precision of sqliteTimeFormat.
+func sqliteNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// labelsJSON
+// Returns labels as stored by SQLiteClient, a JSON object text, as JSON blobs are read as JSONB by SQLite.
+func labelsJSON(labels Labels) string {
+	if labels == nil {
+		return "{}"
+	}
+	data, _ := json.Marshal(labels)
+	return string(data)
+}
+
+// scanSQLiteUser
+// Reads a row selected with userColumns from SQLite, after the columns scanned into leading, if any.
+func scanSQLiteUser(row rowScanner, leading ...interface{}) (*UserRow, error) {
+	var user UserRow
+	var createdAt, updatedAt string
+	var deletedAt sql.NullString
+	err := row.Scan(append(leading,
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Age,
+		&createdAt,
+		&updatedAt,
+		&deletedAt,
+		&user.Version,
+		&user.Labels,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	if user.CreatedAt, err = parseSQLiteTime(createdAt); err != nil {
+		return nil, err
+	}
+	if user.UpdatedAt, err = parseSQLiteTime(updatedAt); err != nil {
+		return nil, err
+	}
+	if deletedAt.Valid {
+		t, err := parseSQLiteTime(deletedAt.String)
+		if err != nil {
+			return nil, err
+		}
+		user.DeletedAt = &t
+	}
+	return &user, nil
+}
+
+// scanSQLiteUsers
+// Reads all the rows selected with userColumns from SQLite.
+func scanSQLiteUsers(rows *sql.Rows) ([]*UserRow, error) {
+	defer rows.Close()
+	var users []*UserRow
+	for rows.Next() {
+		user, err := scanSQLiteUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// isSQLiteUniqueViolation
+// Reports whether err is a SQLite unique constraint violation.
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// sqliteTx
+// A write transaction of SQLiteClient.
+// now is the time of every write, as CURRENT_TIMESTAMP is the start of the transaction in PostgreSQL.
+type sqliteTx struct {
+	*sql.Tx
+	ctx      context.Context
+	now      time.Time
+	actor    string
+	recorded bool
+}
+
+// withTx
+// Runs fn in a write transaction, committed when fn succeeds and rolled back otherwise.
+// The writes of fn are recorded with the actor of ctx, see WithActor.
+func (c *SQLiteClient) withTx(ctx context.Context, fn func(tx *sqliteTx) error) error {
+	sqlTx, err := c.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	tx := &sqliteTx{Tx: sqlTx, ctx: ctx, now: sqliteNow(), actor: Actor(ctx)}
+	if err := fn(tx); err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	if err := sqlTx.Commit(); err != nil {
+		return err
+	}
+	if tx.recorded {
+		c.notify()
+	}
+	return nil
+}
+
+// notify
+// Wakes up the watchers.
+func (c *SQLiteClient) notify() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// changes
+// Returns the channel closed by the next committed write recording events.
+func (c *SQLiteClient) changes() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.changed
+}
+
+// get
+// Reads a user in tx.
+func (tx *sqliteTx) get(id string, showDeleted bool) (*UserRow, error) {
+	query :=
+		`SELECT ` + userColumns + ` 
+		FROM users 
+		WHERE id = ?1 AND (?2 OR deleted_at IS NULL)`
+	return scanSQLiteUser(tx.QueryRowContext(tx.ctx, query, id, showDeleted))
+}
+
+// live
+// Reads the live user with id, for a conditional write at expectedVersion when it is not zero.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist, or is soft deleted.
+//   - ErrEtagMismatch: When expectedVersion is set and the user is at another version.
+func (tx *sqliteTx) live(id string, expectedVersion int64) (*UserRow, error) {
+	user, err := tx.get(id, false)
+	if err != nil {
+		return nil, err
+	}
+	if expectedVersion != 0 && user.Version != expectedVersion {
+		return nil, ErrEtagMismatch
+	}
+	return user, nil
+}
+
+// insert
+// Inserts a new user, with a new id.
+//
+// Errors:
+//   - ErrDuplicateEmail: When a live user already has the email.
+func (tx *sqliteTx) insert(user *NewUser) (*UserRow, error) {
+	query :=
+		`INSERT INTO users 
+		(id, name, email, age, created_at, updated_at, labels) 
+		VALUES (?1, ?2, ?3, ?4, ?5, ?5, ?6) 
+		RETURNING ` + userColumns
+	now := formatSQLiteTime(tx.now)
+	created, err := scanSQLiteUser(tx.QueryRowContext(tx.ctx, query, NewUserID(), user.Name, user.Email, user.Age, now, labelsJSON(user.Labels)))
+	if isSQLiteUniqueViolation(err) {
+		return nil, ErrDuplicateEmail
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.record(EventCreated, nil, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// change
+// Applies sets to the current user, with the next version and updated_at, as the users triggers do.
+// sets are "column = placeholder" assignments, their values are in args.
+//
+// Errors:
+//   - ErrDuplicateEmail: When the changed user is live, and another live user has its email.
+func (tx *sqliteTx) change(current *UserRow, sets []string, args sqliteArgs) (*UserRow, error) {
+	sets = append(sets, "version = version + 1", "updated_at = "+args.add(formatSQLiteTime(tx.now)))
+	query := fmt.Sprintf(
+		`UPDATE users 
+		SET %s 
+		WHERE id = %s 
+		RETURNING `+userColumns,
+		strings.Join(sets, ", "), args.add(current.ID))
+	user, err := scanSQLiteUser(tx.QueryRowContext(tx.ctx, query, args...))
+	if isSQLiteUniqueViolation(err) {
+		return nil, ErrDuplicateEmail
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	kind := EventUpdated
+	if current.DeletedAt == nil && user.DeletedAt != nil {
+		kind = EventDeleted
+	}
+	if err := tx.record(kind, current, user); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// softDelete
+// Soft deletes a user, see DeleteUser.
+func (tx *sqliteTx) softDelete(id string, expectedVersion int64) error {
+	current, err := tx.live(id, expectedVersion)
+	if err != nil {
+		return err
+	}
+	var args sqliteArgs
+	_, err = tx.change(current, []string{"deleted_at = " + args.add(formatSQLiteTime(tx.now))}, args)
+	return err
+}
+
+// historyJSON
+// Returns user as stored in user_history, NULL when there is no user.
+func historyJSON(user *UserRow) (sql.NullString, error) {
+	if user == nil {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(user)
+	return sql.NullString{String: string(data), Valid: true}, err
+}
+
+// record
+// Records a write in user_events and user_history, as the record_user_event and record_user_history triggers do.
+// Purges only have a before row, and are not events.
+func (tx *sqliteTx) record(kind string, before, after *UserRow) error {
+	userID := ""
+	if after != nil {
+		userID = after.ID
+		query :=
+			`INSERT INTO user_events 
+			(event_type, id, name, email, age, created_at, updated_at, deleted_at, version, labels, recorded_at) 
+			VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11)`
+		var deletedAt sql.NullString
+		if after.DeletedAt != nil {
+			deletedAt = sql.NullString{String: formatSQLiteTime(*after.DeletedAt), Valid: true}
+		}
+		_, err := tx.ExecContext(tx.ctx, query, kind, after.ID, after.Name, after.Email, after.Age,
+			formatSQLiteTime(after.CreatedAt), formatSQLiteTime(after.UpdatedAt), deletedAt, after.Version,
+			labelsJSON(after.Labels), formatSQLiteTime(tx.now))
+		if err != nil {
+			return err
+		}
+		tx.recorded = true
+	} else {
+		userID = before.ID
+	}
+
+	beforeJSON, err := historyJSON(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := historyJSON(after)
+	if err != nil {
+		return err
+	}
+	query :=
+		`INSERT INTO user_history 
+		(user_id, change_type, before, after, actor, changed_at) 
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6)`
+	_, err = tx.ExecContext(tx.ctx, query, userID, kind, beforeJSON, afterJSON, tx.actor, formatSQLiteTime(tx.now))
+	return err
+}
+
+func (c *SQLiteClient) CreateUser(ctx context.Context, user *NewUser) (*UserRow, error) {
+	var created *UserRow
+	err := c.withTx(ctx, func(tx *sqliteTx) error {
+		var err error
+		created, err = tx.insert(user)
+		return err
+	})
+	return created, err
+}
+
+// GetUser
+// Soft deleted users are only returned with showDeleted.
+func (c *SQLiteClient) GetUser(ctx context.Context, id string, showDeleted bool) (*UserRow, error) {
+	query :=
+		`SELECT ` + userColumns + ` 
+		FROM users 
+		WHERE id = ?1 AND (?2 OR deleted_at IS NULL)`
+	return scanSQLiteUser(c.readDB.QueryRowContext(ctx, query, id, showDeleted))
+}
+
+// LegacyUserIDs
+// SQLite databases never had users with a numeric id, every legacy id is unknown.
+func (c *SQLiteClient) LegacyUserIDs(ctx context.Context, legacyIDs []int) (map[int]string, error) {
+	return map[int]string{}, nil
+}
+
+// GetUserByEmail
+// Reads the live user having email, which is expected to be normalized.
+// With showDeleted, falls back to the most recently deleted user having it.
+func (c *SQLiteClient) GetUserByEmail(ctx context.Context, email string, showDeleted bool) (*UserRow, error) {
+	query :=
+		`SELECT ` + userColumns + ` 
+		FROM users 
+		WHERE email = ?1 AND (?2 OR deleted_at IS NULL) 
+		ORDER BY deleted_at IS NOT NULL, deleted_at DESC 
+		LIMIT 1`
+	return scanSQLiteUser(c.readDB.QueryRowContext(ctx, query, email, showDeleted))
+}
+
+// UpdateUser
+// Updates only the columns set in update, soft deleted users can't be updated.
+// An empty update returns the current row.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist.
+//   - ErrEtagMismatch: When update.ExpectedVersion is set and the row is at another version.
+//   - ErrDuplicateEmail: When another live user has the new email.
+func (c *SQLiteClient) UpdateUser(ctx context.Context, id string, update *UserUpdate) (*UserRow, error) {
+	var sets []string
+	var args sqliteArgs
+	if update.Name != nil {
+		sets = append(sets, "name = "+args.add(*update.Name))
+	}
+	if update.Email != nil {
+		sets = append(sets, "email = "+args.add(*update.Email))
+	}
+	if update.Age != nil {
+		sets = append(sets, "age = "+args.add(*update.Age))
+	}
+	if update.Labels != nil {
+		sets = append(sets, "labels = "+args.add(labelsJSON(update.Labels)))
+	}
+	if len(sets) == 0 {
+		user, err := c.GetUser(ctx, id, false)
+		if err == nil && update.ExpectedVersion != 0 && user.Version != update.ExpectedVersion {
+			return nil, ErrEtagMismatch
+		}
+		return user, err
+	}
+
+	var user *UserRow
+	err := c.withTx(ctx, func(tx *sqliteTx) error {
+		current, err := tx.live(id, update.ExpectedVersion)
+		if err != nil {
+			return err
+		}
+		user, err = tx.change(current, sets, args)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// DeleteUser
+// Soft deletes a user, by setting its deleted_at.
+// A non zero expectedVersion only deletes the user if it is still at that version.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist.
+//   - ErrEtagMismatch: When expectedVersion is set and the row is at another version.
+func (c *SQLiteClient) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	return c.withTx(ctx, func(tx *sqliteTx) error {
+		return tx.softDelete(id, expectedVersion)
+	})
+}
+
+// UndeleteUser
+// Restores a soft deleted user.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user does not exist, or is not deleted.
+//   - ErrDuplicateEmail: When a live user took its email meanwhile.
+func (c *SQLiteClient) UndeleteUser(ctx context.Context, id string) (*UserRow, error) {
+	var user *UserRow
+	err := c.withTx(ctx, func(tx *sqliteTx) error {
+		current, err := tx.get(id, true)
+		if err != nil {
+			return err
+		}
+		if current.DeletedAt == nil {
+			return sql.ErrNoRows
+		}
+		user, err = tx.change(current, []string{"deleted_at = NULL"}, nil)
+		return err
+	})
+	return user, err
+}
+
+// PurgeDeletedUsers
+// Hard deletes the users soft deleted for longer than retention.
+// Their history is kept, ending with the purge, and the foreign key of their idempotency keys is set to NULL.
+//
+// Returns:
+//   - The number of purged users.
+func (c *SQLiteClient) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error) {
+	var purged int64
+	err := c.withTx(ctx, func(tx *sqliteTx) error {
+		query :=
+			`SELECT ` + userColumns + ` 
+			FROM users 
+			WHERE deleted_at < ?1`
+		rows, err := tx.QueryContext(ctx, query, formatSQLiteTime(tx.now.Add(-retention)))
+		if err != nil {
+			return err
+		}
+		users, err := scanSQLiteUsers(rows)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = ?1`, user.ID); err != nil {
+				return err
+			}
+			if err := tx.record(EventPurged, user, nil); err != nil {
+				return err
+			}
+		}
+		purged = int64(len(users))
+		return nil
+	})
+	return purged, err
+}
+
+// sqliteFilterConditions
+// Returns the WHERE conditions matching the filter, with their values added to args, see filterConditions.
+// LIKE only ignores the case of ASCII letters, where ILIKE ignores the case of every letter.
+func sqliteFilterConditions(f *UserFilter, args *sqliteArgs) []string {
+	var conds []string
+	if !f.ShowDeleted {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if f.NamePrefix != "" {
+		conds = append(conds, `name LIKE `+args.add(escapeLike(f.NamePrefix)+"%")+` ESCAPE '\'`)
+	}
+	if f.EmailDomain != "" {
+		conds = append(conds, `lower(email) LIKE `+args.add("%@"+escapeLike(strings.ToLower(f.EmailDomain)))+` ESCAPE '\'`)
+	}
+	if f.MinAge > 0 {
+		conds = append(conds, "age >= "+args.add(f.MinAge))
+	}
+	if f.MaxAge > 0 {
+		conds = append(conds, "age <= "+args.add(f.MaxAge))
+	}
+	if !f.CreatedAfter.IsZero() {
+		conds = append(conds, "created_at >= "+args.add(formatSQLiteTime(f.CreatedAfter)))
+	}
+	if !f.CreatedBefore.IsZero() {
+		conds = append(conds, "created_at < "+args.add(formatSQLiteTime(f.CreatedBefore)))
+	}
+	if !f.UpdatedAfter.IsZero() {
+		conds = append(conds, "updated_at >= "+args.add(formatSQLiteTime(f.UpdatedAfter)))
+	}
+	if !f.UpdatedBefore.IsZero() {
+		conds = append(conds, "updated_at < "+args.add(formatSQLiteTime(f.UpdatedBefore)))
+	}
+	for _, requirement := range f.Labels {
+		conds = append(conds, sqliteLabelCondition(requirement, args))
+	}
+	return conds
+}
+
+// sqliteLabelCondition
+// Returns the WHERE condition matching a requirement, with its values added to args, see labelCondition.
+func sqliteLabelCondition(r *LabelRequirement, args *sqliteArgs) string {
+	cond := "EXISTS (SELECT 1 FROM json_each(labels) WHERE key = " + args.add(r.Key)
+	if len(r.Values) > 0 {
+		values := make([]string, len(r.Values))
+		for i, value := range r.Values {
+			values[i] = args.add(value)
+		}
+		cond += " AND value IN (" + strings.Join(values, ", ") + ")"
+	}
+	cond += ")"
+
+	switch r.Operator {
+	case LabelNotExists, LabelNotEquals, LabelNotIn:
+		return "NOT " + cond
+	}
+	return cond
+}
+
+// sqliteCursorCondition
+// Returns the keyset condition selecting rows after the cursor in opts, see cursorCondition.
+//
+// Errors:
+//   - When opts.AfterValue is not a value of the OrderBy column.
+func sqliteCursorCondition(opts *ListOptions, args *sqliteArgs) (string, error) {
+	if opts.AfterID == "" {
+		return "", nil
+	}
+	op := ">"
+	if opts.Desc {
+		op = "<"
+	}
+	column := orderColumn(opts)
+	if column == OrderByID {
+		return fmt.Sprintf("id %s %s", op, args.add(opts.AfterID)), nil
+	}
+
+	var value interface{} = opts.AfterValue
+	switch column {
+	case OrderByAge:
+		age, err := strconv.Atoi(opts.AfterValue)
+		if err != nil {
+			return "", fmt.Errorf("invalid cursor value %q for %s: %v", opts.AfterValue, column, err)
+		}
+		value = age
+	case OrderByCreatedAt, OrderByUpdatedAt:
+		t, err := time.Parse(time.RFC3339Nano, opts.AfterValue)
+		if err != nil {
+			return "", fmt.Errorf("invalid cursor value %q for %s: %v", opts.AfterValue, column, err)
+		}
+		value = formatSQLiteTime(t)
+	}
+	return fmt.Sprintf("(%s, id) %s (%s, %s)", column, op, args.add(value), args.add(opts.AfterID)), nil
+}
+
+// ListUsers
+// Returns the page of users selected by opts, see ListOptions.
+// Text columns are sorted by bytes, while PostgreSQL sorts them by the collation of the database.
+func (c *SQLiteClient) ListUsers(ctx context.Context, opts *ListOptions) ([]*UserRow, error) {
+	return sqliteListUsers(ctx, c.readDB, opts)
+}
+
+func (c *SQLiteClient) CountUsers(ctx context.Context, filter *UserFilter) (int, error) {
+	return sqliteCountUsers(ctx, c.readDB, filter)
+}
+
+// ListUsersPage
+// Reads the page and its total in one read transaction, so they come from the same WAL snapshot.
+// SQLite has no statistics to estimate from, TotalEstimate counts the users.
+func (c *SQLiteClient) ListUsersPage(ctx context.Context, opts *ListOptions) (*UserPage, error) {
+	tx, err := c.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	// Read only, there is nothing to commit
+	defer tx.Rollback()
+
+	users, err := sqliteListUsers(ctx, tx, opts)
+	if err != nil {
+		return nil, err
+	}
+	page := &UserPage{Users: users, TotalMode: TotalNone}
+	if opts.TotalMode != TotalNone {
+		if page.Total, err = sqliteCountUsers(ctx, tx, &opts.Filter); err != nil {
+			return nil, err
+		}
+		page.TotalMode = TotalExact
+	}
+	return page, nil
+}
+
+// sqliteListUsers
+// Returns the page of users selected by opts, see ListUsers.
+func sqliteListUsers(ctx context.Context, q querier, opts *ListOptions) ([]*UserRow, error) {
+	var args sqliteArgs
+	conds := sqliteFilterConditions(&opts.Filter, &args)
+	cond, err := sqliteCursorCondition(opts, &args)
+	if err != nil {
+		return nil, err
+	}
+	if cond != "" {
+		conds = append(conds, cond)
+	}
+	query := fmt.Sprintf(
+		`SELECT `+userColumns+` 
+		FROM users 
+		%s 
+		%s 
+		LIMIT %s OFFSET %s`,
+		whereClause(conds), orderClause(opts), args.add(opts.Limit), args.add(opts.Offset))
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSQLiteUsers(rows)
+}
+
+// sqliteCountUsers
+// Returns the number of users matching filter.
+func sqliteCountUsers(ctx context.Context, q querier, filter *UserFilter) (int, error) {
+	var count int
+	var args sqliteArgs
+	query := fmt.Sprintf(
+		`SELECT COUNT(*) 
+		FROM users 
+		%s`,
+		whereClause(sqliteFilterConditions(filter, &args)))
+	err := q.QueryRowContext(ctx, query, args...).Scan(&count)
+	return count, err
+}
+
+// ExportUsers
+// Calls fn for every user matching filter, ordered by id.
+// The rows are read from a single query in a read transaction,
+// so the export is a consistent WAL snapshot however long it takes, and does not block the writes.
+//
+// Errors:
+//   - The first error of fn, which stops the export.
+//   - ctx error, when it is done before the export ends.
+func (c *SQLiteClient) ExportUsers(ctx context.Context, filter *UserFilter, fn func(*UserRow) error) error {
+	tx, err := c.readDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	// Read only, there is nothing to commit
+	defer tx.Rollback()
+
+	var args sqliteArgs
+	query := fmt.Sprintf(
+		`SELECT `+userColumns+` 
+		FROM users 
+		%s 
+		ORDER BY id`,
+		whereClause(sqliteFilterConditions(filter, &args)))
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanSQLiteUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// SearchUsers
+// Returns the users whose name or email match opts.Query, best matches first (ties by id).
+// SQLite has no pg_trgm, so every user is read and scored in Go, see searchScore.
+func (c *SQLiteClient) SearchUsers(ctx context.Context, opts *SearchOptions) ([]*SearchResult, error) {
+	query :=
+		`SELECT ` + userColumns + ` 
+		FROM users 
+		WHERE ?1 OR deleted_at IS NULL`
+	rows, err := c.readDB.QueryContext(ctx, query, opts.ShowDeleted)
+	if err != nil {
+		return nil, err
+	}
+	users, err := scanSQLiteUsers(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	queryWords := searchWords(opts.Query)
+	var results []*SearchResult
+	for _, user := range users {
+		score, ok := searchScore(opts.Query, queryWords, user)
+		if ok && afterSearchCursor(opts, score, user.ID) {
+			results = append(results, &SearchResult{User: user, Score: score})
+		}
+	}
+	return pageSearchResults(results, opts), nil
+}
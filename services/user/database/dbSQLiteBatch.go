@@ -0,0 +1,230 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// runBatch
+// Runs write for each of n items in a single transaction, see SQLClient.runBatch.
+//
+// Returns:
+//   - The per item results, in order.
+//
+// Errors:
+//   - When the transaction itself fails (begin/commit/savepoints).
+func (c *SQLiteClient) runBatch(ctx context.Context, n int, atomic, dryRun bool, write func(tx *sqliteTx, i int) (*UserRow, error)) ([]*BatchResult, error) {
+	results := make([]*BatchResult, n)
+	err := c.withTx(ctx, func(tx *sqliteTx) error {
+		for i := 0; i < n; i++ {
+			if !atomic {
+				if _, err := tx.ExecContext(ctx, "SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+
+			user, err := write(tx, i)
+			results[i] = &BatchResult{User: user, Err: err}
+			if err != nil {
+				results[i].User = nil
+				if atomic {
+					return errBatchItemFailed
+				}
+				if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+			if !atomic {
+				if _, err := tx.ExecContext(ctx, "RELEASE SAVEPOINT batch_item"); err != nil {
+					return err
+				}
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+
+	if errors.Is(err, errDryRun) {
+		return results, nil
+	}
+	if errors.Is(err, errBatchItemFailed) {
+		abortBatch(results)
+		return results, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// BatchCreateUsers
+// Creates the users in a single transaction, see runBatch for atomic.
+// Users with a RequestID are created idempotently, see CreateUserOnce.
+func (c *SQLiteClient) BatchCreateUsers(ctx context.Context, users []*NewUser, atomic bool) ([]*BatchResult, error) {
+	return c.runBatch(ctx, len(users), atomic, false, func(tx *sqliteTx, i int) (*UserRow, error) {
+		if users[i].RequestID != "" {
+			return tx.insertOnce(users[i], c.IdempotencyWindow)
+		}
+		return tx.insert(users[i])
+	})
+}
+
+// BatchDeleteUsers
+// Soft deletes the users in a single transaction, see runBatch for atomic.
+// Item errors are the ones of DeleteUser.
+func (c *SQLiteClient) BatchDeleteUsers(ctx context.Context, deletes []*UserDelete, atomic bool) ([]*BatchResult, error) {
+	return c.runBatch(ctx, len(deletes), atomic, false, func(tx *sqliteTx, i int) (*UserRow, error) {
+		return nil, tx.softDelete(deletes[i].ID, deletes[i].ExpectedVersion)
+	})
+}
+
+// ImportUsers
+// Creates the users in a single transaction, each in its own savepoint,
+// so failing users don't affect the others.
+// With upsert, a user whose email belongs to a live user updates its name, age and labels instead,
+// and its result is marked Updated.
+// With dryRun, the transaction is rolled back, the results are the ones of a real import.
+//
+// Errors (per item):
+//   - ErrDuplicateEmail: When a live user already has the email, without upsert.
+func (c *SQLiteClient) ImportUsers(ctx context.Context, users []*NewUser, upsert, dryRun bool) ([]*BatchResult, error) {
+	updated := make([]bool, len(users))
+	results, err := c.runBatch(ctx, len(users), false, dryRun, func(tx *sqliteTx, i int) (*UserRow, error) {
+		if !upsert {
+			return tx.insert(users[i])
+		}
+
+		query :=
+			`SELECT ` + userColumns + ` 
+			FROM users 
+			WHERE email = ?1 AND deleted_at IS NULL`
+		current, err := scanSQLiteUser(tx.QueryRowContext(ctx, query, users[i].Email))
+		if errors.Is(err, sql.ErrNoRows) {
+			return tx.insert(users[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		updated[i] = true
+		var args sqliteArgs
+		sets := []string{
+			"name = " + args.add(users[i].Name),
+			"age = " + args.add(users[i].Age),
+			"labels = " + args.add(labelsJSON(users[i].Labels)),
+		}
+		return tx.change(current, sets, args)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range results {
+		result.Updated = updated[i] && result.Err == nil
+	}
+	return results, nil
+}
+
+// BatchGetUsers
+// Reads the users with the given ids in a single statement.
+// Missing ids are left out of the result, soft deleted users are only returned with showDeleted.
+func (c *SQLiteClient) BatchGetUsers(ctx context.Context, ids []string, showDeleted bool) ([]*UserRow, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	args := sqliteArgs{showDeleted}
+	placeholders := make([]string, len(ids))
+	for i, id := range ids {
+		placeholders[i] = args.add(id)
+	}
+	query := fmt.Sprintf(
+		`SELECT `+userColumns+` 
+		FROM users 
+		WHERE id IN (%s) AND (?1 OR deleted_at IS NULL)`,
+		strings.Join(placeholders, ", "))
+	rows, err := c.readDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanSQLiteUsers(rows)
+}
+
+// CreateUserOnce
+// Creates the user, unless user.RequestID was already used in the last window, see SQLClient.CreateUserOnce.
+func (c *SQLiteClient) CreateUserOnce(ctx context.Context, user *NewUser) (*UserRow, error) {
+	var created *UserRow
+	err := c.withTx(ctx, func(tx *sqliteTx) error {
+		var err error
+		created, err = tx.insertOnce(user, c.IdempotencyWindow)
+		return err
+	})
+	return created, err
+}
+
+// insertOnce
+// Inserts a new user in tx, see CreateUserOnce.
+// Writes are serialized, so a concurrent request with the same id runs after tx, and replays its user.
+func (tx *sqliteTx) insertOnce(user *NewUser, window time.Duration) (*UserRow, error) {
+	// Expired keys are forgotten right away, not on the next purge
+	query :=
+		`DELETE 
+		FROM idempotency_keys 
+		WHERE request_id = ?1 AND created_at < ?2`
+	if _, err := tx.ExecContext(tx.ctx, query, user.RequestID, formatSQLiteTime(tx.now.Add(-window))); err != nil {
+		return nil, err
+	}
+
+	var requestHash string
+	var userID sql.NullString
+	query =
+		`SELECT request_hash, user_id 
+		FROM idempotency_keys 
+		WHERE request_id = ?1`
+	err := tx.QueryRowContext(tx.ctx, query, user.RequestID).Scan(&requestHash, &userID)
+	if err == nil {
+		if requestHash != user.RequestHash {
+			return nil, ErrRequestIDReused
+		}
+		if !userID.Valid {
+			return nil, sql.ErrNoRows
+		}
+		return tx.get(userID.String, true)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+
+	created, err := tx.insert(user)
+	if err != nil {
+		return nil, err
+	}
+	query =
+		`INSERT INTO idempotency_keys 
+		(request_id, request_hash, user_id, created_at) 
+		VALUES (?1, ?2, ?3, ?4)`
+	if _, err := tx.ExecContext(tx.ctx, query, user.RequestID, user.RequestHash, created.ID, formatSQLiteTime(tx.now)); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// PurgeIdempotencyKeys
+// Deletes the request ids recorded longer than window ago.
+//
+// Returns:
+//   - The number of purged request ids.
+func (c *SQLiteClient) PurgeIdempotencyKeys(ctx context.Context, window time.Duration) (int64, error) {
+	query :=
+		`DELETE 
+		FROM idempotency_keys 
+		WHERE created_at < ?1`
+	result, err := c.DB.ExecContext(ctx, query, formatSQLiteTime(sqliteNow().Add(-window)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
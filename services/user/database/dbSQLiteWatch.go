@@ -0,0 +1,204 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// ListUserHistory
+// Returns the changes of a user, most recent first.
+// The history of a user is kept after it is purged, its last entry is then the purge.
+func (c *SQLiteClient) ListUserHistory(ctx context.Context, opts *HistoryOptions) ([]*HistoryEntry, error) {
+	query :=
+		`SELECT id, user_id, change_type, actor, changed_at, before, after 
+		FROM user_history 
+		WHERE user_id = ?1 AND (?2 = 0 OR id < ?2) 
+		ORDER BY id DESC 
+		LIMIT ?3`
+	rows, err := c.readDB.QueryContext(ctx, query, opts.UserID, opts.BeforeID, opts.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*HistoryEntry
+	for rows.Next() {
+		entry := &HistoryEntry{}
+		var changedAt string
+		var before, after sql.NullString
+		if err := rows.Scan(&entry.ID, &entry.UserID, &entry.Type, &entry.Actor, &changedAt, &before, &after); err != nil {
+			return nil, err
+		}
+		if entry.ChangedAt, err = parseSQLiteTime(changedAt); err != nil {
+			return nil, err
+		}
+		if entry.Before, err = parseHistoryJSON(before); err != nil {
+			return nil, err
+		}
+		if entry.After, err = parseHistoryJSON(after); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// parseHistoryJSON
+// Returns a user stored in user_history, nil when there is none.
+func parseHistoryJSON(data sql.NullString) (*UserRow, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	user := &UserRow{}
+	if err := json.Unmarshal([]byte(data.String), user); err != nil {
+		return nil, err
+	}
+	if user.Labels == nil {
+		user.Labels = Labels{}
+	}
+	return user, nil
+}
+
+// GetUserAsOf
+// Reconstructs a user as it was at asOf, from its last change recorded until then.
+// A user soft deleted at asOf is only returned with showDeleted.
+//
+// Errors:
+//   - sql.ErrNoRows: When the user did not exist at asOf, or was already purged.
+func (c *SQLiteClient) GetUserAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*UserRow, error) {
+	query :=
+		`SELECT after 
+		FROM user_history 
+		WHERE user_id = ?1 AND changed_at <= ?2 
+		ORDER BY changed_at DESC, id DESC 
+		LIMIT 1`
+	var after sql.NullString
+	if err := c.readDB.QueryRowContext(ctx, query, id, formatSQLiteTime(asOf)).Scan(&after); err != nil {
+		return nil, err
+	}
+	user, err := parseHistoryJSON(after)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || (user.DeletedAt != nil && !showDeleted) {
+		return nil, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+// WatchUsers
+// Calls fn for every user event after afterSeq, until ctx is done.
+// A zero afterSeq starts with the events of the writes committed after the call.
+// Writes are serialized, so events are committed in sequence order.
+// The events are read from user_events whenever a write of this client commits,
+// and every watchPollInterval, in case another process wrote to the file.
+//
+// Errors:
+//   - ErrEventsPurged: When the event afterSeq was already purged.
+//   - The first error of fn, which stops the watch.
+//   - ctx error, when it is done.
+func (c *SQLiteClient) WatchUsers(ctx context.Context, afterSeq int64, fn func(*UserEvent) error) error {
+	// Taken before the first read, so no write falls in between
+	changed := c.changes()
+
+	cursor := afterSeq
+	if afterSeq == 0 {
+		if err := c.readDB.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM user_events`).Scan(&cursor); err != nil {
+			return err
+		}
+	} else {
+		var retained bool
+		if err := c.readDB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM user_events WHERE seq = ?1)`, afterSeq).Scan(&retained); err != nil {
+			return err
+		}
+		if !retained {
+			return ErrEventsPurged
+		}
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		var err error
+		if cursor, err = c.sendEventsAfter(ctx, cursor, fn); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			changed = c.changes()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendEventsAfter
+// Calls fn for every event after seq, reading them watchBatchSize at a time.
+//
+// Returns:
+//   - The sequence of the last event passed to fn, seq when there was none.
+func (c *SQLiteClient) sendEventsAfter(ctx context.Context, seq int64, fn func(*UserEvent) error) (int64, error) {
+	query :=
+		`SELECT ` + eventColumns + ` 
+		FROM user_events 
+		WHERE seq > ?1 
+		ORDER BY seq 
+		LIMIT ?2`
+	for {
+		rows, err := c.readDB.QueryContext(ctx, query, seq, watchBatchSize)
+		if err != nil {
+			return seq, err
+		}
+		events, err := scanSQLiteEvents(rows)
+		if err != nil {
+			return seq, err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return seq, err
+			}
+			seq = event.Sequence
+		}
+		if len(events) < watchBatchSize {
+			return seq, nil
+		}
+	}
+}
+
+// scanSQLiteEvents
+// Reads all the rows selected with eventColumns from SQLite.
+func scanSQLiteEvents(rows *sql.Rows) ([]*UserEvent, error) {
+	defer rows.Close()
+	var events []*UserEvent
+	for rows.Next() {
+		event := &UserEvent{}
+		user, err := scanSQLiteUser(rows, &event.Sequence, &event.Type)
+		if err != nil {
+			return nil, err
+		}
+		event.User = user
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// PurgeUserEvents
+// Deletes the events recorded longer than retention ago.
+//
+// Returns:
+//   - The number of purged events.
+func (c *SQLiteClient) PurgeUserEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	query :=
+		`DELETE 
+		FROM user_events 
+		WHERE recorded_at < ?1`
+	result, err := c.DB.ExecContext(ctx, query, formatSQLiteTime(sqliteNow().Add(-retention)))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
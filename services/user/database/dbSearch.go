@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+// SearchUsers
+// Returns the users whose name or email match opts.Query, best matches first (ties by id).
+// A user matches when its words start with every word of the query (full-text),
+// or when the query is similar to a part of its name or email (pg_trgm), e.g. with a typo.
+// The score is the best of the full-text rank and both similarities.
+func (c *SQLClient) SearchUsers(ctx context.Context, opts *SearchOptions) ([]*SearchResult, error) {
+	var tsQuery sql.NullString
+	if prefix := prefixQuery(opts.Query); prefix != "" {
+		tsQuery = sql.NullString{String: prefix, Valid: true}
+	}
+
+	query :=
+		`SELECT ` + userColumns + `, score 
+		FROM (
+			SELECT ` + userColumns + `, GREATEST(
+				ts_rank(search_vector, to_tsquery('simple', $1)), 
+				word_similarity($2, name), 
+				word_similarity($2, email)
+			)::float8 AS score 
+			FROM users 
+			WHERE (search_vector @@ to_tsquery('simple', $1) OR $2 <% name OR $2 <% email) 
+			AND ($3 OR deleted_at IS NULL)
+		) ranked 
+		WHERE $4::uuid IS NULL OR score < $5 OR (score = $5 AND id > $4::uuid) 
+		ORDER BY score DESC, id 
+		LIMIT $6`
+	afterID := sql.NullString{String: opts.AfterID, Valid: opts.AfterID != ""}
+	rows, err := c.DB.QueryContext(ctx, query, tsQuery, opts.Query, opts.ShowDeleted, afterID, opts.AfterScore, opts.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		var user UserRow
+		result := &SearchResult{User: &user}
+		err := rows.Scan(
+			&user.ID,
+			&user.Name,
+			&user.Email,
+			&user.Age,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.DeletedAt,
+			&user.Version,
+			&user.Labels,
+			&result.Score,
+		)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// prefixQuery
+// Converts a search query to a tsquery matching words starting with each of its words,
+// e.g. "Ali john" is "ali:* & john:*".
+// Returns an empty string when the query has no letters or digits.
+func prefixQuery(query string) string {
+	words := strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = word + ":*"
+	}
+	return strings.Join(words, " & ")
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// localTextRank
+// The score of a full-text match computed in Go, the ts_rank of a single matching word.
+const localTextRank = 0.0607927
+
+// similarityThreshold
+// The word similarity from which a name or email matches, the default pg_trgm.word_similarity_threshold of <%.
+const similarityThreshold = 0.6
+
+// searchScore
+// Scores a user as SQLClient.SearchUsers does, for the clients without full-text and pg_trgm indexes.
+// queryWords are the searchWords of query.
+// Every full-text match has the same rank, so scores only follow the ones of PostgreSQL closely.
+//
+// Returns:
+//   - The score of the user, and whether it matches the query.
+func searchScore(query string, queryWords []string, user *UserRow) (float64, bool) {
+	score := max(wordSimilarity(query, user.Name), wordSimilarity(query, user.Email))
+	if matchesPrefixes(queryWords, user) {
+		return max(score, localTextRank), true
+	}
+	return score, score >= similarityThreshold
+}
+
+// afterSearchCursor
+// Reports whether a result with score and id comes after the cursor of opts, true when there is none.
+func afterSearchCursor(opts *SearchOptions, score float64, id string) bool {
+	return opts.AfterID == "" || score < opts.AfterScore || (score == opts.AfterScore && id > opts.AfterID)
+}
+
+// pageSearchResults
+// Sorts results as SQLClient.SearchUsers does, best matches first (ties by id), and keeps the first opts.Limit.
+func pageSearchResults(results []*SearchResult, opts *SearchOptions) []*SearchResult {
+	slices.SortFunc(results, func(a, b *SearchResult) int {
+		if order := cmp.Compare(b.Score, a.Score); order != 0 {
+			return order
+		}
+		return strings.Compare(a.User.ID, b.User.ID)
+	})
+	return results[:min(len(results), max(opts.Limit, 0))]
+}
+
+// searchWords
+// Returns the lowercase words of letters and digits of s, as split by prefixQuery.
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchesPrefixes
+// Reports whether every query word starts a word of the user, as the prefixQuery of its search_vector does.
+// The text search parser keeps emails as a single word.
+func matchesPrefixes(queryWords []string, user *UserRow) bool {
+	if len(queryWords) == 0 {
+		return false
+	}
+	words := append(searchWords(user.Name), strings.ToLower(user.Email))
+	for _, queryWord := range queryWords {
+		if !slices.ContainsFunc(words, func(word string) bool { return strings.HasPrefix(word, queryWord) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// trigrams
+// Returns the trigrams of s, in order, as pg_trgm extracts them:
+// from each lowercase word of letters and digits, padded with two spaces before and one after.
+func trigrams(s string) []string {
+	var extracted []string
+	for _, word := range searchWords(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			extracted = append(extracted, string(padded[i:i+3]))
+		}
+	}
+	return extracted
+}
+
+// wordSimilarity
+// Returns the pg_trgm word_similarity of query and text:
+// the greatest similarity between the trigrams of query, and a continuous extent of the trigrams of text.
+func wordSimilarity(query, text string) float64 {
+	want := make(map[string]bool)
+	for _, trigram := range trigrams(query) {
+		want[trigram] = true
+	}
+	if len(want) == 0 {
+		return 0
+	}
+
+	sequence := trigrams(text)
+	var best float64
+	for start := range sequence {
+		extent := make(map[string]bool)
+		shared := 0
+		for _, trigram := range sequence[start:] {
+			if extent[trigram] {
+				continue
+			}
+			extent[trigram] = true
+			if want[trigram] {
+				shared++
+			}
+			best = max(best, float64(shared)/float64(len(want)+len(extent)-shared))
+		}
+	}
+	return best
+}
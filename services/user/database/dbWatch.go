@@ -0,0 +1,220 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// eventsChannel
+// The channel notified by the record_user_event trigger.
+const eventsChannel = "user_events"
+
+// watchPollInterval
+// How often WatchUsers reads the events without being notified,
+// in case a notification was lost while its listener reconnected.
+const watchPollInterval = 30 * time.Second
+
+// watchBatchSize
+// The maximum number of events read per query.
+const watchBatchSize = 1000
+
+// eventColumns
+// The user_events columns, in the order read by readEvents.
+const eventColumns = "seq, event_type, " + userColumns
+
+// watchRetryInterval
+// How soon WatchUsers reads again while committed events are held back by an older transaction,
+// which may end with a rollback, that is not notified.
+const watchRetryInterval = time.Second
+
+// eventCursor
+// The position of a watcher: the events up to (txid, seq) were sent.
+// Events are read in (txid, seq) order, only from the transactions older than every transaction still in flight,
+// so an event committed after newer ones is read once its transaction ends, instead of being skipped.
+type eventCursor struct {
+	txid int64
+	seq  int64
+}
+
+// WatchUsers
+// Calls fn for every user event after afterSeq, until ctx is done.
+// A zero afterSeq starts with the events of the oldest transaction in flight at the call and of the ones after it,
+// which may repeat events committed right before the call.
+// The events are read from user_events, whenever the listener of a dedicated connection is notified,
+// in the order of their transaction ids, then sequences. An event is only read once every older transaction ended,
+// so a long write transaction delays the events of the writes committed after it, without blocking them.
+//
+// Errors:
+//   - ErrEventsPurged: When the event afterSeq was already purged.
+//   - The first error of fn, which stops the watch.
+//   - ctx error, when it is done.
+func (c *SQLClient) WatchUsers(ctx context.Context, afterSeq int64, fn func(*UserEvent) error) error {
+	if c.connStr == "" {
+		return errors.New("watching users requires a client created by NewPostgresClient")
+	}
+
+	listener := pq.NewListener(c.connStr, time.Second, time.Minute, nil)
+	defer listener.Close()
+	// Listen before the first read, so no event falls in between
+	if err := listener.Listen(eventsChannel); err != nil {
+		return fmt.Errorf("failed to listen to %s: %v", eventsChannel, err)
+	}
+
+	cursor, err := c.startCursor(ctx, afterSeq)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		held, err := c.sendEventsAfter(ctx, cursor, fn)
+		if err != nil {
+			return err
+		}
+
+		var retry <-chan time.Time
+		if held {
+			retry = time.After(watchRetryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-listener.Notify:
+			// nil after a reconnection, the next read catches up either way
+		case <-ticker.C:
+		case <-retry:
+		}
+	}
+}
+
+// startCursor
+// Returns the cursor of a watch resuming after afterSeq,
+// or starting at the oldest transaction in flight for a zero afterSeq.
+//
+// Errors:
+//   - ErrEventsPurged: When the event afterSeq is not retained.
+func (c *SQLClient) startCursor(ctx context.Context, afterSeq int64) (*eventCursor, error) {
+	if afterSeq == 0 {
+		// Events of the oldest transaction in flight, and of the ones after it
+		cursor := &eventCursor{}
+		query := `SELECT pg_snapshot_xmin(pg_current_snapshot())::text::bigint - 1, COALESCE(MAX(seq), 0) FROM user_events`
+		if err := c.DB.QueryRowContext(ctx, query).Scan(&cursor.txid, &cursor.seq); err != nil {
+			return nil, err
+		}
+		return cursor, nil
+	}
+
+	cursor := &eventCursor{seq: afterSeq}
+	query := `SELECT txid::text::bigint FROM user_events WHERE seq = $1`
+	err := c.DB.QueryRowContext(ctx, query, afterSeq).Scan(&cursor.txid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEventsPurged
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+// sendEventsAfter
+// Calls fn for every event after cursor, of the transactions that all ended, reading them watchBatchSize at a time.
+// The cursor is moved past every event passed to fn.
+//
+// Returns:
+//   - Whether committed events after the cursor are held back by an older transaction still in flight.
+func (c *SQLClient) sendEventsAfter(ctx context.Context, cursor *eventCursor, fn func(*UserEvent) error) (bool, error) {
+	query :=
+		`SELECT txid::text::bigint, ` + eventColumns + ` 
+		FROM user_events 
+		WHERE (txid, seq) > ($1::text::xid8, $2) AND txid < pg_snapshot_xmin(pg_current_snapshot()) 
+		ORDER BY txid, seq 
+		LIMIT $3`
+	for {
+		events, txids, err := c.readEvents(ctx, query, cursor.txid, cursor.seq, watchBatchSize)
+		if err != nil {
+			return false, err
+		}
+		for i, event := range events {
+			if err := fn(event); err != nil {
+				return false, err
+			}
+			cursor.txid, cursor.seq = txids[i], event.Sequence
+		}
+		if len(events) < watchBatchSize {
+			break
+		}
+	}
+
+	var held bool
+	query =
+		`SELECT EXISTS (
+			SELECT 1 
+			FROM user_events 
+			WHERE (txid, seq) > ($1::text::xid8, $2)
+		)`
+	err := c.DB.QueryRowContext(ctx, query, cursor.txid, cursor.seq).Scan(&held)
+	return held, err
+}
+
+// readEvents
+// Runs a query selecting the txid, then eventColumns.
+//
+// Returns:
+//   - The events, and the transaction id of each.
+func (c *SQLClient) readEvents(ctx context.Context, query string, args ...interface{}) ([]*UserEvent, []int64, error) {
+	rows, err := c.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var events []*UserEvent
+	var txids []int64
+	for rows.Next() {
+		var txid int64
+		event := &UserEvent{User: &UserRow{}}
+		err := rows.Scan(
+			&txid,
+			&event.Sequence,
+			&event.Type,
+			&event.User.ID,
+			&event.User.Name,
+			&event.User.Email,
+			&event.User.Age,
+			&event.User.CreatedAt,
+			&event.User.UpdatedAt,
+			&event.User.DeletedAt,
+			&event.User.Version,
+			&event.User.Labels,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+		events = append(events, event)
+		txids = append(txids, txid)
+	}
+	return events, txids, rows.Err()
+}
+
+// PurgeUserEvents
+// Deletes the events recorded longer than retention ago.
+//
+// Returns:
+//   - The number of purged user_events.
+func (c *SQLClient) PurgeUserEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	query :=
+		`DELETE 
+		FROM user_events 
+		WHERE recorded_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`
+	result, err := c.DB.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
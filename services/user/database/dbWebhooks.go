@@ -0,0 +1,406 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// webhookLockName
+// The advisory lock held by a claim, so the service instances claim one at a time, see ClaimWebhookDeliveries.
+const webhookLockName = "user webhooks"
+
+// webhookMaxBackoff
+// The longest wait before attempting a failed delivery again.
+const webhookMaxBackoff = time.Hour
+
+// webhookColumns
+// The webhooks columns, in the order read by scanWebhook.
+const webhookColumns = `id, url, event_types, secret, consecutive_failures, disabled_at, created_at, updated_at`
+
+// deliveryColumns
+// The webhook_deliveries columns, with the webhooks columns and the users columns of its payload,
+// in the order read by readWebhookDeliveries.
+const deliveryColumns = `d.id, d.event_type, d.created_at, d.attempts, w.id, w.url, w.secret, 
+	u.id, u.name, u.email, u.age, u.created_at, u.updated_at, u.deleted_at, u.version, u.labels`
+
+// attemptColumns
+// The webhook_attempts columns, with the event type of their delivery, in the order read by ListWebhookAttempts.
+const attemptColumns = `a.id, a.delivery_id, a.webhook_id, d.event_type, a.attempt, a.status_code, a.error, a.duration_ms, a.attempted_at`
+
+// CreateWebhook
+// Creates an enabled webhook, delivered the writes committed from now on.
+//
+// Returns:
+//   - The created webhook.
+func (c *SQLClient) CreateWebhook(ctx context.Context, webhook *NewWebhook) (*WebhookRow, error) {
+	// An empty array rather than NULL, which event_types rejects
+	eventTypes := append([]string{}, webhook.EventTypes...)
+	query :=
+		`INSERT INTO webhooks (url, event_types, secret) 
+		VALUES ($1, $2, $3) 
+		RETURNING ` + webhookColumns
+	return scanWebhook(c.DB.QueryRowContext(ctx, query, webhook.URL, pq.Array(eventTypes), webhook.Secret))
+}
+
+// GetWebhook
+//
+// Errors:
+//   - sql.ErrNoRows: When no webhook has the id.
+func (c *SQLClient) GetWebhook(ctx context.Context, id string) (*WebhookRow, error) {
+	query :=
+		`SELECT ` + webhookColumns + ` 
+		FROM webhooks 
+		WHERE id = $1`
+	return scanWebhook(c.DB.QueryRowContext(ctx, query, id))
+}
+
+// ListWebhooks
+//
+// Returns:
+//   - Every webhook, oldest first.
+func (c *SQLClient) ListWebhooks(ctx context.Context) ([]*WebhookRow, error) {
+	query :=
+		`SELECT ` + webhookColumns + ` 
+		FROM webhooks 
+		ORDER BY id`
+	rows, err := c.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var webhooks []*WebhookRow
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, rows.Err()
+}
+
+// EnableWebhook
+// Enables a webhook again and resets its failures, its pending deliveries are attempted on the next pass,
+// unless it was disabled long enough for them to be purged, see PurgeWebhookDeliveries.
+// The deliveries of the writes made while it was disabled are lost.
+//
+// Returns:
+//   - The enabled webhook, unchanged when it was not disabled.
+//
+// Errors:
+//   - sql.ErrNoRows: When no webhook has the id.
+func (c *SQLClient) EnableWebhook(ctx context.Context, id string) (*WebhookRow, error) {
+	query :=
+		`WITH enabled AS (
+			UPDATE webhooks 
+			SET disabled_at = NULL, consecutive_failures = 0, 
+			updated_at = CASE WHEN disabled_at IS NULL THEN updated_at ELSE CURRENT_TIMESTAMP END 
+			WHERE id = $1 
+			RETURNING ` + webhookColumns + ` 
+		), retried AS (
+			UPDATE webhook_deliveries 
+			SET next_attempt_at = CURRENT_TIMESTAMP 
+			WHERE webhook_id IN (SELECT id FROM enabled) AND delivered_at IS NULL 
+		) 
+		SELECT ` + webhookColumns + ` FROM enabled`
+	return scanWebhook(c.DB.QueryRowContext(ctx, query, id))
+}
+
+// DeleteWebhook
+// Deletes a webhook, with its deliveries and their attempts.
+//
+// Errors:
+//   - sql.ErrNoRows: When no webhook has the id.
+func (c *SQLClient) DeleteWebhook(ctx context.Context, id string) error {
+	result, err := c.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ListWebhookAttempts
+//
+// Returns:
+//   - The attempts to deliver to the webhook, most recent first, empty when it has none or does not exist.
+func (c *SQLClient) ListWebhookAttempts(ctx context.Context, opts *WebhookAttemptOptions) ([]*WebhookAttempt, error) {
+	query :=
+		`SELECT ` + attemptColumns + ` 
+		FROM webhook_attempts a 
+		JOIN webhook_deliveries d ON d.id = a.delivery_id 
+		WHERE a.webhook_id = $1 AND ($2 = 0 OR a.id < $2) 
+		ORDER BY a.id DESC 
+		LIMIT $3`
+	rows, err := c.DB.QueryContext(ctx, query, opts.WebhookID, opts.BeforeID, opts.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*WebhookAttempt
+	for rows.Next() {
+		attempt := &WebhookAttempt{}
+		var durationMs int64
+		err := rows.Scan(
+			&attempt.ID,
+			&attempt.DeliveryID,
+			&attempt.WebhookID,
+			&attempt.EventType,
+			&attempt.Attempt,
+			&attempt.StatusCode,
+			&attempt.Error,
+			&durationMs,
+			&attempt.AttemptedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempt.Duration = time.Duration(durationMs) * time.Millisecond
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// PurgeWebhookDeliveries
+// Deletes the deliveries delivered longer than retention ago, with their attempts,
+// and the abandoned ones: still pending on a webhook disabled longer than retention ago.
+// The pending deliveries of the enabled webhooks are kept, however old, they are still retried.
+//
+// Returns:
+//   - The number of purged deliveries.
+func (c *SQLClient) PurgeWebhookDeliveries(ctx context.Context, retention time.Duration) (int64, error) {
+	query :=
+		`DELETE FROM webhook_deliveries d 
+		WHERE d.delivered_at < CURRENT_TIMESTAMP - make_interval(secs => $1) 
+		OR (d.delivered_at IS NULL AND EXISTS (
+			SELECT 1 FROM webhooks w 
+			WHERE w.id = d.webhook_id AND w.disabled_at < CURRENT_TIMESTAMP - make_interval(secs => $1)
+		))`
+	result, err := c.DB.ExecContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ClaimWebhookDeliveries
+// Claims the due deliveries of up to webhooks enabled webhooks, the ones waiting the longest first,
+// and up to perWebhook deliveries of each, oldest first.
+// A delivery is due once its next_attempt_at passed, and no earlier delivery of its webhook is waiting for a retry,
+// so the deliveries of a webhook are made in order, while the other webhooks go on.
+// The claimed deliveries are leased: their next_attempt_at is moved lease later for the first delivery of a webhook,
+// twice lease for the second, and so on, so they are not claimed again while they are attempted one after another.
+// Deliveries whose attempt is never recorded, e.g. when the service stops, are claimed again once their lease is over (at least once).
+// Claims run in a short transaction, under an advisory lock, a claim does nothing while another one runs.
+//
+// Returns:
+//   - The claimed deliveries, by id, see RecordWebhookAttempt and ReleaseWebhookDeliveries.
+func (c *SQLClient) ClaimWebhookDeliveries(ctx context.Context, webhooks, perWebhook int, lease time.Duration) ([]*WebhookDelivery, error) {
+	var deliveries []*WebhookDelivery
+	err := c.withTx(ctx, func(tx *sql.Tx) error {
+		var locked bool
+		if err := tx.QueryRowContext(ctx, `SELECT pg_try_advisory_xact_lock(hashtext($1))`, webhookLockName).Scan(&locked); err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+
+		query :=
+			`WITH due AS (
+				SELECT d.id, 
+				row_number() OVER (PARTITION BY d.webhook_id ORDER BY d.id) AS position, 
+				min(d.id) OVER (PARTITION BY d.webhook_id) AS first_id 
+				FROM webhook_deliveries d 
+				JOIN webhooks w ON w.id = d.webhook_id 
+				WHERE d.delivered_at IS NULL AND w.disabled_at IS NULL AND d.next_attempt_at <= CURRENT_TIMESTAMP 
+				AND NOT EXISTS (
+					SELECT 1 FROM webhook_deliveries earlier 
+					WHERE earlier.webhook_id = d.webhook_id AND earlier.id < d.id 
+					AND earlier.delivered_at IS NULL AND earlier.next_attempt_at > CURRENT_TIMESTAMP 
+				) 
+			), claimed AS (
+				SELECT id, position, dense_rank() OVER (ORDER BY first_id) AS webhook_rank 
+				FROM due 
+				WHERE position <= $2
+			)
+			UPDATE webhook_deliveries d 
+			SET next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $3::float8 * claimed.position) 
+			FROM claimed 
+			WHERE d.id = claimed.id AND claimed.webhook_rank <= $1 
+			RETURNING d.id`
+		rows, err := tx.QueryContext(ctx, query, webhooks, perWebhook, lease.Seconds())
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+
+		query =
+			`SELECT ` + deliveryColumns + ` 
+			FROM webhook_deliveries d 
+			JOIN webhooks w ON w.id = d.webhook_id 
+			JOIN LATERAL jsonb_populate_record(NULL::users, d.payload) u ON true 
+			WHERE d.id = ANY($1) 
+			ORDER BY d.id`
+		deliveries, err = readWebhookDeliveries(ctx, tx, query, pq.Array(ids))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt
+// Records an attempt of a claimed delivery, and updates the delivery and the failures of its webhook, in a short transaction.
+// A failed delivery is retried after a backoff, its webhook is disabled after maxFailures failures in a row, 0 never disables it.
+// The attempt is numbered after the attempts of the delivery.
+// Nothing is recorded when the delivery was deleted with its webhook meanwhile.
+func (c *SQLClient) RecordWebhookAttempt(ctx context.Context, delivery *WebhookDelivery, attempt *WebhookAttempt, maxFailures int) error {
+	attempt.Attempt = delivery.Attempts + 1
+	return c.withTx(ctx, func(tx *sql.Tx) error {
+		query :=
+			`UPDATE webhook_deliveries 
+			SET attempts = attempts + 1, delivered_at = CURRENT_TIMESTAMP 
+			WHERE id = $1`
+		args := []interface{}{delivery.ID}
+		if attempt.Error != "" {
+			query =
+				`UPDATE webhook_deliveries 
+				SET attempts = attempts + 1, next_attempt_at = CURRENT_TIMESTAMP + make_interval(secs => $2) 
+				WHERE id = $1`
+			args = append(args, retryBackoff(delivery.Attempts, webhookMaxBackoff).Seconds())
+		}
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		if updated, err := result.RowsAffected(); err != nil || updated == 0 {
+			return err
+		}
+
+		query =
+			`INSERT INTO webhook_attempts (delivery_id, webhook_id, attempt, status_code, error, duration_ms) 
+			VALUES ($1, $2, $3, $4, $5, $6)`
+		_, err = tx.ExecContext(ctx, query,
+			delivery.ID, delivery.Webhook.ID, attempt.Attempt, attempt.StatusCode, attempt.Error, attempt.Duration.Milliseconds())
+		if err != nil {
+			return err
+		}
+
+		if attempt.Error == "" {
+			_, err := tx.ExecContext(ctx, `UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1 AND consecutive_failures > 0`, delivery.Webhook.ID)
+			return err
+		}
+		query =
+			`UPDATE webhooks 
+			SET consecutive_failures = consecutive_failures + 1, 
+			disabled_at = CASE WHEN $2 > 0 AND consecutive_failures + 1 >= $2 THEN CURRENT_TIMESTAMP END, 
+			updated_at = CASE WHEN $2 > 0 AND consecutive_failures + 1 >= $2 THEN CURRENT_TIMESTAMP ELSE updated_at END 
+			WHERE id = $1 
+			RETURNING consecutive_failures, disabled_at IS NOT NULL`
+		var failures int
+		var disabled bool
+		if err := tx.QueryRowContext(ctx, query, delivery.Webhook.ID, maxFailures).Scan(&failures, &disabled); err != nil {
+			return err
+		}
+		if disabled {
+			log.Printf("Webhook %s disabled after %d failed deliveries in a row", delivery.Webhook.ID, failures)
+		}
+		return nil
+	})
+}
+
+// ReleaseWebhookDeliveries
+// Ends the lease of claimed deliveries that were not attempted, see ClaimWebhookDeliveries,
+// e.g. the ones after a failed delivery of their webhook, which wait for its retry.
+func (c *SQLClient) ReleaseWebhookDeliveries(ctx context.Context, ids []int64) error {
+	query :=
+		`UPDATE webhook_deliveries 
+		SET next_attempt_at = CURRENT_TIMESTAMP 
+		WHERE id = ANY($1) AND delivered_at IS NULL`
+	_, err := c.DB.ExecContext(ctx, query, pq.Array(ids))
+	return err
+}
+
+// scanWebhook
+// Scans a row of webhookColumns.
+func scanWebhook(row rowScanner) (*WebhookRow, error) {
+	webhook := &WebhookRow{}
+	err := row.Scan(
+		&webhook.ID,
+		&webhook.URL,
+		pq.Array(&webhook.EventTypes),
+		&webhook.Secret,
+		&webhook.ConsecutiveFailures,
+		&webhook.DisabledAt,
+		&webhook.CreatedAt,
+		&webhook.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// readWebhookDeliveries
+// Runs a query selecting deliveryColumns.
+func readWebhookDeliveries(ctx context.Context, q querier, query string, args ...interface{}) ([]*WebhookDelivery, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		delivery := &WebhookDelivery{Webhook: &WebhookRow{}, User: &UserRow{}}
+		err := rows.Scan(
+			&delivery.ID,
+			&delivery.Type,
+			&delivery.CreatedAt,
+			&delivery.Attempts,
+			&delivery.Webhook.ID,
+			&delivery.Webhook.URL,
+			&delivery.Webhook.Secret,
+			&delivery.User.ID,
+			&delivery.User.Name,
+			&delivery.User.Email,
+			&delivery.User.Age,
+			&delivery.User.CreatedAt,
+			&delivery.User.UpdatedAt,
+			&delivery.User.DeletedAt,
+			&delivery.User.Version,
+			&delivery.User.Labels,
+		)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, rows.Err()
+}
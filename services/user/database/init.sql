@@ -1,32 +1,6 @@
--- Initialize database with sample data
-CREATE TABLE IF NOT EXISTS users (
-    id SERIAL PRIMARY KEY,
-    name VARCHAR(100) NOT NULL,
-    email VARCHAR(100) UNIQUE NOT NULL,
-    age INTEGER NOT NULL,
-    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
-
--- Function to update updated_at timestamp
-CREATE OR REPLACE FUNCTION update_updated_at_column()
-RETURNS TRIGGER AS $$
-BEGIN
-    NEW.updated_at = CURRENT_TIMESTAMP;
-    RETURN NEW;
-END;
-$$ language 'plpgsql';
-
--- Trigger for updated_at
-DROP TRIGGER IF EXISTS update_users_updated_at ON users;
-CREATE TRIGGER update_users_updated_at
-    BEFORE UPDATE ON users
-    FOR EACH ROW
-    EXECUTE FUNCTION update_updated_at_column();
-
--- Insert sample data
+-- Sample data, to load once the migrations are applied (see migrations/)
 INSERT INTO users (name, email, age) VALUES
     ('Alice Johnson', 'alice.johnson@example.com', 28),
     ('Bob Smith', 'bob.smith@example.com', 32),
     ('Carol Davis', 'carol.davis@example.com', 24)
-ON CONFLICT (email) DO NOTHING;
\ No newline at end of file
+ON CONFLICT (email) WHERE deleted_at IS NULL DO NOTHING;
@@ -0,0 +1,5 @@
+-- pg_trgm is left installed, other schemas of the database may use it
+DROP TABLE users;
+DROP FUNCTION increment_version_column();
+DROP FUNCTION update_updated_at_column();
+DROP FUNCTION uuid_v7_at(TIMESTAMP);
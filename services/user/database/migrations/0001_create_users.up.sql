@@ -0,0 +1,107 @@
+-- UUIDv7 of a timestamp: its unix milliseconds, then random bits
+CREATE FUNCTION uuid_v7_at(ts TIMESTAMP)
+RETURNS UUID AS $$
+    SELECT encode(
+        set_bit(set_bit(
+            overlay(uuid_send(gen_random_uuid())
+                PLACING substring(int8send(floor(extract(epoch FROM ts) * 1000)::BIGINT) FROM 3)
+                FROM 1 FOR 6),
+            52, 1), 53, 1),
+        'hex')::UUID;
+$$ language 'sql' VOLATILE;
+
+-- SearchUsers: full-text prefix matches, and fuzzy trigram matches
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- The first schema, adopted at version 0, is upgraded in place: users with a SERIAL id get the UUIDv7 of their created_at,
+-- and keep their numeric id in legacy_id, so it can still be looked up.
+-- The updated_at trigger is dropped first, converting ids is not a user change, it is created again below.
+DO $$
+BEGIN
+    IF to_regclass('users') IS NULL THEN
+        RETURN;
+    END IF;
+
+    DROP TRIGGER update_users_updated_at ON users;
+    DROP FUNCTION update_updated_at_column();
+
+    ALTER TABLE users DROP CONSTRAINT users_pkey;
+    ALTER TABLE users RENAME COLUMN id TO legacy_id;
+    ALTER TABLE users ALTER COLUMN legacy_id DROP DEFAULT;
+    ALTER TABLE users ALTER COLUMN legacy_id DROP NOT NULL;
+    DROP SEQUENCE users_id_seq;
+    ALTER TABLE users ADD CONSTRAINT users_legacy_id_key UNIQUE (legacy_id);
+
+    ALTER TABLE users ADD COLUMN id UUID DEFAULT uuid_v7_at(clock_timestamp()::TIMESTAMP);
+    UPDATE users SET id = uuid_v7_at(COALESCE(created_at, CURRENT_TIMESTAMP));
+    ALTER TABLE users ADD PRIMARY KEY (id);
+
+    -- Emails are only unique among live users, see users_email_live_idx
+    ALTER TABLE users DROP CONSTRAINT users_email_key;
+    ALTER TABLE users
+        ADD COLUMN deleted_at TIMESTAMP,
+        ADD COLUMN version BIGINT NOT NULL DEFAULT 1,
+        ADD COLUMN labels JSONB NOT NULL DEFAULT '{}',
+        ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (to_tsvector('simple', name || ' ' || email)) STORED;
+END;
+$$ language 'plpgsql';
+
+-- Users: legacy_id is the numeric id of the users created before ids were UUIDs.
+-- Soft deleted users have a deleted_at, and version (the etag) changes on every write.
+CREATE TABLE IF NOT EXISTS users (
+    id UUID PRIMARY KEY DEFAULT uuid_v7_at(clock_timestamp()::TIMESTAMP),
+    legacy_id INTEGER UNIQUE,
+    name VARCHAR(100) NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    age INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP,
+    version BIGINT NOT NULL DEFAULT 1,
+    labels JSONB NOT NULL DEFAULT '{}',
+    search_vector tsvector GENERATED ALWAYS AS (to_tsvector('simple', name || ' ' || email)) STORED
+);
+
+-- Soft delete: emails are only unique among live users
+CREATE UNIQUE INDEX users_email_live_idx ON users (email) WHERE deleted_at IS NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Labels: selectors match with containment (@>) and key existence (?), both served by the GIN index
+CREATE INDEX users_labels_idx ON users USING GIN (labels);
+
+-- Keyset pagination indexes for ListUsers order_by
+CREATE INDEX users_name_id_idx ON users (name, id);
+CREATE INDEX users_email_id_idx ON users (email, id);
+CREATE INDEX users_age_id_idx ON users (age, id);
+CREATE INDEX users_created_at_id_idx ON users (created_at, id);
+CREATE INDEX users_updated_at_id_idx ON users (updated_at, id);
+
+CREATE INDEX users_search_vector_idx ON users USING GIN (search_vector);
+CREATE INDEX users_name_trgm_idx ON users USING GIN (name gin_trgm_ops);
+CREATE INDEX users_email_trgm_idx ON users USING GIN (email gin_trgm_ops);
+
+CREATE FUNCTION update_updated_at_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER update_users_updated_at
+    BEFORE UPDATE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+CREATE FUNCTION increment_version_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER increment_users_version
+    BEFORE UPDATE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION increment_version_column();
@@ -0,0 +1,3 @@
+DROP TRIGGER record_users_event ON users;
+DROP FUNCTION record_user_event();
+DROP TABLE user_events;
@@ -0,0 +1,47 @@
+-- Change stream: every write is recorded in user_events and notified on the user_events channel.
+-- Watchers read the events in (txid, seq) order, up to the oldest transaction still in flight,
+-- so an event committed late is never skipped, without serializing the writers.
+CREATE TABLE user_events (
+    seq BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(16) NOT NULL,
+    id UUID NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    email VARCHAR(100) NOT NULL,
+    age INTEGER NOT NULL,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    version BIGINT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    recorded_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    txid xid8 NOT NULL DEFAULT pg_current_xact_id()
+);
+CREATE INDEX user_events_recorded_at_idx ON user_events (recorded_at);
+CREATE INDEX user_events_txid_seq_idx ON user_events (txid, seq);
+
+CREATE FUNCTION record_user_event()
+RETURNS TRIGGER AS $$
+DECLARE
+    kind VARCHAR(16);
+    new_seq BIGINT;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'CREATED';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'DELETED';
+    ELSE
+        kind := 'UPDATED';
+    END IF;
+
+    INSERT INTO user_events (event_type, id, name, email, age, created_at, updated_at, deleted_at, version, labels)
+    VALUES (kind, NEW.id, NEW.name, NEW.email, NEW.age, NEW.created_at, NEW.updated_at, NEW.deleted_at, NEW.version, NEW.labels)
+    RETURNING seq INTO new_seq;
+    PERFORM pg_notify('user_events', new_seq::text);
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER record_users_event
+    AFTER INSERT OR UPDATE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION record_user_event();
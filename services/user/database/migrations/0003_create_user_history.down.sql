@@ -0,0 +1,3 @@
+DROP TRIGGER record_users_history ON users;
+DROP FUNCTION record_user_history();
+DROP TABLE user_history;
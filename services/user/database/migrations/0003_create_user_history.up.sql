@@ -0,0 +1,56 @@
+-- History: every write to users, with its before and after rows, and the actor set by the writer.
+-- user_id does not reference users, so the history outlives the purge of its user.
+-- Purges have no after row.
+CREATE TABLE user_history (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    change_type VARCHAR(16) NOT NULL,
+    before JSONB,
+    after JSONB,
+    actor TEXT NOT NULL DEFAULT '',
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX user_history_user_id_idx ON user_history (user_id, changed_at);
+
+CREATE FUNCTION record_user_history()
+RETURNS TRIGGER AS $$
+DECLARE
+    kind VARCHAR(16);
+    changed_id UUID;
+    old_row JSONB;
+    new_row JSONB;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        kind := 'PURGED';
+        changed_id := OLD.id;
+        old_row := to_jsonb(OLD) - 'search_vector';
+    ELSE
+        changed_id := NEW.id;
+        new_row := to_jsonb(NEW) - 'search_vector';
+        IF TG_OP = 'INSERT' THEN
+            kind := 'CREATED';
+        ELSE
+            old_row := to_jsonb(OLD) - 'search_vector';
+            IF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+                kind := 'DELETED';
+            ELSE
+                kind := 'UPDATED';
+            END IF;
+        END IF;
+    END IF;
+
+    INSERT INTO user_history (user_id, change_type, before, after, actor)
+    VALUES (changed_id, kind, old_row, new_row, COALESCE(current_setting('app.actor', true), ''));
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER record_users_history
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION record_user_history();
+
+-- Users written before the history was recorded start with their current row
+INSERT INTO user_history (user_id, change_type, after, changed_at)
+SELECT id, 'CREATED', to_jsonb(users) - 'search_vector', COALESCE(updated_at, created_at, CURRENT_TIMESTAMP)
+FROM users;
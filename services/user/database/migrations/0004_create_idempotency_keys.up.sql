@@ -0,0 +1,9 @@
+-- Idempotency keys: the user created by each CreateUserRequest.request_id.
+-- The key of a purged user is kept until it expires, so a replayed request fails instead of creating the user again.
+CREATE TABLE idempotency_keys (
+    request_id VARCHAR(100) PRIMARY KEY,
+    request_hash VARCHAR(64) NOT NULL,
+    user_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX idempotency_keys_created_at_idx ON idempotency_keys (created_at);
@@ -0,0 +1,3 @@
+DROP TRIGGER record_users_outbox ON users;
+DROP FUNCTION record_user_outbox();
+DROP TABLE outbox;
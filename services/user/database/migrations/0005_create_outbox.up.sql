@@ -0,0 +1,45 @@
+-- Transactional outbox: every write to users inserts its event in the transaction of the write,
+-- deleted once the relay published it, so no committed write goes unpublished.
+-- Failed events are retried at next_attempt_at, the later events of their user wait for them.
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL,
+    event_type VARCHAR(16) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT
+);
+CREATE INDEX outbox_user_id_idx ON outbox (user_id, id);
+
+CREATE FUNCTION record_user_outbox()
+RETURNS TRIGGER AS $$
+DECLARE
+    kind VARCHAR(16);
+    changed users;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'CREATED';
+        changed := NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        kind := 'PURGED';
+        changed := OLD;
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'DELETED';
+        changed := NEW;
+    ELSE
+        kind := 'UPDATED';
+        changed := NEW;
+    END IF;
+
+    INSERT INTO outbox (user_id, event_type, payload)
+    VALUES (changed.id, kind, to_jsonb(changed) - 'search_vector' - 'legacy_id');
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER record_users_outbox
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION record_user_outbox();
@@ -0,0 +1,5 @@
+DROP TRIGGER record_users_webhook_deliveries ON users;
+DROP FUNCTION record_user_webhook_deliveries();
+DROP TABLE webhook_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhooks;
@@ -0,0 +1,76 @@
+-- Webhooks: HTTP endpoints notified of the user writes, see the webhook package.
+-- event_types filters the notified writes, empty means all of them.
+-- A webhook failing WEBHOOK_MAX_FAILURES deliveries in a row is disabled, until it is enabled again.
+CREATE TABLE webhooks (
+    id UUID PRIMARY KEY DEFAULT uuid_v7_at(clock_timestamp()::TIMESTAMP),
+    url TEXT NOT NULL,
+    event_types VARCHAR(16)[] NOT NULL DEFAULT '{}',
+    secret TEXT NOT NULL,
+    consecutive_failures INTEGER NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Every write to users inserts a delivery per matching enabled webhook, in the transaction of the write.
+-- Failed deliveries are retried at next_attempt_at, the later deliveries of their webhook wait for them.
+-- delivered_at is set once the endpoint accepted it, the delivery is kept with its attempts until purged.
+CREATE TABLE webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id UUID NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+    event_type VARCHAR(16) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP
+);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (webhook_id, id) WHERE delivered_at IS NULL;
+CREATE INDEX webhook_deliveries_created_at_idx ON webhook_deliveries (created_at);
+
+-- Every attempt to deliver, successful or not.
+-- status_code is 0 when no response was received, error is empty on success.
+CREATE TABLE webhook_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    webhook_id UUID NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER NOT NULL,
+    error TEXT NOT NULL,
+    duration_ms INTEGER NOT NULL,
+    attempted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX webhook_attempts_webhook_id_idx ON webhook_attempts (webhook_id, id);
+
+CREATE FUNCTION record_user_webhook_deliveries()
+RETURNS TRIGGER AS $$
+DECLARE
+    kind VARCHAR(16);
+    changed users;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        kind := 'CREATED';
+        changed := NEW;
+    ELSIF TG_OP = 'DELETE' THEN
+        kind := 'PURGED';
+        changed := OLD;
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        kind := 'DELETED';
+        changed := NEW;
+    ELSE
+        kind := 'UPDATED';
+        changed := NEW;
+    END IF;
+
+    INSERT INTO webhook_deliveries (webhook_id, event_type, payload)
+    SELECT id, kind, to_jsonb(changed) - 'search_vector' - 'legacy_id'
+    FROM webhooks
+    WHERE disabled_at IS NULL AND (event_types = '{}' OR kind = ANY(event_types));
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER record_users_webhook_deliveries
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION record_user_webhook_deliveries();
@@ -0,0 +1,2 @@
+DROP INDEX webhook_deliveries_delivered_at_idx;
+CREATE INDEX webhook_deliveries_created_at_idx ON webhook_deliveries (created_at);
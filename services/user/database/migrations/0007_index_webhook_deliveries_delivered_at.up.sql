@@ -0,0 +1,3 @@
+-- Deliveries are purged once delivered, or abandoned on a disabled webhook, not by their creation.
+DROP INDEX webhook_deliveries_created_at_idx;
+CREATE INDEX webhook_deliveries_delivered_at_idx ON webhook_deliveries (delivered_at) WHERE delivered_at IS NOT NULL;
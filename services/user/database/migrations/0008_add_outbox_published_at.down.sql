@@ -0,0 +1,5 @@
+DROP INDEX outbox_published_at_idx;
+DROP INDEX outbox_pending_idx;
+DELETE FROM outbox WHERE published_at IS NOT NULL;
+ALTER TABLE outbox DROP COLUMN published_at;
+CREATE INDEX outbox_user_id_idx ON outbox (user_id, id);
@@ -0,0 +1,6 @@
+-- Published events are kept, with when they were published, until purged after the outbox retention,
+-- the unpublished ones are kept until published.
+ALTER TABLE outbox ADD COLUMN published_at TIMESTAMP;
+DROP INDEX outbox_user_id_idx;
+CREATE INDEX outbox_pending_idx ON outbox (user_id, id) WHERE published_at IS NULL;
+CREATE INDEX outbox_published_at_idx ON outbox (published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,67 @@
+-- The users schema of SQLiteClient, the dialect of the PostgreSQL migrations.
+-- SQLite triggers can't change the written row, so updated_at, version, the events and the history
+-- are written by the client, in the transaction of each write.
+-- Timestamps are UTC text in sqliteTimeFormat, which sorts as the time does.
+
+-- Users: soft deleted users have a deleted_at, and version (the etag) changes on every write.
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    age INTEGER NOT NULL,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    deleted_at TEXT,
+    version INTEGER NOT NULL DEFAULT 1,
+    labels TEXT NOT NULL DEFAULT '{}'
+);
+
+-- Soft delete: emails are only unique among live users
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_live_idx ON users (email) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Keyset pagination indexes for ListUsers order_by
+CREATE INDEX IF NOT EXISTS users_name_id_idx ON users (name, id);
+CREATE INDEX IF NOT EXISTS users_email_id_idx ON users (email, id);
+CREATE INDEX IF NOT EXISTS users_age_id_idx ON users (age, id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_updated_at_id_idx ON users (updated_at, id);
+
+-- Change stream: writes are serialized, so events are committed in seq order
+CREATE TABLE IF NOT EXISTS user_events (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type TEXT NOT NULL,
+    id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    age INTEGER NOT NULL,
+    created_at TEXT NOT NULL,
+    updated_at TEXT NOT NULL,
+    deleted_at TEXT,
+    version INTEGER NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    recorded_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_events_recorded_at_idx ON user_events (recorded_at);
+
+-- History: before and after are JSON users, user_id does not reference users,
+-- so the history outlives the purge of its user. Purges have no after row.
+CREATE TABLE IF NOT EXISTS user_history (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,
+    change_type TEXT NOT NULL,
+    before TEXT,
+    after TEXT,
+    actor TEXT NOT NULL DEFAULT '',
+    changed_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS user_history_user_id_idx ON user_history (user_id, changed_at);
+
+-- Idempotency keys: the key of a purged user is kept until it expires
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    request_id TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    user_id TEXT REFERENCES users(id) ON DELETE SET NULL,
+    created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idempotency_keys_created_at_idx ON idempotency_keys (created_at);
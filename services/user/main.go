@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"os"
+	"time"
 
+	"grpc-services/migrate"
 	"grpc-services/user/config"
 	"grpc-services/user/database"
+	"grpc-services/user/outbox"
 	"grpc-services/user/server"
+	"grpc-services/user/webhook"
 
 	"google.golang.org/grpc"
 
@@ -16,6 +21,9 @@ import (
 
 const (
 	defaultGRPCPort = "50051"
+
+	// cacheStatsInterval is how often the counters of the user cache are logged
+	cacheStatsInterval = time.Minute
 )
 
 func main() {
@@ -26,24 +34,58 @@ func main() {
 		panic(err)
 	}
 
-	// Initialize database
-	db, err := database.NewPostgresClient(cfg)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
-		panic(err)
+	// The migrate subcommand manages the schema, then exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(cfg, os.Args[2:])
+		return
 	}
-	defer db.Close()
 
-	// Create tables if they don't exist
-	if err := db.CreateTables(); err != nil {
-		log.Fatalf("Failed to create tables: %v", err)
-		panic(err)
+	// Initialize storage
+	var db database.SQLClientInterface
+	var webhooks database.WebhookStore
+	var outboxStore database.OutboxStore
+	switch cfg.StorageBackend {
+	case config.StorageMemory:
+		log.Println("Storing users in memory, they are lost when the service stops")
+		db = database.NewMemoryClient(cfg)
+	case config.StorageSQLite:
+		sqlite := openSQLite(cfg)
+		defer sqlite.Close()
+		db = sqlite
+	default:
+		postgres := openPostgres(cfg)
+		defer postgres.Close()
+		db = postgres
+		outboxStore = postgres
+		// Start publishing the outbox, when configured
+		if publisher := openOutboxPublisher(cfg); publisher != nil {
+			defer publisher.Close()
+			outbox.NewRelay(postgres, publisher, cfg.OutboxInterval).Start(context.Background())
+		}
+		// Serve and deliver the webhooks, when enabled
+		if cfg.WebhooksEnabled {
+			webhooks = postgres
+			webhook.NewWorker(postgres, cfg.WebhookInterval, cfg.WebhookTimeout, cfg.WebhookConcurrency, cfg.WebhookMaxFailures).Start(context.Background())
+		}
+	}
+	// Cache GetUser, when configured
+	if cfg.CacheSize > 0 {
+		cache := database.NewCachedClient(db, cfg)
+		go logCacheStats(cache)
+		db = cache
 	}
 
 	// Create server instance
 	userServer := server.NewServer(cfg, db)
+	userServer.Webhooks = webhooks
+	userServer.Outbox = outboxStore
+	// Start purging soft deleted users
+	userServer.StartBackgroundPurge(context.Background())
 	// Initialize gRPC server
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(server.ActorUnaryInterceptor, server.ReadPrimaryUnaryInterceptor),
+		grpc.ChainStreamInterceptor(server.ActorStreamInterceptor, server.ReadPrimaryStreamInterceptor),
+	)
 	// Register
 	pb.RegisterUserServiceServer(grpcServer, userServer)
 
@@ -61,6 +103,97 @@ func main() {
 	}
 }
 
+// openOutboxPublisher
+// Returns the publisher of the outbox events, nil with OutboxNone.
+func openOutboxPublisher(cfg *config.Config) *outbox.NDJSONPublisher {
+	if cfg.OutboxPublisher != config.OutboxNDJSON {
+		return nil
+	}
+	publisher, err := outbox.OpenNDJSONFile(cfg.OutboxPath)
+	if err != nil {
+		log.Fatalf("Failed to open the outbox file: %v", err)
+		panic(err)
+	}
+	log.Printf("Publishing the outbox events to %s", cfg.OutboxPath)
+	return publisher
+}
+
+// logCacheStats
+// Logs the counters of the cache every cacheStatsInterval.
+func logCacheStats(cache *database.CachedClient) {
+	ticker := time.NewTicker(cacheStatsInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		stats := cache.Stats()
+		log.Printf("User cache: %d hits (%d not found), %d misses, %d bypasses, %d evictions",
+			stats.Hits, stats.NegativeHits, stats.Misses, stats.Bypasses, stats.Evictions)
+	}
+}
+
+// openPostgres
+// Connects to PostgreSQL, applies the pending migrations and normalizes the stored emails.
+func openPostgres(cfg *config.Config) *database.SQLClient {
+	db, err := database.NewPostgresClient(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+		panic(err)
+	}
+
+	// Apply the pending migrations
+	if err := db.CreateTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+		panic(err)
+	}
+
+	// Normalize the emails stored before they were, conflicts are left for an operator to resolve
+	conflicts, err := db.NormalizeEmails(context.Background(), cfg.FoldEmailLocalPart)
+	if err != nil {
+		log.Fatalf("Failed to normalize emails: %v", err)
+		panic(err)
+	}
+	for _, conflict := range conflicts {
+		log.Printf("Email conflict: users %v all normalize to %q, their emails were left unchanged", conflict.IDs, conflict.Email)
+	}
+	return db
+}
+
+// openSQLite
+// Opens the SQLite file and creates its missing tables.
+func openSQLite(cfg *config.Config) *database.SQLiteClient {
+	db, err := database.NewSQLiteClient(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+		panic(err)
+	}
+
+	if err := db.CreateTables(); err != nil {
+		log.Fatalf("Failed to create tables: %v", err)
+		panic(err)
+	}
+	return db
+}
+
+// runMigrate
+// Runs the migrate subcommand on the PostgreSQL schema, see migrate.Run.
+func runMigrate(cfg *config.Config, args []string) {
+	if cfg.StorageBackend != config.StoragePostgres {
+		log.Fatalf("The migrate subcommand needs STORAGE_BACKEND=%s", config.StoragePostgres)
+	}
+	db, err := database.NewPostgresClient(cfg)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer db.Close()
+
+	migrator, err := db.Migrator()
+	if err != nil {
+		log.Fatalf("Failed to load migrations: %v", err)
+	}
+	if err := migrate.Run(context.Background(), migrator, args, os.Stdout); err != nil {
+		log.Fatalf("Failed to migrate: %v", err)
+	}
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
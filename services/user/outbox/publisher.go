@@ -0,0 +1,141 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"grpc-services/user/database"
+)
+
+// EventPublisher
+// Delivers the outbox events to other systems, see Relay.
+// Publish is called with the events of a user in order, and again for an event until it returns nil.
+// An event may be published more than once, when the relay stops before recording it,
+// consumers skip the events whose ID they already got.
+type EventPublisher interface {
+	Publish(ctx context.Context, event *database.OutboxEvent) error
+}
+
+// ChannelPublisher
+// Publishes the events on C, for consumers in the same process.
+// Publish waits for a consumer to receive the event, or for ctx to be done.
+type ChannelPublisher struct {
+	C chan *database.OutboxEvent
+}
+
+// NewChannelPublisher
+// Returns a ChannelPublisher whose channel buffers size events.
+func NewChannelPublisher(size int) *ChannelPublisher {
+	return &ChannelPublisher{C: make(chan *database.OutboxEvent, size)}
+}
+
+func (p *ChannelPublisher) Publish(ctx context.Context, event *database.OutboxEvent) error {
+	select {
+	case p.C <- event:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// NDJSONEvent
+// An event as written by NDJSONPublisher, one JSON object per line.
+type NDJSONEvent struct {
+	ID        int64      `json:"id"`
+	Type      string     `json:"type"`
+	CreatedAt time.Time  `json:"created_at"`
+	User      NDJSONUser `json:"user"`
+}
+
+// NDJSONUser
+// The user of an NDJSONEvent.
+type NDJSONUser struct {
+	ID        string            `json:"id"`
+	Name      string            `json:"name"`
+	Email     string            `json:"email"`
+	Age       int32             `json:"age"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+	DeletedAt *time.Time        `json:"deleted_at,omitempty"`
+	Version   int64             `json:"version"`
+	Labels    map[string]string `json:"labels"`
+}
+
+// NewNDJSONUser
+// Converts a user, also used by the webhook deliveries, so both have the same JSON.
+func NewNDJSONUser(user *database.UserRow) NDJSONUser {
+	return NDJSONUser{
+		ID:        user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Age:       user.Age,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: user.UpdatedAt,
+		DeletedAt: user.DeletedAt,
+		Version:   user.Version,
+		Labels:    user.Labels,
+	}
+}
+
+// NDJSONPublisher
+// Appends the events to w as newline delimited JSON, see NDJSONEvent.
+// When w is a file, every event is synced to disk before being published.
+type NDJSONPublisher struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewNDJSONPublisher
+// Returns a NDJSONPublisher writing to w.
+func NewNDJSONPublisher(w io.Writer) *NDJSONPublisher {
+	return &NDJSONPublisher{w: w}
+}
+
+// OpenNDJSONFile
+// Returns a NDJSONPublisher appending to the file at path, created if missing.
+//
+// Errors:
+//   - Failed to open the file.
+func OpenNDJSONFile(path string) (*NDJSONPublisher, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	return NewNDJSONPublisher(file), nil
+}
+
+func (p *NDJSONPublisher) Publish(ctx context.Context, event *database.OutboxEvent) error {
+	line, err := json.Marshal(&NDJSONEvent{
+		ID:        event.ID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		User:      NewNDJSONUser(event.User),
+	})
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if file, ok := p.w.(*os.File); ok {
+		return file.Sync()
+	}
+	return nil
+}
+
+// Close
+// Closes w, when it is a io.Closer.
+func (p *NDJSONPublisher) Close() error {
+	if closer, ok := p.w.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
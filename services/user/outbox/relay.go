@@ -0,0 +1,78 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"grpc-services/user/database"
+)
+
+// relayBatchSize
+// The maximum number of events published per pass.
+const relayBatchSize = 100
+
+// Store
+// The outbox the relay reads, implemented by database.SQLClient, see database.SQLClient.RelayOutbox.
+type Store interface {
+	RelayOutbox(ctx context.Context, limit int, publish func(*database.OutboxEvent) error) (int, error)
+}
+
+// Relay
+// Publishes the events of the outbox with its EventPublisher, at least once, in order per user.
+type Relay struct {
+	store     Store
+	publisher EventPublisher
+	interval  time.Duration
+}
+
+// NewRelay
+// Returns a Relay reading store every interval, and right away while events are left.
+func NewRelay(store Store, publisher EventPublisher, interval time.Duration) *Relay {
+	return &Relay{store: store, publisher: publisher, interval: interval}
+}
+
+// Start
+// Runs the relay in the background, until ctx is done.
+func (r *Relay) Start(ctx context.Context) {
+	go func() {
+		r.Run(ctx)
+		log.Println("Outbox relay stopped")
+	}()
+}
+
+// Run
+// Publishes the events of the outbox until ctx is done.
+// Failed passes are logged and retried after interval.
+//
+// Errors:
+//   - ctx error, when it is done.
+func (r *Relay) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		published, err := r.store.RelayOutbox(ctx, relayBatchSize, func(event *database.OutboxEvent) error {
+			err := r.publisher.Publish(ctx, event)
+			if err != nil && ctx.Err() == nil {
+				log.Printf("Failed to publish outbox event %d, attempt %d: %v", event.ID, event.Attempts+1, err)
+			}
+			return err
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to relay the outbox: %v", err)
+		}
+
+		// A full batch may have more events waiting
+		if published == relayBatchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.interval)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+
+	"grpc-services/user/database"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ActorMetadataKey
+// The metadata key clients set to identify who makes a request, e.g. a user or a service name.
+// Writes made by the request are recorded in the user history with it.
+const ActorMetadataKey = "x-actor"
+
+// actorContext
+// Returns ctx carrying the actor of the incoming metadata, see database.WithActor.
+func actorContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(ActorMetadataKey); len(values) > 0 && values[0] != "" {
+		return database.WithActor(ctx, values[0])
+	}
+	return ctx
+}
+
+// ActorUnaryInterceptor
+// Passes the actor of unary requests to the database, see ActorMetadataKey.
+func ActorUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(actorContext(ctx), req)
+}
+
+// ActorStreamInterceptor
+// Passes the actor of streaming requests to the database, see ActorMetadataKey.
+func ActorStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: stream, ctx: actorContext(stream.Context())})
+}
+
+// contextStream
+// A ServerStream with the context set by an interceptor.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
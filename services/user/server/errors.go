@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"grpc-services/user/database"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// errorDomain
+// The ErrorInfo domain of the errors returned by the service.
+const errorDomain = "user.grpc-services"
+
+// ErrorInfo reasons, stable values clients can switch on
+const (
+	ReasonUserNotFound        = "USER_NOT_FOUND"
+	ReasonEmailAlreadyExists  = "EMAIL_ALREADY_EXISTS"
+	ReasonAlreadyExists       = "ALREADY_EXISTS"
+	ReasonConstraintViolation = "CONSTRAINT_VIOLATION"
+	ReasonEtagMismatch        = "ETAG_MISMATCH"
+	ReasonRequestIDReused     = "REQUEST_ID_REUSED"
+	ReasonTimeout             = "TIMEOUT"
+	ReasonCanceled            = "CANCELED"
+	ReasonDatabaseUnavailable = "DATABASE_UNAVAILABLE"
+	ReasonDatabaseError       = "DATABASE_ERROR"
+	ReasonWebhookNotFound     = "WEBHOOK_NOT_FOUND"
+)
+
+// resourceTypes
+// The ResourceInfo types of the tables whose rows a database error can be about, see dbStatus.
+var resourceTypes = map[string]string{
+	"users":            "user",
+	"idempotency_keys": "idempotency_key",
+	"webhooks":         "webhook",
+}
+
+// dbError
+// Converts a database error to a gRPC error, see dbStatus.
+func dbError(ctx context.Context, err error, action, resource string) error {
+	return dbStatus(ctx, err, action, resource).Err()
+}
+
+// dbStatus
+// Converts a database error to a status, by its database.Classify class.
+// When ctx is done, its error wins, as the driver may report it as a failed statement.
+// action describes what failed, e.g. "create user", and resource names the user it was about (id or email).
+// Only a duplicate email (database.EmailConstraint) is an email conflict, other unique violations,
+// e.g. of a legacy id or an idempotency key, are reported as a generic conflict, on the resource of their table.
+//
+// Returns:
+//   - A status with an ErrorInfo detail, and a ResourceInfo detail when resource is set, or the conflict is not on a user.
+func dbStatus(ctx context.Context, err error, action, resource string) *status.Status {
+	class := database.Classify(err)
+	if ctx.Err() != nil {
+		class = database.ClassTimeout
+		if errors.Is(ctx.Err(), context.Canceled) {
+			class = database.ClassCanceled
+		}
+	}
+
+	var code codes.Code
+	var reason, message string
+	resourceType := "user"
+	switch class {
+	case database.ClassNotFound:
+		code, reason, message = codes.NotFound, ReasonUserNotFound, "user not found"
+	case database.ClassAlreadyExists:
+		if database.Constraint(err) == database.EmailConstraint {
+			code, reason, message = codes.AlreadyExists, ReasonEmailAlreadyExists, "a user with this email already exists"
+			break
+		}
+		code, reason = codes.AlreadyExists, ReasonAlreadyExists
+		if conflictType, ok := resourceTypes[database.ConstraintTable(err)]; ok && conflictType != resourceType {
+			// The resource names the user, not the conflicting row
+			resourceType, resource = conflictType, ""
+		}
+		message = fmt.Sprintf("failed to %s: the %s already exists", action, strings.ReplaceAll(resourceType, "_", " "))
+	case database.ClassConstraint:
+		code, reason, message = codes.FailedPrecondition, ReasonConstraintViolation, fmt.Sprintf("failed to %s: constraint violation", action)
+	case database.ClassEtagMismatch:
+		code, reason, message = codes.Aborted, ReasonEtagMismatch, "etag mismatch: the user was modified, get it again and retry"
+	case database.ClassRequestIDReused:
+		code, reason, message = codes.InvalidArgument, ReasonRequestIDReused, "request_id was already used by a different request"
+	case database.ClassTimeout:
+		code, reason, message = codes.DeadlineExceeded, ReasonTimeout, fmt.Sprintf("failed to %s: deadline exceeded", action)
+	case database.ClassCanceled:
+		code, reason, message = codes.Canceled, ReasonCanceled, fmt.Sprintf("failed to %s: request canceled", action)
+	case database.ClassUnavailable:
+		code, reason, message = codes.Unavailable, ReasonDatabaseUnavailable, fmt.Sprintf("failed to %s: database unavailable", action)
+	default:
+		code, reason, message = codes.Internal, ReasonDatabaseError, fmt.Sprintf("failed to %s: %v", action, err)
+	}
+
+	info := &errdetails.ErrorInfo{Reason: reason, Domain: errorDomain}
+	if constraint := database.Constraint(err); constraint != "" {
+		info.Metadata = map[string]string{"constraint": constraint}
+	}
+	details := []protoadapt.MessageV1{info}
+	if resource != "" || resourceType != "user" {
+		details = append(details, &errdetails.ResourceInfo{
+			ResourceType: resourceType,
+			ResourceName: resource,
+			Description:  message,
+		})
+	}
+
+	st := status.New(code, message)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		return withDetails
+	}
+	return st
+}
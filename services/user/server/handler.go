@@ -2,12 +2,14 @@ package server
 
 import (
 	"context"
-	"strings"
+	"fmt"
 
 	pb "grpc-services/user/proto"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Handlers
@@ -24,17 +26,9 @@ import (
 // CreateUser handler
 func (s *Server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.UserResponse, error) {
 	// Validate Request
-	if req.GetName() == "" {
-		return nil, status.Error(codes.InvalidArgument, "name cannot be empty")
-	}
-	if req.GetEmail() == "" {
-		return nil, status.Error(codes.InvalidArgument, "email cannot be empty")
-	}
-	if req.GetAge() <= 0 {
-		return nil, status.Error(codes.InvalidArgument, "age must be positive")
-	}
-	if !strings.Contains(req.GetEmail(), "@") {
-		return nil, status.Error(codes.InvalidArgument, "invalid email format")
+	req.Email = s.normalizeEmail(req.GetEmail())
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
 	}
 
 	// Execute Logic
@@ -44,31 +38,51 @@ func (s *Server) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb
 // GetUser handler
 func (s *Server) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.UserResponse, error) {
 	// Validate Request
-	if req.GetId() == "" {
-		return nil, status.Error(codes.InvalidArgument, "user ID cannot be empty")
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
 	}
 
 	// Execute Logic
 	return s.getUser(ctx, req)
 }
 
-// UpdateUser handler
-func (s *Server) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UserResponse, error) {
+// ListUserHistory handler
+func (s *Server) ListUserHistory(ctx context.Context, req *pb.ListUserHistoryRequest) (*pb.ListUserHistoryResponse, error) {
 	// Validate Request
-	if req.GetId() == "" {
-		return nil, status.Error(codes.InvalidArgument, "user ID cannot be empty")
-	}
-	if req.GetName() == "" {
-		return nil, status.Error(codes.InvalidArgument, "name cannot be empty")
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
 	}
-	if req.GetEmail() == "" {
-		return nil, status.Error(codes.InvalidArgument, "email cannot be empty")
+
+	// Execute Logic
+	return s.listUserHistory(ctx, req)
+}
+
+// GetUserByEmail handler
+func (s *Server) GetUserByEmail(ctx context.Context, req *pb.GetUserByEmailRequest) (*pb.UserResponse, error) {
+	// Validate Request
+	req.Email = s.normalizeEmail(req.GetEmail())
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
 	}
-	if req.GetAge() <= 0 {
-		return nil, status.Error(codes.InvalidArgument, "age must be positive")
+
+	// Execute Logic
+	return s.getUserByEmail(ctx, req)
+}
+
+// UpdateUser handler
+// Only the id and the fields listed in update_mask are validated,
+// an empty mask validates all of them.
+func (s *Server) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UserResponse, error) {
+	// Validate Request
+	paths := updatePaths(req)
+	for _, path := range paths {
+		if path != fieldName && path != fieldEmail && path != fieldAge && path != fieldLabels {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid update_mask path: %q", path))
+		}
 	}
-	if !strings.Contains(req.GetEmail(), "@") {
-		return nil, status.Error(codes.InvalidArgument, "invalid email format")
+	req.Email = s.normalizeEmail(req.GetEmail())
+	if err := validateRules(req, append([]string{"id"}, paths...)); err != nil {
+		return nil, err
 	}
 
 	// Execute Logic
@@ -78,24 +92,240 @@ func (s *Server) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb
 // DeleteUser handler
 func (s *Server) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
 	// Validate Request
-	if req.GetId() == "" {
-		return nil, status.Error(codes.InvalidArgument, "user ID cannot be empty")
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
 	}
 
 	// Execute Logic
 	return s.deleteUser(ctx, req)
 }
 
+// UndeleteUser handler
+func (s *Server) UndeleteUser(ctx context.Context, req *pb.UndeleteUserRequest) (*pb.UserResponse, error) {
+	// Validate Request
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.undeleteUser(ctx, req)
+}
+
+// BatchCreateUsers handler
+// In all-or-nothing mode every item must be valid,
+// in best-effort mode invalid items are reported in their result.
+func (s *Server) BatchCreateUsers(ctx context.Context, req *pb.BatchCreateUsersRequest) (*pb.BatchUsersResponse, error) {
+	// Validate Request
+	if err := validateBatch(len(req.GetRequests()), req.GetMode()); err != nil {
+		return nil, err
+	}
+	for _, item := range req.GetRequests() {
+		item.Email = s.normalizeEmail(item.GetEmail())
+	}
+	if isAtomic(req.GetMode()) {
+		for i, item := range req.GetRequests() {
+			if err := validateRules(item, nil); err != nil {
+				return nil, batchItemError(i, status.Convert(err))
+			}
+		}
+	}
+
+	// Execute Logic
+	return s.batchCreateUsers(ctx, req)
+}
+
+// BatchGetUsers handler
+func (s *Server) BatchGetUsers(ctx context.Context, req *pb.BatchGetUsersRequest) (*pb.BatchUsersResponse, error) {
+	// Validate Request
+	if err := validateBatch(len(req.GetIds()), req.GetMode()); err != nil {
+		return nil, err
+	}
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.batchGetUsers(ctx, req)
+}
+
+// BatchDeleteUsers handler
+func (s *Server) BatchDeleteUsers(ctx context.Context, req *pb.BatchDeleteUsersRequest) (*pb.BatchUsersResponse, error) {
+	// Validate Request
+	if err := validateBatch(len(req.GetRequests()), req.GetMode()); err != nil {
+		return nil, err
+	}
+	for i, item := range req.GetRequests() {
+		if err := validateRules(item, nil); err != nil {
+			return nil, batchItemError(i, status.Convert(err))
+		}
+	}
+
+	// Execute Logic
+	return s.batchDeleteUsers(ctx, req)
+}
+
 // ListUsers handler
 func (s *Server) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
 	// Validate Request
-	if req.GetPage() < 0 {
-		return nil, status.Error(codes.InvalidArgument, "page cannot be negative")
+	var violations []*errdetails.BadRequest_FieldViolation
+	if _, ok := pb.TotalMode_name[int32(req.GetTotalMode())]; !ok {
+		violations = append(violations, fieldViolation("total_mode", fmt.Sprintf("invalid total mode %d", req.GetTotalMode())))
 	}
-	if req.GetLimit() < 0 {
-		return nil, status.Error(codes.InvalidArgument, "limit cannot be negative")
+	if err := validateFilter(req, violations...); err != nil {
+		return nil, err
 	}
 
 	// Execute Logic
 	return s.listUsers(ctx, req)
 }
+
+// SearchUsers handler
+func (s *Server) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	// Validate Request
+	if err := validateRules(req, nil); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.searchUsers(ctx, req)
+}
+
+// ExportUsers handler
+func (s *Server) ExportUsers(req *pb.ExportUsersRequest, stream pb.UserService_ExportUsersServer) error {
+	// Validate Request
+	if err := validateFilter(req); err != nil {
+		return err
+	}
+
+	// Execute Logic
+	return s.exportUsers(req, stream)
+}
+
+// ImportUsers handler
+// Records are validated one by one as they are received,
+// invalid records are reported in their result instead of failing the stream,
+// but a message changing the upsert or dry_run options of the first one fails it.
+func (s *Server) ImportUsers(stream pb.UserService_ImportUsersServer) error {
+	// Execute Logic
+	return s.importUsers(stream)
+}
+
+// WatchUsers handler
+func (s *Server) WatchUsers(req *pb.WatchUsersRequest, stream pb.UserService_WatchUsersServer) error {
+	// Validate Request
+	if err := validateRules(req, nil); err != nil {
+		return err
+	}
+
+	// Execute Logic
+	return s.watchUsers(req, stream)
+}
+
+// CreateWebhook handler
+func (s *Server) CreateWebhook(ctx context.Context, req *pb.CreateWebhookRequest) (*pb.WebhookResponse, error) {
+	// Validate Request
+	if err := validateRules(req, nil, webhookViolations(req)...); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.createWebhook(ctx, req)
+}
+
+// GetWebhook handler
+func (s *Server) GetWebhook(ctx context.Context, req *pb.GetWebhookRequest) (*pb.WebhookResponse, error) {
+	// Validate Request
+	if err := validateRules(req, nil, webhookIDViolations("id", req.GetId())...); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.getWebhook(ctx, req)
+}
+
+// ListWebhooks handler
+func (s *Server) ListWebhooks(ctx context.Context, req *pb.ListWebhooksRequest) (*pb.ListWebhooksResponse, error) {
+	// Execute Logic
+	return s.listWebhooks(ctx, req)
+}
+
+// EnableWebhook handler
+func (s *Server) EnableWebhook(ctx context.Context, req *pb.EnableWebhookRequest) (*pb.WebhookResponse, error) {
+	// Validate Request
+	if err := validateRules(req, nil, webhookIDViolations("id", req.GetId())...); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.enableWebhook(ctx, req)
+}
+
+// DeleteWebhook handler
+func (s *Server) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.DeleteWebhookResponse, error) {
+	// Validate Request
+	if err := validateRules(req, nil, webhookIDViolations("id", req.GetId())...); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.deleteWebhook(ctx, req)
+}
+
+// ListWebhookAttempts handler
+func (s *Server) ListWebhookAttempts(ctx context.Context, req *pb.ListWebhookAttemptsRequest) (*pb.ListWebhookAttemptsResponse, error) {
+	// Validate Request
+	if err := validateRules(req, nil, webhookIDViolations("webhook_id", req.GetWebhookId())...); err != nil {
+		return nil, err
+	}
+
+	// Execute Logic
+	return s.listWebhookAttempts(ctx, req)
+}
+
+// validateFilter
+// Ensures the (rules) of the request hold, and the ListUsers filters describe a non empty range.
+// Every violation is reported at once, with the violations of the other fields of the request.
+func validateFilter(req filterRequest, violations ...*errdetails.BadRequest_FieldViolation) error {
+	if req.GetMaxAge() > 0 && req.GetMinAge() > req.GetMaxAge() {
+		violations = append(violations, fieldViolation("min_age", "min_age cannot be greater than max_age"))
+	}
+	violations = append(violations, timeWindowViolations("created", req.GetCreatedAfter(), req.GetCreatedBefore())...)
+	violations = append(violations, timeWindowViolations("updated", req.GetUpdatedAfter(), req.GetUpdatedBefore())...)
+	if _, err := parseLabelSelector(req.GetLabelSelector()); err != nil {
+		violations = append(violations, fieldViolation("label_selector", fmt.Sprintf("invalid label_selector: %v", err)))
+	}
+	return validateRules(req, nil, violations...)
+}
+
+// timeWindowViolations
+// Returns the violations of the <name>_after and <name>_before timestamps,
+// when they are invalid, or describe an empty window.
+func timeWindowViolations(name string, after, before *timestamppb.Timestamp) []*errdetails.BadRequest_FieldViolation {
+	afterField, beforeField := name+"_after", name+"_before"
+	var violations []*errdetails.BadRequest_FieldViolation
+	if after != nil && after.CheckValid() != nil {
+		violations = append(violations, fieldViolation(afterField, fmt.Sprintf("invalid %s timestamp", afterField)))
+	}
+	if before != nil && before.CheckValid() != nil {
+		violations = append(violations, fieldViolation(beforeField, fmt.Sprintf("invalid %s timestamp", beforeField)))
+	}
+	if len(violations) == 0 && after != nil && before != nil && !after.AsTime().Before(before.AsTime()) {
+		violations = append(violations, fieldViolation(afterField, fmt.Sprintf("%s must be before %s", afterField, beforeField)))
+	}
+	return violations
+}
+
+// validateBatch
+// Ensures a batch has between 1 and maxBatchSize items, and a known mode.
+func validateBatch(size int, mode pb.BatchMode) error {
+	if size == 0 {
+		return status.Error(codes.InvalidArgument, "batch cannot be empty")
+	}
+	if size > maxBatchSize {
+		return status.Error(codes.InvalidArgument, fmt.Sprintf("batch cannot have more than %d items", maxBatchSize))
+	}
+	if _, ok := pb.BatchMode_name[int32(mode)]; !ok {
+		return status.Error(codes.InvalidArgument, "invalid batch mode")
+	}
+	return nil
+}
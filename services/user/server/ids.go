@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// userNamePrefix
+// The prefix of user resource names, "users/{id}".
+const userNamePrefix = "users/"
+
+// parseID
+// Parses a user id, or a "users/{id}" resource name.
+//
+// Returns:
+//   - The canonical (lowercase) form of a UUID id.
+//   - The legacy id of a numeric id, to look up with resolveIDs.
+//
+// Errors:
+//   - InvalidArgument: When the id is neither a UUID nor a positive number.
+func parseID(idString string) (string, int, error) {
+	idString = strings.TrimPrefix(idString, userNamePrefix)
+	if id, err := uuid.Parse(idString); err == nil && len(idString) == 36 {
+		return id.String(), 0, nil
+	}
+	if legacyID, err := strconv.Atoi(idString); err == nil && legacyID > 0 {
+		return "", legacyID, nil
+	}
+	return "", 0, status.Error(codes.InvalidArgument, "invalid user ID")
+}
+
+// resolveID
+// Parses a user id, looking up legacy numeric ids, see resolveIDs.
+//
+// Errors:
+//   - InvalidArgument: When the id is malformed, or is a legacy id while they are rejected.
+//   - NotFound: When no user has the legacy id.
+//   - Other: The dbError of failing to look up the legacy id.
+func (s *Server) resolveID(ctx context.Context, idString string) (string, error) {
+	ids, errs, err := s.resolveIDs(ctx, []string{idString})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], errs[0]
+}
+
+// resolveIDs
+// Parses user ids, each a UUID, a "users/{id}" resource name, or a legacy numeric id.
+// Legacy ids are the ids of users created before ids were UUIDs,
+// they are looked up in a single statement, unless Config.RejectLegacyIDs.
+//
+// Returns:
+//   - The id of each idString, empty when it can't be resolved.
+//   - The error of each idString that can't be resolved (InvalidArgument or NotFound, see resolveID), nil otherwise.
+//
+// Errors:
+//   - Other: The dbError of failing to look up the legacy ids.
+func (s *Server) resolveIDs(ctx context.Context, idStrings []string) ([]string, []error, error) {
+	ids := make([]string, len(idStrings))
+	errs := make([]error, len(idStrings))
+	legacyIDs := make([]int, len(idStrings))
+	var lookup []int
+	for i, idString := range idStrings {
+		ids[i], legacyIDs[i], errs[i] = parseID(idString)
+		if legacyIDs[i] == 0 {
+			continue
+		}
+		if s.Config != nil && s.Config.RejectLegacyIDs {
+			errs[i] = status.Error(codes.InvalidArgument, "invalid user ID: legacy numeric ids are no longer accepted")
+			continue
+		}
+		lookup = append(lookup, legacyIDs[i])
+	}
+	if len(lookup) == 0 {
+		return ids, errs, nil
+	}
+
+	found, err := s.DB.LegacyUserIDs(ctx, lookup)
+	if err != nil {
+		return nil, nil, dbError(ctx, err, "look up legacy user ids", "")
+	}
+	for i, legacyID := range legacyIDs {
+		if legacyID == 0 || errs[i] != nil {
+			continue
+		}
+		if id, ok := found[legacyID]; ok {
+			ids[i] = id
+		} else {
+			errs[i] = dbError(ctx, sql.ErrNoRows, "look up legacy user id", idStrings[i])
+		}
+	}
+	return ids, errs, nil
+}
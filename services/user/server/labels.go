@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"grpc-services/user/database"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Limits of the labels of a user.
+const (
+	maxLabels          = 64
+	maxLabelNameLen    = 63
+	maxLabelPrefixLen  = 253
+	maxLabelValueLen   = 63
+	labelNameFormat    = `[A-Za-z0-9]([-_.A-Za-z0-9]*[A-Za-z0-9])?`
+	labelPrefixFormat  = `[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*`
+	labelNameFormatMsg = "alphanumeric, '-', '_' or '.', starting and ending with an alphanumeric character"
+)
+
+var (
+	labelNamePattern   = regexp.MustCompile(`^` + labelNameFormat + `$`)
+	labelPrefixPattern = regexp.MustCompile(`^` + labelPrefixFormat + `$`)
+
+	// setRequirementPattern matches "key in (v1,v2)" and "key notin (v1,v2)".
+	setRequirementPattern = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+)
+
+// labelViolations
+// Returns the descriptions of the invalid keys and values of a labels map, in key order.
+func labelViolations(name string, labels protoreflect.Map) []string {
+	var descriptions []string
+	if labels.Len() > maxLabels {
+		descriptions = append(descriptions, fmt.Sprintf("%s cannot have more than %d entries", name, maxLabels))
+	}
+
+	keys := make([]string, 0, labels.Len())
+	labels.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+		keys = append(keys, key.String())
+		return true
+	})
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := validateLabelKey(key); err != nil {
+			descriptions = append(descriptions, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		value := labels.Get(protoreflect.ValueOfString(key).MapKey()).String()
+		if err := validateLabelValue(value); err != nil {
+			descriptions = append(descriptions, fmt.Sprintf("%s: key %q: %v", name, key, err))
+		}
+	}
+	return descriptions
+}
+
+// validateLabelKey
+// Ensures a key is an optional DNS subdomain prefix and "/", then a valid name.
+func validateLabelKey(key string) error {
+	name := key
+	if prefix, rest, found := strings.Cut(key, "/"); found {
+		if len(prefix) > maxLabelPrefixLen || !labelPrefixPattern.MatchString(prefix) {
+			return fmt.Errorf("key %q prefix must be a lowercase DNS subdomain of at most %d characters", key, maxLabelPrefixLen)
+		}
+		name = rest
+	}
+	if len(name) > maxLabelNameLen || !labelNamePattern.MatchString(name) {
+		return fmt.Errorf("key %q name must be at most %d characters, %s", key, maxLabelNameLen, labelNameFormatMsg)
+	}
+	return nil
+}
+
+// validateLabelValue
+// Ensures a value is empty or a valid name.
+func validateLabelValue(value string) error {
+	if value == "" {
+		return nil
+	}
+	if len(value) > maxLabelValueLen || !labelNamePattern.MatchString(value) {
+		return fmt.Errorf("value %q must be at most %d characters, %s", value, maxLabelValueLen, labelNameFormatMsg)
+	}
+	return nil
+}
+
+// parseLabelSelector
+// Parses a ListUsersRequest.label_selector, e.g. "team=payments,env notin (dev,test),!legacy".
+//
+// Returns:
+//   - The requirements of the selector, all of which must match. nil for an empty selector.
+//
+// Errors:
+//   - If a requirement is malformed, or has an invalid key or value.
+func parseLabelSelector(selector string) ([]*database.LabelRequirement, error) {
+	if strings.TrimSpace(selector) == "" {
+		return nil, nil
+	}
+
+	var requirements []*database.LabelRequirement
+	for _, part := range splitSelector(selector) {
+		requirement, err := parseLabelRequirement(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		if err := validateLabelKey(requirement.Key); err != nil {
+			return nil, err
+		}
+		for _, value := range requirement.Values {
+			if err := validateLabelValue(value); err != nil {
+				return nil, err
+			}
+		}
+		requirements = append(requirements, requirement)
+	}
+	return requirements, nil
+}
+
+// splitSelector
+// Splits a selector on the commas that are not inside a set of values.
+func splitSelector(selector string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range selector {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				parts = append(parts, selector[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, selector[start:])
+}
+
+// parseLabelRequirement
+// Parses a single requirement of a selector, see parseLabelSelector.
+func parseLabelRequirement(part string) (*database.LabelRequirement, error) {
+	if part == "" {
+		return nil, fmt.Errorf("empty requirement")
+	}
+
+	if match := setRequirementPattern.FindStringSubmatch(part); match != nil {
+		requirement := &database.LabelRequirement{Key: match[1], Operator: database.LabelIn}
+		if match[2] == "notin" {
+			requirement.Operator = database.LabelNotIn
+		}
+		for _, value := range strings.Split(match[3], ",") {
+			requirement.Values = append(requirement.Values, strings.TrimSpace(value))
+		}
+		return requirement, nil
+	}
+
+	for _, op := range []struct{ token, operator string }{
+		{"!=", database.LabelNotEquals},
+		{"==", database.LabelEquals},
+		{"=", database.LabelEquals},
+	} {
+		if key, value, found := strings.Cut(part, op.token); found {
+			return &database.LabelRequirement{
+				Key:      strings.TrimSpace(key),
+				Operator: op.operator,
+				Values:   []string{strings.TrimSpace(value)},
+			}, nil
+		}
+	}
+
+	if key, found := strings.CutPrefix(part, "!"); found {
+		return &database.LabelRequirement{Key: strings.TrimSpace(key), Operator: database.LabelNotExists}, nil
+	}
+	if strings.ContainsAny(part, " ()") {
+		return nil, fmt.Errorf("invalid requirement %q", part)
+	}
+	return &database.LabelRequirement{Key: part, Operator: database.LabelExists}, nil
+}
@@ -2,21 +2,29 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
+	"strings"
 
+	"grpc-services/user/database"
 	pb "grpc-services/user/proto"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Logic
 // Preforms the logic behind and rpc.
 // Assumes all given values are validated before calling the function.
-//
-// (Note: A real implementation would split the errors into: internal, Unavailable, NotFound based on error type)
-// TODO(HI): Proper error codes in the response.
+// Database errors are converted by dbError, see errors.go for their codes and details.
 
 // createUser
 //
@@ -24,15 +32,41 @@ import (
 //   - User: The Created User.
 //
 // Errors:
-//   - Internal: When failing to create user in DB.
+//   - AlreadyExists: When a live user has the same email.
+//   - InvalidArgument: When request_id was used by a request with other fields.
+//   - Other: The dbError of failing to create user in DB.
 func (s *Server) createUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.UserResponse, error) {
-	user, err := s.DB.CreateUser(ctx, req.GetName(), req.GetEmail(), req.GetAge())
+	var user *database.UserRow
+	var err error
+	if req.GetRequestId() != "" {
+		user, err = s.DB.CreateUserOnce(ctx, newUser(req))
+	} else {
+		user, err = s.DB.CreateUser(ctx, newUser(req))
+	}
 	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to create user: %v", err))
+		return nil, dbError(ctx, err, "create user", req.GetEmail())
 	}
 	return &pb.UserResponse{User: user.ToProto()}, nil
 }
 
+// newUser
+// Converts a CreateUserRequest, fingerprinting it when it has a request_id.
+func newUser(req *pb.CreateUserRequest) *database.NewUser {
+	user := &database.NewUser{
+		Name:      req.GetName(),
+		Email:     req.GetEmail(),
+		Age:       req.GetAge(),
+		Labels:    req.GetLabels(),
+		RequestID: req.GetRequestId(),
+	}
+	if user.RequestID != "" {
+		data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(req)
+		sum := sha256.Sum256(data)
+		user.RequestHash = hex.EncodeToString(sum[:])
+	}
+	return user
+}
+
 // getUser
 //
 // Returns:
@@ -40,72 +74,354 @@ func (s *Server) createUser(ctx context.Context, req *pb.CreateUserRequest) (*pb
 //
 // Errors:
 //   - NotFound: When failing to find user in DB.
+//   - Other: The dbError of failing to read the user.
 func (s *Server) getUser(ctx context.Context, req *pb.GetUserRequest) (*pb.UserResponse, error) {
-	id, err := parseID(req.GetId())
+	id, err := s.resolveID(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	var user *database.UserRow
+	if req.GetAsOf() != nil {
+		user, err = s.DB.GetUserAsOf(ctx, id, req.GetAsOf().AsTime(), req.GetShowDeleted())
+	} else {
+		user, err = s.DB.GetUser(ctx, id, req.GetShowDeleted())
+	}
+	if err != nil {
+		return nil, dbError(ctx, err, "get user", req.GetId())
+	}
+	return &pb.UserResponse{User: user.ToProto()}, nil
+}
+
+// listUserHistory
+// Pages through the changes with keyset page tokens, on the history entry id.
+//
+// Returns:
+//   - Entries: The changes of the user, most recent first.
+//   - NextPageToken: Empty on the last page.
+//
+// Errors:
+//   - InvalidArgument: When the page token is invalid, or was returned for another user.
+//   - Other: The dbError of failing to read the history.
+func (s *Server) listUserHistory(ctx context.Context, req *pb.ListUserHistoryRequest) (*pb.ListUserHistoryResponse, error) {
+	id, err := s.resolveID(ctx, req.GetUserId())
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := s.DB.GetUser(ctx, id)
+	limit := int(req.GetLimit())
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	// Fetch one extra entry to know if there is a next page.
+	opts := &database.HistoryOptions{UserID: id, Limit: limit + 1}
+	query := id
+	page := 1
+	if req.GetPageToken() != "" {
+		token, err := decodePageToken(req.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		if token.Query != query {
+			return nil, status.Error(codes.InvalidArgument, "page token does not match the request user_id")
+		}
+		opts.BeforeID = token.AfterEntry
+		page = token.Page
+	}
+
+	entries, err := s.DB.ListUserHistory(ctx, opts)
+	if err != nil {
+		return nil, dbError(ctx, err, "list user history", req.GetUserId())
+	}
+
+	resp := &pb.ListUserHistoryResponse{}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		resp.NextPageToken = (&pageToken{
+			AfterEntry: entries[limit-1].ID,
+			Page:       page + 1,
+			Query:      query,
+		}).encode()
+	}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, entry.ToProto())
+	}
+	return resp, nil
+}
+
+// getUserByEmail
+//
+// Returns:
+//   - User: The User.
+//
+// Errors:
+//   - NotFound: When no user has the email.
+//   - Other: The dbError of failing to read the user.
+func (s *Server) getUserByEmail(ctx context.Context, req *pb.GetUserByEmailRequest) (*pb.UserResponse, error) {
+	user, err := s.DB.GetUserByEmail(ctx, req.GetEmail(), req.GetShowDeleted())
 	if err != nil {
-		return nil, status.Error(codes.NotFound, "user not found")
+		return nil, dbError(ctx, err, "get user", req.GetEmail())
 	}
 	return &pb.UserResponse{User: user.ToProto()}, nil
 }
 
 // updateUser
+// Writes only the fields selected by updatePaths.
+// When an etag is given, only updates the user if it is still at that etag.
 //
 // Returns:
 //   - User: The Updated User.
 //
 // Errors:
+//   - InvalidArgument: When the etag is malformed.
+//   - Aborted: When the user was modified since the given etag.
 //   - NotFound: When failing to find user in DB.
+//   - AlreadyExists: When the new email is used by another live user.
+//   - Other: The dbError of failing to update the user.
 func (s *Server) updateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UserResponse, error) {
-	id, err := parseID(req.GetId())
+	// The etag is checked first, it does not need a legacy id lookup
+	version, err := parseEtag(req.GetEtag())
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.resolveID(ctx, req.GetId())
 	if err != nil {
 		return nil, err
 	}
 
-	user, err := s.DB.UpdateUser(ctx, id, req.GetName(), req.GetEmail(), req.GetAge())
+	update := &database.UserUpdate{ExpectedVersion: version}
+	for _, path := range updatePaths(req) {
+		switch path {
+		case fieldName:
+			name := req.GetName()
+			update.Name = &name
+		case fieldEmail:
+			email := req.GetEmail()
+			update.Email = &email
+		case fieldAge:
+			age := req.GetAge()
+			update.Age = &age
+		case fieldLabels:
+			// Non nil, so an empty map clears the labels
+			update.Labels = database.Labels{}
+			for key, value := range req.GetLabels() {
+				update.Labels[key] = value
+			}
+		}
+	}
+
+	user, err := s.DB.UpdateUser(ctx, id, update)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, "user not found")
+		return nil, dbError(ctx, err, "update user", req.GetId())
 	}
 	return &pb.UserResponse{User: user.ToProto()}, nil
 }
 
 // deleteUser
+// When an etag is given, only deletes the user if it is still at that etag.
 //
 // Returns:
 //   - Success: The result of deleting the User.
 //
 // Errors:
+//   - InvalidArgument: When the etag is malformed.
+//   - Aborted: When the user was modified since the given etag.
 //   - NotFound: When failing to find user in DB.
+//   - Other: The dbError of failing to delete the user.
 func (s *Server) deleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
-	id, err := parseID(req.GetId())
+	// The etag is checked first, it does not need a legacy id lookup
+	version, err := parseEtag(req.GetEtag())
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.resolveID(ctx, req.GetId())
 	if err != nil {
 		return nil, err
 	}
 
-	err = s.DB.DeleteUser(ctx, id)
+	err = s.DB.DeleteUser(ctx, id, version)
 	if err != nil {
-		return nil, status.Error(codes.NotFound, "user not found")
+		return nil, dbError(ctx, err, "delete user", req.GetId())
 	}
 	return &pb.DeleteUserResponse{Success: true}, nil
 }
 
+// undeleteUser
+//
+// Returns:
+//   - User: The restored User.
+//
+// Errors:
+//   - NotFound: When failing to find a soft deleted user in DB.
+//   - AlreadyExists: When a live user has taken its email meanwhile.
+//   - Other: The dbError of failing to restore the user.
+func (s *Server) undeleteUser(ctx context.Context, req *pb.UndeleteUserRequest) (*pb.UserResponse, error) {
+	id, err := s.resolveID(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.DB.UndeleteUser(ctx, id)
+	if err != nil {
+		return nil, dbError(ctx, err, "undelete user", req.GetId())
+	}
+	return &pb.UserResponse{User: user.ToProto()}, nil
+}
+
+// batchCreateUsers
+// Creates the valid items in a single transaction.
+//
+// Returns:
+//   - Results: The created user or the error of each item.
+//
+// Errors:
+//   - The error of the first failing item, in all-or-nothing mode.
+//   - Other: The dbError of the failed transaction.
+func (s *Server) batchCreateUsers(ctx context.Context, req *pb.BatchCreateUsersRequest) (*pb.BatchUsersResponse, error) {
+	results := make([]*pb.BatchUserResult, len(req.GetRequests()))
+	var users []*database.NewUser
+	var positions []int
+	for i, item := range req.GetRequests() {
+		if err := validateRules(item, nil); err != nil {
+			results[i] = batchErrorResult(i, "", status.Convert(err))
+			continue
+		}
+		users = append(users, newUser(item))
+		positions = append(positions, i)
+	}
+
+	dbResults, err := s.DB.BatchCreateUsers(ctx, users, isAtomic(req.GetMode()))
+	if err != nil {
+		return nil, dbError(ctx, err, "create users", "")
+	}
+	return batchResponse(ctx, results, positions, dbResults, nil, req.GetMode())
+}
+
+// batchGetUsers
+// Reads all the users in a single statement.
+//
+// Returns:
+//   - Results: The user, or a NotFound error, of each id.
+//
+// Errors:
+//   - The error of the first failing id, in all-or-nothing mode.
+//   - Other: The dbError of failing to read the users.
+func (s *Server) batchGetUsers(ctx context.Context, req *pb.BatchGetUsersRequest) (*pb.BatchUsersResponse, error) {
+	results := make([]*pb.BatchUserResult, len(req.GetIds()))
+	resolved, errs, err := s.resolveIDs(ctx, req.GetIds())
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for i, idString := range req.GetIds() {
+		if errs[i] != nil {
+			results[i] = batchErrorResult(i, idString, status.Convert(errs[i]))
+			continue
+		}
+		ids = append(ids, resolved[i])
+	}
+
+	users, err := s.DB.BatchGetUsers(ctx, ids, req.GetShowDeleted())
+	if err != nil {
+		return nil, dbError(ctx, err, "get users", "")
+	}
+	found := make(map[string]*pb.User, len(users))
+	for _, user := range users {
+		protoUser := user.ToProto()
+		found[protoUser.GetId()] = protoUser
+	}
+
+	for i, idString := range req.GetIds() {
+		if results[i] != nil {
+			continue
+		}
+		user, ok := found[resolved[i]]
+		if !ok {
+			results[i] = batchErrorResult(i, idString, dbStatus(ctx, sql.ErrNoRows, "get user", idString))
+			continue
+		}
+		results[i] = &pb.BatchUserResult{Id: user.GetId(), User: user}
+	}
+
+	if isAtomic(req.GetMode()) {
+		for i, result := range results {
+			if result.GetError() != nil {
+				return nil, batchItemError(i, status.New(codes.Code(result.GetError().GetCode()), result.GetError().GetMessage()))
+			}
+		}
+	}
+	return &pb.BatchUsersResponse{Results: results}, nil
+}
+
+// batchDeleteUsers
+// Soft deletes the users in a single transaction.
+//
+// Returns:
+//   - Results: The id, and error if any, of each item.
+//
+// Errors:
+//   - The error of the first failing item, in all-or-nothing mode.
+//   - Other: The dbError of the failed transaction.
+func (s *Server) batchDeleteUsers(ctx context.Context, req *pb.BatchDeleteUsersRequest) (*pb.BatchUsersResponse, error) {
+	atomic := isAtomic(req.GetMode())
+	results := make([]*pb.BatchUserResult, len(req.GetRequests()))
+	ids := make([]string, len(req.GetRequests()))
+	for i, item := range req.GetRequests() {
+		ids[i] = item.GetId()
+	}
+	resolved, errs, err := s.resolveIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var deletes []*database.UserDelete
+	var positions []int
+	for i, item := range req.GetRequests() {
+		err := errs[i]
+		if err == nil {
+			var version int64
+			version, err = parseEtag(item.GetEtag())
+			if err == nil {
+				deletes = append(deletes, &database.UserDelete{ID: resolved[i], ExpectedVersion: version})
+				positions = append(positions, i)
+				continue
+			}
+		}
+		if atomic {
+			return nil, batchItemError(i, status.Convert(err))
+		}
+		results[i] = batchErrorResult(i, item.GetId(), status.Convert(err))
+	}
+
+	dbResults, err := s.DB.BatchDeleteUsers(ctx, deletes, atomic)
+	if err != nil {
+		return nil, dbError(ctx, err, "delete users", "")
+	}
+	return batchResponse(ctx, results, positions, dbResults, ids, req.GetMode())
+}
+
 // listUsers
 // Return a list of users with paging
 // Limits page size to 100
 // defaults (page, limit) to: (1, 10) if missing.
+// Pages after a page_token are read with a keyset cursor (rows after the last one by order_by, id),
+// so rows don't shift between pages when users are added or removed.
 //
 // Returns:
 //   - Users: List of requested users.
-//   - Total: Total users count.
+//   - Total: Count of users matching the filters, from the snapshot of the page, estimated or skipped as asked by total_mode.
 //   - Page: Number of the page.
 //   - Limit: page limit.
+//   - NextPageToken: Cursor of the next page, empty on the last page.
+//   - TotalMode: How Total was computed.
 //
 // Errors:
-//   - Internal: When failing to find user in DB.
+//   - InvalidArgument: When order_by or the page token are invalid.
+//   - Other: The dbError of failing to list or count the users.
 func (s *Server) listUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
 	page := int(req.GetPage())
 	if page < 1 {
@@ -120,16 +436,51 @@ func (s *Server) listUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.L
 		limit = 100
 	}
 
-	offset := (page - 1) * limit
-
-	users, err := s.DB.ListUsers(ctx, limit, offset)
+	orderBy, desc, err := parseOrderBy(req.GetOrderBy())
 	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to list users: %v", err))
+		return nil, err
 	}
 
-	total, err := s.DB.CountUsers(ctx)
+	// Fetch one extra row to know if there is a next page.
+	opts := &database.ListOptions{
+		Filter:    listFilter(req),
+		OrderBy:   orderBy,
+		Desc:      desc,
+		Limit:     limit + 1,
+		TotalMode: totalMode(req.GetTotalMode()),
+	}
+	query := listQuery(req)
+	if req.GetPageToken() != "" {
+		token, err := decodePageToken(req.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		if token.Query != query {
+			return nil, status.Error(codes.InvalidArgument, "page token does not match the request filters or order_by")
+		}
+		opts.AfterID = token.AfterID
+		opts.AfterValue = token.AfterValue
+		page = token.Page
+	} else {
+		opts.Offset = (page - 1) * limit
+	}
+
+	result, err := s.DB.ListUsersPage(ctx, opts)
 	if err != nil {
-		return nil, status.Error(codes.Internal, fmt.Sprintf("failed to count users: %v", err))
+		return nil, dbError(ctx, err, "list users", "")
+	}
+
+	users := result.Users
+	var nextPageToken string
+	if len(users) > limit {
+		users = users[:limit]
+		last := users[limit-1]
+		nextPageToken = (&pageToken{
+			AfterID:    last.ID,
+			AfterValue: last.SortValue(orderBy),
+			Page:       page + 1,
+			Query:      query,
+		}).encode()
 	}
 
 	var protoUsers []*pb.User
@@ -138,24 +489,478 @@ func (s *Server) listUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.L
 	}
 
 	return &pb.ListUsersResponse{
-		Users: protoUsers,
-		Total: int32(total),
-		Page:  int32(page),
-		Limit: int32(limit),
+		Users:         protoUsers,
+		Total:         int32(result.Total),
+		Page:          int32(page),
+		Limit:         int32(limit),
+		NextPageToken: nextPageToken,
+		TotalMode:     totalModes[result.TotalMode],
 	}, nil
 }
 
-// parseID
-// parse a given idString
+// totalModes
+// The TotalMode of each database Total mode.
+var totalModes = map[string]pb.TotalMode{
+	database.TotalExact:    pb.TotalMode_TOTAL_MODE_EXACT,
+	database.TotalEstimate: pb.TotalMode_TOTAL_MODE_ESTIMATE,
+	database.TotalNone:     pb.TotalMode_TOTAL_MODE_NONE,
+}
+
+// totalMode
+// Returns the database Total mode of a TotalMode, TotalExact when unspecified.
+func totalMode(mode pb.TotalMode) string {
+	switch mode {
+	case pb.TotalMode_TOTAL_MODE_ESTIMATE:
+		return database.TotalEstimate
+	case pb.TotalMode_TOTAL_MODE_NONE:
+		return database.TotalNone
+	default:
+		return database.TotalExact
+	}
+}
+
+// filterRequest
+// Implemented by the requests accepting the ListUsers filters.
+type filterRequest interface {
+	proto.Message
+	GetShowDeleted() bool
+	GetNamePrefix() string
+	GetEmailDomain() string
+	GetMinAge() int32
+	GetMaxAge() int32
+	GetCreatedAfter() *timestamppb.Timestamp
+	GetCreatedBefore() *timestamppb.Timestamp
+	GetUpdatedAfter() *timestamppb.Timestamp
+	GetUpdatedBefore() *timestamppb.Timestamp
+	GetLabelSelector() string
+}
+
+// searchUsers
+// Pages through the results with keyset page tokens, on their score and id.
+//
+// Returns:
+//   - Results: The matching users and their score, best matches first.
+//   - NextPageToken: Empty on the last page.
+//
+// Errors:
+//   - InvalidArgument: When the page token is invalid, or was returned for another query.
+//   - Other: The dbError of failing to search the users.
+func (s *Server) searchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	limit := int(req.GetLimit())
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	// Fetch one extra result to know if there is a next page.
+	opts := &database.SearchOptions{
+		Query:       req.GetQuery(),
+		ShowDeleted: req.GetShowDeleted(),
+		Limit:       limit + 1,
+	}
+	query := searchQuery(req)
+	page := 1
+	if req.GetPageToken() != "" {
+		token, err := decodePageToken(req.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		if token.Query != query {
+			return nil, status.Error(codes.InvalidArgument, "page token does not match the request query")
+		}
+		if opts.AfterScore, err = strconv.ParseFloat(token.AfterValue, 64); err != nil {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		opts.AfterID = token.AfterID
+		page = token.Page
+	}
+
+	results, err := s.DB.SearchUsers(ctx, opts)
+	if err != nil {
+		return nil, dbError(ctx, err, "search users", "")
+	}
+
+	resp := &pb.SearchUsersResponse{}
+	if len(results) > limit {
+		results = results[:limit]
+		last := results[limit-1]
+		resp.NextPageToken = (&pageToken{
+			AfterID:    last.User.ID,
+			AfterValue: strconv.FormatFloat(last.Score, 'g', -1, 64),
+			Page:       page + 1,
+			Query:      query,
+		}).encode()
+	}
+	for _, result := range results {
+		resp.Results = append(resp.Results, &pb.UserSearchResult{
+			User:  result.User.ToProto(),
+			Score: result.Score,
+		})
+	}
+	return resp, nil
+}
+
+// exportUsers
+// Sends every user matching the filters on the stream.
+// stream.Send blocks while the client is not receiving, which in turn pauses the database read.
+//
+// Errors:
+//   - Canceled/DeadlineExceeded: When the client goes away before the end of the export.
+//   - The stream error, when failing to send a user.
+//   - Other: The dbError of failing to read the users.
+func (s *Server) exportUsers(req *pb.ExportUsersRequest, stream pb.UserService_ExportUsersServer) error {
+	ctx := stream.Context()
+	filter := listFilter(req)
+	err := s.DB.ExportUsers(ctx, &filter, func(user *database.UserRow) error {
+		return stream.Send(user.ToProto())
+	})
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok && ctx.Err() == nil {
+		return err
+	}
+	return dbError(ctx, err, "export users", "")
+}
+
+// importUsers
+// Receives the records until the client closes the stream,
+// and writes the valid ones every importBatchSize records.
+// The options of the stream are the ones of its first message, every message must repeat them.
+//
+// Returns:
+//   - Results: The status of every record, in stream order.
+//
+// Errors:
+//   - The stream error, when failing to receive a record.
+//   - InvalidArgument: When a message has other options than the first one, the batches before it are written.
+//   - Other: The dbError of a failed batch transaction.
+func (s *Server) importUsers(stream pb.UserService_ImportUsersServer) error {
+	ctx := stream.Context()
+	imp := &userImport{}
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(imp.results) == 0 {
+			imp.upsert, imp.dryRun = req.GetUpsert(), req.GetDryRun()
+		} else if req.GetUpsert() != imp.upsert || req.GetDryRun() != imp.dryRun {
+			return status.Errorf(codes.InvalidArgument,
+				"record %d: upsert and dry_run must be the same in every message, got upsert=%t dry_run=%t after upsert=%t dry_run=%t",
+				len(imp.results), req.GetUpsert(), req.GetDryRun(), imp.upsert, imp.dryRun)
+		}
+
+		if user := req.GetUser(); user != nil {
+			user.Email = s.normalizeEmail(user.GetEmail())
+		}
+		imp.add(req.GetUser())
+		if len(imp.batch) == importBatchSize {
+			if err := s.flushImport(ctx, imp); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.flushImport(ctx, imp); err != nil {
+		return err
+	}
+	return stream.SendAndClose(imp.response())
+}
+
+// userImport
+// The state of an ImportUsers stream.
+type userImport struct {
+	upsert bool
+	dryRun bool
+
+	results []*pb.ImportUserResult
+	// The valid records not written yet, and their index.
+	batch     []*database.NewUser
+	positions []int
+	// The emails seen so far, as a dry run can't rely on the previous batches being written.
+	emails map[string]bool
+}
+
+// add
+// Validates the next record, and queues it for the next batch when valid.
+func (imp *userImport) add(user *pb.CreateUserRequest) {
+	index := len(imp.results)
+	result := &pb.ImportUserResult{Index: int32(index)}
+	imp.results = append(imp.results, result)
+
+	if err := validateRules(user, nil); err != nil {
+		result.Status = pb.ImportStatus_IMPORT_STATUS_INVALID
+		result.Message = status.Convert(err).Message()
+		return
+	}
+	if imp.dryRun {
+		if imp.emails == nil {
+			imp.emails = make(map[string]bool)
+		}
+		if imp.emails[user.GetEmail()] {
+			result.Status = pb.ImportStatus_IMPORT_STATUS_CONFLICT
+			result.Message = database.ErrDuplicateEmail.Error()
+			if imp.upsert {
+				result.Status = pb.ImportStatus_IMPORT_STATUS_UPDATED
+				result.Message = ""
+			}
+			return
+		}
+		imp.emails[user.GetEmail()] = true
+	}
+
+	imp.batch = append(imp.batch, &database.NewUser{
+		Name:   user.GetName(),
+		Email:  user.GetEmail(),
+		Age:    user.GetAge(),
+		Labels: user.GetLabels(),
+	})
+	imp.positions = append(imp.positions, index)
+}
+
+// response
+// Returns the results of the import, with their totals.
+func (imp *userImport) response() *pb.ImportUsersResponse {
+	resp := &pb.ImportUsersResponse{Results: imp.results}
+	for _, result := range imp.results {
+		switch result.GetStatus() {
+		case pb.ImportStatus_IMPORT_STATUS_CREATED:
+			resp.Created++
+		case pb.ImportStatus_IMPORT_STATUS_UPDATED:
+			resp.Updated++
+		default:
+			resp.Failed++
+		}
+	}
+	return resp
+}
+
+// flushImport
+// Writes the queued records of imp, and sets their results.
+//
+// Errors:
+//   - Other: The dbError of the failed batch transaction.
+func (s *Server) flushImport(ctx context.Context, imp *userImport) error {
+	if len(imp.batch) == 0 {
+		return nil
+	}
+	dbResults, err := s.DB.ImportUsers(ctx, imp.batch, imp.upsert, imp.dryRun)
+	if err != nil {
+		return dbError(ctx, err, "import users", "")
+	}
+
+	for j, dbResult := range dbResults {
+		result := imp.results[imp.positions[j]]
+		switch {
+		case database.Classify(dbResult.Err) == database.ClassAlreadyExists:
+			result.Status = pb.ImportStatus_IMPORT_STATUS_CONFLICT
+			result.Message = dbResult.Err.Error()
+		case dbResult.Err != nil:
+			result.Status = pb.ImportStatus_IMPORT_STATUS_FAILED
+			result.Message = dbResult.Err.Error()
+		case dbResult.Updated:
+			result.Status = pb.ImportStatus_IMPORT_STATUS_UPDATED
+		default:
+			result.Status = pb.ImportStatus_IMPORT_STATUS_CREATED
+		}
+		if dbResult.Err == nil && !imp.dryRun {
+			result.User = dbResult.User.ToProto()
+		}
+	}
+	imp.batch, imp.positions = nil, nil
+	return nil
+}
+
+// watchUsers
+// Sends every user event after req.AfterSequence on the stream, until the client goes away.
+//
+// Errors:
+//   - OutOfRange: When the events after req.AfterSequence were purged, the client has to list the users again.
+//   - Canceled/DeadlineExceeded: When the client goes away.
+//   - The stream error, when failing to send an event.
+//   - Other: The dbError of failing to read the events.
+func (s *Server) watchUsers(req *pb.WatchUsersRequest, stream pb.UserService_WatchUsersServer) error {
+	ctx := stream.Context()
+	err := s.DB.WatchUsers(ctx, req.GetAfterSequence(), func(event *database.UserEvent) error {
+		return stream.Send(event.ToProto())
+	})
+	if errors.Is(err, database.ErrEventsPurged) {
+		return status.Error(codes.OutOfRange, fmt.Sprintf("the events after sequence %d were purged, list the users again", req.GetAfterSequence()))
+	}
+	if _, ok := status.FromError(err); ok && ctx.Err() == nil {
+		return err
+	}
+	return dbError(ctx, err, "watch users", "")
+}
+
+// listFilter
+// Converts the filters of a ListUsersRequest or ExportUsersRequest.
+func listFilter(req filterRequest) database.UserFilter {
+	filter := database.UserFilter{
+		ShowDeleted: req.GetShowDeleted(),
+		NamePrefix:  req.GetNamePrefix(),
+		EmailDomain: req.GetEmailDomain(),
+		MinAge:      req.GetMinAge(),
+		MaxAge:      req.GetMaxAge(),
+	}
+	if req.GetCreatedAfter() != nil {
+		filter.CreatedAfter = req.GetCreatedAfter().AsTime()
+	}
+	if req.GetCreatedBefore() != nil {
+		filter.CreatedBefore = req.GetCreatedBefore().AsTime()
+	}
+	if req.GetUpdatedAfter() != nil {
+		filter.UpdatedAfter = req.GetUpdatedAfter().AsTime()
+	}
+	if req.GetUpdatedBefore() != nil {
+		filter.UpdatedBefore = req.GetUpdatedBefore().AsTime()
+	}
+	// The selector was checked by validateFilter
+	filter.Labels, _ = parseLabelSelector(req.GetLabelSelector())
+	return filter
+}
+
+// updatePaths
+// Returns the fields an UpdateUserRequest applies to.
+// An empty update_mask means name, email and age, labels are only updated when listed.
+func updatePaths(req *pb.UpdateUserRequest) []string {
+	if paths := req.GetUpdateMask().GetPaths(); len(paths) > 0 {
+		return paths
+	}
+	return []string{fieldName, fieldEmail, fieldAge}
+}
+
+// parseEtag
+// parse a given etag, an empty etag means no condition.
 // Returns:
-//   - id value as an int
+//   - The expected row version, 0 when etag is empty.
 //
 // Errors:
-//   - InvalidArgument: When failing to find user in DB.
-func parseID(idString string) (int, error) {
-	id, err := strconv.Atoi(idString)
+//   - InvalidArgument: When the etag is malformed.
+func parseEtag(etag string) (int64, error) {
+	if etag == "" {
+		return 0, nil
+	}
+	version, err := database.ParseEtag(etag)
 	if err != nil {
-		return 0, status.Error(codes.InvalidArgument, "invalid user ID")
+		return 0, status.Error(codes.InvalidArgument, "invalid etag")
+	}
+	return version, nil
+}
+
+// parseOrderBy
+// parse a given order_by ("<field> [asc|desc]")
+// Returns:
+//   - The column to sort by, defaults to id.
+//   - Whether the order is descending.
+//
+// Errors:
+//   - InvalidArgument: When the field or direction is unknown.
+func parseOrderBy(orderBy string) (string, bool, error) {
+	parts := strings.Fields(orderBy)
+	if len(parts) == 0 {
+		return database.OrderByID, false, nil
+	}
+	if len(parts) > 2 || !database.IsSortColumn(parts[0]) {
+		return "", false, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid order_by: %q", orderBy))
+	}
+	if len(parts) == 1 {
+		return parts[0], false, nil
+	}
+	switch strings.ToLower(parts[1]) {
+	case "asc":
+		return parts[0], false, nil
+	case "desc":
+		return parts[0], true, nil
+	default:
+		return "", false, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid order_by: %q", orderBy))
+	}
+}
+
+// isAtomic
+// Reports whether a batch runs in all-or-nothing mode, the default.
+func isAtomic(mode pb.BatchMode) bool {
+	return mode != pb.BatchMode_BATCH_MODE_BEST_EFFORT
+}
+
+// batchResponse
+// Fills results with the database results of the items at positions,
+// and builds the response of a batch write.
+// ids are the requested ids, for the writes that don't return the user.
+//
+// Errors:
+//   - The error of the item that failed the batch, in all-or-nothing mode.
+func batchResponse(ctx context.Context, results []*pb.BatchUserResult, positions []int, dbResults []*database.BatchResult, ids []string, mode pb.BatchMode) (*pb.BatchUsersResponse, error) {
+	for j, result := range dbResults {
+		i := positions[j]
+		var id string
+		if ids != nil {
+			id = ids[i]
+		}
+		if result.Err != nil {
+			if isAtomic(mode) && !errors.Is(result.Err, database.ErrBatchAborted) {
+				return nil, batchItemError(i, batchItemStatus(ctx, result.Err, id))
+			}
+			results[i] = batchErrorResult(i, id, batchItemStatus(ctx, result.Err, id))
+			continue
+		}
+		results[i] = &pb.BatchUserResult{Id: id}
+		if result.User != nil {
+			results[i].User = result.User.ToProto()
+			results[i].Id = results[i].User.GetId()
+		}
+	}
+	return &pb.BatchUsersResponse{Results: results}, nil
+}
+
+// batchItemStatus
+// Returns the status of a failed batch item, as the single item RPC would.
+func batchItemStatus(ctx context.Context, err error, id string) *status.Status {
+	if errors.Is(err, database.ErrBatchAborted) {
+		return status.New(codes.Aborted, err.Error())
+	}
+	return dbStatus(ctx, err, "write user", id)
+}
+
+// batchErrorResult
+// Returns the result of the batch item at index, that failed with st.
+func batchErrorResult(index int, id string, st *status.Status) *pb.BatchUserResult {
+	return &pb.BatchUserResult{
+		Id: id,
+		Error: &pb.BatchError{
+			Code:    int32(st.Code()),
+			Message: st.Message(),
+			Index:   int32(index),
+		},
+	}
+}
+
+// batchItemError
+// Returns the error failing a whole batch, because of the item at index.
+// The fields of a BadRequest detail are prefixed with the item.
+func batchItemError(index int, st *status.Status) error {
+	prefix := fmt.Sprintf("requests[%d]", index)
+	itemStatus := status.New(st.Code(), fmt.Sprintf("%s: %s", prefix, st.Message()))
+	for _, detail := range st.Details() {
+		badRequest, ok := detail.(*errdetails.BadRequest)
+		if !ok {
+			continue
+		}
+		var violations []*errdetails.BadRequest_FieldViolation
+		for _, violation := range badRequest.GetFieldViolations() {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       prefix + "." + violation.GetField(),
+				Description: violation.GetDescription(),
+			})
+		}
+		if withDetails, err := itemStatus.WithDetails(&errdetails.BadRequest{FieldViolations: violations}); err == nil {
+			itemStatus = withDetails
+		}
 	}
-	return id, nil
+	return itemStatus.Err()
 }
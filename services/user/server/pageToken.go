@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+
+	pb "grpc-services/user/proto"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// pageToken
+// The cursor behind ListUsers, SearchUsers, ListUserHistory and ListWebhookAttempts page tokens.
+// Encoded as base64 json, clients should treat it as opaque.
+// AfterEntry is the last history entry of a ListUserHistory page, or the last attempt of a ListWebhookAttempts one.
+type pageToken struct {
+	AfterID    string `json:"after_id,omitempty"`
+	AfterValue string `json:"after_value,omitempty"`
+	AfterEntry int64  `json:"after_entry,omitempty"`
+	Page       int    `json:"page"`
+	Query      string `json:"query"`
+}
+
+// encode
+// Returns the token as sent to clients.
+func (t *pageToken) encode() string {
+	data, _ := json.Marshal(t)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageToken
+// parse a given page token
+// Returns:
+//   - The decoded pageToken
+//
+// Errors:
+//   - InvalidArgument: When the token is malformed.
+func decodePageToken(token string) (*pageToken, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+
+	var t pageToken
+	if err := json.Unmarshal(data, &t); err != nil || t.AfterEntry < 0 || t.Page < 1 {
+		return nil, status.Error(codes.InvalidArgument, "invalid page token")
+	}
+	return &t, nil
+}
+
+// listQuery
+// Fingerprints the filters and ordering of a ListUsersRequest,
+// so a page token can't be replayed against a different query.
+// The total mode does not change the pages, it can differ between them.
+func listQuery(req *pb.ListUsersRequest) string {
+	query := proto.Clone(req).(*pb.ListUsersRequest)
+	query.Page, query.Limit, query.PageToken, query.TotalMode = 0, 0, "", pb.TotalMode_TOTAL_MODE_UNSPECIFIED
+	return fingerprint(query)
+}
+
+// searchQuery
+// Fingerprints the query of a SearchUsersRequest, see listQuery.
+func searchQuery(req *pb.SearchUsersRequest) string {
+	query := proto.Clone(req).(*pb.SearchUsersRequest)
+	query.Limit, query.PageToken = 0, ""
+	return fingerprint(query)
+}
+
+// fingerprint
+// Returns a short hash of a request.
+func fingerprint(msg proto.Message) string {
+	data, _ := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// StartBackgroundPurge
+// Starts the background worker that permanently deletes soft deleted users,
+// once they have been deleted for longer than Config.PurgeRetention,
+// the user events recorded longer than Config.PurgeRetention ago,
+// the outbox events published longer than Config.OutboxRetention ago, when there is an outbox,
+// the webhook deliveries delivered or abandoned longer than Config.WebhookRetention ago, when webhooks are enabled,
+// and the CreateUser request ids recorded longer than Config.IdempotencyWindow ago.
+// Runs every Config.PurgeInterval until ctx is done.
+func (s *Server) StartBackgroundPurge(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.Config.PurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Background purge stopped")
+				return
+			case <-ticker.C:
+				s.purgeDeletedUsers(ctx)
+			}
+		}
+	}()
+}
+
+// purgeDeletedUsers
+// Runs a single purge, failures are logged and retried on the next run.
+func (s *Server) purgeDeletedUsers(ctx context.Context) {
+	purged, err := s.DB.PurgeDeletedUsers(ctx, s.Config.PurgeRetention)
+	if err != nil {
+		log.Printf("Failed to purge deleted users: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d deleted users", purged)
+	}
+
+	purged, err = s.DB.PurgeUserEvents(ctx, s.Config.PurgeRetention)
+	if err != nil {
+		log.Printf("Failed to purge user events: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d user events", purged)
+	}
+
+	if s.Outbox != nil {
+		purged, err = s.Outbox.PurgeOutbox(ctx, s.Config.OutboxRetention)
+		if err != nil {
+			log.Printf("Failed to purge outbox events: %v", err)
+			return
+		}
+		if purged > 0 {
+			log.Printf("Purged %d outbox events", purged)
+		}
+	}
+
+	if s.Webhooks != nil {
+		purged, err = s.Webhooks.PurgeWebhookDeliveries(ctx, s.Config.WebhookRetention)
+		if err != nil {
+			log.Printf("Failed to purge webhook deliveries: %v", err)
+			return
+		}
+		if purged > 0 {
+			log.Printf("Purged %d webhook deliveries", purged)
+		}
+	}
+
+	purged, err = s.DB.PurgeIdempotencyKeys(ctx, s.Config.IdempotencyWindow)
+	if err != nil {
+		log.Printf("Failed to purge idempotency keys: %v", err)
+		return
+	}
+	if purged > 0 {
+		log.Printf("Purged %d idempotency keys", purged)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"context"
+
+	"grpc-services/user/database"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ReadPrimaryMetadataKey
+// The metadata key clients set to "true" so the reads of a request go to the primary database,
+// and see every committed write whatever the replication lag, see database.WithPrimaryReads.
+const ReadPrimaryMetadataKey = "x-read-primary"
+
+// readPrimaryContext
+// Returns ctx reading from the primary when the incoming metadata asks for it.
+func readPrimaryContext(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(ReadPrimaryMetadataKey); len(values) > 0 && values[0] == "true" {
+		return database.WithPrimaryReads(ctx)
+	}
+	return ctx
+}
+
+// ReadPrimaryUnaryInterceptor
+// Passes the read primary flag of unary requests to the database, see ReadPrimaryMetadataKey.
+func ReadPrimaryUnaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(readPrimaryContext(ctx), req)
+}
+
+// ReadPrimaryStreamInterceptor
+// Passes the read primary flag of streaming requests to the database, see ReadPrimaryMetadataKey.
+func ReadPrimaryStreamInterceptor(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: stream, ctx: readPrimaryContext(stream.Context())})
+}
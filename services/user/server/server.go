@@ -6,6 +6,22 @@ import (
 	pb "grpc-services/user/proto"
 )
 
+// Updatable User fields, as used in update_mask paths.
+const (
+	fieldName   = "name"
+	fieldEmail  = "email"
+	fieldAge    = "age"
+	fieldLabels = "labels"
+)
+
+// maxBatchSize
+// The maximum number of items of a batch RPC.
+const maxBatchSize = 1000
+
+// importBatchSize
+// The number of records ImportUsers writes per transaction.
+const importBatchSize = 500
+
 // Server
 // A struct that hold all needed values for the service during its life time.
 // DB clients, and Clients of internal or external services can be added here.
@@ -14,6 +30,10 @@ type Server struct {
 	pb.UnimplementedUserServiceServer
 	Config *config.Config
 	DB     database.SQLClientInterface
+	// Webhooks manages the webhooks, nil unless they are enabled, see Config.WebhooksEnabled.
+	Webhooks database.WebhookStore
+	// Outbox is the transactional outbox, nil unless StoragePostgres keeps it, see Config.OutboxRetention.
+	Outbox database.OutboxStore
 }
 
 // NewServer
@@ -23,3 +43,10 @@ func NewServer(cfg *config.Config, db database.SQLClientInterface) *Server {
 		DB:     db,
 	}
 }
+
+// normalizeEmail
+// Returns the stored form of an email, see database.NormalizeEmail.
+// Emails are normalized before being validated, written or looked up.
+func (s *Server) normalizeEmail(email string) string {
+	return database.NormalizeEmail(email, s.Config != nil && s.Config.FoldEmailLocalPart)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"fmt"
+	"net/mail"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	pb "grpc-services/user/proto"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// validateRules
+// Checks the fields of msg against the (rules) options declared in user.proto.
+// Only the fields named in paths are checked, nil checks all of them.
+// The violations found by the checks of the caller are reported along with them.
+//
+// Errors:
+//   - InvalidArgument: With a BadRequest detail listing every violation,
+//     the message joins their descriptions.
+func validateRules(msg proto.Message, paths []string, checked ...*errdetails.BadRequest_FieldViolation) error {
+	violations := append(ruleViolations(msg.ProtoReflect(), paths), checked...)
+	if len(violations) == 0 {
+		return nil
+	}
+	return badRequest(violations)
+}
+
+// ruleViolations
+// Returns the violations of the fields of msg, see validateRules.
+func ruleViolations(msg protoreflect.Message, paths []string) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		name := string(field.Name())
+		if paths != nil && !slices.Contains(paths, name) {
+			continue
+		}
+		rules, ok := proto.GetExtension(field.Options(), pb.E_Rules).(*pb.FieldRules)
+		if !ok || rules == nil {
+			continue
+		}
+		if !field.IsList() {
+			violations = append(violations, violationsOf(name, msg.Get(field), field.Kind(), rules)...)
+			continue
+		}
+		list := msg.Get(field).List()
+		for j := 0; j < list.Len(); j++ {
+			violations = append(violations, violationsOf(fmt.Sprintf("%s[%d]", name, j), list.Get(j), field.Kind(), rules)...)
+		}
+	}
+	return violations
+}
+
+// violationsOf
+// Returns the violations of the value of a field, or of an element of a repeated field.
+func violationsOf(name string, value protoreflect.Value, kind protoreflect.Kind, rules *pb.FieldRules) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	for _, description := range fieldViolations(name, value, kind, rules) {
+		violations = append(violations, fieldViolation(name, description))
+	}
+	return violations
+}
+
+// fieldViolation
+// Returns the violation of the field name.
+func fieldViolation(name string, description string) *errdetails.BadRequest_FieldViolation {
+	return &errdetails.BadRequest_FieldViolation{
+		Field:       name,
+		Description: description,
+	}
+}
+
+// fieldViolations
+// Returns the descriptions of the rules violated by the value of a field.
+// An empty required string only reports that it is empty.
+func fieldViolations(name string, value protoreflect.Value, kind protoreflect.Kind, rules *pb.FieldRules) []string {
+	if rules.GetLabels() {
+		return labelViolations(name, value.Map())
+	}
+
+	var descriptions []string
+	switch kind {
+	case protoreflect.StringKind:
+		s := value.String()
+		if s == "" {
+			if rules.GetRequired() {
+				descriptions = append(descriptions, fmt.Sprintf("%s cannot be empty", name))
+			}
+			return descriptions
+		}
+		if rules.GetMaxLen() > 0 && utf8.RuneCountInString(s) > int(rules.GetMaxLen()) {
+			descriptions = append(descriptions, fmt.Sprintf("%s cannot be longer than %d characters", name, rules.GetMaxLen()))
+		}
+		if rules.GetEmail() && !isEmail(s) {
+			descriptions = append(descriptions, "invalid email format")
+		}
+
+	case protoreflect.Int32Kind, protoreflect.Int64Kind:
+		n := value.Int()
+		if rules.Min != nil && n < rules.GetMin() {
+			switch rules.GetMin() {
+			case 0:
+				descriptions = append(descriptions, fmt.Sprintf("%s cannot be negative", name))
+			case 1:
+				descriptions = append(descriptions, fmt.Sprintf("%s must be positive", name))
+			default:
+				descriptions = append(descriptions, fmt.Sprintf("%s must be at least %d", name, rules.GetMin()))
+			}
+		}
+		if rules.Max != nil && n > rules.GetMax() {
+			descriptions = append(descriptions, fmt.Sprintf("%s must be at most %d", name, rules.GetMax()))
+		}
+	}
+	return descriptions
+}
+
+// isEmail
+// Reports whether s is a bare email address, without a display name or angle brackets.
+func isEmail(s string) bool {
+	address, err := mail.ParseAddress(s)
+	return err == nil && address.Address == s
+}
+
+// badRequest
+// Returns an InvalidArgument error listing violations in a BadRequest detail.
+func badRequest(violations []*errdetails.BadRequest_FieldViolation) error {
+	descriptions := make([]string, len(violations))
+	for i, violation := range violations {
+		descriptions[i] = violation.GetDescription()
+	}
+
+	st := status.New(codes.InvalidArgument, strings.Join(descriptions, "; "))
+	withDetails, err := st.WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"grpc-services/user/database"
+	pb "grpc-services/user/proto"
+
+	"github.com/google/uuid"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// minSecretLength
+// The shortest webhook secret, in characters.
+const minSecretLength = 16
+
+// eventTypePrefix
+// The prefix of the UserEventType names, before the database event types.
+const eventTypePrefix = "USER_EVENT_TYPE_"
+
+// webhookStore
+// Returns the store of the webhooks.
+//
+// Errors:
+//   - Unimplemented: When the webhooks are not enabled, see Server.Webhooks.
+func (s *Server) webhookStore() (database.WebhookStore, error) {
+	if s.Webhooks == nil {
+		return nil, status.Error(codes.Unimplemented, "webhooks are not enabled, they need the postgres storage backend and WEBHOOKS_ENABLED")
+	}
+	return s.Webhooks, nil
+}
+
+// createWebhook
+//
+// Returns:
+//   - Webhook: The created webhook, without its secret.
+//
+// Errors:
+//   - Unimplemented: When the webhooks are not enabled.
+//   - Other: The dbError of failing to create the webhook.
+func (s *Server) createWebhook(ctx context.Context, req *pb.CreateWebhookRequest) (*pb.WebhookResponse, error) {
+	store, err := s.webhookStore()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := store.CreateWebhook(ctx, &database.NewWebhook{
+		URL:        req.GetUrl(),
+		EventTypes: webhookEventTypes(req.GetEventTypes()),
+		Secret:     req.GetSecret(),
+	})
+	if err != nil {
+		return nil, dbError(ctx, err, "create webhook", "")
+	}
+	return &pb.WebhookResponse{Webhook: webhook.ToProto()}, nil
+}
+
+// getWebhook
+//
+// Returns:
+//   - Webhook: The webhook.
+//
+// Errors:
+//   - Unimplemented: When the webhooks are not enabled.
+//   - NotFound: When no webhook has the id.
+//   - Other: The dbError of failing to read the webhook.
+func (s *Server) getWebhook(ctx context.Context, req *pb.GetWebhookRequest) (*pb.WebhookResponse, error) {
+	store, err := s.webhookStore()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := store.GetWebhook(ctx, req.GetId())
+	if err != nil {
+		return nil, webhookError(ctx, err, "get webhook", req.GetId())
+	}
+	return &pb.WebhookResponse{Webhook: webhook.ToProto()}, nil
+}
+
+// listWebhooks
+//
+// Returns:
+//   - Webhooks: Every webhook, oldest first.
+//
+// Errors:
+//   - Unimplemented: When the webhooks are not enabled.
+//   - Other: The dbError of failing to read the webhooks.
+func (s *Server) listWebhooks(ctx context.Context, req *pb.ListWebhooksRequest) (*pb.ListWebhooksResponse, error) {
+	store, err := s.webhookStore()
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := store.ListWebhooks(ctx)
+	if err != nil {
+		return nil, dbError(ctx, err, "list webhooks", "")
+	}
+	resp := &pb.ListWebhooksResponse{}
+	for _, webhook := range webhooks {
+		resp.Webhooks = append(resp.Webhooks, webhook.ToProto())
+	}
+	return resp, nil
+}
+
+// enableWebhook
+//
+// Returns:
+//   - Webhook: The enabled webhook.
+//
+// Errors:
+//   - Unimplemented: When the webhooks are not enabled.
+//   - NotFound: When no webhook has the id.
+//   - Other: The dbError of failing to enable the webhook.
+func (s *Server) enableWebhook(ctx context.Context, req *pb.EnableWebhookRequest) (*pb.WebhookResponse, error) {
+	store, err := s.webhookStore()
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := store.EnableWebhook(ctx, req.GetId())
+	if err != nil {
+		return nil, webhookError(ctx, err, "enable webhook", req.GetId())
+	}
+	return &pb.WebhookResponse{Webhook: webhook.ToProto()}, nil
+}
+
+// deleteWebhook
+//
+// Returns:
+//   - Success: true once deleted.
+//
+// Errors:
+//   - Unimplemented: When the webhooks are not enabled.
+//   - NotFound: When no webhook has the id.
+//   - Other: The dbError of failing to delete the webhook.
+func (s *Server) deleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.DeleteWebhookResponse, error) {
+	store, err := s.webhookStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.DeleteWebhook(ctx, req.GetId()); err != nil {
+		return nil, webhookError(ctx, err, "delete webhook", req.GetId())
+	}
+	return &pb.DeleteWebhookResponse{Success: true}, nil
+}
+
+// listWebhookAttempts
+// Pages through the attempts with keyset page tokens, on the attempt id.
+//
+// Returns:
+//   - Attempts: The attempts of the webhook, most recent first.
+//   - NextPageToken: Empty on the last page.
+//
+// Errors:
+//   - Unimplemented: When the webhooks are not enabled.
+//   - NotFound: When no webhook has the id.
+//   - InvalidArgument: When the page token is invalid, or was returned for another webhook.
+//   - Other: The dbError of failing to read the attempts.
+func (s *Server) listWebhookAttempts(ctx context.Context, req *pb.ListWebhookAttemptsRequest) (*pb.ListWebhookAttemptsResponse, error) {
+	store, err := s.webhookStore()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := int(req.GetLimit())
+	if limit < 1 {
+		limit = 10
+	}
+	if limit > 100 {
+		limit = 100
+	}
+
+	// Fetch one extra attempt to know if there is a next page.
+	opts := &database.WebhookAttemptOptions{WebhookID: req.GetWebhookId(), Limit: limit + 1}
+	query := req.GetWebhookId()
+	page := 1
+	if req.GetPageToken() != "" {
+		token, err := decodePageToken(req.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		if token.Query != query {
+			return nil, status.Error(codes.InvalidArgument, "page token does not match the request webhook_id")
+		}
+		opts.BeforeID = token.AfterEntry
+		page = token.Page
+	} else if _, err := store.GetWebhook(ctx, req.GetWebhookId()); err != nil {
+		// The first page tells an unknown webhook from one without attempts
+		return nil, webhookError(ctx, err, "list webhook attempts", req.GetWebhookId())
+	}
+
+	attempts, err := store.ListWebhookAttempts(ctx, opts)
+	if err != nil {
+		return nil, dbError(ctx, err, "list webhook attempts", "")
+	}
+
+	resp := &pb.ListWebhookAttemptsResponse{}
+	if len(attempts) > limit {
+		attempts = attempts[:limit]
+		resp.NextPageToken = (&pageToken{
+			AfterEntry: attempts[limit-1].ID,
+			Page:       page + 1,
+			Query:      query,
+		}).encode()
+	}
+	for _, attempt := range attempts {
+		resp.Attempts = append(resp.Attempts, attempt.ToProto())
+	}
+	return resp, nil
+}
+
+// webhookError
+// Converts a database error about a webhook to a gRPC error, see dbError.
+// A missing webhook is NotFound, with a WEBHOOK_NOT_FOUND ErrorInfo detail and a ResourceInfo detail.
+func webhookError(ctx context.Context, err error, action, id string) error {
+	if database.Classify(err) != database.ClassNotFound || ctx.Err() != nil {
+		return dbError(ctx, err, action, "")
+	}
+
+	st := status.New(codes.NotFound, "webhook not found")
+	if withDetails, err := st.WithDetails(
+		&errdetails.ErrorInfo{Reason: ReasonWebhookNotFound, Domain: errorDomain},
+		&errdetails.ResourceInfo{ResourceType: "webhook", ResourceName: id, Description: "webhook not found"},
+	); err == nil {
+		return withDetails.Err()
+	}
+	return st.Err()
+}
+
+// webhookViolations
+// Returns the violations of a CreateWebhookRequest not covered by its (rules):
+// the url must be an absolute http or https URL, the secret long enough, and the event types known.
+func webhookViolations(req *pb.CreateWebhookRequest) []*errdetails.BadRequest_FieldViolation {
+	var violations []*errdetails.BadRequest_FieldViolation
+	if req.GetUrl() != "" {
+		endpoint, err := url.Parse(req.GetUrl())
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+			violations = append(violations, fieldViolation("url", "url must be an absolute http or https URL"))
+		}
+	}
+	if req.GetSecret() != "" && utf8.RuneCountInString(req.GetSecret()) < minSecretLength {
+		violations = append(violations, fieldViolation("secret", fmt.Sprintf("secret must be at least %d characters", minSecretLength)))
+	}
+	for _, eventType := range req.GetEventTypes() {
+		if _, known := pb.UserEventType_name[int32(eventType)]; !known || eventType == pb.UserEventType_USER_EVENT_TYPE_UNSPECIFIED {
+			violations = append(violations, fieldViolation("event_types", fmt.Sprintf("invalid event type %d", eventType)))
+		}
+	}
+	return violations
+}
+
+// webhookIDViolations
+// Returns the violation of the field name, when it is set but not a webhook id.
+func webhookIDViolations(name, id string) []*errdetails.BadRequest_FieldViolation {
+	if id == "" {
+		return nil
+	}
+	if _, err := uuid.Parse(id); err != nil {
+		return []*errdetails.BadRequest_FieldViolation{fieldViolation(name, fmt.Sprintf("%s must be a webhook id", name))}
+	}
+	return nil
+}
+
+// webhookEventTypes
+// Converts the event types of a webhook to the database ones, without duplicates.
+func webhookEventTypes(eventTypes []pb.UserEventType) []string {
+	var types []string
+	for _, eventType := range eventTypes {
+		name := strings.TrimPrefix(eventType.String(), eventTypePrefix)
+		if !slices.Contains(types, name) {
+			types = append(types, name)
+		}
+	}
+	return types
+}
@@ -2,6 +2,7 @@ package client
 
 import (
 	"context"
+	"io"
 
 	pb "grpc-services/user/proto"
 
@@ -11,39 +12,119 @@ import (
 type GRPCClientInterface interface {
 	CreateUser(ctx context.Context, in *pb.CreateUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
 	GetUser(ctx context.Context, in *pb.GetUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
+	GetUserByEmail(ctx context.Context, in *pb.GetUserByEmailRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
+	ListUserHistory(ctx context.Context, in *pb.ListUserHistoryRequest, opts ...grpc.CallOption) (*pb.ListUserHistoryResponse, error)
 	UpdateUser(ctx context.Context, in *pb.UpdateUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
 	DeleteUser(ctx context.Context, in *pb.DeleteUserRequest, opts ...grpc.CallOption) (*pb.DeleteUserResponse, error)
+	UndeleteUser(ctx context.Context, in *pb.UndeleteUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error)
+	BatchCreateUsers(ctx context.Context, in *pb.BatchCreateUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error)
+	BatchGetUsers(ctx context.Context, in *pb.BatchGetUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error)
+	BatchDeleteUsers(ctx context.Context, in *pb.BatchDeleteUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error)
 	ListUsers(ctx context.Context, in *pb.ListUsersRequest, opts ...grpc.CallOption) (*pb.ListUsersResponse, error)
+	SearchUsers(ctx context.Context, in *pb.SearchUsersRequest, opts ...grpc.CallOption) (*pb.SearchUsersResponse, error)
+	ExportUsers(ctx context.Context, in *pb.ExportUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.User], error)
+	ImportUsers(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[pb.ImportUsersRequest, pb.ImportUsersResponse], error)
+	WatchUsers(ctx context.Context, in *pb.WatchUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.UserEvent], error)
+	CreateWebhook(ctx context.Context, in *pb.CreateWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error)
+	GetWebhook(ctx context.Context, in *pb.GetWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error)
+	ListWebhooks(ctx context.Context, in *pb.ListWebhooksRequest, opts ...grpc.CallOption) (*pb.ListWebhooksResponse, error)
+	EnableWebhook(ctx context.Context, in *pb.EnableWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error)
+	DeleteWebhook(ctx context.Context, in *pb.DeleteWebhookRequest, opts ...grpc.CallOption) (*pb.DeleteWebhookResponse, error)
+	ListWebhookAttempts(ctx context.Context, in *pb.ListWebhookAttemptsRequest, opts ...grpc.CallOption) (*pb.ListWebhookAttemptsResponse, error)
 }
 
 type MockGRPCClient struct {
 	// Responses
-	CreateUserResponse *pb.UserResponse
-	GetUserResponse    *pb.UserResponse
-	UpdateUserResponse *pb.UserResponse
-	DeleteUserResponse *pb.DeleteUserResponse
-	ListUsersResponse  *pb.ListUsersResponse
+	CreateUserResponse          *pb.UserResponse
+	GetUserResponse             *pb.UserResponse
+	GetUserByEmailResponse      *pb.UserResponse
+	ListUserHistoryResponse     *pb.ListUserHistoryResponse
+	UpdateUserResponse          *pb.UserResponse
+	DeleteUserResponse          *pb.DeleteUserResponse
+	UndeleteUserResponse        *pb.UserResponse
+	BatchCreateUsersResponse    *pb.BatchUsersResponse
+	BatchGetUsersResponse       *pb.BatchUsersResponse
+	BatchDeleteUsersResponse    *pb.BatchUsersResponse
+	ListUsersResponse           *pb.ListUsersResponse
+	SearchUsersResponse         *pb.SearchUsersResponse
+	ExportUsersResponse         []*pb.User
+	ImportUsersResponse         *pb.ImportUsersResponse
+	WatchUsersResponse          []*pb.UserEvent
+	CreateWebhookResponse       *pb.WebhookResponse
+	GetWebhookResponse          *pb.WebhookResponse
+	ListWebhooksResponse        *pb.ListWebhooksResponse
+	EnableWebhookResponse       *pb.WebhookResponse
+	DeleteWebhookResponse       *pb.DeleteWebhookResponse
+	ListWebhookAttemptsResponse *pb.ListWebhookAttemptsResponse
 
 	// Errors
-	CreateUserError error
-	GetUserError    error
-	UpdateUserError error
-	DeleteUserError error
-	ListUsersError  error
+	CreateUserError          error
+	GetUserError             error
+	GetUserByEmailError      error
+	ListUserHistoryError     error
+	UpdateUserError          error
+	DeleteUserError          error
+	UndeleteUserError        error
+	BatchCreateUsersError    error
+	BatchGetUsersError       error
+	BatchDeleteUsersError    error
+	ListUsersError           error
+	SearchUsersError         error
+	ExportUsersError         error
+	ImportUsersError         error
+	WatchUsersError          error
+	CreateWebhookError       error
+	GetWebhookError          error
+	ListWebhooksError        error
+	EnableWebhookError       error
+	DeleteWebhookError       error
+	ListWebhookAttemptsError error
 
 	// Call counts
-	CreateUserCount int
-	GetUserCount    int
-	UpdateUserCount int
-	DeleteUserCount int
-	ListUsersCount  int
+	CreateUserCount          int
+	GetUserCount             int
+	GetUserByEmailCount      int
+	ListUserHistoryCount     int
+	UpdateUserCount          int
+	DeleteUserCount          int
+	UndeleteUserCount        int
+	BatchCreateUsersCount    int
+	BatchGetUsersCount       int
+	BatchDeleteUsersCount    int
+	ListUsersCount           int
+	SearchUsersCount         int
+	ExportUsersCount         int
+	ImportUsersCount         int
+	WatchUsersCount          int
+	CreateWebhookCount       int
+	GetWebhookCount          int
+	ListWebhooksCount        int
+	EnableWebhookCount       int
+	DeleteWebhookCount       int
+	ListWebhookAttemptsCount int
 
 	// Last requests
-	LastCreateUserRequest *pb.CreateUserRequest
-	LastGetUserRequest    *pb.GetUserRequest
-	LastUpdateUserRequest *pb.UpdateUserRequest
-	LastDeleteUserRequest *pb.DeleteUserRequest
-	LastListUsersRequest  *pb.ListUsersRequest
+	LastCreateUserRequest          *pb.CreateUserRequest
+	LastGetUserRequest             *pb.GetUserRequest
+	LastGetUserByEmailRequest      *pb.GetUserByEmailRequest
+	LastListUserHistoryRequest     *pb.ListUserHistoryRequest
+	LastUpdateUserRequest          *pb.UpdateUserRequest
+	LastDeleteUserRequest          *pb.DeleteUserRequest
+	LastUndeleteUserRequest        *pb.UndeleteUserRequest
+	LastBatchCreateUsersRequest    *pb.BatchCreateUsersRequest
+	LastBatchGetUsersRequest       *pb.BatchGetUsersRequest
+	LastBatchDeleteUsersRequest    *pb.BatchDeleteUsersRequest
+	LastListUsersRequest           *pb.ListUsersRequest
+	LastSearchUsersRequest         *pb.SearchUsersRequest
+	LastExportUsersRequest         *pb.ExportUsersRequest
+	LastImportUsersStream          *MockImportStream
+	LastWatchUsersRequest          *pb.WatchUsersRequest
+	LastCreateWebhookRequest       *pb.CreateWebhookRequest
+	LastGetWebhookRequest          *pb.GetWebhookRequest
+	LastListWebhooksRequest        *pb.ListWebhooksRequest
+	LastEnableWebhookRequest       *pb.EnableWebhookRequest
+	LastDeleteWebhookRequest       *pb.DeleteWebhookRequest
+	LastListWebhookAttemptsRequest *pb.ListWebhookAttemptsRequest
 }
 
 func (c *MockGRPCClient) CreateUser(ctx context.Context, in *pb.CreateUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error) {
@@ -64,6 +145,24 @@ func (c *MockGRPCClient) GetUser(ctx context.Context, in *pb.GetUserRequest, opt
 	return c.GetUserResponse, nil
 }
 
+func (c *MockGRPCClient) GetUserByEmail(ctx context.Context, in *pb.GetUserByEmailRequest, opts ...grpc.CallOption) (*pb.UserResponse, error) {
+	c.GetUserByEmailCount++
+	c.LastGetUserByEmailRequest = in
+	if c.GetUserByEmailError != nil {
+		return nil, c.GetUserByEmailError
+	}
+	return c.GetUserByEmailResponse, nil
+}
+
+func (c *MockGRPCClient) ListUserHistory(ctx context.Context, in *pb.ListUserHistoryRequest, opts ...grpc.CallOption) (*pb.ListUserHistoryResponse, error) {
+	c.ListUserHistoryCount++
+	c.LastListUserHistoryRequest = in
+	if c.ListUserHistoryError != nil {
+		return nil, c.ListUserHistoryError
+	}
+	return c.ListUserHistoryResponse, nil
+}
+
 func (c *MockGRPCClient) UpdateUser(ctx context.Context, in *pb.UpdateUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error) {
 	c.UpdateUserCount++
 	c.LastUpdateUserRequest = in
@@ -82,6 +181,42 @@ func (c *MockGRPCClient) DeleteUser(ctx context.Context, in *pb.DeleteUserReques
 	return c.DeleteUserResponse, nil
 }
 
+func (c *MockGRPCClient) UndeleteUser(ctx context.Context, in *pb.UndeleteUserRequest, opts ...grpc.CallOption) (*pb.UserResponse, error) {
+	c.UndeleteUserCount++
+	c.LastUndeleteUserRequest = in
+	if c.UndeleteUserError != nil {
+		return nil, c.UndeleteUserError
+	}
+	return c.UndeleteUserResponse, nil
+}
+
+func (c *MockGRPCClient) BatchCreateUsers(ctx context.Context, in *pb.BatchCreateUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error) {
+	c.BatchCreateUsersCount++
+	c.LastBatchCreateUsersRequest = in
+	if c.BatchCreateUsersError != nil {
+		return nil, c.BatchCreateUsersError
+	}
+	return c.BatchCreateUsersResponse, nil
+}
+
+func (c *MockGRPCClient) BatchGetUsers(ctx context.Context, in *pb.BatchGetUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error) {
+	c.BatchGetUsersCount++
+	c.LastBatchGetUsersRequest = in
+	if c.BatchGetUsersError != nil {
+		return nil, c.BatchGetUsersError
+	}
+	return c.BatchGetUsersResponse, nil
+}
+
+func (c *MockGRPCClient) BatchDeleteUsers(ctx context.Context, in *pb.BatchDeleteUsersRequest, opts ...grpc.CallOption) (*pb.BatchUsersResponse, error) {
+	c.BatchDeleteUsersCount++
+	c.LastBatchDeleteUsersRequest = in
+	if c.BatchDeleteUsersError != nil {
+		return nil, c.BatchDeleteUsersError
+	}
+	return c.BatchDeleteUsersResponse, nil
+}
+
 func (c *MockGRPCClient) ListUsers(ctx context.Context, in *pb.ListUsersRequest, opts ...grpc.CallOption) (*pb.ListUsersResponse, error) {
 	c.ListUsersCount++
 	c.LastListUsersRequest = in
@@ -91,6 +226,137 @@ func (c *MockGRPCClient) ListUsers(ctx context.Context, in *pb.ListUsersRequest,
 	return c.ListUsersResponse, nil
 }
 
+func (c *MockGRPCClient) SearchUsers(ctx context.Context, in *pb.SearchUsersRequest, opts ...grpc.CallOption) (*pb.SearchUsersResponse, error) {
+	c.SearchUsersCount++
+	c.LastSearchUsersRequest = in
+	if c.SearchUsersError != nil {
+		return nil, c.SearchUsersError
+	}
+	return c.SearchUsersResponse, nil
+}
+
+// ExportUsers
+// Streams ExportUsersResponse, then ends with ExportUsersError if set.
+func (c *MockGRPCClient) ExportUsers(ctx context.Context, in *pb.ExportUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.User], error) {
+	c.ExportUsersCount++
+	c.LastExportUsersRequest = in
+	return &MockServerStream[pb.User]{Items: c.ExportUsersResponse, Err: c.ExportUsersError}, nil
+}
+
+// MockServerStream
+// Replays Items as a server stream, then ends with Err, or io.EOF when nil.
+// Only Recv is implemented.
+type MockServerStream[T any] struct {
+	grpc.ClientStream
+
+	Items []*T
+	Err   error
+}
+
+func (s *MockServerStream[T]) Recv() (*T, error) {
+	if len(s.Items) == 0 {
+		if s.Err != nil {
+			return nil, s.Err
+		}
+		return nil, io.EOF
+	}
+	item := s.Items[0]
+	s.Items = s.Items[1:]
+	return item, nil
+}
+
+// ImportUsers
+// Collects the sent records, CloseAndRecv returns ImportUsersResponse or ImportUsersError.
+func (c *MockGRPCClient) ImportUsers(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[pb.ImportUsersRequest, pb.ImportUsersResponse], error) {
+	c.ImportUsersCount++
+	c.LastImportUsersStream = &MockImportStream{Response: c.ImportUsersResponse, Err: c.ImportUsersError}
+	return c.LastImportUsersStream, nil
+}
+
+// MockImportStream
+// Records the sent requests as a client stream, only Send and CloseAndRecv are implemented.
+type MockImportStream struct {
+	grpc.ClientStream
+
+	Requests []*pb.ImportUsersRequest
+	Response *pb.ImportUsersResponse
+	Err      error
+}
+
+func (s *MockImportStream) Send(req *pb.ImportUsersRequest) error {
+	s.Requests = append(s.Requests, req)
+	return nil
+}
+
+func (s *MockImportStream) CloseAndRecv() (*pb.ImportUsersResponse, error) {
+	if s.Err != nil {
+		return nil, s.Err
+	}
+	return s.Response, nil
+}
+
+// WatchUsers
+// Streams WatchUsersResponse, then ends with WatchUsersError if set.
+func (c *MockGRPCClient) WatchUsers(ctx context.Context, in *pb.WatchUsersRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[pb.UserEvent], error) {
+	c.WatchUsersCount++
+	c.LastWatchUsersRequest = in
+	return &MockServerStream[pb.UserEvent]{Items: c.WatchUsersResponse, Err: c.WatchUsersError}, nil
+}
+
+func (c *MockGRPCClient) CreateWebhook(ctx context.Context, in *pb.CreateWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error) {
+	c.CreateWebhookCount++
+	c.LastCreateWebhookRequest = in
+	if c.CreateWebhookError != nil {
+		return nil, c.CreateWebhookError
+	}
+	return c.CreateWebhookResponse, nil
+}
+
+func (c *MockGRPCClient) GetWebhook(ctx context.Context, in *pb.GetWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error) {
+	c.GetWebhookCount++
+	c.LastGetWebhookRequest = in
+	if c.GetWebhookError != nil {
+		return nil, c.GetWebhookError
+	}
+	return c.GetWebhookResponse, nil
+}
+
+func (c *MockGRPCClient) ListWebhooks(ctx context.Context, in *pb.ListWebhooksRequest, opts ...grpc.CallOption) (*pb.ListWebhooksResponse, error) {
+	c.ListWebhooksCount++
+	c.LastListWebhooksRequest = in
+	if c.ListWebhooksError != nil {
+		return nil, c.ListWebhooksError
+	}
+	return c.ListWebhooksResponse, nil
+}
+
+func (c *MockGRPCClient) EnableWebhook(ctx context.Context, in *pb.EnableWebhookRequest, opts ...grpc.CallOption) (*pb.WebhookResponse, error) {
+	c.EnableWebhookCount++
+	c.LastEnableWebhookRequest = in
+	if c.EnableWebhookError != nil {
+		return nil, c.EnableWebhookError
+	}
+	return c.EnableWebhookResponse, nil
+}
+
+func (c *MockGRPCClient) DeleteWebhook(ctx context.Context, in *pb.DeleteWebhookRequest, opts ...grpc.CallOption) (*pb.DeleteWebhookResponse, error) {
+	c.DeleteWebhookCount++
+	c.LastDeleteWebhookRequest = in
+	if c.DeleteWebhookError != nil {
+		return nil, c.DeleteWebhookError
+	}
+	return c.DeleteWebhookResponse, nil
+}
+
+func (c *MockGRPCClient) ListWebhookAttempts(ctx context.Context, in *pb.ListWebhookAttemptsRequest, opts ...grpc.CallOption) (*pb.ListWebhookAttemptsResponse, error) {
+	c.ListWebhookAttemptsCount++
+	c.LastListWebhookAttemptsRequest = in
+	if c.ListWebhookAttemptsError != nil {
+		return nil, c.ListWebhookAttemptsError
+	}
+	return c.ListWebhookAttemptsResponse, nil
+}
+
 // Helper methods for test setup
 func (c *MockGRPCClient) Reset() {
 	*c = MockGRPCClient{}
@@ -104,6 +370,14 @@ func (c *MockGRPCClient) SetGetUserResponse(user *pb.User) {
 	c.GetUserResponse = &pb.UserResponse{User: user}
 }
 
+func (c *MockGRPCClient) SetGetUserByEmailResponse(user *pb.User) {
+	c.GetUserByEmailResponse = &pb.UserResponse{User: user}
+}
+
+func (c *MockGRPCClient) SetListUserHistoryResponse(entries []*pb.UserHistoryEntry) {
+	c.ListUserHistoryResponse = &pb.ListUserHistoryResponse{Entries: entries}
+}
+
 func (c *MockGRPCClient) SetUpdateUserResponse(user *pb.User) {
 	c.UpdateUserResponse = &pb.UserResponse{User: user}
 }
@@ -112,6 +386,22 @@ func (c *MockGRPCClient) SetDeleteUserResponse(success bool) {
 	c.DeleteUserResponse = &pb.DeleteUserResponse{Success: success}
 }
 
+func (c *MockGRPCClient) SetUndeleteUserResponse(user *pb.User) {
+	c.UndeleteUserResponse = &pb.UserResponse{User: user}
+}
+
+func (c *MockGRPCClient) SetBatchCreateUsersResponse(results []*pb.BatchUserResult) {
+	c.BatchCreateUsersResponse = &pb.BatchUsersResponse{Results: results}
+}
+
+func (c *MockGRPCClient) SetBatchGetUsersResponse(results []*pb.BatchUserResult) {
+	c.BatchGetUsersResponse = &pb.BatchUsersResponse{Results: results}
+}
+
+func (c *MockGRPCClient) SetBatchDeleteUsersResponse(results []*pb.BatchUserResult) {
+	c.BatchDeleteUsersResponse = &pb.BatchUsersResponse{Results: results}
+}
+
 func (c *MockGRPCClient) SetListUsersResponse(users []*pb.User, total int32) {
 	c.ListUsersResponse = &pb.ListUsersResponse{
 		Users: users,
@@ -120,3 +410,7 @@ func (c *MockGRPCClient) SetListUsersResponse(users []*pb.User, total int32) {
 		Limit: int32(len(users)),
 	}
 }
+
+func (c *MockGRPCClient) SetSearchUsersResponse(results []*pb.UserSearchResult) {
+	c.SearchUsersResponse = &pb.SearchUsersResponse{Results: results}
+}
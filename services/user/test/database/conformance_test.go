@@ -0,0 +1,478 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-services/user/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newClientFunc
+// Returns an empty store, for a single test.
+type newClientFunc func(t *testing.T) database.SQLClientInterface
+
+// runConformance
+// Runs the behaviours every SQLClientInterface implementation shares, on the stores of newClient,
+// so the storage backends can be swapped without the service noticing.
+func runConformance(t *testing.T, newClient newClientFunc) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, newClient newClientFunc)
+	}{
+		{name: "CreateUser", run: conformanceCreateUser},
+		{name: "UpdateUser", run: conformanceUpdateUser},
+		{name: "DeleteAndUndelete", run: conformanceDeleteAndUndelete},
+		{name: "ListUsers", run: conformanceListUsers},
+		{name: "ListUsersPage", run: conformanceListUsersPage},
+		{name: "SearchUsers", run: conformanceSearchUsers},
+		{name: "BatchCreateUsers", run: conformanceBatchCreateUsers},
+		{name: "ImportUsers", run: conformanceImportUsers},
+		{name: "CreateUserOnce", run: conformanceCreateUserOnce},
+		{name: "History", run: conformanceHistory},
+		{name: "WatchUsers", run: conformanceWatchUsers},
+		{name: "ConcurrentCreates", run: conformanceConcurrentCreates},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newClient)
+		})
+	}
+}
+
+func createUsers(t *testing.T, db database.SQLClientInterface, users ...*database.NewUser) []*database.UserRow {
+	var created []*database.UserRow
+	for _, user := range users {
+		row, err := db.CreateUser(context.Background(), user)
+		require.NoError(t, err)
+		created = append(created, row)
+	}
+	return created
+}
+
+func conformanceCreateUser(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+
+	user, err := db.CreateUser(ctx, &database.NewUser{Name: "Alice", Email: "alice@example.com", Age: 30})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), user.Version)
+	assert.False(t, user.CreatedAt.IsZero())
+	assert.Equal(t, user.CreatedAt, user.UpdatedAt)
+	assert.Equal(t, database.Labels{}, user.Labels)
+
+	_, err = db.CreateUser(ctx, &database.NewUser{Name: "Other", Email: "alice@example.com", Age: 40})
+	assert.ErrorIs(t, err, database.ErrDuplicateEmail)
+	assert.Equal(t, database.ClassAlreadyExists, database.Classify(err))
+}
+
+func conformanceUpdateUser(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	name := "Alicia"
+	taken := "bob@example.com"
+
+	tests := []struct {
+		name         string
+		givenDeleted bool
+		givenID      string
+		givenUpdate  *database.UserUpdate
+		wantName     string
+		wantVersion  int64
+		wantError    error
+	}{
+		{name: "updates the set fields", givenUpdate: &database.UserUpdate{Name: &name}, wantName: "Alicia", wantVersion: 2},
+		{name: "empty update", givenUpdate: &database.UserUpdate{}, wantName: "Alice", wantVersion: 1},
+		{name: "expected version", givenUpdate: &database.UserUpdate{Name: &name, ExpectedVersion: 1}, wantName: "Alicia", wantVersion: 2},
+		{name: "etag mismatch", givenUpdate: &database.UserUpdate{Name: &name, ExpectedVersion: 2}, wantError: database.ErrEtagMismatch},
+		{name: "etag mismatch on empty update", givenUpdate: &database.UserUpdate{ExpectedVersion: 2}, wantError: database.ErrEtagMismatch},
+		{name: "email taken", givenUpdate: &database.UserUpdate{Email: &taken}, wantError: database.ErrDuplicateEmail},
+		{name: "not found", givenID: database.NewUserID(), givenUpdate: &database.UserUpdate{Name: &name}, wantError: sql.ErrNoRows},
+		{name: "soft deleted", givenDeleted: true, givenUpdate: &database.UserUpdate{Name: &name, ExpectedVersion: 2}, wantError: sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newClient(t)
+			users := createUsers(t, db,
+				&database.NewUser{Name: "Alice", Email: "alice@example.com"},
+				&database.NewUser{Name: "Bob", Email: "bob@example.com"},
+			)
+			if tt.givenDeleted {
+				require.NoError(t, db.DeleteUser(ctx, users[0].ID, 0))
+			}
+			id := users[0].ID
+			if tt.givenID != "" {
+				id = tt.givenID
+			}
+
+			user, err := db.UpdateUser(ctx, id, tt.givenUpdate)
+			if tt.wantError != nil {
+				assert.ErrorIs(t, err, tt.wantError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantName, user.Name)
+			assert.Equal(t, tt.wantVersion, user.Version)
+			assert.False(t, user.UpdatedAt.Before(user.CreatedAt))
+		})
+	}
+}
+
+func conformanceDeleteAndUndelete(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+	users := createUsers(t, db, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+	id := users[0].ID
+
+	assert.ErrorIs(t, db.DeleteUser(ctx, id, 2), database.ErrEtagMismatch)
+	assert.NoError(t, db.DeleteUser(ctx, id, 1))
+	assert.ErrorIs(t, db.DeleteUser(ctx, id, 0), sql.ErrNoRows)
+
+	_, err := db.GetUser(ctx, id, false)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	deleted, err := db.GetUser(ctx, id, true)
+	assert.NoError(t, err)
+	assert.NotNil(t, deleted.DeletedAt)
+	assert.Equal(t, int64(2), deleted.Version)
+
+	// The email is free again, until the user is restored
+	createUsers(t, db, &database.NewUser{Name: "New Alice", Email: "alice@example.com"})
+	_, err = db.UndeleteUser(ctx, id)
+	assert.ErrorIs(t, err, database.ErrDuplicateEmail)
+
+	byEmail, err := db.GetUserByEmail(ctx, "alice@example.com", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "New Alice", byEmail.Name)
+}
+
+func conformanceListUsers(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+	users := createUsers(t, db,
+		&database.NewUser{Name: "Carol", Email: "carol@example.com", Age: 30},
+		&database.NewUser{Name: "alice", Email: "alice@example.org", Age: 30},
+		&database.NewUser{Name: "Bob", Email: "bob@example.com", Age: 20},
+		&database.NewUser{Name: "Dave", Email: "dave@example.com", Age: 40},
+	)
+	require.NoError(t, db.DeleteUser(ctx, users[3].ID, 0))
+
+	tests := []struct {
+		name      string
+		givenOpts *database.ListOptions
+		wantNames []string
+	}{
+		{
+			name:      "by id",
+			givenOpts: &database.ListOptions{Limit: 10},
+			wantNames: []string{"Carol", "alice", "Bob"},
+		},
+		{
+			name:      "by age desc, ties by id",
+			givenOpts: &database.ListOptions{OrderBy: database.OrderByAge, Desc: true, Limit: 10},
+			wantNames: []string{"alice", "Carol", "Bob"},
+		},
+		{
+			name:      "keyset cursor",
+			givenOpts: &database.ListOptions{OrderBy: database.OrderByAge, Limit: 10, AfterID: users[0].ID, AfterValue: "30"},
+			wantNames: []string{"alice"},
+		},
+		{
+			name:      "offset and limit",
+			givenOpts: &database.ListOptions{Limit: 1, Offset: 1},
+			wantNames: []string{"alice"},
+		},
+		{
+			name: "filters",
+			givenOpts: &database.ListOptions{Limit: 10, Filter: database.UserFilter{
+				ShowDeleted: true, EmailDomain: "EXAMPLE.com", MinAge: 25,
+			}},
+			wantNames: []string{"Carol", "Dave"},
+		},
+		{
+			name:      "case insensitive name prefix",
+			givenOpts: &database.ListOptions{Limit: 10, Filter: database.UserFilter{NamePrefix: "A"}},
+			wantNames: []string{"alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := db.ListUsers(ctx, tt.givenOpts)
+			assert.NoError(t, err)
+			var names []string
+			for _, user := range users {
+				names = append(names, user.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+
+			count, err := db.CountUsers(ctx, &tt.givenOpts.Filter)
+			assert.NoError(t, err)
+			assert.GreaterOrEqual(t, count, len(users))
+		})
+	}
+}
+
+func conformanceListUsersPage(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+	users := createUsers(t, db,
+		&database.NewUser{Name: "Alice", Email: "alice@example.com", Age: 30},
+		&database.NewUser{Name: "Bob", Email: "bob@example.com", Age: 20},
+		&database.NewUser{Name: "Carol", Email: "carol@example.org", Age: 40},
+	)
+	require.NoError(t, db.DeleteUser(ctx, users[2].ID, 0))
+
+	// The table is too small to estimate, every backend counts it
+	tests := []struct {
+		name      string
+		givenMode string
+		wantTotal int
+		wantMode  string
+	}{
+		{name: "unspecified", wantTotal: 2, wantMode: database.TotalExact},
+		{name: "exact", givenMode: database.TotalExact, wantTotal: 2, wantMode: database.TotalExact},
+		{name: "estimate", givenMode: database.TotalEstimate, wantTotal: 2, wantMode: database.TotalExact},
+		{name: "none", givenMode: database.TotalNone, wantTotal: 0, wantMode: database.TotalNone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The total is not restricted by the cursor
+			page, err := db.ListUsersPage(ctx, &database.ListOptions{Limit: 10, AfterID: users[0].ID, TotalMode: tt.givenMode})
+			require.NoError(t, err)
+			require.Len(t, page.Users, 1)
+			assert.Equal(t, "Bob", page.Users[0].Name)
+			assert.Equal(t, tt.wantTotal, page.Total)
+			assert.Equal(t, tt.wantMode, page.TotalMode)
+		})
+	}
+
+	page, err := db.ListUsersPage(ctx, &database.ListOptions{Limit: 1, Filter: database.UserFilter{ShowDeleted: true, EmailDomain: "example.org"}})
+	require.NoError(t, err)
+	require.Len(t, page.Users, 1)
+	assert.Equal(t, "Carol", page.Users[0].Name)
+	assert.Equal(t, 1, page.Total)
+}
+
+func conformanceSearchUsers(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+	createUsers(t, db,
+		&database.NewUser{Name: "Alice Johnson", Email: "alice@example.com"},
+		&database.NewUser{Name: "Bob Smith", Email: "bob@example.com"},
+	)
+
+	tests := []struct {
+		name       string
+		givenQuery string
+		wantNames  []string
+	}{
+		{name: "word prefixes", givenQuery: "ali john", wantNames: []string{"Alice Johnson"}},
+		{name: "typo", givenQuery: "Johnsen", wantNames: []string{"Alice Johnson"}},
+		{name: "no match", givenQuery: "Zed", wantNames: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := db.SearchUsers(ctx, &database.SearchOptions{Query: tt.givenQuery, Limit: 10})
+			assert.NoError(t, err)
+			var names []string
+			for _, result := range results {
+				names = append(names, result.User.Name)
+				assert.Greater(t, result.Score, 0.0)
+			}
+			assert.Equal(t, tt.wantNames, names)
+		})
+	}
+}
+
+func conformanceBatchCreateUsers(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	users := []*database.NewUser{
+		{Name: "Alice", Email: "alice@example.com"},
+		{Name: "Again", Email: "alice@example.com"},
+		{Name: "Bob", Email: "bob@example.com"},
+	}
+
+	t.Run("atomic", func(t *testing.T) {
+		db := newClient(t)
+		results, err := db.BatchCreateUsers(ctx, users, true)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, results[0].Err, database.ErrBatchAborted)
+		assert.ErrorIs(t, results[1].Err, database.ErrDuplicateEmail)
+		assert.ErrorIs(t, results[2].Err, database.ErrBatchAborted)
+
+		// Rolled back, without events
+		count, err := db.CountUsers(ctx, &database.UserFilter{})
+		assert.NoError(t, err)
+		assert.Zero(t, count)
+		_, err = db.GetUserByEmail(ctx, "alice@example.com", true)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	})
+
+	t.Run("best effort", func(t *testing.T) {
+		db := newClient(t)
+		results, err := db.BatchCreateUsers(ctx, users, false)
+		assert.NoError(t, err)
+		assert.NoError(t, results[0].Err)
+		assert.ErrorIs(t, results[1].Err, database.ErrDuplicateEmail)
+		assert.Nil(t, results[1].User)
+		assert.NoError(t, results[2].Err)
+		assert.Less(t, results[0].User.ID, results[2].User.ID)
+	})
+}
+
+func conformanceImportUsers(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+	createUsers(t, db, &database.NewUser{Name: "Alice", Email: "alice@example.com", Age: 30})
+	users := []*database.NewUser{
+		{Name: "Alice Updated", Email: "alice@example.com", Age: 31},
+		{Name: "Bob", Email: "bob@example.com", Age: 20},
+	}
+
+	results, err := db.ImportUsers(ctx, users, false, false)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, results[0].Err, database.ErrDuplicateEmail)
+	assert.NoError(t, results[1].Err)
+
+	results, err = db.ImportUsers(ctx, users[:1], true, true)
+	assert.NoError(t, err)
+	assert.True(t, results[0].Updated)
+	assert.Equal(t, "Alice Updated", results[0].User.Name)
+	alice, err := db.GetUserByEmail(ctx, "alice@example.com", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", alice.Name, "a dry run is rolled back")
+
+	results, err = db.ImportUsers(ctx, users[:1], true, false)
+	assert.NoError(t, err)
+	assert.True(t, results[0].Updated)
+	assert.Equal(t, int64(2), results[0].User.Version)
+}
+
+func conformanceCreateUserOnce(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+	user := &database.NewUser{Name: "Alice", Email: "alice@example.com", RequestID: "req-1", RequestHash: "hash"}
+
+	created, err := db.CreateUserOnce(ctx, user)
+	assert.NoError(t, err)
+	replayed, err := db.CreateUserOnce(ctx, user)
+	assert.NoError(t, err)
+	assert.Equal(t, created, replayed)
+
+	_, err = db.CreateUserOnce(ctx, &database.NewUser{Name: "Bob", Email: "bob@example.com", RequestID: "req-1", RequestHash: "other"})
+	assert.ErrorIs(t, err, database.ErrRequestIDReused)
+
+	// The key of a purged user is kept, so the request is not replayed into a new user
+	require.NoError(t, db.DeleteUser(ctx, created.ID, 0))
+	purged, err := db.PurgeDeletedUsers(ctx, -time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = db.CreateUserOnce(ctx, user)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	purgedKeys, err := db.PurgeIdempotencyKeys(ctx, -time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purgedKeys)
+}
+
+func conformanceHistory(t *testing.T, newClient newClientFunc) {
+	ctx := database.WithActor(context.Background(), "admin")
+	db := newClient(t)
+	users := createUsers(t, db, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+	id := users[0].ID
+	name := "Alicia"
+	_, err := db.UpdateUser(ctx, id, &database.UserUpdate{Name: &name})
+	require.NoError(t, err)
+	require.NoError(t, db.DeleteUser(ctx, id, 0))
+	_, err = db.PurgeDeletedUsers(ctx, -time.Second)
+	require.NoError(t, err)
+
+	entries, err := db.ListUserHistory(ctx, &database.HistoryOptions{UserID: id, Limit: 10})
+	assert.NoError(t, err)
+	var types []string
+	for _, entry := range entries {
+		types = append(types, entry.Type)
+	}
+	assert.Equal(t, []string{database.EventPurged, database.EventDeleted, database.EventUpdated, database.EventCreated}, types)
+	assert.Equal(t, "admin", entries[0].Actor)
+	assert.Nil(t, entries[0].After)
+	assert.Empty(t, entries[3].Actor)
+
+	_, err = db.GetUserAsOf(ctx, id, time.Now(), true)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	page, err := db.ListUserHistory(ctx, &database.HistoryOptions{UserID: id, Limit: 2, BeforeID: entries[1].ID})
+	assert.NoError(t, err)
+	assert.Equal(t, entries[2:], page)
+}
+
+func conformanceWatchUsers(t *testing.T, newClient newClientFunc) {
+	db := newClient(t)
+	createUsers(t, db, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events := make(chan *database.UserEvent)
+	go db.WatchUsers(ctx, 1, func(event *database.UserEvent) error {
+		events <- event
+		return nil
+	})
+
+	users := createUsers(t, db, &database.NewUser{Name: "Bob", Email: "bob@example.com"})
+	require.NoError(t, db.DeleteUser(ctx, users[0].ID, 0))
+
+	for _, want := range []struct {
+		seq  int64
+		kind string
+	}{{2, database.EventCreated}, {3, database.EventDeleted}} {
+		select {
+		case event := <-events:
+			assert.Equal(t, want.seq, event.Sequence)
+			assert.Equal(t, want.kind, event.Type)
+			assert.Equal(t, "Bob", event.User.Name)
+		case <-ctx.Done():
+			t.Fatal("missing event")
+		}
+	}
+
+	purged, err := db.PurgeUserEvents(ctx, -time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+	assert.ErrorIs(t, db.WatchUsers(ctx, 1, func(*database.UserEvent) error { return nil }), database.ErrEventsPurged)
+}
+
+func conformanceConcurrentCreates(t *testing.T, newClient newClientFunc) {
+	ctx := context.Background()
+	db := newClient(t)
+
+	// Only one of the concurrent creates of an email succeeds
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := db.CreateUser(ctx, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var created int
+	for err := range errs {
+		if err == nil {
+			created++
+			continue
+		}
+		assert.ErrorIs(t, err, database.ErrDuplicateEmail)
+	}
+	assert.Equal(t, 1, created)
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-services/user/config"
+	"grpc-services/user/database"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCachedClient(db database.SQLClientInterface, size int, ttl time.Duration) *database.CachedClient {
+	return database.NewCachedClient(db, &config.Config{CacheSize: size, CacheTTL: ttl, CacheNegativeTTL: ttl})
+}
+
+func TestCachedClient_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) database.SQLClientInterface {
+		return newCachedClient(newMemoryClient(), 100, time.Hour)
+	})
+}
+
+func TestCachedClient_GetUser(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemoryClient()
+	users := createUsers(t, inner, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+	id := users[0].ID
+	cache := newCachedClient(inner, 10, time.Hour)
+
+	user, err := cache.GetUser(ctx, id, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", user.Name)
+
+	// Writes bypassing the cache are not seen until the user expires
+	name := "Alicia"
+	_, err = inner.UpdateUser(ctx, id, &database.UserUpdate{Name: &name})
+	require.NoError(t, err)
+	user, err = cache.GetUser(ctx, id, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", user.Name)
+
+	// Returned users are copies
+	user.Name = "Changed"
+	user, err = cache.GetUser(ctx, id, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", user.Name)
+
+	// Writes through the cache invalidate it
+	name = "Ali"
+	_, err = cache.UpdateUser(ctx, id, &database.UserUpdate{Name: &name})
+	require.NoError(t, err)
+	user, err = cache.GetUser(ctx, id, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ali", user.Name)
+
+	// Deleted users share the entry of live ones
+	require.NoError(t, cache.DeleteUser(ctx, id, 0))
+	_, err = cache.GetUser(ctx, id, false)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	deleted, err := cache.GetUser(ctx, id, true)
+	assert.NoError(t, err)
+	assert.NotNil(t, deleted.DeletedAt)
+
+	assert.Equal(t, database.CacheStats{Hits: 3, Misses: 3}, cache.Stats())
+}
+
+// primaryRecorder
+// Records whether the GetUser calls reaching the wrapped client asked for the primary.
+type primaryRecorder struct {
+	database.SQLClientInterface
+	primary []bool
+}
+
+func (r *primaryRecorder) GetUser(ctx context.Context, id string, showDeleted bool) (*database.UserRow, error) {
+	r.primary = append(r.primary, database.PrimaryReads(ctx))
+	return r.SQLClientInterface.GetUser(ctx, id, showDeleted)
+}
+
+func TestCachedClient_PrimaryReads(t *testing.T) {
+	ctx := context.Background()
+	inner := &primaryRecorder{SQLClientInterface: newMemoryClient()}
+	users := createUsers(t, inner, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+	id := users[0].ID
+	cache := newCachedClient(inner, 10, time.Hour)
+
+	// Misses are read from the primary, and cached
+	_, err := cache.GetUser(ctx, id, false)
+	require.NoError(t, err)
+	_, err = cache.GetUser(ctx, id, false)
+	require.NoError(t, err)
+
+	// A write then a primary read bypass the cache, which keeps the primary read it has
+	name := "Alicia"
+	_, err = inner.UpdateUser(ctx, id, &database.UserUpdate{Name: &name})
+	require.NoError(t, err)
+	user, err := cache.GetUser(database.WithPrimaryReads(ctx), id, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alicia", user.Name)
+	user, err = cache.GetUser(ctx, id, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", user.Name)
+
+	assert.Equal(t, []bool{true, true}, inner.primary)
+	assert.Equal(t, database.CacheStats{Hits: 2, Misses: 1, Bypasses: 1}, cache.Stats())
+}
+
+func TestCachedClient_NotFound(t *testing.T) {
+	ctx := context.Background()
+	cache := newCachedClient(newMemoryClient(), 10, time.Hour)
+	id := database.NewUserID()
+
+	for i := 0; i < 2; i++ {
+		_, err := cache.GetUser(ctx, id, true)
+		assert.ErrorIs(t, err, sql.ErrNoRows)
+	}
+	assert.Equal(t, database.CacheStats{Hits: 1, NegativeHits: 1, Misses: 1}, cache.Stats())
+}
+
+func TestCachedClient_Expiry(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemoryClient()
+	users := createUsers(t, inner, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+	cache := newCachedClient(inner, 10, time.Millisecond)
+
+	_, err := cache.GetUser(ctx, users[0].ID, false)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = cache.GetUser(ctx, users[0].ID, false)
+	require.NoError(t, err)
+	assert.Equal(t, database.CacheStats{Misses: 2}, cache.Stats())
+}
+
+func TestCachedClient_Eviction(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemoryClient()
+	users := createUsers(t, inner,
+		&database.NewUser{Name: "Alice", Email: "alice@example.com"},
+		&database.NewUser{Name: "Bob", Email: "bob@example.com"},
+		&database.NewUser{Name: "Carol", Email: "carol@example.com"},
+	)
+	cache := newCachedClient(inner, 2, time.Hour)
+
+	// Alice is used again before Carol is cached, so Bob is evicted
+	for _, i := range []int{0, 1, 0, 2, 0, 1} {
+		_, err := cache.GetUser(ctx, users[i].ID, false)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, database.CacheStats{Hits: 2, Misses: 4, Evictions: 2}, cache.Stats())
+}
+
+func TestCachedClient_Purge(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemoryClient()
+	users := createUsers(t, inner, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+	cache := newCachedClient(inner, 10, time.Hour)
+	require.NoError(t, cache.DeleteUser(ctx, users[0].ID, 0))
+	_, err := cache.GetUser(ctx, users[0].ID, true)
+	require.NoError(t, err)
+
+	purged, err := cache.PurgeDeletedUsers(ctx, -time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, err = cache.GetUser(ctx, users[0].ID, true)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+func TestCachedClient_ConcurrentAccess(t *testing.T) {
+	ctx := context.Background()
+	inner := newMemoryClient()
+	users := createUsers(t, inner,
+		&database.NewUser{Name: "Alice", Email: "alice@example.com"},
+		&database.NewUser{Name: "Bob", Email: "bob@example.com"},
+	)
+	cache := newCachedClient(inner, 1, time.Hour)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			user := users[i%2]
+			name := user.Name
+			for j := 0; j < 20; j++ {
+				_, err := cache.GetUser(ctx, user.ID, false)
+				assert.NoError(t, err)
+				_, err = cache.UpdateUser(ctx, user.ID, &database.UserUpdate{Name: &name})
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// The last write is seen, whatever the interleaving
+	for _, user := range users {
+		cached, err := cache.GetUser(ctx, user.ID, false)
+		assert.NoError(t, err)
+		stored, err := inner.GetUser(ctx, user.ID, false)
+		assert.NoError(t, err)
+		assert.Equal(t, stored, cached)
+	}
+	stats := cache.Stats()
+	assert.Equal(t, int64(20*20+2), stats.Hits+stats.Misses)
+}
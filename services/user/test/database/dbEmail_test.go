@@ -0,0 +1,51 @@
+package database
+
+import (
+	"testing"
+
+	"grpc-services/user/database"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		name             string
+		givenEmail       string
+		givenFoldLocal   bool
+		wantNormalizedAs string
+	}{
+		{
+			name:             "lowercases the domain",
+			givenEmail:       "Alice@Example.COM",
+			wantNormalizedAs: "Alice@example.com",
+		},
+		{
+			name:             "trims spaces",
+			givenEmail:       " \talice@example.com\n",
+			wantNormalizedAs: "alice@example.com",
+		},
+		{
+			name:             "folds the local part",
+			givenEmail:       "Alice@Example.COM",
+			givenFoldLocal:   true,
+			wantNormalizedAs: "alice@example.com",
+		},
+		{
+			name:             "splits on the last @",
+			givenEmail:       `"a@B"@Example.com`,
+			wantNormalizedAs: `"a@B"@example.com`,
+		},
+		{
+			name:             "no @",
+			givenEmail:       " Alice ",
+			wantNormalizedAs: "Alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantNormalizedAs, database.NormalizeEmail(tt.givenEmail, tt.givenFoldLocal))
+		})
+	}
+}
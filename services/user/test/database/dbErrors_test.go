@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"grpc-services/user/database"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		givenErr  error
+		wantClass database.ErrorClass
+	}{
+		{
+			name:      "no rows",
+			givenErr:  fmt.Errorf("get user: %w", sql.ErrNoRows),
+			wantClass: database.ClassNotFound,
+		},
+		{
+			name:      "unique violation",
+			givenErr:  &pq.Error{Code: "23505"},
+			wantClass: database.ClassAlreadyExists,
+		},
+		{
+			name:      "duplicate email",
+			givenErr:  database.ErrDuplicateEmail,
+			wantClass: database.ClassAlreadyExists,
+		},
+		{
+			name:      "check violation",
+			givenErr:  &pq.Error{Code: "23514"},
+			wantClass: database.ClassConstraint,
+		},
+		{
+			name:      "etag mismatch",
+			givenErr:  database.ErrEtagMismatch,
+			wantClass: database.ClassEtagMismatch,
+		},
+		{
+			name:      "deadline exceeded",
+			givenErr:  context.DeadlineExceeded,
+			wantClass: database.ClassTimeout,
+		},
+		{
+			name:      "statement timeout",
+			givenErr:  &pq.Error{Code: "57014"},
+			wantClass: database.ClassTimeout,
+		},
+		{
+			name:      "canceled",
+			givenErr:  context.Canceled,
+			wantClass: database.ClassCanceled,
+		},
+		{
+			name:      "bad connection",
+			givenErr:  driver.ErrBadConn,
+			wantClass: database.ClassUnavailable,
+		},
+		{
+			name:      "connection refused",
+			givenErr:  &net.OpError{Op: "dial", Err: errors.New("connection refused")},
+			wantClass: database.ClassUnavailable,
+		},
+		{
+			name:      "too many connections",
+			givenErr:  &pq.Error{Code: "53300"},
+			wantClass: database.ClassUnavailable,
+		},
+		{
+			name:      "server shutting down",
+			givenErr:  &pq.Error{Code: "57P01"},
+			wantClass: database.ClassUnavailable,
+		},
+		{
+			name:      "syntax error",
+			givenErr:  &pq.Error{Code: "42601"},
+			wantClass: database.ClassUnknown,
+		},
+		{
+			name:      "other error",
+			givenErr:  errors.New("database error"),
+			wantClass: database.ClassUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantClass, database.Classify(tt.givenErr))
+		})
+	}
+}
+
+func TestConstraint(t *testing.T) {
+	tests := []struct {
+		name           string
+		givenErr       error
+		wantConstraint string
+		wantTable      string
+	}{
+		{
+			name:           "duplicate email",
+			givenErr:       fmt.Errorf("create user: %w", database.ErrDuplicateEmail),
+			wantConstraint: database.EmailConstraint,
+			wantTable:      "users",
+		},
+		{
+			name:           "unique violation",
+			givenErr:       &pq.Error{Code: "23505", Constraint: "idempotency_keys_pkey", Table: "idempotency_keys"},
+			wantConstraint: "idempotency_keys_pkey",
+			wantTable:      "idempotency_keys",
+		},
+		{
+			name:     "other error",
+			givenErr: errors.New("database error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantConstraint, database.Constraint(tt.givenErr))
+			assert.Equal(t, tt.wantTable, database.ConstraintTable(tt.givenErr))
+		})
+	}
+}
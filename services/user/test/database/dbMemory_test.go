@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"grpc-services/user/config"
+	"grpc-services/user/database"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMemoryClient() *database.MemoryClient {
+	return database.NewMemoryClient(&config.Config{IdempotencyWindow: time.Hour})
+}
+
+func TestMemoryClient_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) database.SQLClientInterface {
+		return newMemoryClient()
+	})
+}
+
+func TestMemoryClient_ReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	db := newMemoryClient()
+	users := createUsers(t, db, &database.NewUser{Name: "Alice", Email: "alice@example.com", Labels: database.Labels{"team": "a"}})
+
+	users[0].Name = "Changed"
+	users[0].Labels["team"] = "b"
+	got, err := db.GetUser(ctx, users[0].ID, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Alice", got.Name)
+	assert.Equal(t, database.Labels{"team": "a"}, got.Labels)
+}
@@ -0,0 +1,346 @@
+//go:build integration
+// +build integration
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"grpc-services/user/config"
+	"grpc-services/user/database"
+	"grpc-services/user/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postgresConfig
+// Returns the config of the PostgreSQL database of the DB_* variables, see .env.example.
+func postgresConfig(t *testing.T) *config.Config {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set")
+	}
+	return &config.Config{
+		DBHost:            os.Getenv("DB_HOST"),
+		DBPort:            os.Getenv("DB_PORT"),
+		DBUser:            os.Getenv("DB_USER"),
+		DBPassword:        os.Getenv("DB_PASSWORD"),
+		DBName:            os.Getenv("DB_NAME"),
+		DBReplicaMaxLag:   time.Second,
+		IdempotencyWindow: time.Hour,
+	}
+}
+
+// runPostgresConformance
+// Runs the conformance suite on the database of cfg, emptying the users tables before every test.
+func runPostgresConformance(t *testing.T, cfg *config.Config) {
+	db, err := database.NewPostgresClient(cfg)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTables())
+
+	runConformance(t, func(t *testing.T) database.SQLClientInterface {
+		_, err := db.DB.Exec(`TRUNCATE users, user_events, user_history, idempotency_keys, outbox, webhooks RESTART IDENTITY CASCADE`)
+		require.NoError(t, err)
+		return db
+	})
+}
+
+func TestSQLClient_Conformance(t *testing.T) {
+	runPostgresConformance(t, postgresConfig(t))
+}
+
+// TestSQLClient_Replicas
+// Runs the conformance suite with the primary as its own replica, so reads go through the replica routing,
+// next to a replica that can't be reached, which must be ejected.
+func TestSQLClient_Replicas(t *testing.T) {
+	cfg := postgresConfig(t)
+	cfg.DBReplicas = []string{
+		fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+			cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName),
+		"host=127.0.0.1 port=1 user=nobody dbname=none sslmode=disable connect_timeout=1",
+	}
+	// Reads go to the replicas right after writes
+	cfg.ReadYourWritesWindow = time.Nanosecond
+	runPostgresConformance(t, cfg)
+}
+
+func TestSQLClient_RelayOutbox(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.NewPostgresClient(postgresConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTables())
+	_, err = db.DB.Exec(`TRUNCATE users, user_events, user_history, idempotency_keys, outbox, webhooks RESTART IDENTITY CASCADE`)
+	require.NoError(t, err)
+
+	users := createUsers(t, db,
+		&database.NewUser{Name: "Alice", Email: "alice@example.com"},
+		&database.NewUser{Name: "Bob", Email: "bob@example.com"},
+	)
+	name := "Alicia"
+	_, err = db.UpdateUser(ctx, users[0].ID, &database.UserUpdate{Name: &name})
+	require.NoError(t, err)
+	require.NoError(t, db.DeleteUser(ctx, users[1].ID, 0))
+
+	// Bob fails, so his delete waits for his create, while Alice goes on
+	var published []*database.OutboxEvent
+	publish := func(event *database.OutboxEvent) error {
+		if event.User.ID == users[1].ID {
+			return errors.New("unavailable")
+		}
+		published = append(published, event)
+		return nil
+	}
+	n, err := db.RelayOutbox(ctx, 10, publish)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, published, 2)
+	assert.Equal(t, database.EventCreated, published[0].Type)
+	assert.Equal(t, database.EventUpdated, published[1].Type)
+	assert.Equal(t, "Alicia", published[1].User.Name)
+	assert.Less(t, published[0].ID, published[1].ID)
+
+	// The failed event is retried after its backoff, not before
+	n, err = db.RelayOutbox(ctx, 10, publish)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+	_, err = db.DB.Exec(`UPDATE outbox SET next_attempt_at = CURRENT_TIMESTAMP`)
+	require.NoError(t, err)
+
+	published = nil
+	n, err = db.RelayOutbox(ctx, 10, func(event *database.OutboxEvent) error {
+		published = append(published, event)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	require.Len(t, published, 2)
+	assert.Equal(t, database.EventCreated, published[0].Type)
+	assert.Equal(t, 1, published[0].Attempts)
+	assert.Equal(t, database.EventDeleted, published[1].Type)
+	assert.NotNil(t, published[1].User.DeletedAt)
+
+	// The published events are purged after the retention, the unpublished ones are kept
+	createUsers(t, db, &database.NewUser{Name: "Carol", Email: "carol@example.com"})
+	purged, err := db.PurgeOutbox(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	_, err = db.DB.Exec(`UPDATE outbox SET published_at = published_at - interval '2 hours', created_at = created_at - interval '2 hours'`)
+	require.NoError(t, err)
+	purged, err = db.PurgeOutbox(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), purged)
+	var left int
+	require.NoError(t, db.DB.QueryRow(`SELECT count(*) FROM outbox WHERE published_at IS NULL`).Scan(&left))
+	assert.Equal(t, 1, left)
+}
+
+func TestSQLClient_ClaimWebhookDeliveries(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.NewPostgresClient(postgresConfig(t))
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.CreateTables())
+	_, err = db.DB.Exec(`TRUNCATE users, user_events, user_history, idempotency_keys, outbox, webhooks RESTART IDENTITY CASCADE`)
+	require.NoError(t, err)
+
+	// The receiver fails the first attempt
+	var received []webhook.Payload
+	failNext := true
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhook.HeaderTimestamp), 10, 64)
+		if !webhook.Verify("0123456789abcdef", timestamp, body, r.Header.Get(webhook.HeaderSignature)) || failNext {
+			failNext = false
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var payload webhook.Payload
+		json.Unmarshal(body, &payload)
+		received = append(received, payload)
+	}))
+	defer endpoint.Close()
+
+	all, err := db.CreateWebhook(ctx, &database.NewWebhook{URL: endpoint.URL, Secret: "0123456789abcdef"})
+	require.NoError(t, err)
+	assert.Empty(t, all.EventTypes)
+	deletes, err := db.CreateWebhook(ctx, &database.NewWebhook{URL: "http://127.0.0.1:1", EventTypes: []string{database.EventDeleted}, Secret: "0123456789abcdef"})
+	require.NoError(t, err)
+	webhooks, err := db.ListWebhooks(ctx)
+	require.NoError(t, err)
+	assert.Len(t, webhooks, 2)
+
+	users := createUsers(t, db, &database.NewUser{Name: "Alice", Email: "alice@example.com"})
+	require.NoError(t, db.DeleteUser(ctx, users[0].ID, 0))
+
+	// The claimed deliveries are leased, they are not claimed again
+	claimed, err := db.ClaimWebhookDeliveries(ctx, 10, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 3)
+	again, err := db.ClaimWebhookDeliveries(ctx, 10, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, again)
+	var pending []*database.WebhookDelivery
+	worker := webhook.NewWorker(db, time.Second, time.Second, 1, 1)
+	for _, delivery := range claimed {
+		if delivery.Webhook.ID == all.ID {
+			pending = append(pending, delivery)
+			continue
+		}
+		// The unreachable webhook is disabled after its first failure
+		require.NoError(t, db.RecordWebhookAttempt(ctx, delivery, worker.Deliver(ctx, delivery), 1))
+	}
+	disabled, err := db.GetWebhook(ctx, deletes.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, disabled.DisabledAt)
+	assert.Equal(t, 1, disabled.ConsecutiveFailures)
+
+	// The first delivery fails, the later one is released, and waits for its retry
+	require.Len(t, pending, 2)
+	require.NoError(t, db.RecordWebhookAttempt(ctx, pending[0], worker.Deliver(ctx, pending[0]), 0))
+	require.NoError(t, db.ReleaseWebhookDeliveries(ctx, []int64{pending[1].ID}))
+	again, err = db.ClaimWebhookDeliveries(ctx, 10, 10, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, again)
+
+	// The failed delivery is retried after its backoff, then the later one follows in order
+	_, err = db.DB.Exec(`UPDATE webhook_deliveries SET next_attempt_at = CURRENT_TIMESTAMP`)
+	require.NoError(t, err)
+	claimed, err = db.ClaimWebhookDeliveries(ctx, 10, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, 1, claimed[0].Attempts)
+	for _, delivery := range claimed {
+		require.NoError(t, db.RecordWebhookAttempt(ctx, delivery, worker.Deliver(ctx, delivery), 0))
+	}
+	require.Len(t, received, 2)
+	assert.Equal(t, database.EventCreated, received[0].Type)
+	assert.Equal(t, database.EventDeleted, received[1].Type)
+	assert.Equal(t, users[0].ID, received[1].User.ID)
+
+	// Every attempt is recorded, most recent first
+	attempts, err := db.ListWebhookAttempts(ctx, &database.WebhookAttemptOptions{WebhookID: all.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, attempts, 3)
+	assert.Equal(t, []int{200, 200, 500}, []int{attempts[0].StatusCode, attempts[1].StatusCode, attempts[2].StatusCode})
+	assert.Equal(t, 2, attempts[1].Attempt)
+	assert.Equal(t, database.EventCreated, attempts[1].EventType)
+
+	// The delivered deliveries and the pending one of the disabled webhook are purged after the retention
+	purged, err := db.PurgeWebhookDeliveries(ctx, time.Hour)
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+	purged, err = db.PurgeWebhookDeliveries(ctx, time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), purged)
+
+	// Enabling resets the failures, deleting removes the deliveries
+	enabled, err := db.EnableWebhook(ctx, deletes.ID)
+	require.NoError(t, err)
+	assert.Nil(t, enabled.DisabledAt)
+	assert.Zero(t, enabled.ConsecutiveFailures)
+	require.NoError(t, db.DeleteWebhook(ctx, deletes.ID))
+	assert.ErrorIs(t, db.DeleteWebhook(ctx, deletes.ID), sql.ErrNoRows)
+	_, err = db.GetWebhook(ctx, deletes.ID)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+}
+
+// openSchema
+// Returns a client of a new, empty schema of the database of cfg, dropped at the end of the test.
+func openSchema(t *testing.T, cfg *config.Config, schema string) *database.SQLClient {
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
+		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
+	admin, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	_, err = admin.Exec(fmt.Sprintf(`DROP SCHEMA IF EXISTS %[1]s CASCADE; CREATE SCHEMA %[1]s`, schema))
+	require.NoError(t, err)
+
+	// pg_trgm stays in public
+	db, err := sql.Open("postgres", fmt.Sprintf("%s search_path=%s,public", dsn, schema))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		db.Close()
+		admin.Exec(fmt.Sprintf(`DROP SCHEMA %s CASCADE`, schema))
+		admin.Close()
+	})
+	return &database.SQLClient{DB: db}
+}
+
+// TestSQLClient_MigrateFirstSchema
+// Upgrades the first schema of the service, with SERIAL ids, and its users, in place.
+func TestSQLClient_MigrateFirstSchema(t *testing.T) {
+	ctx := context.Background()
+	db := openSchema(t, postgresConfig(t), "migrate_first_schema")
+	_, err := db.DB.Exec(
+		`CREATE TABLE users (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR(100) NOT NULL,
+			email VARCHAR(100) UNIQUE NOT NULL,
+			age INTEGER NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE FUNCTION update_updated_at_column()
+		RETURNS TRIGGER AS $$
+		BEGIN
+			NEW.updated_at = CURRENT_TIMESTAMP;
+			RETURN NEW;
+		END;
+		$$ language 'plpgsql';
+		CREATE TRIGGER update_users_updated_at
+			BEFORE UPDATE ON users
+			FOR EACH ROW
+			EXECUTE FUNCTION update_updated_at_column();
+		INSERT INTO users (name, email, age) VALUES ('Alice', 'alice@example.com', 30), ('Bob', 'bob@example.com', 40);`)
+	require.NoError(t, err)
+
+	require.NoError(t, db.CreateTables())
+
+	ids, err := db.LegacyUserIDs(ctx, []int{1, 2})
+	require.NoError(t, err)
+	require.Len(t, ids, 2)
+	alice, err := db.GetUser(ctx, ids[1], false)
+	require.NoError(t, err)
+	assert.Equal(t, "alice@example.com", alice.Email)
+	assert.Equal(t, int64(1), alice.Version)
+
+	// The history starts with the current row, and users can be created again
+	history, err := db.ListUserHistory(ctx, &database.HistoryOptions{UserID: alice.ID, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, database.EventCreated, history[0].Type)
+	_, err = db.CreateUser(ctx, &database.NewUser{Name: "Carol", Email: "carol@example.com", Age: 50})
+	require.NoError(t, err)
+
+	migrator, err := db.Migrator()
+	require.NoError(t, err)
+	statuses, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.AppliedAt, "migration %d", status.Version)
+	}
+}
+
+// TestSQLClient_MigrateUnknownSchema
+// Refuses to adopt a users table matching no baseline.
+func TestSQLClient_MigrateUnknownSchema(t *testing.T) {
+	db := openSchema(t, postgresConfig(t), "migrate_unknown_schema")
+	_, err := db.DB.Exec(`CREATE TABLE users (id UUID PRIMARY KEY, name VARCHAR(100) NOT NULL)`)
+	require.NoError(t, err)
+
+	err = db.CreateTables()
+	assert.ErrorContains(t, err, "unknown schema")
+	assert.ErrorContains(t, err, "users.email is missing")
+}
@@ -0,0 +1,25 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"grpc-services/user/config"
+	"grpc-services/user/database"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteClient_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) database.SQLClientInterface {
+		db, err := database.NewSQLiteClient(&config.Config{
+			SQLitePath:        filepath.Join(t.TempDir(), "users.db"),
+			IdempotencyWindow: time.Hour,
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		require.NoError(t, db.CreateTables())
+		return db
+	})
+}
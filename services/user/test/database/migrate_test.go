@@ -0,0 +1,21 @@
+package database
+
+import (
+	"testing"
+
+	"grpc-services/user/database"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrations(t *testing.T) {
+	// The embedded migrations are numbered without gaps, from 1
+	migrations, err := database.Migrations()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version)
+		assert.NotEmpty(t, migration.Up)
+		assert.NotEmpty(t, migration.Down)
+	}
+}
@@ -2,18 +2,43 @@ package database
 
 import (
 	"context"
-	"fmt"
+	"database/sql"
 	"grpc-services/user/database"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
 )
 
+// errEmailTaken
+// The error of the users_email_live_idx unique index.
+var errEmailTaken = &pq.Error{Code: "23505", Constraint: "users_email_live_idx"}
+
 // Error Client
 type MockClient struct {
 	givenCreateError error
 	givenListError   error
 	givenCountError  error
 
-	Users  map[int]*database.UserRow
-	NextID int
+	Users map[string]*database.UserRow
+	// LegacyIDs maps the numeric ids of users created before ids were UUIDs to their ids
+	LegacyIDs map[int]string
+	Events    []*database.UserEvent
+	// History is recorded alongside Events, with the actor of the write context
+	History []*database.HistoryEntry
+	// RequestIDs are the idempotency keys of CreateUserOnce, they never expire
+	RequestIDs map[string]RequestIDRecord
+	// Estimate is the total of ListUsersPage with TotalEstimate, when set, the users are counted otherwise
+	Estimate int
+}
+
+// RequestIDRecord
+// The request hash and created user of a request id.
+type RequestIDRecord struct {
+	RequestHash string
+	UserID      string
 }
 
 func NewMockClient(givenCreateError error, givenListError error, givenCountError error) *MockClient {
@@ -21,76 +46,581 @@ func NewMockClient(givenCreateError error, givenListError error, givenCountError
 		givenCreateError: givenCreateError,
 		givenListError:   givenListError,
 		givenCountError:  givenCountError,
-		Users:            make(map[int]*database.UserRow),
-		NextID:           1,
+		Users:            make(map[string]*database.UserRow),
+		LegacyIDs:        make(map[int]string),
+		RequestIDs:       make(map[string]RequestIDRecord),
 	}
 }
 
-func (m *MockClient) CreateUser(ctx context.Context, name, email string, age int32) (*database.UserRow, error) {
+func (m *MockClient) CreateUser(ctx context.Context, newUser *database.NewUser) (*database.UserRow, error) {
 	if m.givenCreateError != nil {
 		return nil, m.givenCreateError
 	}
 
 	user := &database.UserRow{
-		ID:    m.NextID,
-		Name:  name,
-		Email: email,
-		Age:   age,
+		ID:      database.NewUserID(),
+		Name:    newUser.Name,
+		Email:   newUser.Email,
+		Age:     newUser.Age,
+		Version: 1,
+		Labels:  copyLabels(newUser.Labels),
 	}
-	m.Users[m.NextID] = user
-	m.NextID++
+	m.Users[user.ID] = user
+	m.record(ctx, database.EventCreated, user)
 	return user, nil
 }
 
-func (m *MockClient) GetUser(ctx context.Context, id int) (*database.UserRow, error) {
+// CreateUserOnce
+// Mirrors the SQL client, replaying the user of a known request id.
+func (m *MockClient) CreateUserOnce(ctx context.Context, user *database.NewUser) (*database.UserRow, error) {
+	if record, exists := m.RequestIDs[user.RequestID]; exists {
+		if record.RequestHash != user.RequestHash {
+			return nil, database.ErrRequestIDReused
+		}
+		return m.GetUser(ctx, record.UserID, true)
+	}
+
+	created, err := m.CreateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	m.RequestIDs[user.RequestID] = RequestIDRecord{RequestHash: user.RequestHash, UserID: created.ID}
+	return created, nil
+}
+
+// LegacyUserIDs
+// Looks the legacy ids up in LegacyIDs.
+func (m *MockClient) LegacyUserIDs(ctx context.Context, legacyIDs []int) (map[int]string, error) {
+	ids := make(map[int]string, len(legacyIDs))
+	for _, legacyID := range legacyIDs {
+		if id, ok := m.LegacyIDs[legacyID]; ok {
+			ids[legacyID] = id
+		}
+	}
+	return ids, nil
+}
+
+func (m *MockClient) GetUser(ctx context.Context, id string, showDeleted bool) (*database.UserRow, error) {
 	user, exists := m.Users[id]
-	if !exists {
-		return nil, fmt.Errorf("user not found")
+	if !exists || (user.DeletedAt != nil && !showDeleted) {
+		return nil, sql.ErrNoRows
 	}
 	return user, nil
 }
 
-func (m *MockClient) UpdateUser(ctx context.Context, id int, name, email string, age int32) (*database.UserRow, error) {
+// GetUserByEmail
+// Mirrors the SQL client, preferring the live user, then the most recently deleted one.
+func (m *MockClient) GetUserByEmail(ctx context.Context, email string, showDeleted bool) (*database.UserRow, error) {
+	var found *database.UserRow
+	for _, user := range m.Users {
+		if user.Email != email || (user.DeletedAt != nil && !showDeleted) {
+			continue
+		}
+		if user.DeletedAt == nil {
+			return user, nil
+		}
+		if found == nil || user.DeletedAt.After(*found.DeletedAt) {
+			found = user
+		}
+	}
+	if found == nil {
+		return nil, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+func (m *MockClient) UpdateUser(ctx context.Context, id string, update *database.UserUpdate) (*database.UserRow, error) {
 
 	user, exists := m.Users[id]
-	if !exists {
-		return nil, fmt.Errorf("user not found")
+	if !exists || user.DeletedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+	if update.ExpectedVersion != 0 && user.Version != update.ExpectedVersion {
+		return nil, database.ErrEtagMismatch
 	}
-	user.Name = name
-	user.Email = email
-	user.Age = age
+	user.Version++
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Email != nil {
+		user.Email = *update.Email
+	}
+	if update.Age != nil {
+		user.Age = *update.Age
+	}
+	if update.Labels != nil {
+		user.Labels = copyLabels(update.Labels)
+	}
+	m.record(ctx, database.EventUpdated, user)
 	return user, nil
 }
 
-func (m *MockClient) DeleteUser(ctx context.Context, id int) error {
-	if _, exists := m.Users[id]; !exists {
-		return fmt.Errorf("user not found")
+func (m *MockClient) DeleteUser(ctx context.Context, id string, expectedVersion int64) error {
+	user, exists := m.Users[id]
+	if !exists || user.DeletedAt != nil {
+		return sql.ErrNoRows
+	}
+	if expectedVersion != 0 && user.Version != expectedVersion {
+		return database.ErrEtagMismatch
 	}
-	delete(m.Users, id)
+	user.Version++
+	now := time.Now()
+	user.DeletedAt = &now
+	m.record(ctx, database.EventDeleted, user)
 	return nil
 }
 
-func (m *MockClient) ListUsers(ctx context.Context, limit, offset int) ([]*database.UserRow, error) {
+func (m *MockClient) UndeleteUser(ctx context.Context, id string) (*database.UserRow, error) {
+	user, exists := m.Users[id]
+	if !exists || user.DeletedAt == nil {
+		return nil, sql.ErrNoRows
+	}
+	user.DeletedAt = nil
+	user.Version++
+	m.record(ctx, database.EventUpdated, user)
+	return user, nil
+}
+
+func (m *MockClient) PurgeDeletedUsers(ctx context.Context, retention time.Duration) (int64, error) {
+	var purged int64
+	for id, user := range m.Users {
+		if user.DeletedAt != nil && time.Since(*user.DeletedAt) > retention {
+			delete(m.Users, id)
+			m.recordPurge(ctx, user)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (m *MockClient) ExportUsers(ctx context.Context, filter *database.UserFilter, fn func(*database.UserRow) error) error {
+	if m.givenListError != nil {
+		return m.givenListError
+	}
+
+	// filterUsers returns the users ordered by id
+	for _, user := range m.filterUsers(filter) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchCreateUsers
+// Mirrors the SQL client: atomic batches are rolled back on the first failing item.
+func (m *MockClient) BatchCreateUsers(ctx context.Context, users []*database.NewUser, atomic bool) ([]*database.BatchResult, error) {
+	return m.runBatch(len(users), atomic, func(i int) (*database.UserRow, error) {
+		// Only batches enforce the unique emails, tests reuse them with CreateUser
+		if _, exists := m.RequestIDs[users[i].RequestID]; exists {
+			return m.CreateUserOnce(ctx, users[i])
+		}
+		if m.emailTaken(users[i].Email, "") {
+			return nil, errEmailTaken
+		}
+		if users[i].RequestID != "" {
+			return m.CreateUserOnce(ctx, users[i])
+		}
+		return m.CreateUser(ctx, users[i])
+	})
+}
+
+func (m *MockClient) BatchGetUsers(ctx context.Context, ids []string, showDeleted bool) ([]*database.UserRow, error) {
+	var users []*database.UserRow
+	for _, id := range ids {
+		if user, err := m.GetUser(ctx, id, showDeleted); err == nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (m *MockClient) BatchDeleteUsers(ctx context.Context, deletes []*database.UserDelete, atomic bool) ([]*database.BatchResult, error) {
+	return m.runBatch(len(deletes), atomic, func(i int) (*database.UserRow, error) {
+		user, exists := m.Users[deletes[i].ID]
+		if !exists || user.DeletedAt != nil {
+			return nil, sql.ErrNoRows
+		}
+		return nil, m.DeleteUser(ctx, deletes[i].ID, deletes[i].ExpectedVersion)
+	})
+}
+
+// emailTaken
+// Reports whether a live user, other than the one with exceptID, has email.
+func (m *MockClient) emailTaken(email string, exceptID string) bool {
+	for id, user := range m.Users {
+		if id != exceptID && user.DeletedAt == nil && user.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchUsers
+// Replays the recorded events after afterSeq, then waits for ctx to be done.
+// Unlike the SQL client, events recorded during the watch are not sent.
+func (m *MockClient) WatchUsers(ctx context.Context, afterSeq int64, fn func(*database.UserEvent) error) error {
+	if afterSeq == 0 && len(m.Events) > 0 {
+		afterSeq = m.Events[len(m.Events)-1].Sequence
+	}
+	if afterSeq > 0 && len(m.Events) > 0 && m.Events[0].Sequence > afterSeq+1 {
+		return database.ErrEventsPurged
+	}
+	for _, event := range m.Events {
+		if event.Sequence <= afterSeq {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (m *MockClient) PurgeUserEvents(ctx context.Context, retention time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockClient) PurgeIdempotencyKeys(ctx context.Context, window time.Duration) (int64, error) {
+	return 0, nil
+}
+
+// record
+// Records an event and a history entry with a copy of user, sequences and entry ids start at 1.
+func (m *MockClient) record(ctx context.Context, eventType string, user *database.UserRow) {
+	row := *user
+	var sequence int64 = 1
+	if len(m.Events) > 0 {
+		sequence = m.Events[len(m.Events)-1].Sequence + 1
+	}
+	m.Events = append(m.Events, &database.UserEvent{Sequence: sequence, Type: eventType, User: &row})
+
+	entry := &database.HistoryEntry{
+		ID:        int64(len(m.History) + 1),
+		UserID:    user.ID,
+		Type:      eventType,
+		After:     &row,
+		Actor:     database.Actor(ctx),
+		ChangedAt: time.Now(),
+	}
+	for i := len(m.History) - 1; i >= 0; i-- {
+		if m.History[i].UserID == user.ID {
+			entry.Before = m.History[i].After
+			break
+		}
+	}
+	m.History = append(m.History, entry)
+}
+
+// recordPurge
+// Records the history entry of a purged user, like the record_user_history trigger.
+func (m *MockClient) recordPurge(ctx context.Context, user *database.UserRow) {
+	row := *user
+	m.History = append(m.History, &database.HistoryEntry{
+		ID:        int64(len(m.History) + 1),
+		UserID:    user.ID,
+		Type:      database.EventPurged,
+		Before:    &row,
+		Actor:     database.Actor(ctx),
+		ChangedAt: time.Now(),
+	})
+}
+
+// ListUserHistory
+// Mirrors the SQL client, most recent entries first.
+func (m *MockClient) ListUserHistory(ctx context.Context, opts *database.HistoryOptions) ([]*database.HistoryEntry, error) {
+	var entries []*database.HistoryEntry
+	for i := len(m.History) - 1; i >= 0 && len(entries) < opts.Limit; i-- {
+		entry := m.History[i]
+		if entry.UserID == opts.UserID && (opts.BeforeID == 0 || entry.ID < opts.BeforeID) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// GetUserAsOf
+// Mirrors the SQL client, from the last entry recorded until asOf.
+func (m *MockClient) GetUserAsOf(ctx context.Context, id string, asOf time.Time, showDeleted bool) (*database.UserRow, error) {
+	for i := len(m.History) - 1; i >= 0; i-- {
+		entry := m.History[i]
+		if entry.UserID != id || entry.ChangedAt.After(asOf) {
+			continue
+		}
+		if entry.After == nil || (entry.After.DeletedAt != nil && !showDeleted) {
+			return nil, sql.ErrNoRows
+		}
+		return entry.After, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// ImportUsers
+// Mirrors the SQL client, dry runs restore the users once all the items ran.
+func (m *MockClient) ImportUsers(ctx context.Context, users []*database.NewUser, upsert, dryRun bool) ([]*database.BatchResult, error) {
+	if m.givenCreateError != nil {
+		return nil, m.givenCreateError
+	}
+
+	restore := m.snapshot()
+	results := make([]*database.BatchResult, len(users))
+	for i, newUser := range users {
+		results[i] = &database.BatchResult{}
+		for _, user := range m.Users {
+			if user.DeletedAt != nil || user.Email != newUser.Email {
+				continue
+			}
+			if !upsert {
+				results[i].Err = database.ErrDuplicateEmail
+				break
+			}
+			user.Name = newUser.Name
+			user.Age = newUser.Age
+			user.Labels = copyLabels(newUser.Labels)
+			user.Version++
+			m.record(ctx, database.EventUpdated, user)
+			results[i].User = user
+			results[i].Updated = true
+			break
+		}
+		if results[i].Err == nil && results[i].User == nil {
+			results[i].User, _ = m.CreateUser(ctx, newUser)
+		}
+	}
+	if dryRun {
+		restore()
+	}
+	return results, nil
+}
+
+// snapshot
+// Copies the users and request ids, the returned func restores them.
+func (m *MockClient) snapshot() func() {
+	users := make(map[string]database.UserRow, len(m.Users))
+	for id, user := range m.Users {
+		users[id] = *user
+	}
+	events := m.Events
+	history := m.History
+	requestIDs := make(map[string]RequestIDRecord, len(m.RequestIDs))
+	for requestID, record := range m.RequestIDs {
+		requestIDs[requestID] = record
+	}
+	return func() {
+		m.Users = make(map[string]*database.UserRow, len(users))
+		for id, user := range users {
+			user := user
+			m.Users[id] = &user
+		}
+		m.Events = events
+		m.History = history
+		m.RequestIDs = requestIDs
+	}
+}
+
+// runBatch
+// Restores a copy of the users when an atomic batch fails.
+func (m *MockClient) runBatch(n int, atomic bool, write func(i int) (*database.UserRow, error)) ([]*database.BatchResult, error) {
+	restore := m.snapshot()
+	results := make([]*database.BatchResult, n)
+	for i := 0; i < n; i++ {
+		user, err := write(i)
+		results[i] = &database.BatchResult{User: user, Err: err}
+		if err == nil || !atomic {
+			continue
+		}
+
+		restore()
+		for j := range results {
+			if j != i {
+				results[j] = &database.BatchResult{Err: database.ErrBatchAborted}
+			}
+		}
+		break
+	}
+	return results, nil
+}
+
+func (m *MockClient) ListUsers(ctx context.Context, opts *database.ListOptions) ([]*database.UserRow, error) {
 	if m.givenListError != nil {
 		return nil, m.givenListError
 	}
 
+	column := opts.OrderBy
+	if column == "" {
+		column = database.OrderByID
+	}
+	// after compares a against b (or the cursor), in the requested order.
+	after := func(a *database.UserRow, bValue string, bID string) bool {
+		c := compareSortValues(column, a.SortValue(column), bValue)
+		if c == 0 {
+			c = strings.Compare(a.ID, bID)
+		}
+		if opts.Desc {
+			return c < 0
+		}
+		return c > 0
+	}
+
+	matching := m.filterUsers(&opts.Filter)
+	sort.Slice(matching, func(i, j int) bool {
+		return after(matching[j], matching[i].SortValue(column), matching[i].ID)
+	})
+
 	var users []*database.UserRow
 	count := 0
-	for i := 1; i < m.NextID; i++ {
-		if user, exists := m.Users[i]; exists {
-			if count >= offset && len(users) < limit {
-				users = append(users, user)
-			}
-			count++
+	for _, user := range matching {
+		if opts.AfterID != "" && !after(user, opts.AfterValue, opts.AfterID) {
+			continue
 		}
+		if count >= opts.Offset && len(users) < opts.Limit {
+			users = append(users, user)
+		}
+		count++
 	}
 	return users, nil
 }
 
-func (m *MockClient) CountUsers(ctx context.Context) (int, error) {
+func (m *MockClient) CountUsers(ctx context.Context, filter *database.UserFilter) (int, error) {
 	if m.givenCountError != nil {
 		return 0, m.givenCountError
 	}
-	return len(m.Users), nil
+	return len(m.filterUsers(filter)), nil
+}
+
+// ListUsersPage
+// Mirrors the SQL client with ListUsers and CountUsers, estimating the total as Estimate.
+func (m *MockClient) ListUsersPage(ctx context.Context, opts *database.ListOptions) (*database.UserPage, error) {
+	users, err := m.ListUsers(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	page := &database.UserPage{Users: users, TotalMode: opts.TotalMode}
+	switch {
+	case opts.TotalMode == database.TotalNone:
+	case opts.TotalMode == database.TotalEstimate && m.Estimate > 0:
+		page.Total = m.Estimate
+	default:
+		page.TotalMode = database.TotalExact
+		if page.Total, err = m.CountUsers(ctx, &opts.Filter); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// SearchUsers
+// A substring fallback of the SQL search: users match when their name or email contains the query,
+// case-insensitively, scored by the share of the field the query covers.
+func (m *MockClient) SearchUsers(ctx context.Context, opts *database.SearchOptions) ([]*database.SearchResult, error) {
+	if m.givenListError != nil {
+		return nil, m.givenListError
+	}
+
+	query := strings.ToLower(opts.Query)
+	var results []*database.SearchResult
+	for _, user := range m.filterUsers(&database.UserFilter{ShowDeleted: opts.ShowDeleted}) {
+		var score float64
+		for _, field := range []string{user.Name, user.Email} {
+			if strings.Contains(strings.ToLower(field), query) {
+				score = max(score, float64(len(query))/float64(len(field)))
+			}
+		}
+		if score == 0 {
+			continue
+		}
+		if opts.AfterID != "" && (score > opts.AfterScore || (score == opts.AfterScore && user.ID <= opts.AfterID)) {
+			continue
+		}
+		results = append(results, &database.SearchResult{User: user, Score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > opts.Limit {
+		results = results[:opts.Limit]
+	}
+	return results, nil
+}
+
+// filterUsers returns the users matching the filter, in id order
+func (m *MockClient) filterUsers(f *database.UserFilter) []*database.UserRow {
+	ids := make([]string, 0, len(m.Users))
+	for id := range m.Users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var users []*database.UserRow
+	for _, id := range ids {
+		user := m.Users[id]
+		if user.DeletedAt != nil && !f.ShowDeleted {
+			continue
+		}
+		if f.NamePrefix != "" && !strings.HasPrefix(strings.ToLower(user.Name), strings.ToLower(f.NamePrefix)) {
+			continue
+		}
+		if f.EmailDomain != "" && !strings.HasSuffix(strings.ToLower(user.Email), "@"+strings.ToLower(f.EmailDomain)) {
+			continue
+		}
+		if (f.MinAge > 0 && user.Age < f.MinAge) || (f.MaxAge > 0 && user.Age > f.MaxAge) {
+			continue
+		}
+		if !inWindow(user.CreatedAt, f.CreatedAfter, f.CreatedBefore) || !inWindow(user.UpdatedAt, f.UpdatedAfter, f.UpdatedBefore) {
+			continue
+		}
+		if !matchesLabels(user.Labels, f.Labels) {
+			continue
+		}
+		users = append(users, user)
+	}
+	return users
+}
+
+// matchesLabels reports whether labels meet all the requirements
+func matchesLabels(labels database.Labels, requirements []*database.LabelRequirement) bool {
+	for _, requirement := range requirements {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// copyLabels copies labels, so the stored users don't share maps with the callers
+func copyLabels(labels database.Labels) database.Labels {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := database.Labels{}
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
+
+// inWindow reports whether t is in [after, before), zero bounds are open
+func inWindow(t, after, before time.Time) bool {
+	return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+}
+
+// compareSortValues compares two UserRow.SortValue results of a column
+func compareSortValues(column, a, b string) int {
+	switch column {
+	case database.OrderByAge:
+		x, _ := strconv.Atoi(a)
+		y, _ := strconv.Atoi(b)
+		return x - y
+	case database.OrderByCreatedAt, database.OrderByUpdatedAt:
+		x, _ := time.Parse(time.RFC3339Nano, a)
+		y, _ := time.Parse(time.RFC3339Nano, b)
+		return x.Compare(y)
+	default:
+		return strings.Compare(a, b)
+	}
 }
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"time"
+
+	"grpc-services/user/database"
+
+	"github.com/google/uuid"
+)
+
+// MockWebhookStore
+// Keeps the webhooks and their attempts in memory, mirroring the SQL client.
+type MockWebhookStore struct {
+	givenError error
+
+	Webhooks map[string]*database.WebhookRow
+	// Attempts are recorded by the tests, in order
+	Attempts []*database.WebhookAttempt
+}
+
+func NewMockWebhookStore(givenError error) *MockWebhookStore {
+	return &MockWebhookStore{
+		givenError: givenError,
+		Webhooks:   make(map[string]*database.WebhookRow),
+	}
+}
+
+func (m *MockWebhookStore) CreateWebhook(ctx context.Context, newWebhook *database.NewWebhook) (*database.WebhookRow, error) {
+	if m.givenError != nil {
+		return nil, m.givenError
+	}
+
+	now := time.Now()
+	webhook := &database.WebhookRow{
+		ID:         uuid.Must(uuid.NewV7()).String(),
+		URL:        newWebhook.URL,
+		EventTypes: append([]string{}, newWebhook.EventTypes...),
+		Secret:     newWebhook.Secret,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	m.Webhooks[webhook.ID] = webhook
+	return webhook, nil
+}
+
+func (m *MockWebhookStore) GetWebhook(ctx context.Context, id string) (*database.WebhookRow, error) {
+	if m.givenError != nil {
+		return nil, m.givenError
+	}
+	webhook, ok := m.Webhooks[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return webhook, nil
+}
+
+func (m *MockWebhookStore) ListWebhooks(ctx context.Context) ([]*database.WebhookRow, error) {
+	if m.givenError != nil {
+		return nil, m.givenError
+	}
+	var webhooks []*database.WebhookRow
+	for _, webhook := range m.Webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].ID < webhooks[j].ID })
+	return webhooks, nil
+}
+
+func (m *MockWebhookStore) EnableWebhook(ctx context.Context, id string) (*database.WebhookRow, error) {
+	webhook, err := m.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if webhook.DisabledAt != nil {
+		webhook.DisabledAt = nil
+		webhook.UpdatedAt = time.Now()
+	}
+	webhook.ConsecutiveFailures = 0
+	return webhook, nil
+}
+
+func (m *MockWebhookStore) DeleteWebhook(ctx context.Context, id string) error {
+	if _, err := m.GetWebhook(ctx, id); err != nil {
+		return err
+	}
+	delete(m.Webhooks, id)
+	return nil
+}
+
+// ListWebhookAttempts
+// Attempts are numbered by their position in Attempts, starting at 1.
+func (m *MockWebhookStore) ListWebhookAttempts(ctx context.Context, opts *database.WebhookAttemptOptions) ([]*database.WebhookAttempt, error) {
+	if m.givenError != nil {
+		return nil, m.givenError
+	}
+	var attempts []*database.WebhookAttempt
+	for i := len(m.Attempts) - 1; i >= 0 && len(attempts) < opts.Limit; i-- {
+		attempt := m.Attempts[i]
+		attempt.ID = int64(i + 1)
+		if attempt.WebhookID == opts.WebhookID && (opts.BeforeID == 0 || attempt.ID < opts.BeforeID) {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts, nil
+}
+
+// PurgeWebhookDeliveries
+// The mock keeps no deliveries, nothing is purged.
+func (m *MockWebhookStore) PurgeWebhookDeliveries(ctx context.Context, retention time.Duration) (int64, error) {
+	return 0, m.givenError
+}
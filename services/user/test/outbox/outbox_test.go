@@ -0,0 +1,163 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-services/user/database"
+	"grpc-services/user/outbox"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEvent(id int64, userID string) *database.OutboxEvent {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &database.OutboxEvent{
+		ID:        id,
+		Type:      database.EventCreated,
+		CreatedAt: created,
+		User: &database.UserRow{
+			ID:        userID,
+			Name:      "Alice",
+			Email:     "alice@example.com",
+			Age:       30,
+			CreatedAt: created,
+			UpdatedAt: created,
+			Version:   1,
+			Labels:    database.Labels{"team": "a"},
+		},
+	}
+}
+
+func TestChannelPublisher(t *testing.T) {
+	publisher := outbox.NewChannelPublisher(1)
+	event := newEvent(1, "u1")
+	assert.NoError(t, publisher.Publish(context.Background(), event))
+	assert.Same(t, event, <-publisher.C)
+
+	// Without a consumer, publishing waits for ctx
+	assert.NoError(t, publisher.Publish(context.Background(), event))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, publisher.Publish(ctx, event), context.Canceled)
+}
+
+func TestNDJSONPublisher(t *testing.T) {
+	var buf bytes.Buffer
+	publisher := outbox.NewNDJSONPublisher(&buf)
+	require.NoError(t, publisher.Publish(context.Background(), newEvent(1, "u1")))
+	require.NoError(t, publisher.Publish(context.Background(), newEvent(2, "u2")))
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{
+		"id": 1,
+		"type": "CREATED",
+		"created_at": "2026-01-02T03:04:05Z",
+		"user": {
+			"id": "u1",
+			"name": "Alice",
+			"email": "alice@example.com",
+			"age": 30,
+			"created_at": "2026-01-02T03:04:05Z",
+			"updated_at": "2026-01-02T03:04:05Z",
+			"version": 1,
+			"labels": {"team": "a"}
+		}
+	}`, lines[0])
+	var second outbox.NDJSONEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &second))
+	assert.Equal(t, "u2", second.User.ID)
+}
+
+func TestOpenNDJSONFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.ndjson")
+
+	// Reopening appends
+	for id := int64(1); id <= 2; id++ {
+		publisher, err := outbox.OpenNDJSONFile(path)
+		require.NoError(t, err)
+		require.NoError(t, publisher.Publish(context.Background(), newEvent(id, "u1")))
+		require.NoError(t, publisher.Close())
+	}
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+}
+
+// fakeStore
+// Hands out its events once, until publish succeeds for them.
+type fakeStore struct {
+	mu     sync.Mutex
+	events []*database.OutboxEvent
+	passes int
+}
+
+func (s *fakeStore) RelayOutbox(ctx context.Context, limit int, publish func(*database.OutboxEvent) error) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.passes++
+	var left []*database.OutboxEvent
+	published := 0
+	for _, event := range s.events {
+		if published < limit && publish(event) == nil {
+			published++
+			continue
+		}
+		event.Attempts++
+		left = append(left, event)
+	}
+	s.events = left
+	return published, nil
+}
+
+// flakyPublisher
+// Fails the first publish of every event, then passes them to next.
+type flakyPublisher struct {
+	failed map[int64]bool
+	next   outbox.EventPublisher
+}
+
+func (p *flakyPublisher) Publish(ctx context.Context, event *database.OutboxEvent) error {
+	if !p.failed[event.ID] {
+		p.failed[event.ID] = true
+		return errors.New("unavailable")
+	}
+	return p.next.Publish(ctx, event)
+}
+
+func TestRelay_Run(t *testing.T) {
+	store := &fakeStore{events: []*database.OutboxEvent{newEvent(1, "u1"), newEvent(2, "u2")}}
+	channel := outbox.NewChannelPublisher(2)
+	relay := outbox.NewRelay(store, &flakyPublisher{failed: map[int64]bool{}, next: channel}, time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- relay.Run(ctx) }()
+
+	// Failed events are published on a later pass
+	for _, want := range []int64{1, 2} {
+		select {
+		case event := <-channel.C:
+			assert.Equal(t, want, event.ID)
+			assert.Equal(t, 1, event.Attempts)
+		case <-ctx.Done():
+			t.Fatal("missing event")
+		}
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.GreaterOrEqual(t, store.passes, 2)
+}
@@ -2,16 +2,25 @@ package server
 
 import (
 	"context"
+	"database/sql/driver"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"grpc-services/user/database"
 	pb "grpc-services/user/proto"
 	"grpc-services/user/server"
 	dbMock "grpc-services/user/test/database"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestServer_CreateUser_Handler(t *testing.T) {
@@ -33,6 +42,34 @@ func TestServer_CreateUser_Handler(t *testing.T) {
 			wantErrorCode: codes.Internal,
 			wantErrorMsg:  "failed to create user",
 		},
+		{
+			name:          "db error - duplicate email",
+			givenReq:      fixtureCreateRequest(),
+			givenDBError:  &pq.Error{Code: "23505", Constraint: "users_email_live_idx"},
+			wantErrorCode: codes.AlreadyExists,
+			wantErrorMsg:  "a user with this email already exists",
+		},
+		{
+			name:          "db error - duplicate idempotency key",
+			givenReq:      fixtureCreateRequest(),
+			givenDBError:  &pq.Error{Code: "23505", Constraint: "idempotency_keys_pkey", Table: "idempotency_keys"},
+			wantErrorCode: codes.AlreadyExists,
+			wantErrorMsg:  "failed to create user: the idempotency key already exists",
+		},
+		{
+			name:          "db error - timeout",
+			givenReq:      fixtureCreateRequest(),
+			givenDBError:  fmt.Errorf("query failed: %w", context.DeadlineExceeded),
+			wantErrorCode: codes.DeadlineExceeded,
+			wantErrorMsg:  "deadline exceeded",
+		},
+		{
+			name:          "db error - connection lost",
+			givenReq:      fixtureCreateRequest(),
+			givenDBError:  driver.ErrBadConn,
+			wantErrorCode: codes.Unavailable,
+			wantErrorMsg:  "database unavailable",
+		},
 		{
 			name: "validation error - empty name",
 			givenReq: fixtureCreateRequest(
@@ -60,6 +97,33 @@ func TestServer_CreateUser_Handler(t *testing.T) {
 			wantErrorCode: codes.InvalidArgument,
 			wantErrorMsg:  "invalid email format",
 		},
+		{
+			name: "validation error - email with display name",
+			givenReq: fixtureCreateRequest(
+				func(req *pb.CreateUserRequest) {
+					req.Email = "Valid User <valid@example.com>"
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid email format",
+		},
+		{
+			name: "validation error - name too long",
+			givenReq: fixtureCreateRequest(
+				func(req *pb.CreateUserRequest) {
+					req.Name = strings.Repeat("a", 101)
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "name cannot be longer than 100 characters",
+		},
+		{
+			name: "validation error - age too high",
+			givenReq: fixtureCreateRequest(
+				func(req *pb.CreateUserRequest) {
+					req.Age = 151
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "age must be at most 150",
+		},
 		{
 			name: "validation error - zero age",
 			givenReq: fixtureCreateRequest(
@@ -111,26 +175,59 @@ func TestServer_CreateUser_Handler(t *testing.T) {
 	}
 }
 
+func TestServer_CreateUser_Handler_FieldViolations(t *testing.T) {
+	srv := &server.Server{DB: dbMock.NewMockClient(nil, nil, nil)}
+
+	_, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:  "",
+		Email: "invalid-email",
+		Age:   -1,
+	})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "name cannot be empty; invalid email format; age must be positive", st.Message())
+	if assert.Len(t, st.Details(), 1) {
+		badRequest := st.Details()[0].(*errdetails.BadRequest)
+		var fields []string
+		for _, violation := range badRequest.FieldViolations {
+			fields = append(fields, violation.Field)
+		}
+		assert.Equal(t, []string{"name", "email", "age"}, fields)
+	}
+
+	// Batches prefix the fields with the item
+	_, err = srv.BatchCreateUsers(context.Background(), &pb.BatchCreateUsersRequest{
+		Requests: []*pb.CreateUserRequest{fixtureCreateRequest(), {Name: "Valid User", Email: "invalid-email", Age: 30}},
+	})
+	st = status.Convert(err)
+	assert.Equal(t, "requests[1]: invalid email format", st.Message())
+	if assert.Len(t, st.Details(), 1) {
+		assert.Equal(t, "requests[1].email", st.Details()[0].(*errdetails.BadRequest).FieldViolations[0].Field)
+	}
+}
+
 func TestServer_GetUser_Handler(t *testing.T) {
 	tests := []struct {
 		name          string
 		givenReq      *pb.GetUserRequest
-		setupMock     func(ctx context.Context, m *dbMock.MockClient)
+		setupMock     func(ctx context.Context, m *dbMock.MockClient) string
 		wantErrorCode codes.Code
 		wantErrorMsg  string
 	}{
 		{
 			name:     "works - successful retrieval",
 			givenReq: fixtureGetRequest("1"),
-			setupMock: func(ctx context.Context, m *dbMock.MockClient) {
-				m.CreateUser(ctx, "Test User", "test@example.com", 30)
+			setupMock: func(ctx context.Context, m *dbMock.MockClient) string {
+				user, _ := m.CreateUser(ctx, &database.NewUser{Name: "Test User", Email: "test@example.com", Age: 30})
+				return user.ToProto().Id
 			},
 		},
 		{
 			name:          "validation error - empty id",
 			givenReq:      fixtureGetRequest(""),
 			wantErrorCode: codes.InvalidArgument,
-			wantErrorMsg:  "user ID cannot be empty",
+			wantErrorMsg:  "id cannot be empty",
 		},
 		{
 			name:          "db error - user not found",
@@ -153,8 +250,12 @@ func TestServer_GetUser_Handler(t *testing.T) {
 			mockDB := dbMock.NewMockClient(
 				nil,
 				nil, nil)
+			var userID string
 			if tt.setupMock != nil {
-				tt.setupMock(ctx, mockDB)
+				userID = tt.setupMock(ctx, mockDB)
+				if tt.givenReq.Id == "1" {
+					tt.givenReq.Id = userID
+				}
 			}
 			srv := &server.Server{DB: mockDB}
 
@@ -188,7 +289,7 @@ func TestServer_UpdateUser_Handler(t *testing.T) {
 			name:     "works - successful update",
 			givenReq: fixtureUpdateRequest("1"),
 			setupMock: func(ctx context.Context, m *dbMock.MockClient) string {
-				user, _ := m.CreateUser(ctx, "Original", "original@example.com", 25)
+				user, _ := m.CreateUser(ctx, &database.NewUser{Name: "Original", Email: "original@example.com", Age: 25})
 				return user.ToProto().Id
 			},
 		},
@@ -196,7 +297,7 @@ func TestServer_UpdateUser_Handler(t *testing.T) {
 			name:          "validation error - empty id",
 			givenReq:      fixtureUpdateRequest(""),
 			wantErrorCode: codes.InvalidArgument,
-			wantErrorMsg:  "user ID cannot be empty",
+			wantErrorMsg:  "id cannot be empty",
 		},
 		{
 			name:          "validation error - invalid id",
@@ -240,6 +341,25 @@ func TestServer_UpdateUser_Handler(t *testing.T) {
 			wantErrorCode: codes.InvalidArgument,
 			wantErrorMsg:  "age must be positive",
 		},
+		{
+			name: "validation error - invalid update mask path",
+			givenReq: fixtureUpdateRequest("1",
+				func(req *pb.UpdateUserRequest) {
+					req.UpdateMask = &fieldmaskpb.FieldMask{Paths: []string{"id"}}
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid update_mask path",
+		},
+		{
+			name: "validation error - masked field invalid",
+			givenReq: fixtureUpdateRequest("1",
+				func(req *pb.UpdateUserRequest) {
+					req.Email = "invalid-email"
+					req.UpdateMask = &fieldmaskpb.FieldMask{Paths: []string{"email"}}
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid email format",
+		},
 		{
 			name:          "db error - user not found",
 			givenReq:      fixtureUpdateRequest("999"),
@@ -296,7 +416,7 @@ func TestServer_DeleteUser_Handler(t *testing.T) {
 			name:     "works - successful deletion",
 			givenReq: fixtureDeleteRequest("1"),
 			setupMock: func(ctx context.Context, m *dbMock.MockClient) string {
-				user, _ := m.CreateUser(ctx, "To Delete", "delete@example.com", 25)
+				user, _ := m.CreateUser(ctx, &database.NewUser{Name: "To Delete", Email: "delete@example.com", Age: 25})
 				return user.ToProto().Id
 			},
 		},
@@ -304,7 +424,16 @@ func TestServer_DeleteUser_Handler(t *testing.T) {
 			name:          "validation error - empty id",
 			givenReq:      fixtureDeleteRequest(""),
 			wantErrorCode: codes.InvalidArgument,
-			wantErrorMsg:  "user ID cannot be empty",
+			wantErrorMsg:  "id cannot be empty",
+		},
+		{
+			name: "validation error - invalid etag",
+			givenReq: &pb.DeleteUserRequest{
+				Id:   "1",
+				Etag: "not-a-version",
+			},
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid etag",
 		},
 		{
 			name:          "db error - user not found",
@@ -354,35 +483,115 @@ func TestServer_DeleteUser_Handler(t *testing.T) {
 	}
 }
 
+func TestServer_BatchCreateUsers_Handler(t *testing.T) {
+	tests := []struct {
+		name          string
+		givenReq      *pb.BatchCreateUsersRequest
+		wantErrorCode codes.Code
+		wantErrorMsg  string
+	}{
+		{
+			name: "works - all-or-nothing",
+			givenReq: &pb.BatchCreateUsersRequest{
+				Requests: []*pb.CreateUserRequest{fixtureCreateRequest()},
+			},
+		},
+		{
+			name: "works - best-effort keeps invalid items",
+			givenReq: &pb.BatchCreateUsersRequest{
+				Requests: []*pb.CreateUserRequest{fixtureCreateRequest(func(r *pb.CreateUserRequest) { r.Name = "" })},
+				Mode:     pb.BatchMode_BATCH_MODE_BEST_EFFORT,
+			},
+		},
+		{
+			name:          "validation error - empty batch",
+			givenReq:      &pb.BatchCreateUsersRequest{},
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "batch cannot be empty",
+		},
+		{
+			name: "validation error - too many items",
+			givenReq: &pb.BatchCreateUsersRequest{
+				Requests: make([]*pb.CreateUserRequest, 1001),
+			},
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "batch cannot have more than 1000 items",
+		},
+		{
+			name: "validation error - unknown mode",
+			givenReq: &pb.BatchCreateUsersRequest{
+				Requests: []*pb.CreateUserRequest{fixtureCreateRequest()},
+				Mode:     pb.BatchMode(42),
+			},
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid batch mode",
+		},
+		{
+			name: "validation error - invalid item",
+			givenReq: &pb.BatchCreateUsersRequest{
+				Requests: []*pb.CreateUserRequest{
+					fixtureCreateRequest(),
+					fixtureCreateRequest(func(r *pb.CreateUserRequest) { r.Email = "invalid-email" }),
+				},
+			},
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "requests[1]: invalid email format",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &server.Server{DB: dbMock.NewMockClient(nil, nil, nil)}
+
+			resp, err := srv.BatchCreateUsers(context.Background(), tt.givenReq)
+
+			if tt.wantErrorMsg != "" {
+				assert.Error(t, err)
+				assert.Nil(t, resp)
+				grpcStatus, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.wantErrorCode, grpcStatus.Code())
+				assert.Contains(t, grpcStatus.Message(), tt.wantErrorMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, resp.Results, len(tt.givenReq.Requests))
+			}
+		})
+	}
+}
+
 func TestServer_ListUsers_Handler(t *testing.T) {
 	tests := []struct {
 		name              string
 		givenReq          *pb.ListUsersRequest
-		setupMock         func(ctx context.Context, m *dbMock.MockClient)
+		setupMock         func(ctx context.Context, m *dbMock.MockClient) []string
 		givenDBErrorList  error
 		givenDBErrorCount error
 		wantErrorCode     codes.Code
 		wantErrorMsg      string
-		wantUsers         []*pb.User
+		wantUsers         func(ids []string) []*pb.User
 	}{
 		{
 			name:     "works - successful list",
 			givenReq: fixtureListRequest(),
-			setupMock: func(ctx context.Context, m *dbMock.MockClient) {
-				m.CreateUser(ctx, "User", "user@example.com", 25)
-				m.CreateUser(ctx, "User", "user@example.com", 30)
+			setupMock: func(ctx context.Context, m *dbMock.MockClient) []string {
+				userA, _ := m.CreateUser(ctx, &database.NewUser{Name: "User", Email: "user@example.com", Age: 25})
+				userB, _ := m.CreateUser(ctx, &database.NewUser{Name: "User", Email: "user@example.com", Age: 30})
+				return []string{userA.ID, userB.ID}
 			},
-			wantUsers: []*pb.User{
-				fixtureUser(
-					"1",
-					func(user *pb.User) {
-						user.Age = 25
-					}),
-				fixtureUser(
-					"2",
-					func(user *pb.User) {
-						user.Age = 30
-					}),
+			wantUsers: func(ids []string) []*pb.User {
+				return []*pb.User{
+					fixtureUser(
+						ids[0],
+						func(user *pb.User) {
+							user.Age = 25
+						}),
+					fixtureUser(
+						ids[1],
+						func(user *pb.User) {
+							user.Age = 30
+						}),
+				}
 			},
 		},
 		{
@@ -392,16 +601,19 @@ func TestServer_ListUsers_Handler(t *testing.T) {
 					req.Page = 1
 					req.Limit = 1
 				}),
-			setupMock: func(ctx context.Context, m *dbMock.MockClient) {
-				m.CreateUser(ctx, "User", "user@example.com", 25)
-				m.CreateUser(ctx, "User 2", "user2@example.com", 30)
+			setupMock: func(ctx context.Context, m *dbMock.MockClient) []string {
+				userA, _ := m.CreateUser(ctx, &database.NewUser{Name: "User", Email: "user@example.com", Age: 25})
+				userB, _ := m.CreateUser(ctx, &database.NewUser{Name: "User 2", Email: "user2@example.com", Age: 30})
+				return []string{userA.ID, userB.ID}
 			},
-			wantUsers: []*pb.User{
-				fixtureUser(
-					"1",
-					func(user *pb.User) {
-						user.Age = 25
-					}),
+			wantUsers: func(ids []string) []*pb.User {
+				return []*pb.User{
+					fixtureUser(
+						ids[0],
+						func(user *pb.User) {
+							user.Age = 25
+						}),
+				}
 			},
 		},
 		{
@@ -411,21 +623,24 @@ func TestServer_ListUsers_Handler(t *testing.T) {
 					req.Page = 1
 					req.Limit = 101
 				}),
-			setupMock: func(ctx context.Context, m *dbMock.MockClient) {
-				m.CreateUser(ctx, "User", "user@example.com", 25)
-				m.CreateUser(ctx, "User", "user@example.com", 30)
+			setupMock: func(ctx context.Context, m *dbMock.MockClient) []string {
+				userA, _ := m.CreateUser(ctx, &database.NewUser{Name: "User", Email: "user@example.com", Age: 25})
+				userB, _ := m.CreateUser(ctx, &database.NewUser{Name: "User", Email: "user@example.com", Age: 30})
+				return []string{userA.ID, userB.ID}
 			},
-			wantUsers: []*pb.User{
-				fixtureUser(
-					"1",
-					func(user *pb.User) {
-						user.Age = 25
-					}),
-				fixtureUser(
-					"2",
-					func(user *pb.User) {
-						user.Age = 30
-					}),
+			wantUsers: func(ids []string) []*pb.User {
+				return []*pb.User{
+					fixtureUser(
+						ids[0],
+						func(user *pb.User) {
+							user.Age = 25
+						}),
+					fixtureUser(
+						ids[1],
+						func(user *pb.User) {
+							user.Age = 30
+						}),
+				}
 			},
 		},
 		{
@@ -446,6 +661,66 @@ func TestServer_ListUsers_Handler(t *testing.T) {
 			wantErrorCode: codes.InvalidArgument,
 			wantErrorMsg:  "limit cannot be negative",
 		},
+		{
+			name: "validation error - invalid page token",
+			givenReq: fixtureListRequest(
+				func(req *pb.ListUsersRequest) {
+					req.PageToken = "not-a-token"
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid page token",
+		},
+		{
+			name: "validation error - invalid order_by",
+			givenReq: fixtureListRequest(
+				func(req *pb.ListUsersRequest) {
+					req.OrderBy = "password desc"
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid order_by",
+		},
+		{
+			name: "validation error - inverted age range",
+			givenReq: fixtureListRequest(
+				func(req *pb.ListUsersRequest) {
+					req.MinAge = 40
+					req.MaxAge = 30
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "min_age cannot be greater than max_age",
+		},
+		{
+			name: "validation error - every violation at once",
+			givenReq: fixtureListRequest(
+				func(req *pb.ListUsersRequest) {
+					req.Page = -1
+					req.Limit = -1
+					req.MinAge = 40
+					req.MaxAge = 30
+					req.LabelSelector = "team in"
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "page cannot be negative; limit cannot be negative; min_age cannot be greater than max_age; invalid label_selector",
+		},
+		{
+			name: "validation error - empty created window",
+			givenReq: fixtureListRequest(
+				func(req *pb.ListUsersRequest) {
+					req.CreatedAfter = timestamppb.New(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC))
+					req.CreatedBefore = timestamppb.New(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "created_after must be before created_before",
+		},
+		{
+			name: "validation error - invalid total_mode",
+			givenReq: fixtureListRequest(
+				func(req *pb.ListUsersRequest) {
+					req.TotalMode = pb.TotalMode(9)
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid total mode 9",
+		},
 		{
 			name:             "DB error - list",
 			givenReq:         fixtureListRequest(),
@@ -458,7 +733,7 @@ func TestServer_ListUsers_Handler(t *testing.T) {
 			givenReq:          fixtureListRequest(),
 			givenDBErrorCount: errors.New("database error"),
 			wantErrorCode:     codes.Internal,
-			wantErrorMsg:      "failed to count users",
+			wantErrorMsg:      "failed to list users",
 		},
 		{
 			name: "validation error - negative limit",
@@ -484,8 +759,9 @@ func TestServer_ListUsers_Handler(t *testing.T) {
 				nil,
 				tt.givenDBErrorList,
 				tt.givenDBErrorCount)
+			var ids []string
 			if tt.setupMock != nil {
-				tt.setupMock(ctx, mockDB)
+				ids = tt.setupMock(ctx, mockDB)
 			}
 			srv := &server.Server{DB: mockDB}
 
@@ -501,7 +777,11 @@ func TestServer_ListUsers_Handler(t *testing.T) {
 			} else {
 				assert.NoError(t, err)
 				assert.NotNil(t, resp)
-				assert.Equal(t, tt.wantUsers, resp.Users)
+				var wantUsers []*pb.User
+				if tt.wantUsers != nil {
+					wantUsers = tt.wantUsers(ids)
+				}
+				assert.Equal(t, wantUsers, resp.Users)
 			}
 		})
 	}
@@ -535,6 +815,7 @@ func fixtureUser(id string, mods ...func(*pb.User)) *pb.User {
 		Age:       35,
 		CreatedAt: "0001-01-01T00:00:00Z",
 		UpdatedAt: "0001-01-01T00:00:00Z",
+		Etag:      "1",
 	}
 
 	for _, mod := range mods {
@@ -572,3 +853,134 @@ func fixtureListRequest(mods ...func(*pb.ListUsersRequest)) *pb.ListUsersRequest
 	}
 	return val
 }
+
+func TestServer_CreateWebhook_Handler(t *testing.T) {
+	tests := []struct {
+		name          string
+		givenReq      *pb.CreateWebhookRequest
+		givenDBError  error
+		wantErrorCode codes.Code
+		wantErrorMsg  string
+	}{
+		{
+			name:     "works - successful creation",
+			givenReq: fixtureCreateWebhookRequest(),
+		},
+		{
+			name:          "db error - database failure",
+			givenReq:      fixtureCreateWebhookRequest(),
+			givenDBError:  errors.New("database error"),
+			wantErrorCode: codes.Internal,
+			wantErrorMsg:  "failed to create webhook",
+		},
+		{
+			name: "validation error - empty url",
+			givenReq: fixtureCreateWebhookRequest(
+				func(req *pb.CreateWebhookRequest) {
+					req.Url = ""
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "url cannot be empty",
+		},
+		{
+			name: "validation error - relative url",
+			givenReq: fixtureCreateWebhookRequest(
+				func(req *pb.CreateWebhookRequest) {
+					req.Url = "/hooks"
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "url must be an absolute http or https URL",
+		},
+		{
+			name: "validation error - other scheme",
+			givenReq: fixtureCreateWebhookRequest(
+				func(req *pb.CreateWebhookRequest) {
+					req.Url = "ftp://example.com/hooks"
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "url must be an absolute http or https URL",
+		},
+		{
+			name: "validation error - empty secret",
+			givenReq: fixtureCreateWebhookRequest(
+				func(req *pb.CreateWebhookRequest) {
+					req.Secret = ""
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "secret cannot be empty",
+		},
+		{
+			name: "validation error - short secret",
+			givenReq: fixtureCreateWebhookRequest(
+				func(req *pb.CreateWebhookRequest) {
+					req.Secret = "short"
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "secret must be at least 16 characters",
+		},
+		{
+			name: "validation error - unspecified event type",
+			givenReq: fixtureCreateWebhookRequest(
+				func(req *pb.CreateWebhookRequest) {
+					req.EventTypes = []pb.UserEventType{pb.UserEventType_USER_EVENT_TYPE_UNSPECIFIED}
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid event type 0",
+		},
+		{
+			name: "validation error - unknown event type",
+			givenReq: fixtureCreateWebhookRequest(
+				func(req *pb.CreateWebhookRequest) {
+					req.EventTypes = []pb.UserEventType{42}
+				}),
+			wantErrorCode: codes.InvalidArgument,
+			wantErrorMsg:  "invalid event type 42",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &server.Server{Webhooks: dbMock.NewMockWebhookStore(tt.givenDBError)}
+
+			resp, err := srv.CreateWebhook(context.Background(), tt.givenReq)
+
+			if tt.wantErrorMsg != "" {
+				assert.Error(t, err)
+				assert.Nil(t, resp)
+
+				grpcStatus, ok := status.FromError(err)
+				assert.True(t, ok)
+				assert.Equal(t, tt.wantErrorCode, grpcStatus.Code())
+				assert.Contains(t, grpcStatus.Message(), tt.wantErrorMsg)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, resp)
+				assert.Equal(t, tt.givenReq.Url, resp.Webhook.Url)
+			}
+		})
+	}
+}
+
+func TestServer_GetWebhook_Handler_InvalidID(t *testing.T) {
+	srv := &server.Server{Webhooks: dbMock.NewMockWebhookStore(nil)}
+
+	_, err := srv.GetWebhook(context.Background(), &pb.GetWebhookRequest{Id: "not-a-uuid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "id must be a webhook id")
+
+	_, err = srv.ListWebhookAttempts(context.Background(), &pb.ListWebhookAttemptsRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "webhook_id cannot be empty")
+}
+
+func fixtureCreateWebhookRequest(mods ...func(*pb.CreateWebhookRequest)) *pb.CreateWebhookRequest {
+	val := &pb.CreateWebhookRequest{
+		Url:    "https://partner.example.com/hooks",
+		Secret: "0123456789abcdef",
+	}
+
+	for _, mod := range mods {
+		mod(val)
+	}
+	return val
+}
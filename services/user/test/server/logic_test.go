@@ -3,13 +3,27 @@ package server
 import (
 	"context"
 	"fmt"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
+	"grpc-services/user/config"
+	"grpc-services/user/database"
 	pb "grpc-services/user/proto"
 	"grpc-services/user/server"
 	dbMock "grpc-services/user/test/database"
 
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func TestServer_CreateUser(t *testing.T) {
@@ -28,6 +42,60 @@ func TestServer_CreateUser(t *testing.T) {
 	assert.Equal(t, int32(30), resp.User.Age)
 }
 
+func TestServer_CreateUser_RequestID(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	req := &pb.CreateUserRequest{
+		Name:      "Test User",
+		Email:     "test@example.com",
+		Age:       30,
+		RequestId: "create-1",
+	}
+	first, err := srv.CreateUser(context.Background(), req)
+	assert.NoError(t, err)
+
+	// A retry returns the same user, without creating another one
+	retry, err := srv.CreateUser(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, first.User.Id, retry.User.Id)
+	assert.Len(t, mockDB.Users, 1)
+
+	// The same request id with other fields is rejected
+	_, err = srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:      "Other User",
+		Email:     "other@example.com",
+		Age:       30,
+		RequestId: "create-1",
+	})
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			assert.Equal(t, server.ReasonRequestIDReused, info.Reason)
+		}
+	}
+	assert.Len(t, mockDB.Users, 1)
+
+	// Batch items replay the users of their request ids too
+	resp, err := srv.BatchCreateUsers(context.Background(), &pb.BatchCreateUsersRequest{
+		Requests: []*pb.CreateUserRequest{req, {Name: "User B", Email: "b@example.com", Age: 25, RequestId: "create-2"}},
+		Mode:     pb.BatchMode_BATCH_MODE_ALL_OR_NOTHING,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, first.User.Id, resp.Results[0].User.Id)
+	assert.Len(t, mockDB.Users, 2)
+
+	// Once the user is purged, a retry fails instead of creating it again
+	_, err = srv.DeleteUser(context.Background(), &pb.DeleteUserRequest{Id: first.User.Id})
+	assert.NoError(t, err)
+	_, err = mockDB.PurgeDeletedUsers(context.Background(), 0)
+	assert.NoError(t, err)
+	_, err = srv.CreateUser(context.Background(), req)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Len(t, mockDB.Users, 1)
+}
+
 func TestServer_GetUser(t *testing.T) {
 	mockDB := dbMock.NewMockClient(nil, nil, nil)
 	srv := &server.Server{DB: mockDB}
@@ -48,6 +116,172 @@ func TestServer_GetUser(t *testing.T) {
 	assert.Equal(t, "Test User", resp.User.Name)
 }
 
+func TestServer_GetUser_IDs(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	createResp, _ := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:  "Test User",
+		Email: "test@example.com",
+		Age:   30,
+	})
+	id := createResp.User.Id
+
+	// Ids are UUIDs, also accepted as "users/{id}" resource names, in any case
+	for _, name := range []string{id, "users/" + id, strings.ToUpper(id)} {
+		resp, err := srv.GetUser(context.Background(), &pb.GetUserRequest{Id: name})
+		if assert.NoError(t, err, name) {
+			assert.Equal(t, id, resp.User.Id)
+		}
+	}
+
+	// Legacy numeric ids are looked up
+	mockDB.LegacyIDs[7] = id
+	resp, err := srv.GetUser(context.Background(), &pb.GetUserRequest{Id: "users/7"})
+	assert.NoError(t, err)
+	assert.Equal(t, id, resp.User.Id)
+
+	batch, err := srv.BatchGetUsers(context.Background(), &pb.BatchGetUsersRequest{
+		Ids:  []string{"7", "8", "users/"},
+		Mode: pb.BatchMode_BATCH_MODE_BEST_EFFORT,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, id, batch.Results[0].User.Id)
+	assert.Equal(t, int32(codes.NotFound), batch.Results[1].Error.Code)
+	assert.Equal(t, int32(codes.InvalidArgument), batch.Results[2].Error.Code)
+
+	// Until they are rejected
+	srv.Config = &config.Config{RejectLegacyIDs: true}
+	_, err = srv.GetUser(context.Background(), &pb.GetUserRequest{Id: "7"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_UserHistory(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+	ctx := context.Background()
+
+	createResp, err := srv.CreateUser(ctx, &pb.CreateUserRequest{
+		Name:  "Original",
+		Email: "original@example.com",
+		Age:   25,
+	})
+	assert.NoError(t, err)
+	beforeUpdate := time.Now()
+
+	// The actor is read from the request metadata by the interceptor
+	incoming := metadata.NewIncomingContext(ctx, metadata.Pairs(server.ActorMetadataKey, "support/jane"))
+	_, err = server.ActorUnaryInterceptor(incoming, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ any) (any, error) {
+		return srv.UpdateUser(ctx, &pb.UpdateUserRequest{
+			Id:         createResp.User.Id,
+			Email:      "updated@example.com",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"email"}},
+		})
+	})
+	assert.NoError(t, err)
+	_, err = srv.DeleteUser(ctx, &pb.DeleteUserRequest{Id: createResp.User.Id})
+	assert.NoError(t, err)
+
+	// Most recent first, paginated
+	resp, err := srv.ListUserHistory(ctx, &pb.ListUserHistoryRequest{UserId: createResp.User.Id, Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Entries, 2)
+	assert.Equal(t, pb.UserEventType_USER_EVENT_TYPE_DELETED, resp.Entries[0].Type)
+	assert.Equal(t, pb.UserEventType_USER_EVENT_TYPE_UPDATED, resp.Entries[1].Type)
+	assert.Equal(t, "original@example.com", resp.Entries[1].Before.Email)
+	assert.Equal(t, "updated@example.com", resp.Entries[1].After.Email)
+	assert.Equal(t, "support/jane", resp.Entries[1].Actor)
+
+	resp, err = srv.ListUserHistory(ctx, &pb.ListUserHistoryRequest{
+		UserId:    createResp.User.Id,
+		Limit:     2,
+		PageToken: resp.NextPageToken,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Entries, 1)
+	assert.Equal(t, pb.UserEventType_USER_EVENT_TYPE_CREATED, resp.Entries[0].Type)
+	assert.Nil(t, resp.Entries[0].Before)
+	assert.Empty(t, resp.NextPageToken)
+
+	// as_of reconstructs the user at that time
+	getResp, err := srv.GetUser(ctx, &pb.GetUserRequest{
+		Id:   createResp.User.Id,
+		AsOf: timestamppb.New(beforeUpdate),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "original@example.com", getResp.User.Email)
+
+	_, err = srv.GetUser(ctx, &pb.GetUserRequest{Id: createResp.User.Id, AsOf: timestamppb.Now()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = srv.GetUser(ctx, &pb.GetUserRequest{
+		Id:   createResp.User.Id,
+		AsOf: timestamppb.New(beforeUpdate.Add(-time.Hour)),
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// The history outlives the purge, which it records
+	_, err = mockDB.PurgeDeletedUsers(ctx, 0)
+	assert.NoError(t, err)
+	resp, err = srv.ListUserHistory(ctx, &pb.ListUserHistoryRequest{UserId: createResp.User.Id})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Entries, 4)
+	assert.Equal(t, pb.UserEventType_USER_EVENT_TYPE_PURGED, resp.Entries[0].Type)
+	assert.Equal(t, "updated@example.com", resp.Entries[0].Before.Email)
+	assert.Nil(t, resp.Entries[0].After)
+
+	_, err = srv.GetUser(ctx, &pb.GetUserRequest{Id: createResp.User.Id, AsOf: timestamppb.Now(), ShowDeleted: true})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_GetUserByEmail(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	// Emails are stored normalized
+	createResp, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:  "Test User",
+		Email: " Test@Example.COM ",
+		Age:   30,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Test@example.com", createResp.User.Email)
+
+	// And looked up normalized
+	resp, err := srv.GetUserByEmail(context.Background(), &pb.GetUserByEmailRequest{
+		Email: "Test@EXAMPLE.com",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, createResp.User.Id, resp.User.Id)
+
+	// The local part is only folded when configured
+	_, err = srv.GetUserByEmail(context.Background(), &pb.GetUserByEmailRequest{
+		Email: "test@example.com",
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	srv.Config = &config.Config{FoldEmailLocalPart: true}
+	createResp, err = srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:  "Other User",
+		Email: "Other@Example.com",
+		Age:   30,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "other@example.com", createResp.User.Email)
+
+	// Soft deleted users are only returned with show_deleted
+	_, err = srv.DeleteUser(context.Background(), &pb.DeleteUserRequest{Id: createResp.User.Id})
+	assert.NoError(t, err)
+	_, err = srv.GetUserByEmail(context.Background(), &pb.GetUserByEmailRequest{Email: "OTHER@example.com"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	resp, err = srv.GetUserByEmail(context.Background(), &pb.GetUserByEmailRequest{
+		Email:       "OTHER@example.com",
+		ShowDeleted: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, createResp.User.Id, resp.User.Id)
+}
+
 func TestServer_UpdateUser(t *testing.T) {
 	mockDB := dbMock.NewMockClient(nil, nil, nil)
 	srv := &server.Server{DB: mockDB}
@@ -73,6 +307,162 @@ func TestServer_UpdateUser(t *testing.T) {
 	assert.Equal(t, int32(30), resp.User.Age)
 }
 
+func TestServer_UpdateUser_Mask(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	// Create user
+	createResp, _ := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:  "Original",
+		Email: "original@example.com",
+		Age:   25,
+	})
+
+	// Update only the age, other fields are empty and must be ignored
+	resp, err := srv.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Id:         createResp.User.Id,
+		Age:        40,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"age"}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Original", resp.User.Name)
+	assert.Equal(t, "original@example.com", resp.User.Email)
+	assert.Equal(t, int32(40), resp.User.Age)
+}
+
+func TestServer_UserLabels(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	create := func(name string, labels map[string]string) *pb.User {
+		resp, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+			Name:   name,
+			Email:  strings.ToLower(name) + "@example.com",
+			Age:    30,
+			Labels: labels,
+		})
+		assert.NoError(t, err)
+		return resp.GetUser()
+	}
+	payments := create("Payments", map[string]string{"team": "payments", "env": "prod"})
+	search := create("Search", map[string]string{"team": "search", "example.com/tier": "gold"})
+	create("Unlabeled", nil)
+	assert.Equal(t, map[string]string{"team": "payments", "env": "prod"}, payments.Labels)
+
+	// Keys and values are validated
+	_, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:   "Invalid",
+		Email:  "invalid@example.com",
+		Age:    30,
+		Labels: map[string]string{"-team": "payments", "Example.com/env": "prod", "tier": "gold!"},
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	var badRequest *errdetails.BadRequest
+	for _, detail := range status.Convert(err).Details() {
+		if d, ok := detail.(*errdetails.BadRequest); ok {
+			badRequest = d
+		}
+	}
+	if assert.NotNil(t, badRequest) {
+		assert.Len(t, badRequest.FieldViolations, 3)
+	}
+
+	list := func(selector string) []string {
+		resp, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{LabelSelector: selector})
+		assert.NoError(t, err, selector)
+		var names []string
+		for _, user := range resp.GetUsers() {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+	assert.Equal(t, []string{"Payments"}, list("team=payments"))
+	assert.Equal(t, []string{"Payments"}, list("team==payments, env"))
+	assert.Equal(t, []string{"Search", "Unlabeled"}, list("team!=payments"))
+	assert.Equal(t, []string{"Payments", "Search"}, list("team in (payments, search)"))
+	assert.Equal(t, []string{"Unlabeled"}, list("team notin (payments,search)"))
+	assert.Equal(t, []string{"Search"}, list("example.com/tier,!env"))
+
+	for _, selector := range []string{"team=pay=ments", "=payments", "team in payments", "team in (a", "a b", "team=pay ments"} {
+		_, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{LabelSelector: selector})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), selector)
+	}
+
+	// Labels are only updated when in the update_mask, and replaced as a whole
+	resp, err := srv.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Id:     search.Id,
+		Name:   "Search",
+		Email:  "search@example.com",
+		Age:    31,
+		Labels: map[string]string{"team": "ignored"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, search.Labels, resp.User.Labels)
+
+	resp, err = srv.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Id:         search.Id,
+		Labels:     map[string]string{"team": "payments"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"labels"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments"}, resp.User.Labels)
+	assert.Equal(t, []string{"Payments", "Search"}, list("team=payments"))
+
+	resp, err = srv.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Id:         search.Id,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"labels"}},
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.User.Labels)
+}
+
+func TestServer_UpdateUser_Etag(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	// Create user
+	createResp, _ := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:  "Original",
+		Email: "original@example.com",
+		Age:   25,
+	})
+	originalEtag := createResp.User.Etag
+
+	// Update based on the current etag
+	resp, err := srv.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Id:         createResp.User.Id,
+		Name:       "First Writer",
+		Etag:       originalEtag,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, originalEtag, resp.User.Etag)
+
+	// A second writer with the stale etag is rejected
+	_, err = srv.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Id:         createResp.User.Id,
+		Name:       "Second Writer",
+		Etag:       originalEtag,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+	})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	_, err = srv.DeleteUser(context.Background(), &pb.DeleteUserRequest{
+		Id:   createResp.User.Id,
+		Etag: originalEtag,
+	})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// The current etag is accepted
+	deleteResp, err := srv.DeleteUser(context.Background(), &pb.DeleteUserRequest{
+		Id:   createResp.User.Id,
+		Etag: resp.User.Etag,
+	})
+	assert.NoError(t, err)
+	assert.True(t, deleteResp.Success)
+}
+
 func TestServer_DeleteUser(t *testing.T) {
 	mockDB := dbMock.NewMockClient(nil, nil, nil)
 	srv := &server.Server{DB: mockDB}
@@ -99,6 +489,142 @@ func TestServer_DeleteUser(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestServer_UndeleteUser(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	// Create and delete a user
+	createResp, _ := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Name:  "User To Restore",
+		Email: "restore@example.com",
+		Age:   30,
+	})
+	_, err := srv.DeleteUser(context.Background(), &pb.DeleteUserRequest{
+		Id: createResp.User.Id,
+	})
+	assert.NoError(t, err)
+
+	// Soft deleted users are only visible with show_deleted
+	getResp, err := srv.GetUser(context.Background(), &pb.GetUserRequest{
+		Id:          createResp.User.Id,
+		ShowDeleted: true,
+	})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, getResp.User.DeletedAt)
+
+	listResp, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), listResp.Total)
+
+	listResp, err = srv.ListUsers(context.Background(), &pb.ListUsersRequest{ShowDeleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), listResp.Total)
+
+	// Restore the user
+	resp, err := srv.UndeleteUser(context.Background(), &pb.UndeleteUserRequest{
+		Id: createResp.User.Id,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.User.DeletedAt)
+
+	_, err = srv.GetUser(context.Background(), &pb.GetUserRequest{
+		Id: createResp.User.Id,
+	})
+	assert.NoError(t, err)
+
+	// A live user can't be undeleted
+	_, err = srv.UndeleteUser(context.Background(), &pb.UndeleteUserRequest{
+		Id: createResp.User.Id,
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_GetUser_ErrorDetails(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	_, err := srv.GetUser(context.Background(), &pb.GetUserRequest{Id: "999"})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+	var reason, resource string
+	for _, detail := range st.Details() {
+		switch d := detail.(type) {
+		case *errdetails.ErrorInfo:
+			reason = d.Reason
+			assert.Equal(t, "user.grpc-services", d.Domain)
+		case *errdetails.ResourceInfo:
+			resource = d.ResourceName
+			assert.Equal(t, "user", d.ResourceType)
+		}
+	}
+	assert.Equal(t, server.ReasonUserNotFound, reason)
+	assert.Equal(t, "999", resource)
+}
+
+func TestServer_CreateUser_AlreadyExistsDetails(t *testing.T) {
+	tests := []struct {
+		name             string
+		givenDBError     error
+		wantReason       string
+		wantResourceType string
+		wantResourceName string
+	}{
+		{
+			name:             "duplicate email",
+			givenDBError:     &pq.Error{Code: "23505", Constraint: "users_email_live_idx", Table: "users"},
+			wantReason:       server.ReasonEmailAlreadyExists,
+			wantResourceType: "user",
+			wantResourceName: "test@example.com",
+		},
+		{
+			name:             "duplicate email of the in-memory storage",
+			givenDBError:     database.ErrDuplicateEmail,
+			wantReason:       server.ReasonEmailAlreadyExists,
+			wantResourceType: "user",
+			wantResourceName: "test@example.com",
+		},
+		{
+			name:             "duplicate legacy id",
+			givenDBError:     &pq.Error{Code: "23505", Constraint: "users_legacy_id_key", Table: "users"},
+			wantReason:       server.ReasonAlreadyExists,
+			wantResourceType: "user",
+			wantResourceName: "test@example.com",
+		},
+		{
+			name:             "duplicate idempotency key",
+			givenDBError:     &pq.Error{Code: "23505", Constraint: "idempotency_keys_pkey", Table: "idempotency_keys"},
+			wantReason:       server.ReasonAlreadyExists,
+			wantResourceType: "idempotency_key",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &server.Server{DB: dbMock.NewMockClient(tt.givenDBError, nil, nil)}
+			_, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{Name: "Test User", Email: "test@example.com", Age: 30})
+
+			st := status.Convert(err)
+			assert.Equal(t, codes.AlreadyExists, st.Code())
+			var reason, constraint string
+			var resource *errdetails.ResourceInfo
+			for _, detail := range st.Details() {
+				switch d := detail.(type) {
+				case *errdetails.ErrorInfo:
+					reason, constraint = d.Reason, d.Metadata["constraint"]
+				case *errdetails.ResourceInfo:
+					resource = d
+				}
+			}
+			assert.Equal(t, tt.wantReason, reason)
+			assert.Equal(t, database.Constraint(tt.givenDBError), constraint)
+			require.NotNil(t, resource)
+			assert.Equal(t, tt.wantResourceType, resource.ResourceType)
+			assert.Equal(t, tt.wantResourceName, resource.ResourceName)
+		})
+	}
+}
+
 func TestServer_DeleteUser_NotFound(t *testing.T) {
 	mockDB := dbMock.NewMockClient(nil, nil, nil)
 	srv := &server.Server{DB: mockDB}
@@ -110,6 +636,113 @@ func TestServer_DeleteUser_NotFound(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestServer_BatchCreateUsers(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	requests := []*pb.CreateUserRequest{
+		{Name: "User A", Email: "a@example.com", Age: 25},
+		{Name: "User B", Email: "a@example.com", Age: 30},
+		{Name: "", Email: "c@example.com", Age: 35},
+	}
+
+	// All-or-nothing: the invalid item fails the whole batch
+	_, err := srv.BatchCreateUsers(context.Background(), &pb.BatchCreateUsersRequest{
+		Requests: requests,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "requests[2]: name cannot be empty")
+
+	// All-or-nothing: the duplicate email rolls back the first user
+	_, err = srv.BatchCreateUsers(context.Background(), &pb.BatchCreateUsersRequest{
+		Requests: requests[:2],
+		Mode:     pb.BatchMode_BATCH_MODE_ALL_OR_NOTHING,
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "requests[1]")
+	assert.Empty(t, mockDB.Users)
+
+	// Best-effort: each item gets its own result
+	resp, err := srv.BatchCreateUsers(context.Background(), &pb.BatchCreateUsersRequest{
+		Requests: requests,
+		Mode:     pb.BatchMode_BATCH_MODE_BEST_EFFORT,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Results, 3)
+	assert.Nil(t, resp.Results[0].Error)
+	assert.Equal(t, "User A", resp.Results[0].User.Name)
+	assert.Equal(t, resp.Results[0].User.Id, resp.Results[0].Id)
+	assert.NotNil(t, resp.Results[1].Error)
+	assert.Equal(t, int32(1), resp.Results[1].Error.Index)
+	assert.Equal(t, int32(codes.InvalidArgument), resp.Results[2].Error.Code)
+	assert.Equal(t, "name cannot be empty", resp.Results[2].Error.Message)
+	assert.Len(t, mockDB.Users, 1)
+}
+
+func TestServer_BatchGetUsers(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	user, _ := mockDB.CreateUser(context.Background(), &database.NewUser{Name: "User A", Email: "a@example.com", Age: 25})
+	id := user.ToProto().Id
+
+	resp, err := srv.BatchGetUsers(context.Background(), &pb.BatchGetUsersRequest{
+		Ids:  []string{id, "999", "invalid", id},
+		Mode: pb.BatchMode_BATCH_MODE_BEST_EFFORT,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Results, 4)
+	assert.Equal(t, "User A", resp.Results[0].User.Name)
+	assert.Equal(t, int32(codes.NotFound), resp.Results[1].Error.Code)
+	assert.Equal(t, "999", resp.Results[1].Id)
+	assert.Equal(t, int32(codes.InvalidArgument), resp.Results[2].Error.Code)
+	assert.Equal(t, "User A", resp.Results[3].User.Name)
+
+	_, err = srv.BatchGetUsers(context.Background(), &pb.BatchGetUsersRequest{
+		Ids: []string{id, "999"},
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, err.Error(), "requests[1]: user not found")
+
+	_, err = srv.BatchGetUsers(context.Background(), &pb.BatchGetUsersRequest{
+		Ids:  []string{id, ""},
+		Mode: pb.BatchMode_BATCH_MODE_BEST_EFFORT,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "ids[1] cannot be empty")
+}
+
+func TestServer_BatchDeleteUsers(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	userA, _ := mockDB.CreateUser(context.Background(), &database.NewUser{Name: "User A", Email: "a@example.com", Age: 25})
+	userB, _ := mockDB.CreateUser(context.Background(), &database.NewUser{Name: "User B", Email: "b@example.com", Age: 30})
+	idA, idB := userA.ToProto().Id, userB.ToProto().Id
+
+	// All-or-nothing: the stale etag keeps both users
+	_, err := srv.BatchDeleteUsers(context.Background(), &pb.BatchDeleteUsersRequest{
+		Requests: []*pb.DeleteUserRequest{{Id: idA}, {Id: idB, Etag: "7"}},
+	})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	assert.Nil(t, mockDB.Users[userA.ID].DeletedAt)
+
+	// Best-effort: the missing user doesn't stop the others
+	resp, err := srv.BatchDeleteUsers(context.Background(), &pb.BatchDeleteUsersRequest{
+		Requests: []*pb.DeleteUserRequest{{Id: idA}, {Id: "999"}, {Id: idB, Etag: "1"}},
+		Mode:     pb.BatchMode_BATCH_MODE_BEST_EFFORT,
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, resp.Results[0].Error)
+	assert.Equal(t, idA, resp.Results[0].Id)
+	assert.Equal(t, int32(codes.NotFound), resp.Results[1].Error.Code)
+	assert.Nil(t, resp.Results[2].Error)
+
+	listResp, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), listResp.Total)
+}
+
 func TestServer_ListUsers(t *testing.T) {
 	mockDB := dbMock.NewMockClient(nil, nil, nil)
 	srv := &server.Server{DB: mockDB}
@@ -189,6 +822,442 @@ func TestServer_ListUsers_Pagination(t *testing.T) {
 	assert.Equal(t, "User 4", resp2.Users[1].Name)
 }
 
+func TestServer_ListUsers_PageToken(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	// Create 5 users
+	var ids []string
+	for i := 1; i <= 5; i++ {
+		resp, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+			Name:  fmt.Sprintf("User %d", i),
+			Email: fmt.Sprintf("user%d@example.com", i),
+			Age:   20 + int32(i),
+		})
+		assert.NoError(t, err)
+		ids = append(ids, resp.User.Id)
+	}
+
+	// First page
+	resp1, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{
+		Limit: 2,
+	})
+
+	assert.NoError(t, err)
+	assert.Len(t, resp1.Users, 2)
+	assert.Equal(t, "User 2", resp1.Users[1].Name)
+	assert.NotEmpty(t, resp1.NextPageToken)
+
+	// Delete a user of the next page, the cursor should not skip any row
+	_, err = srv.DeleteUser(context.Background(), &pb.DeleteUserRequest{Id: ids[2]})
+	assert.NoError(t, err)
+
+	resp2, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{
+		Limit:     2,
+		PageToken: resp1.NextPageToken,
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), resp2.Page)
+	assert.Len(t, resp2.Users, 2)
+	assert.Equal(t, "User 4", resp2.Users[0].Name)
+	assert.Equal(t, "User 5", resp2.Users[1].Name)
+	assert.Empty(t, resp2.NextPageToken)
+}
+
+func TestServer_ListUsers_TotalMode(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+	for i := 1; i <= 3; i++ {
+		_, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+			Name:  fmt.Sprintf("User %d", i),
+			Email: fmt.Sprintf("user%d@example.com", i),
+			Age:   20 + int32(i),
+		})
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name          string
+		givenMode     pb.TotalMode
+		givenEstimate int
+		wantTotal     int32
+		wantMode      pb.TotalMode
+	}{
+		{name: "unspecified counts", givenMode: pb.TotalMode_TOTAL_MODE_UNSPECIFIED, wantTotal: 3, wantMode: pb.TotalMode_TOTAL_MODE_EXACT},
+		{name: "exact", givenMode: pb.TotalMode_TOTAL_MODE_EXACT, givenEstimate: 1000, wantTotal: 3, wantMode: pb.TotalMode_TOTAL_MODE_EXACT},
+		{name: "estimate", givenMode: pb.TotalMode_TOTAL_MODE_ESTIMATE, givenEstimate: 1000, wantTotal: 1000, wantMode: pb.TotalMode_TOTAL_MODE_ESTIMATE},
+		{name: "estimate without statistics counts", givenMode: pb.TotalMode_TOTAL_MODE_ESTIMATE, wantTotal: 3, wantMode: pb.TotalMode_TOTAL_MODE_EXACT},
+		{name: "none", givenMode: pb.TotalMode_TOTAL_MODE_NONE, givenEstimate: 1000, wantTotal: 0, wantMode: pb.TotalMode_TOTAL_MODE_NONE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB.Estimate = tt.givenEstimate
+			resp, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{Limit: 2, TotalMode: tt.givenMode})
+			require.NoError(t, err)
+			assert.Len(t, resp.Users, 2)
+			assert.Equal(t, tt.wantTotal, resp.Total)
+			assert.Equal(t, tt.wantMode, resp.TotalMode)
+		})
+	}
+
+	// The next pages can skip the total, with the page token of a counted page
+	mockDB.Estimate = 0
+	resp1, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{Limit: 2})
+	require.NoError(t, err)
+	resp2, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{
+		Limit:     2,
+		PageToken: resp1.NextPageToken,
+		TotalMode: pb.TotalMode_TOTAL_MODE_NONE,
+	})
+	require.NoError(t, err)
+	assert.Len(t, resp2.Users, 1)
+	assert.Equal(t, "User 3", resp2.Users[0].Name)
+	assert.Equal(t, pb.TotalMode_TOTAL_MODE_NONE, resp2.TotalMode)
+}
+
+func TestServer_ListUsers_FilterAndOrder(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	users := []struct {
+		name  string
+		email string
+		age   int32
+	}{
+		{"Alice", "alice@corp.com", 31},
+		{"Bob", "bob@example.com", 45},
+		{"Alan", "alan@CORP.com", 52},
+		{"Carol", "carol@corp.com", 19},
+		{"Albert", "albert@corp.com", 40},
+	}
+	for _, u := range users {
+		_, err := srv.CreateUser(context.Background(), &pb.CreateUserRequest{
+			Name:  u.name,
+			Email: u.email,
+			Age:   u.age,
+		})
+		assert.NoError(t, err)
+	}
+
+	// Filter on domain and age, ordered by age descending, one per page
+	req := &pb.ListUsersRequest{
+		Limit:       1,
+		EmailDomain: "corp.com",
+		MinAge:      20,
+		OrderBy:     "age desc",
+	}
+	var names []string
+	for {
+		resp, err := srv.ListUsers(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, int32(3), resp.Total)
+		for _, user := range resp.Users {
+			names = append(names, user.Name)
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	assert.Equal(t, []string{"Alan", "Albert", "Alice"}, names)
+
+	// Name prefix, ordered by name
+	resp, err := srv.ListUsers(context.Background(), &pb.ListUsersRequest{
+		NamePrefix: "al",
+		OrderBy:    "name",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), resp.Total)
+	assert.Equal(t, "Alan", resp.Users[0].Name)
+	assert.Equal(t, "Albert", resp.Users[1].Name)
+	assert.Equal(t, "Alice", resp.Users[2].Name)
+
+	// A page token can't be reused with other filters
+	_, err = srv.ListUsers(context.Background(), &pb.ListUsersRequest{
+		Limit:     1,
+		OrderBy:   "age",
+		PageToken: req.PageToken,
+	})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// sendStream
+// Collects the messages sent by a server-streaming RPC, failing the sends after sendLimit messages when set.
+type sendStream[T any] struct {
+	grpc.ServerStream
+
+	ctx       context.Context
+	sent      []*T
+	sendLimit int
+}
+
+func (s *sendStream[T]) Context() context.Context {
+	return s.ctx
+}
+
+func (s *sendStream[T]) Send(msg *T) error {
+	if s.sendLimit > 0 && len(s.sent) >= s.sendLimit {
+		return status.Error(codes.Unavailable, "transport is closing")
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestServer_SearchUsers(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+	ctx := context.Background()
+
+	mockDB.CreateUser(ctx, &database.NewUser{Name: "Alice Johnson", Email: "alice.johnson@example.com", Age: 28})
+	mockDB.CreateUser(ctx, &database.NewUser{Name: "Bob Smith", Email: "bob@example.com", Age: 32})
+	mockDB.CreateUser(ctx, &database.NewUser{Name: "Alicia", Email: "alicia@example.com", Age: 24})
+	mockDB.CreateUser(ctx, &database.NewUser{Name: "Carol", Email: "carol@example.com", Age: 40})
+
+	// Best matches first
+	resp, err := srv.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "ALIC", Limit: 1})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Results, 1)
+	assert.Equal(t, "Alicia", resp.Results[0].User.Name)
+	assert.NotEmpty(t, resp.NextPageToken)
+
+	resp, err = srv.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "ALIC", Limit: 1, PageToken: resp.NextPageToken})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Results, 1)
+	assert.Equal(t, "Alice Johnson", resp.Results[0].User.Name)
+	assert.Empty(t, resp.NextPageToken)
+
+	// Page tokens are bound to their query
+	first, _ := srv.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "alic", Limit: 1})
+	_, err = srv.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "bob", Limit: 1, PageToken: first.NextPageToken})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = srv.SearchUsers(ctx, &pb.SearchUsersRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_ExportUsers(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	var ids []string
+	for i := 1; i <= 5; i++ {
+		user, _ := mockDB.CreateUser(context.Background(), &database.NewUser{Name: fmt.Sprintf("User %d", i), Email: fmt.Sprintf("user%d@example.com", i), Age: int32(20 + i)})
+		ids = append(ids, user.ID)
+	}
+	mockDB.DeleteUser(context.Background(), ids[1], 0)
+
+	// Exports the live users, ordered by id
+	stream := &sendStream[pb.User]{ctx: context.Background()}
+	err := srv.ExportUsers(&pb.ExportUsersRequest{}, stream)
+	assert.NoError(t, err)
+	assert.Len(t, stream.sent, 4)
+	assert.Equal(t, "User 1", stream.sent[0].Name)
+	assert.Equal(t, "User 5", stream.sent[3].Name)
+
+	// Same filters as ListUsers
+	stream = &sendStream[pb.User]{ctx: context.Background()}
+	err = srv.ExportUsers(&pb.ExportUsersRequest{MinAge: 23, ShowDeleted: true}, stream)
+	assert.NoError(t, err)
+	assert.Len(t, stream.sent, 3)
+
+	// The export stops with the stream
+	stream = &sendStream[pb.User]{ctx: context.Background(), sendLimit: 2}
+	err = srv.ExportUsers(&pb.ExportUsersRequest{}, stream)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Len(t, stream.sent, 2)
+
+	// A cancelled client stops the export
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream = &sendStream[pb.User]{ctx: ctx}
+	err = srv.ExportUsers(&pb.ExportUsersRequest{}, stream)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	assert.Empty(t, stream.sent)
+
+	// Invalid filters
+	err = srv.ExportUsers(&pb.ExportUsersRequest{MinAge: 30, MaxAge: 20}, &sendStream[pb.User]{ctx: context.Background()})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// importStream
+// Replays requests as a client stream, and keeps the response.
+type importStream struct {
+	grpc.ServerStream
+
+	requests []*pb.ImportUsersRequest
+	response *pb.ImportUsersResponse
+}
+
+func (s *importStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *importStream) Recv() (*pb.ImportUsersRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *importStream) SendAndClose(resp *pb.ImportUsersResponse) error {
+	s.response = resp
+	return nil
+}
+
+func TestServer_ImportUsers(t *testing.T) {
+	records := []*pb.CreateUserRequest{
+		{Name: "User A", Email: "a@example.com", Age: 25},
+		{Name: "User B", Email: "taken@example.com", Age: 30},
+		{Name: "", Email: "c@example.com", Age: 35},
+		{Name: "User A Again", Email: "a@example.com", Age: 26},
+	}
+	importRequests := func(upsert, dryRun bool) []*pb.ImportUsersRequest {
+		var requests []*pb.ImportUsersRequest
+		for _, record := range records {
+			requests = append(requests, &pb.ImportUsersRequest{User: record, Upsert: upsert, DryRun: dryRun})
+		}
+		return requests
+	}
+	statuses := func(resp *pb.ImportUsersResponse) []pb.ImportStatus {
+		var statuses []pb.ImportStatus
+		for _, result := range resp.Results {
+			statuses = append(statuses, result.Status)
+		}
+		return statuses
+	}
+
+	tests := []struct {
+		name         string
+		upsert       bool
+		dryRun       bool
+		wantStatuses []pb.ImportStatus
+		wantUsers    int
+	}{
+		{
+			name:   "works - conflicts are reported",
+			upsert: false,
+			wantStatuses: []pb.ImportStatus{
+				pb.ImportStatus_IMPORT_STATUS_CREATED,
+				pb.ImportStatus_IMPORT_STATUS_CONFLICT,
+				pb.ImportStatus_IMPORT_STATUS_INVALID,
+				pb.ImportStatus_IMPORT_STATUS_CONFLICT,
+			},
+			wantUsers: 2,
+		},
+		{
+			name:   "works - upsert updates by email",
+			upsert: true,
+			wantStatuses: []pb.ImportStatus{
+				pb.ImportStatus_IMPORT_STATUS_CREATED,
+				pb.ImportStatus_IMPORT_STATUS_UPDATED,
+				pb.ImportStatus_IMPORT_STATUS_INVALID,
+				pb.ImportStatus_IMPORT_STATUS_UPDATED,
+			},
+			wantUsers: 2,
+		},
+		{
+			name:   "works - dry run writes nothing",
+			dryRun: true,
+			wantStatuses: []pb.ImportStatus{
+				pb.ImportStatus_IMPORT_STATUS_CREATED,
+				pb.ImportStatus_IMPORT_STATUS_CONFLICT,
+				pb.ImportStatus_IMPORT_STATUS_INVALID,
+				pb.ImportStatus_IMPORT_STATUS_CONFLICT,
+			},
+			wantUsers: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockDB := dbMock.NewMockClient(nil, nil, nil)
+			mockDB.CreateUser(context.Background(), &database.NewUser{Name: "Existing", Email: "taken@example.com", Age: 40})
+			srv := &server.Server{DB: mockDB}
+
+			stream := &importStream{requests: importRequests(tt.upsert, tt.dryRun)}
+			err := srv.ImportUsers(stream)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantStatuses, statuses(stream.response))
+			assert.Equal(t, int32(3), stream.response.Results[3].Index)
+			assert.Equal(t, "name cannot be empty", stream.response.Results[2].Message)
+			assert.Equal(t, int32(1), stream.response.Created)
+			assert.Equal(t, int32(3), stream.response.Failed+stream.response.Updated)
+			assert.Len(t, mockDB.Users, tt.wantUsers)
+			if tt.dryRun {
+				assert.Nil(t, stream.response.Results[0].User)
+			} else {
+				assert.Equal(t, "a@example.com", stream.response.Results[0].User.Email)
+			}
+		})
+	}
+}
+
+func TestServer_ImportUsers_ChangedOptions(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	// The options come from the first message, a later one can't change them
+	stream := &importStream{requests: []*pb.ImportUsersRequest{
+		{User: &pb.CreateUserRequest{Name: "User A", Email: "a@example.com", Age: 25}, DryRun: true},
+		{User: &pb.CreateUserRequest{Name: "User B", Email: "b@example.com", Age: 30}},
+	}}
+	err := srv.ImportUsers(stream)
+
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "record 1")
+	assert.Nil(t, stream.response)
+	assert.Empty(t, mockDB.Users)
+}
+
+func TestServer_WatchUsers(t *testing.T) {
+	mockDB := dbMock.NewMockClient(nil, nil, nil)
+	srv := &server.Server{DB: mockDB}
+
+	createResp, _ := srv.CreateUser(context.Background(), fixtureCreateRequest())
+	name := "Renamed User"
+	_, err := srv.UpdateUser(context.Background(), &pb.UpdateUserRequest{
+		Id:         createResp.User.Id,
+		Name:       name,
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+	})
+	assert.NoError(t, err)
+	_, err = srv.DeleteUser(context.Background(), &pb.DeleteUserRequest{Id: createResp.User.Id})
+	assert.NoError(t, err)
+
+	// Resumes after the first event, until the client goes away
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stream := &sendStream[pb.UserEvent]{ctx: ctx}
+	err = srv.WatchUsers(&pb.WatchUsersRequest{AfterSequence: 1}, stream)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Len(t, stream.sent, 2)
+	assert.Equal(t, int64(2), stream.sent[0].Sequence)
+	assert.Equal(t, pb.UserEventType_USER_EVENT_TYPE_UPDATED, stream.sent[0].Type)
+	assert.Equal(t, name, stream.sent[0].User.Name)
+	assert.Equal(t, pb.UserEventType_USER_EVENT_TYPE_DELETED, stream.sent[1].Type)
+	assert.NotEmpty(t, stream.sent[1].User.DeletedAt)
+
+	// Zero only streams the new events
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	stream = &sendStream[pb.UserEvent]{ctx: ctx}
+	err = srv.WatchUsers(&pb.WatchUsersRequest{}, stream)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	assert.Empty(t, stream.sent)
+
+	// Purged events can't be resumed from
+	mockDB.Events = mockDB.Events[2:]
+	err = srv.WatchUsers(&pb.WatchUsersRequest{AfterSequence: 1}, &sendStream[pb.UserEvent]{ctx: context.Background()})
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+
+	err = srv.WatchUsers(&pb.WatchUsersRequest{AfterSequence: -1}, &sendStream[pb.UserEvent]{ctx: context.Background()})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
 func TestServer_ListUsers_Empty(t *testing.T) {
 	mockDB := dbMock.NewMockClient(nil, nil, nil)
 	srv := &server.Server{DB: mockDB}
@@ -226,3 +1295,180 @@ func TestServer_ListUsers_DefaultPagination(t *testing.T) {
 	assert.Equal(t, int32(10), resp.Limit) // Default limit
 	assert.Len(t, resp.Users, 10)
 }
+
+func TestServer_ReadPrimaryInterceptor(t *testing.T) {
+	tests := []struct {
+		name        string
+		givenValue  string
+		wantPrimary bool
+	}{
+		{name: "set", givenValue: "true", wantPrimary: true},
+		{name: "other value", givenValue: "yes", wantPrimary: false},
+		{name: "missing", wantPrimary: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if tt.givenValue != "" {
+				md.Set(server.ReadPrimaryMetadataKey, tt.givenValue)
+			}
+			incoming := metadata.NewIncomingContext(context.Background(), md)
+			_, err := server.ReadPrimaryUnaryInterceptor(incoming, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, _ any) (any, error) {
+				assert.Equal(t, tt.wantPrimary, database.PrimaryReads(ctx))
+				return nil, nil
+			})
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestServer_GetUserReadPrimaryCache(t *testing.T) {
+	cfg := &config.Config{IdempotencyWindow: time.Hour, CacheSize: 10, CacheTTL: time.Hour, CacheNegativeTTL: time.Hour}
+	inner := database.NewMemoryClient(cfg)
+	cache := database.NewCachedClient(inner, cfg)
+	srv := &server.Server{Config: cfg, DB: cache}
+	ctx := context.Background()
+
+	created, err := srv.CreateUser(ctx, &pb.CreateUserRequest{Name: "Alice", Email: "alice@example.com", Age: 30})
+	require.NoError(t, err)
+	_, err = srv.GetUser(ctx, &pb.GetUserRequest{Id: created.User.Id})
+	require.NoError(t, err)
+
+	// A write of another instance, the cached user is stale
+	name := "Alicia"
+	_, err = inner.UpdateUser(ctx, created.User.Id, &database.UserUpdate{Name: &name})
+	require.NoError(t, err)
+
+	// Reads asking for the primary read through the cache, and don't store what they read
+	getUser := func(md metadata.MD) *pb.User {
+		resp, err := server.ReadPrimaryUnaryInterceptor(metadata.NewIncomingContext(ctx, md), nil, &grpc.UnaryServerInfo{},
+			func(ctx context.Context, _ any) (any, error) {
+				return srv.GetUser(ctx, &pb.GetUserRequest{Id: created.User.Id})
+			})
+		require.NoError(t, err)
+		return resp.(*pb.UserResponse).User
+	}
+	assert.Equal(t, "Alicia", getUser(metadata.Pairs(server.ReadPrimaryMetadataKey, "true")).Name)
+	assert.Equal(t, "Alice", getUser(metadata.MD{}).Name)
+	assert.Equal(t, database.CacheStats{Hits: 1, Misses: 1, Bypasses: 1}, cache.Stats())
+}
+
+func TestServer_Webhooks(t *testing.T) {
+	store := dbMock.NewMockWebhookStore(nil)
+	srv := &server.Server{DB: dbMock.NewMockClient(nil, nil, nil), Webhooks: store}
+	ctx := context.Background()
+
+	created, err := srv.CreateWebhook(ctx, &pb.CreateWebhookRequest{
+		Url:        "https://partner.example.com/hooks",
+		EventTypes: []pb.UserEventType{pb.UserEventType_USER_EVENT_TYPE_CREATED, pb.UserEventType_USER_EVENT_TYPE_DELETED, pb.UserEventType_USER_EVENT_TYPE_CREATED},
+		Secret:     "0123456789abcdef",
+	})
+	assert.NoError(t, err)
+	id := created.Webhook.Id
+	assert.Equal(t, "https://partner.example.com/hooks", created.Webhook.Url)
+	assert.Equal(t, []pb.UserEventType{pb.UserEventType_USER_EVENT_TYPE_CREATED, pb.UserEventType_USER_EVENT_TYPE_DELETED}, created.Webhook.EventTypes)
+	assert.Equal(t, []string{database.EventCreated, database.EventDeleted}, store.Webhooks[id].EventTypes)
+	assert.Equal(t, "0123456789abcdef", store.Webhooks[id].Secret)
+
+	got, err := srv.GetWebhook(ctx, &pb.GetWebhookRequest{Id: id})
+	assert.NoError(t, err)
+	assert.False(t, got.Webhook.Disabled)
+
+	// A disabled webhook is enabled again, with its failures reset
+	disabledAt := time.Now()
+	store.Webhooks[id].DisabledAt = &disabledAt
+	store.Webhooks[id].ConsecutiveFailures = 20
+	list, err := srv.ListWebhooks(ctx, &pb.ListWebhooksRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, list.Webhooks, 1)
+	assert.True(t, list.Webhooks[0].Disabled)
+	assert.Equal(t, int32(20), list.Webhooks[0].ConsecutiveFailures)
+
+	enabled, err := srv.EnableWebhook(ctx, &pb.EnableWebhookRequest{Id: id})
+	assert.NoError(t, err)
+	assert.False(t, enabled.Webhook.Disabled)
+	assert.Zero(t, enabled.Webhook.ConsecutiveFailures)
+
+	deleted, err := srv.DeleteWebhook(ctx, &pb.DeleteWebhookRequest{Id: id})
+	assert.NoError(t, err)
+	assert.True(t, deleted.Success)
+
+	// Once deleted, it is not found
+	_, err = srv.GetWebhook(ctx, &pb.GetWebhookRequest{Id: id})
+	st := status.Convert(err)
+	assert.Equal(t, codes.NotFound, st.Code())
+	assert.Equal(t, "webhook not found", st.Message())
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ErrorInfo:
+			assert.Equal(t, server.ReasonWebhookNotFound, detail.Reason)
+		case *errdetails.ResourceInfo:
+			assert.Equal(t, "webhook", detail.ResourceType)
+			assert.Equal(t, id, detail.ResourceName)
+		}
+	}
+	_, err = srv.DeleteWebhook(ctx, &pb.DeleteWebhookRequest{Id: id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = srv.EnableWebhook(ctx, &pb.EnableWebhookRequest{Id: id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_ListWebhookAttempts(t *testing.T) {
+	store := dbMock.NewMockWebhookStore(nil)
+	srv := &server.Server{DB: dbMock.NewMockClient(nil, nil, nil), Webhooks: store}
+	ctx := context.Background()
+
+	webhook, err := srv.CreateWebhook(ctx, &pb.CreateWebhookRequest{Url: "http://localhost:8080/hooks", Secret: "0123456789abcdef"})
+	assert.NoError(t, err)
+	id := webhook.Webhook.Id
+	for i := 1; i <= 3; i++ {
+		store.Attempts = append(store.Attempts, &database.WebhookAttempt{
+			DeliveryID: 1,
+			WebhookID:  id,
+			EventType:  database.EventUpdated,
+			Attempt:    i,
+			StatusCode: 500,
+			Error:      "unexpected status 500 Internal Server Error",
+			Duration:   15 * time.Millisecond,
+		})
+		// Attempts of other webhooks are left out
+		store.Attempts = append(store.Attempts, &database.WebhookAttempt{WebhookID: "other", Attempt: i})
+	}
+
+	// Most recent first, two per page
+	first, err := srv.ListWebhookAttempts(ctx, &pb.ListWebhookAttemptsRequest{WebhookId: id, Limit: 2})
+	assert.NoError(t, err)
+	assert.Len(t, first.Attempts, 2)
+	assert.Equal(t, int32(3), first.Attempts[0].Attempt)
+	assert.Equal(t, int32(2), first.Attempts[1].Attempt)
+	assert.Equal(t, pb.UserEventType_USER_EVENT_TYPE_UPDATED, first.Attempts[0].EventType)
+	assert.Equal(t, int32(500), first.Attempts[0].StatusCode)
+	assert.Equal(t, 15*time.Millisecond, first.Attempts[0].Duration.AsDuration())
+	assert.NotEmpty(t, first.NextPageToken)
+
+	second, err := srv.ListWebhookAttempts(ctx, &pb.ListWebhookAttemptsRequest{WebhookId: id, Limit: 2, PageToken: first.NextPageToken})
+	assert.NoError(t, err)
+	assert.Len(t, second.Attempts, 1)
+	assert.Equal(t, int32(1), second.Attempts[0].Attempt)
+	assert.Empty(t, second.NextPageToken)
+
+	// A page token only continues the webhook it was returned for
+	other, err := srv.CreateWebhook(ctx, &pb.CreateWebhookRequest{Url: "http://localhost:8080/other", Secret: "0123456789abcdef"})
+	assert.NoError(t, err)
+	_, err = srv.ListWebhookAttempts(ctx, &pb.ListWebhookAttemptsRequest{WebhookId: other.Webhook.Id, PageToken: first.NextPageToken})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// An unknown webhook is not found, rather than without attempts
+	_, err = srv.ListWebhookAttempts(ctx, &pb.ListWebhookAttemptsRequest{WebhookId: "01890a5d-ac96-774b-bcce-b302099a8057"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_Webhooks_NotEnabled(t *testing.T) {
+	srv := &server.Server{DB: dbMock.NewMockClient(nil, nil, nil)}
+
+	_, err := srv.ListWebhooks(context.Background(), &pb.ListWebhooksRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	_, err = srv.CreateWebhook(context.Background(), &pb.CreateWebhookRequest{Url: "https://example.com", Secret: "0123456789abcdef"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
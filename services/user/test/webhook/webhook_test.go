@@ -0,0 +1,287 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"grpc-services/user/database"
+	"grpc-services/user/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const secret = "0123456789abcdef"
+
+func newDelivery(id int64, webhookID, url string) *database.WebhookDelivery {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &database.WebhookDelivery{
+		ID:        id,
+		Webhook:   &database.WebhookRow{ID: webhookID, URL: url, Secret: secret},
+		Type:      database.EventUpdated,
+		CreatedAt: created,
+		User: &database.UserRow{
+			ID:        "u1",
+			Name:      "Alice",
+			Email:     "alice@example.com",
+			Age:       30,
+			CreatedAt: created,
+			UpdatedAt: created,
+			Version:   2,
+			Labels:    database.Labels{"team": "a"},
+		},
+	}
+}
+
+// receiver
+// An httptest endpoint recording the deliveries whose signature is valid,
+// answering with the statuses in order, then 200.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []webhook.Payload
+	headers  []http.Header
+	invalid  int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	timestamp, _ := strconv.ParseInt(req.Header.Get(webhook.HeaderTimestamp), 10, 64)
+	if !webhook.Verify(secret, timestamp, body, req.Header.Get(webhook.HeaderSignature)) {
+		r.invalid++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	status := http.StatusOK
+	if len(r.statuses) > 0 {
+		status, r.statuses = r.statuses[0], r.statuses[1:]
+	}
+	if status == http.StatusOK {
+		var payload webhook.Payload
+		json.Unmarshal(body, &payload)
+		r.bodies = append(r.bodies, payload)
+		r.headers = append(r.headers, req.Header.Clone())
+	}
+	w.WriteHeader(status)
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	signature := webhook.Sign(secret, 1700000000, body)
+	assert.Regexp(t, `^sha256=[0-9a-f]{64}$`, signature)
+
+	tests := []struct {
+		name       string
+		givenKey   string
+		givenTime  int64
+		givenBody  []byte
+		wantVerify bool
+	}{
+		{name: "same delivery", givenKey: secret, givenTime: 1700000000, givenBody: body, wantVerify: true},
+		{name: "other secret", givenKey: "fedcba9876543210", givenTime: 1700000000, givenBody: body},
+		{name: "other timestamp", givenKey: secret, givenTime: 1700000001, givenBody: body},
+		{name: "other body", givenKey: secret, givenTime: 1700000000, givenBody: []byte(`{"id":2}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantVerify, webhook.Verify(tt.givenKey, tt.givenTime, tt.givenBody, signature))
+		})
+	}
+}
+
+func TestWorker_Deliver(t *testing.T) {
+	recv := &receiver{}
+	endpoint := httptest.NewServer(recv)
+	defer endpoint.Close()
+	worker := webhook.NewWorker(nil, time.Second, time.Second, 1, 3)
+
+	attempt := worker.Deliver(context.Background(), newDelivery(7, "w1", endpoint.URL))
+	assert.Empty(t, attempt.Error)
+	assert.Equal(t, http.StatusOK, attempt.StatusCode)
+	assert.Equal(t, int64(7), attempt.DeliveryID)
+	assert.Positive(t, attempt.Duration)
+
+	require.Len(t, recv.bodies, 1)
+	assert.Equal(t, int64(7), recv.bodies[0].ID)
+	assert.Equal(t, database.EventUpdated, recv.bodies[0].Type)
+	assert.Equal(t, "alice@example.com", recv.bodies[0].User.Email)
+	assert.Equal(t, "7", recv.headers[0].Get(webhook.HeaderDelivery))
+	assert.Equal(t, database.EventUpdated, recv.headers[0].Get(webhook.HeaderEvent))
+	assert.Equal(t, "application/json", recv.headers[0].Get("Content-Type"))
+}
+
+func TestWorker_Deliver_Failures(t *testing.T) {
+	redirect := httptest.NewServer(http.RedirectHandler("http://example.com", http.StatusFound))
+	defer redirect.Close()
+	failing := httptest.NewServer(&receiver{statuses: []int{http.StatusServiceUnavailable}})
+	defer failing.Close()
+	// The slow endpoint answers once the test is over
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		givenURL   string
+		wantStatus int
+		wantError  string
+	}{
+		{name: "error status", givenURL: failing.URL, wantStatus: http.StatusServiceUnavailable, wantError: "unexpected status 503 Service Unavailable"},
+		{name: "redirect not followed", givenURL: redirect.URL, wantStatus: http.StatusFound, wantError: "unexpected status 302 Found"},
+		{name: "timeout", givenURL: slow.URL, wantError: "Timeout"},
+		{name: "unreachable", givenURL: closed.URL, wantError: "connection refused"},
+	}
+	worker := webhook.NewWorker(nil, time.Second, 100*time.Millisecond, 1, 3)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempt := worker.Deliver(context.Background(), newDelivery(1, "w1", tt.givenURL))
+			assert.Equal(t, tt.wantStatus, attempt.StatusCode)
+			assert.Contains(t, attempt.Error, tt.wantError)
+		})
+	}
+}
+
+// fakeStore
+// Mirrors database.SQLClient in memory: claims the pending deliveries of the enabled webhooks in order,
+// without their backoff, leases them until their attempt is recorded or they are released,
+// and disables webhooks after maxFailures failures in a row.
+type fakeStore struct {
+	mu         sync.Mutex
+	deliveries []*database.WebhookDelivery
+	leased     map[int64]bool
+	attempts   []*database.WebhookAttempt
+	failures   map[string]int
+	disabled   map[string]bool
+}
+
+func newFakeStore(deliveries ...*database.WebhookDelivery) *fakeStore {
+	return &fakeStore{deliveries: deliveries, leased: map[int64]bool{}, failures: map[string]int{}, disabled: map[string]bool{}}
+}
+
+func (s *fakeStore) ClaimWebhookDeliveries(ctx context.Context, webhooks, perWebhook int, lease time.Duration) ([]*database.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// A webhook with a leased delivery waits for it
+	waiting := map[string]bool{}
+	claimed := map[string]int{}
+	var deliveries []*database.WebhookDelivery
+	for _, delivery := range s.deliveries {
+		webhookID := delivery.Webhook.ID
+		if s.leased[delivery.ID] {
+			waiting[webhookID] = true
+			continue
+		}
+		if waiting[webhookID] || s.disabled[webhookID] || claimed[webhookID] == perWebhook {
+			continue
+		}
+		if _, ok := claimed[webhookID]; !ok && len(claimed) == webhooks {
+			continue
+		}
+		claimed[webhookID]++
+		s.leased[delivery.ID] = true
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+func (s *fakeStore) RecordWebhookAttempt(ctx context.Context, delivery *database.WebhookDelivery, attempt *database.WebhookAttempt, maxFailures int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	webhookID := delivery.Webhook.ID
+	attempt.Attempt = delivery.Attempts + 1
+	s.attempts = append(s.attempts, attempt)
+	delete(s.leased, delivery.ID)
+	if attempt.Error == "" {
+		s.failures[webhookID] = 0
+		s.deliveries = slices.DeleteFunc(s.deliveries, func(d *database.WebhookDelivery) bool { return d.ID == delivery.ID })
+		return nil
+	}
+	delivery.Attempts++
+	s.failures[webhookID]++
+	if maxFailures > 0 && s.failures[webhookID] >= maxFailures {
+		s.disabled[webhookID] = true
+	}
+	return nil
+}
+
+func (s *fakeStore) ReleaseWebhookDeliveries(ctx context.Context, ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range ids {
+		delete(s.leased, id)
+	}
+	return nil
+}
+
+func (s *fakeStore) snapshot() (pending int, attempts []*database.WebhookAttempt, disabled map[string]bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	disabled = make(map[string]bool)
+	for id, d := range s.disabled {
+		disabled[id] = d
+	}
+	return len(s.deliveries), append([]*database.WebhookAttempt(nil), s.attempts...), disabled
+}
+
+func TestWorker_Run(t *testing.T) {
+	// The flaky endpoint fails twice, the broken one always fails
+	flaky := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	flakyEndpoint := httptest.NewServer(flaky)
+	defer flakyEndpoint.Close()
+	brokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer brokenEndpoint.Close()
+
+	store := newFakeStore(
+		newDelivery(1, "flaky", flakyEndpoint.URL),
+		newDelivery(2, "broken", brokenEndpoint.URL),
+		newDelivery(3, "flaky", flakyEndpoint.URL),
+		newDelivery(4, "broken", brokenEndpoint.URL),
+	)
+	worker := webhook.NewWorker(store, time.Millisecond, time.Second, 2, 3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- worker.Run(ctx) }()
+
+	// Runs until the flaky deliveries are made, and the broken webhook is disabled
+	assert.Eventually(t, func() bool {
+		pending, _, disabled := store.snapshot()
+		return pending == 2 && disabled["broken"]
+	}, 5*time.Second, time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+
+	// The flaky deliveries are made in order, once its first delivery went through
+	require.Len(t, flaky.bodies, 2)
+	assert.Equal(t, int64(1), flaky.bodies[0].ID)
+	assert.Equal(t, int64(3), flaky.bodies[1].ID)
+
+	// Every attempt is recorded, the broken webhook stopped at its third failure
+	_, attempts, _ := store.snapshot()
+	perWebhook := map[string][]int{}
+	for _, attempt := range attempts {
+		perWebhook[attempt.WebhookID] = append(perWebhook[attempt.WebhookID], attempt.StatusCode)
+	}
+	assert.Equal(t, []int{500, 502, 200, 200}, perWebhook["flaky"])
+	assert.Equal(t, []int{500, 500, 500}, perWebhook["broken"])
+	assert.Zero(t, flaky.invalid)
+}
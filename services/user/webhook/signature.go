@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+)
+
+// Headers of the deliveries.
+const (
+	// HeaderSignature is the Sign signature of the body.
+	HeaderSignature = "X-Webhook-Signature"
+	// HeaderTimestamp is the unix time the delivery was sent at, in seconds.
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	// HeaderDelivery is the id of the delivery, shared by its retries.
+	HeaderDelivery = "X-Webhook-Delivery"
+	// HeaderEvent is the type of the delivered write, e.g. "CREATED".
+	HeaderEvent = "X-Webhook-Event"
+)
+
+// signaturePrefix
+// Names the algorithm of the signature, so another one can be added later.
+const signaturePrefix = "sha256="
+
+// Sign
+// Returns the signature of a body sent at timestamp (unix seconds):
+// "sha256=" then the hex HMAC-SHA256 of the timestamp, ".", and the body, keyed with secret.
+// The timestamp is signed so receivers can reject old deliveries replayed by a third party.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify
+// Reports whether signature is the Sign signature of body sent at timestamp, in constant time.
+// Receivers should also check that timestamp is recent.
+func Verify(secret string, timestamp int64, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
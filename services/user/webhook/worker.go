@@ -0,0 +1,229 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"grpc-services/user/database"
+	"grpc-services/user/outbox"
+)
+
+// deliveriesPerWebhook
+// The maximum number of deliveries of a webhook claimed per pass.
+const deliveriesPerWebhook = 10
+
+// leaseMargin
+// The time left to record an attempt, on top of the request timeout, before its delivery can be claimed again.
+const leaseMargin = 5 * time.Second
+
+// maxResponseSize
+// The most of a response body read, so the connection can be reused, the rest is dropped.
+const maxResponseSize = 64 << 10
+
+// Store
+// The deliveries the worker makes, implemented by database.SQLClient, see database.SQLClient.ClaimWebhookDeliveries.
+type Store interface {
+	ClaimWebhookDeliveries(ctx context.Context, webhooks, perWebhook int, lease time.Duration) ([]*database.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, delivery *database.WebhookDelivery, attempt *database.WebhookAttempt, maxFailures int) error
+	ReleaseWebhookDeliveries(ctx context.Context, ids []int64) error
+}
+
+// Payload
+// The JSON body of a delivery.
+// ID is the id of the delivery, shared by its retries, receivers can skip the deliveries they already got.
+type Payload struct {
+	ID        int64             `json:"id"`
+	Type      string            `json:"type"`
+	CreatedAt time.Time         `json:"created_at"`
+	User      outbox.NDJSONUser `json:"user"`
+}
+
+// Worker
+// POSTs the webhook deliveries to their endpoints, at least once, in order per webhook.
+// A delivery is made when the endpoint answers with a 2xx status, redirects are not followed.
+type Worker struct {
+	store       Store
+	client      *http.Client
+	interval    time.Duration
+	lease       time.Duration
+	concurrency int
+	maxFailures int
+}
+
+// NewWorker
+// Returns a Worker reading store every interval, and right away while deliveries are left.
+// Requests time out after timeout, concurrency webhooks are delivered to at the same time,
+// and webhooks are disabled after maxFailures failed attempts in a row, 0 never disables them.
+func NewWorker(store Store, interval, timeout time.Duration, concurrency, maxFailures int) *Worker {
+	return &Worker{
+		store: store,
+		client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		interval:    interval,
+		lease:       timeout + leaseMargin,
+		concurrency: concurrency,
+		maxFailures: maxFailures,
+	}
+}
+
+// Start
+// Runs the worker in the background, until ctx is done.
+func (w *Worker) Start(ctx context.Context) {
+	go func() {
+		w.Run(ctx)
+		log.Println("Webhook worker stopped")
+	}()
+}
+
+// Run
+// Claims the due deliveries and makes them until ctx is done, see Store.
+// Failed passes are logged and retried after interval.
+//
+// Errors:
+//   - ctx error, when it is done.
+func (w *Worker) Run(ctx context.Context) error {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		deliveries, err := w.store.ClaimWebhookDeliveries(ctx, w.concurrency, deliveriesPerWebhook, w.lease)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to claim the webhook deliveries: %v", err)
+		}
+		w.deliverAll(ctx, deliveries)
+
+		// More deliveries may be waiting
+		if len(deliveries) > 0 {
+			timer.Reset(0)
+		} else {
+			timer.Reset(w.interval)
+		}
+	}
+}
+
+// deliverAll
+// Makes claimed deliveries, the webhooks at the same time, and the deliveries of each one in order.
+// Returns once every webhook is done.
+func (w *Worker) deliverAll(ctx context.Context, deliveries []*database.WebhookDelivery) {
+	var order []string
+	perWebhook := make(map[string][]*database.WebhookDelivery)
+	for _, delivery := range deliveries {
+		if _, ok := perWebhook[delivery.Webhook.ID]; !ok {
+			order = append(order, delivery.Webhook.ID)
+		}
+		perWebhook[delivery.Webhook.ID] = append(perWebhook[delivery.Webhook.ID], delivery)
+	}
+
+	var wg sync.WaitGroup
+	for _, webhookID := range order {
+		wg.Add(1)
+		go func(deliveries []*database.WebhookDelivery) {
+			defer wg.Done()
+			w.deliverInOrder(ctx, deliveries)
+		}(perWebhook[webhookID])
+	}
+	wg.Wait()
+}
+
+// deliverInOrder
+// Makes the claimed deliveries of a webhook one after another, and records their attempts.
+// Once one is not delivered, the next ones are released to wait for its retry.
+// When ctx is done, the attempt in flight is dropped, the deliveries are claimed again after their lease.
+func (w *Worker) deliverInOrder(ctx context.Context, deliveries []*database.WebhookDelivery) {
+	for i, delivery := range deliveries {
+		attempt := w.Deliver(ctx, delivery)
+		if ctx.Err() != nil {
+			return
+		}
+		if attempt.Error != "" {
+			log.Printf("Failed to deliver %d to webhook %s, attempt %d: %s", delivery.ID, delivery.Webhook.ID, delivery.Attempts+1, attempt.Error)
+		}
+		err := w.store.RecordWebhookAttempt(ctx, delivery, attempt, w.maxFailures)
+		if err != nil {
+			log.Printf("Failed to record the attempt of delivery %d: %v", delivery.ID, err)
+		}
+		if err == nil && attempt.Error == "" {
+			continue
+		}
+
+		rest := make([]int64, 0, len(deliveries)-i-1)
+		for _, next := range deliveries[i+1:] {
+			rest = append(rest, next.ID)
+		}
+		if len(rest) > 0 {
+			if err := w.store.ReleaseWebhookDeliveries(ctx, rest); err != nil {
+				log.Printf("Failed to release the deliveries of webhook %s: %v", delivery.Webhook.ID, err)
+			}
+		}
+		return
+	}
+}
+
+// Deliver
+// POSTs a delivery to its webhook as a JSON Payload, signed with the webhook secret, see Sign.
+//
+// Returns:
+//   - The attempt, with the response status and the request duration, and why it failed, if it did.
+func (w *Worker) Deliver(ctx context.Context, delivery *database.WebhookDelivery) *database.WebhookAttempt {
+	attempt := &database.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		WebhookID:  delivery.Webhook.ID,
+		EventType:  delivery.Type,
+	}
+	body, err := json.Marshal(&Payload{
+		ID:        delivery.ID,
+		Type:      delivery.Type,
+		CreatedAt: delivery.CreatedAt,
+		User:      outbox.NewNDJSONUser(delivery.User),
+	})
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to encode the payload: %v", err)
+		return attempt
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		attempt.Error = fmt.Sprintf("failed to create the request: %v", err)
+		return attempt
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Webhook.Secret, timestamp, body))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderEvent, delivery.Type)
+
+	start := time.Now()
+	resp, err := w.client.Do(req)
+	if err != nil {
+		attempt.Duration = time.Since(start)
+		attempt.Error = err.Error()
+		return attempt
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+	resp.Body.Close()
+	attempt.Duration = time.Since(start)
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = fmt.Sprintf("unexpected status %s", resp.Status)
+	}
+	return attempt
+}